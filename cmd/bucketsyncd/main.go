@@ -0,0 +1,16 @@
+// Command bucketsyncd synchronises local folders with S3-compatible buckets.
+package main
+
+import "github.com/rossigee/bucketsyncd/pkg/bucketsync"
+
+// Embedded via -ldflags at build time
+var (
+	version   string
+	buildTime string
+	gitCommit string
+)
+
+func main() {
+	bucketsync.SetBuildInfo(version, gitCommit, buildTime)
+	bucketsync.Main()
+}
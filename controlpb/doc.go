@@ -0,0 +1,5 @@
+// Package controlpb holds the generated gRPC client and server for the bucketsyncd control API.
+// Regenerate it with "make proto" after changing control.proto.
+package controlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
@@ -1,519 +0,0 @@
-package main
-
-import (
-	"net/url"
-	"os"
-	"path/filepath"
-	"strings"
-	"testing"
-
-	"github.com/ryanuber/go-glob"
-)
-
-func TestOutboundConfig(t *testing.T) {
-	// Test valid outbound configuration
-	outbound := Outbound{
-		Name:        "test-outbound",
-		Description: "Test outbound configuration",
-		Sensitive:   false,
-		Source:      "/tmp/test/*",
-		Destination: "s3://test-bucket/uploads/",
-		ProcessWith: "",
-	}
-
-	if outbound.Name != "test-outbound" {
-		t.Errorf("Expected name 'test-outbound', got '%s'", outbound.Name)
-	}
-
-	if outbound.Sensitive {
-		t.Error("Expected sensitive to be false")
-	}
-
-	if outbound.Source != "/tmp/test/*" {
-		t.Errorf("Expected source '/tmp/test/*', got '%s'", outbound.Source)
-	}
-
-	if outbound.Destination != "s3://test-bucket/uploads/" {
-		t.Errorf("Expected destination 's3://test-bucket/uploads/', got '%s'", outbound.Destination)
-	}
-}
-
-func TestOutboundSensitiveConfig(t *testing.T) {
-	// Test sensitive outbound configuration
-	outbound := Outbound{
-		Name:        "sensitive-outbound",
-		Description: "Sensitive data outbound",
-		Sensitive:   true,
-		Source:      "/secure/data/*",
-		Destination: "s3://secure-bucket/encrypted/",
-		ProcessWith: "/usr/bin/encrypt",
-	}
-
-	if !outbound.Sensitive {
-		t.Error("Expected sensitive to be true")
-	}
-
-	if outbound.ProcessWith != "/usr/bin/encrypt" {
-		t.Errorf("Expected process_with '/usr/bin/encrypt', got '%s'", outbound.ProcessWith)
-	}
-}
-
-func TestOutboundFunctionInitialization(t *testing.T) {
-	// Test that outbound function initializes correctly with valid config
-	originalConfig := config
-	defer func() { config = originalConfig }()
-
-	// Set up test remotes and outbound config
-	config = Config{
-		Remotes: []Remote{
-			{
-				Name:      "test-remote",
-				Endpoint:  "localhost:9000",
-				AccessKey: "test-access",
-				SecretKey: "test-secret",
-			},
-		},
-	}
-
-	outboundConfig := Outbound{
-		Name:        "test-outbound-init",
-		Description: "Test outbound initialization",
-		Source:      "/tmp/test/*",
-		Destination: "s3://test-bucket/uploads/",
-		Sensitive:   false,
-	}
-
-	// Test that the configuration is properly structured
-	if outboundConfig.Name == "" {
-		t.Error("Outbound name should not be empty")
-	}
-
-	if outboundConfig.Source == "" {
-		t.Error("Outbound source should not be empty")
-	}
-
-	if outboundConfig.Destination == "" {
-		t.Error("Outbound destination should not be empty")
-	}
-
-	// Test folder and glob pattern extraction
-	folder, pattern := func(source string) (string, string) {
-		// Simulate the logic from outbound function
-		if strings.Contains(source, "*") {
-			parts := strings.Split(source, "/")
-			for i := len(parts) - 1; i >= 0; i-- {
-				if strings.Contains(parts[i], "*") {
-					folderParts := parts[:i]
-					return strings.Join(folderParts, "/"), parts[i]
-				}
-			}
-		}
-		return source, "*"
-	}(outboundConfig.Source)
-
-	expectedFolder := "/tmp/test"
-	expectedPattern := "*"
-
-	if folder != expectedFolder {
-		t.Errorf("Expected folder '%s', got '%s'", expectedFolder, folder)
-	}
-
-	if pattern != expectedPattern {
-		t.Errorf("Expected pattern '%s', got '%s'", expectedPattern, pattern)
-	}
-}
-
-func TestOutboundFunctionExecution(t *testing.T) {
-	// Test calling the outbound function with a test configuration
-	originalConfig := config
-	defer func() { config = originalConfig }()
-
-	// Create a temporary directory for testing
-	tmpDir := t.TempDir()
-
-	// Set up minimal remote configuration
-	config = Config{
-		Remotes: []Remote{
-			{
-				Name:      "test-remote",
-				Endpoint:  "localhost:9000",
-				AccessKey: "test-access",
-				SecretKey: "test-secret",
-			},
-		},
-	}
-
-	outboundConfig := Outbound{
-		Name:        "test-outbound-exec",
-		Description: "Test outbound execution",
-		Source:      tmpDir + "/*",
-		Destination: "s3://test-bucket/uploads/",
-		Sensitive:   false,
-	}
-
-	// Test that the outbound function can be called without panicking
-	// This will fail at S3 connection, but we're testing the initialization part
-	defer func() {
-		if r := recover(); r != nil {
-			// Expected to fail due to no real S3 service, that's OK for coverage testing
-			t.Logf("Function panicked as expected: %v", r)
-		}
-	}()
-
-	// Call the outbound function - this should cover the initialization code
-	outbound(outboundConfig)
-
-	// If we get here, the function initialized properly (even if it failed later)
-	// The main goal is to get coverage of the function's entry and setup logic
-}
-
-func TestFileGlobMatching(t *testing.T) {
-	tests := []struct {
-		pattern  string
-		filename string
-		expected bool
-	}{
-		{"*", "test.txt", true},
-		{"*.txt", "test.txt", true},
-		{"*.txt", "test.log", false},
-		{"test.*", "test.txt", true},
-		{"test.*", "other.txt", false},
-		{"*.log", "app.log", true},
-		{"*.log", "app.txt", false},
-		{"data-*", "data-file.txt", true},
-		{"data-*", "other-file.txt", false},
-	}
-
-	for _, test := range tests {
-		result := glob.Glob(test.pattern, test.filename)
-		if result != test.expected {
-			t.Errorf("Pattern '%s' with filename '%s': expected %v, got %v",
-				test.pattern, test.filename, test.expected, result)
-		}
-	}
-}
-
-func TestFolderAndGlobExtraction(t *testing.T) {
-	tests := []struct {
-		source         string
-		expectedFolder string
-		expectedGlob   string
-	}{
-		{"/tmp/test/*", "/tmp/test", "*"},
-		{"/var/log/*.log", "/var/log", "*.log"},
-		{"/data/files/backup-*", "/data/files", "backup-*"},
-		{"/uploads/images/*.jpg", "/uploads/images", "*.jpg"},
-		{"./local/*", "local", "*"},
-	}
-
-	for _, test := range tests {
-		folder := filepath.Dir(test.source)
-		glob := filepath.Base(test.source)
-
-		if folder != test.expectedFolder {
-			t.Errorf("Source '%s': expected folder '%s', got '%s'",
-				test.source, test.expectedFolder, folder)
-		}
-
-		if glob != test.expectedGlob {
-			t.Errorf("Source '%s': expected glob '%s', got '%s'",
-				test.source, test.expectedGlob, glob)
-		}
-	}
-}
-
-func TestS3DestinationParsing(t *testing.T) {
-	tests := []struct {
-		destination    string
-		expectedHost   string
-		expectedBucket string
-		expectedPath   string
-		shouldError    bool
-	}{
-		{
-			"s3://test-bucket/uploads/",
-			"test-bucket",
-			"uploads",
-			"",
-			false,
-		},
-		{
-			"https://s3.amazonaws.com/my-bucket/data/",
-			"s3.amazonaws.com",
-			"my-bucket",
-			"data/",
-			false,
-		},
-		{
-			"http://localhost:9000/local-bucket/files/",
-			"localhost",
-			"local-bucket",
-			"files/",
-			false,
-		},
-		{
-			"invalid-url",
-			"",
-			"invalid-url",
-			"",
-			false,
-		},
-	}
-
-	for _, test := range tests {
-		u, err := url.Parse(test.destination)
-		if test.shouldError {
-			if err == nil {
-				t.Errorf("Expected error parsing '%s', but got none", test.destination)
-			}
-			continue
-		}
-
-		if err != nil {
-			t.Errorf("Unexpected error parsing '%s': %v", test.destination, err)
-			continue
-		}
-
-		hostname := u.Hostname()
-		if hostname != test.expectedHost {
-			t.Errorf("Destination '%s': expected host '%s', got '%s'",
-				test.destination, test.expectedHost, hostname)
-		}
-
-		tokens := strings.Split(u.Path, "/")
-		validateS3Tokens(t, tokens, test)
-	}
-}
-
-// validateS3Tokens validates S3 URL path tokens against expected values
-func validateS3Tokens(t *testing.T, tokens []string, test struct {
-	destination    string
-	expectedHost   string
-	expectedBucket string
-	expectedPath   string
-	shouldError    bool
-}) {
-	if len(tokens) >= 2 && tokens[1] != "" {
-		validateS3BucketAndPath(t, tokens, test)
-		return
-	}
-
-	if test.destination == "invalid-url" {
-		validateInvalidURL(t, tokens, test)
-	}
-}
-
-// validateS3BucketAndPath validates bucket and path from tokens
-func validateS3BucketAndPath(t *testing.T, tokens []string, test struct {
-	destination    string
-	expectedHost   string
-	expectedBucket string
-	expectedPath   string
-	shouldError    bool
-}) {
-	bucket := tokens[1]
-	if bucket != test.expectedBucket {
-		t.Errorf("Destination '%s': expected bucket '%s', got '%s'",
-			test.destination, test.expectedBucket, bucket)
-	}
-
-	if len(tokens) > 2 {
-		path := strings.Join(tokens[2:], "/")
-		if path != test.expectedPath {
-			t.Errorf("Destination '%s': expected path '%s', got '%s'",
-				test.destination, test.expectedPath, path)
-		}
-	}
-}
-
-// validateInvalidURL validates invalid URL special case
-func validateInvalidURL(t *testing.T, tokens []string, test struct {
-	destination    string
-	expectedHost   string
-	expectedBucket string
-	expectedPath   string
-	shouldError    bool
-}) {
-	// Special case for invalid-url which becomes a path
-	if len(tokens) > 0 && tokens[0] != test.expectedBucket {
-		t.Errorf("Destination '%s': expected bucket '%s', got '%s'",
-			test.destination, test.expectedBucket, tokens[0])
-	}
-}
-
-func TestS3PathTokenValidation(t *testing.T) {
-	tests := []struct {
-		path        string
-		shouldError bool
-		reason      string
-	}{
-		{"/bucket/path", false, "valid path with bucket and path"},
-		{"/bucket", false, "bucket only is valid (tokens = ['', 'bucket'])"},
-		{"/", false, "root path is valid (tokens = ['', ''])"},
-		{"", true, "empty path has insufficient tokens"},
-		{"/bucket/sub/path", false, "valid path with sub-directories"},
-	}
-
-	for _, test := range tests {
-		tokens := strings.Split(test.path, "/")
-		const minTokens = 2
-		hasError := len(tokens) < minTokens
-
-		if hasError != test.shouldError {
-			t.Errorf("Path '%s' (%s): expected error=%v, got error=%v",
-				test.path, test.reason, test.shouldError, hasError)
-		}
-	}
-}
-
-func TestAwsFileKeyGeneration(t *testing.T) {
-	tests := []struct {
-		urlPath     string
-		filename    string
-		expectedKey string
-		shouldError bool
-	}{
-		{
-			"/bucket/uploads",
-			"test.txt",
-			"uploads/test.txt",
-			false,
-		},
-		{
-			"/bucket/data/logs",
-			"app.log",
-			"data/logs/app.log",
-			false,
-		},
-		{
-			"/bucket",
-			"file.txt",
-			"/file.txt",
-			false,
-		},
-		{
-			"/bucket/deep/nested/path",
-			"document.pdf",
-			"deep/nested/path/document.pdf",
-			false,
-		},
-	}
-
-	for _, test := range tests {
-		tokens := strings.Split(test.urlPath, "/")
-		const minTokens = 2
-		if len(tokens) < minTokens {
-			if !test.shouldError {
-				t.Errorf("Path '%s': expected success but got insufficient tokens", test.urlPath)
-			}
-			continue
-		}
-
-		awsFileKey := strings.Join(tokens[2:], "/") + "/" + test.filename
-		if awsFileKey != test.expectedKey {
-			t.Errorf("Path '%s' with filename '%s': expected key '%s', got '%s'",
-				test.urlPath, test.filename, test.expectedKey, awsFileKey)
-		}
-	}
-}
-
-func TestRemoteEndpointMatching(t *testing.T) {
-	// Set up test configuration
-	config = Config{
-		Remotes: []Remote{
-			{
-				Name:      "aws-remote",
-				Endpoint:  "s3.amazonaws.com",
-				AccessKey: "aws-access-key",
-				SecretKey: "aws-secret-key",
-			},
-			{
-				Name:      "local-remote",
-				Endpoint:  "localhost:9000",
-				AccessKey: "local-access-key",
-				SecretKey: "local-secret-key",
-			},
-			{
-				Name:      "custom-remote",
-				Endpoint:  "custom.s3.example.com",
-				AccessKey: "custom-access-key",
-				SecretKey: "custom-secret-key",
-			},
-		},
-	}
-
-	tests := []struct {
-		endpoint       string
-		shouldFind     bool
-		expectedRemote string
-	}{
-		{"s3.amazonaws.com", true, "aws-remote"},
-		{"localhost:9000", true, "local-remote"},
-		{"custom.s3.example.com", true, "custom-remote"},
-		{"unknown.endpoint.com", false, ""},
-	}
-
-	for _, test := range tests {
-		found := false
-		var foundRemote Remote
-
-		for _, remote := range config.Remotes {
-			if remote.Endpoint == test.endpoint {
-				found = true
-				foundRemote = remote
-				break
-			}
-		}
-
-		if found != test.shouldFind {
-			t.Errorf("Endpoint '%s': expected found=%v, got found=%v",
-				test.endpoint, test.shouldFind, found)
-			continue
-		}
-
-		if test.shouldFind && foundRemote.Name != test.expectedRemote {
-			t.Errorf("Endpoint '%s': expected remote '%s', got '%s'",
-				test.endpoint, test.expectedRemote, foundRemote.Name)
-		}
-	}
-}
-
-func TestWatcherInitialization(t *testing.T) {
-	// Test that watchers slice is properly initialized
-	initialWatchers := len(watchers)
-
-	// This should be 0 initially unless modified by other tests
-	if initialWatchers < 0 {
-		t.Errorf("Expected non-negative watcher count, got %d", initialWatchers)
-	}
-}
-
-func TestCreateTempFileForTesting(t *testing.T) {
-	// Create a temporary file for testing file operations
-	tmpDir := t.TempDir()
-	testFile := filepath.Join(tmpDir, "test-file.txt")
-
-	content := []byte("test content for file operations")
-	err := os.WriteFile(testFile, content, 0600)
-	if err != nil {
-		t.Fatalf("Failed to create test file: %v", err)
-	}
-
-	// Verify file was created
-	_, err = os.Stat(testFile)
-	if err != nil {
-		t.Errorf("Test file was not created properly: %v", err)
-	}
-
-	// Test file reading
-	// #nosec G304 - testFile is created in test temp directory, safe to read
-	readContent, err := os.ReadFile(testFile)
-	if err != nil {
-		t.Errorf("Failed to read test file: %v", err)
-	}
-
-	if string(readContent) != string(content) {
-		t.Errorf("File content mismatch: expected '%s', got '%s'",
-			string(content), string(readContent))
-	}
-}
@@ -0,0 +1,211 @@
+package bucketsync
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// startAdminServer serves the admin API on addr until the returned server is closed.
+// When token is set, every request must present it as a bearer token.
+func startAdminServer(addr, token string, profiling bool) (*http.Server, error) {
+	ln, err := net.Listen("tcp", loopbackDefault(addr))
+	if err != nil {
+		return nil, err
+	}
+	handler := adminHandler(profiling)
+	if token != "" {
+		handler = requireToken(token, handler)
+	}
+	return serveAdmin(ln, withHealthChecks(handler)), nil
+}
+
+// startAdminSocket serves the admin API on a unix socket only accessible to the daemon's user
+func startAdminSocket(path string, profiling bool) (*http.Server, error) {
+	// Remove a socket left behind by an unclean shutdown, but nothing else
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return serveAdmin(ln, withHealthChecks(adminHandler(profiling))), nil
+}
+
+// loopbackDefault binds a listen address given as just a port, such as ":8990", to the
+// loopback interface rather than every interface
+func loopbackDefault(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// loopbackOnly reports whether the admin API on addr is only reachable from this host
+func loopbackOnly(addr string) bool {
+	host, _, err := net.SplitHostPort(loopbackDefault(addr))
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func serveAdmin(ln net.Listener, handler http.Handler) *http.Server {
+	const readHeaderTimeout = 5 * time.Second
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("admin server failed: ", err)
+		}
+	}()
+	log.Info("serving admin API on ", ln.Addr())
+	return srv
+}
+
+// adminHandler routes the admin API, adding the pprof profiling endpoints when profiling is set
+func adminHandler(profiling bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", handleAdminStatus)
+	mux.HandleFunc("GET /api/workflows", handleListWorkflows)
+	mux.HandleFunc("GET /api/workflows/{name}", withWorkflow(handleGetWorkflow))
+	mux.HandleFunc("POST /api/workflows/{name}/pause", withWorkflow(handlePauseWorkflow))
+	mux.HandleFunc("POST /api/workflows/{name}/resume", withWorkflow(handleResumeWorkflow))
+	mux.HandleFunc("POST /api/workflows/{name}/scan", withWorkflow(handleScanWorkflow))
+	mux.HandleFunc("GET /api/transfers", handleRecentTransfers)
+	mux.HandleFunc("POST /api/reload", handleReload)
+	if profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+// requireToken rejects requests which do not carry the token as a bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withWorkflow looks up the workflow named in the request path, responding 404 if it is not running
+func withWorkflow(fn func(http.ResponseWriter, *http.Request, *workflowState)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wf, ok := findWorkflow(r.PathValue("name"))
+		if !ok {
+			writeAdminError(w, http.StatusNotFound, "no workflow named "+strconv.Quote(r.PathValue("name")))
+			return
+		}
+		fn(w, r, wf)
+	}
+}
+
+func handleAdminStatus(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, http.StatusOK, currentStatus())
+}
+
+func handleListWorkflows(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, http.StatusOK, workflowStatuses())
+}
+
+func handleGetWorkflow(w http.ResponseWriter, _ *http.Request, wf *workflowState) {
+	writeAdminJSON(w, http.StatusOK, wf.status())
+}
+
+func handlePauseWorkflow(w http.ResponseWriter, _ *http.Request, wf *workflowState) {
+	wf.pause()
+	log.WithFields(log.Fields{"workflow": wf.name}).Info("workflow paused")
+	writeAdminJSON(w, http.StatusOK, wf.status())
+}
+
+func handleResumeWorkflow(w http.ResponseWriter, _ *http.Request, wf *workflowState) {
+	wf.resume()
+	log.WithFields(log.Fields{"workflow": wf.name}).Info("workflow resumed")
+	writeAdminJSON(w, http.StatusOK, wf.status())
+}
+
+func handleScanWorkflow(w http.ResponseWriter, _ *http.Request, wf *workflowState) {
+	if wf.scan == nil {
+		writeAdminError(w, http.StatusBadRequest, "workflow cannot be scanned")
+		return
+	}
+	if wf.isPaused() {
+		writeAdminError(w, http.StatusConflict, "workflow is paused")
+		return
+	}
+	files, err := wf.scan()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.WithFields(log.Fields{"workflow": wf.name, "files": files}).Info("manual scan started")
+	writeAdminJSON(w, http.StatusAccepted, map[string]int{"files": files})
+}
+
+func handleRecentTransfers(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeAdminError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	writeAdminJSON(w, http.StatusOK, recentTransferRecords(limit))
+}
+
+func handleReload(w http.ResponseWriter, _ *http.Request) {
+	started, err := reloadConfig()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, reloadResult{Started: started})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("failed to write admin API response: ", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
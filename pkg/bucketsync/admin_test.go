@@ -0,0 +1,209 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminWorkflows(t *testing.T) {
+	resetWorkflows(t)
+	registerWorkflow("photos", workflowOutbound)
+	registerWorkflow("backups", workflowInbound)
+	srv := httptest.NewServer(adminHandler(false))
+	defer srv.Close()
+
+	var statuses []workflowStatus
+	getAdminJSON(t, srv.URL+"/api/workflows", http.StatusOK, &statuses)
+	if len(statuses) != 2 || statuses[0].Name != "backups" {
+		t.Errorf("unexpected workflows: %+v", statuses)
+	}
+
+	var status workflowStatus
+	getAdminJSON(t, srv.URL+"/api/workflows/photos", http.StatusOK, &status)
+	if status.Name != "photos" || status.Kind != workflowOutbound {
+		t.Errorf("unexpected workflow: %+v", status)
+	}
+
+	getAdminJSON(t, srv.URL+"/api/workflows/missing", http.StatusNotFound, nil)
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	w.scan = func() (int, error) {
+		t.Error("paused workflow was scanned")
+		return 0, nil
+	}
+	srv := httptest.NewServer(adminHandler(false))
+	defer srv.Close()
+
+	var status workflowStatus
+	postAdmin(t, srv.URL+"/api/workflows/photos/pause", http.StatusOK, &status)
+	if !status.Paused || !w.isPaused() {
+		t.Error("expected workflow to be paused")
+	}
+	postAdmin(t, srv.URL+"/api/workflows/photos/scan", http.StatusConflict, nil)
+
+	postAdmin(t, srv.URL+"/api/workflows/photos/resume", http.StatusOK, &status)
+	if status.Paused || w.isPaused() {
+		t.Error("expected workflow to be running")
+	}
+
+	postAdmin(t, srv.URL+"/api/workflows/missing/pause", http.StatusNotFound, nil)
+
+	resp, err := http.Get(srv.URL + "/api/workflows/photos/pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET pause status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminScan(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	scanned := 0
+	w.scan = func() (int, error) {
+		scanned++
+		return 3, nil
+	}
+	registerWorkflow("backups", workflowInbound)
+	srv := httptest.NewServer(adminHandler(false))
+	defer srv.Close()
+
+	var result map[string]int
+	postAdmin(t, srv.URL+"/api/workflows/photos/scan", http.StatusAccepted, &result)
+	if scanned != 1 || result["files"] != 3 {
+		t.Errorf("scanned %d times, result %v", scanned, result)
+	}
+
+	postAdmin(t, srv.URL+"/api/workflows/backups/scan", http.StatusBadRequest, nil)
+}
+
+func TestAdminTransfers(t *testing.T) {
+	resetWorkflows(t)
+	rememberTransfer(TransferRecord{Key: "first"})
+	rememberTransfer(TransferRecord{Key: "second"})
+	srv := httptest.NewServer(adminHandler(false))
+	defer srv.Close()
+
+	var records []TransferRecord
+	getAdminJSON(t, srv.URL+"/api/transfers?limit=1", http.StatusOK, &records)
+	if len(records) != 1 || records[0].Key != "second" {
+		t.Errorf("unexpected transfers: %+v", records)
+	}
+
+	getAdminJSON(t, srv.URL+"/api/transfers", http.StatusOK, &records)
+	if len(records) != 2 {
+		t.Errorf("got %d transfers, want 2", len(records))
+	}
+
+	getAdminJSON(t, srv.URL+"/api/transfers?limit=-1", http.StatusBadRequest, nil)
+}
+
+func getAdminJSON(t *testing.T, url string, wantStatus int, v any) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeAdminResponse(t, resp, wantStatus, v)
+}
+
+func postAdmin(t *testing.T, url string, wantStatus int, v any) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeAdminResponse(t, resp, wantStatus, v)
+}
+
+func decodeAdminResponse(t *testing.T, resp *http.Response, wantStatus int, v any) {
+	t.Helper()
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: status = %d, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, wantStatus)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	resetWorkflows(t)
+	srv := httptest.NewServer(requireToken("s3cret", adminHandler(false)))
+	defer srv.Close()
+
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/workflows", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.header, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestAdminProfiling(t *testing.T) {
+	for _, profiling := range []bool{false, true} {
+		srv := httptest.NewServer(adminHandler(profiling))
+		resp, err := http.Get(srv.URL + "/debug/pprof/goroutine?debug=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		srv.Close()
+
+		want := http.StatusNotFound
+		if profiling {
+			want = http.StatusOK
+		}
+		if resp.StatusCode != want {
+			t.Errorf("profiling %v: status = %d, want %d", profiling, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestLoopbackDefault(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{":8990", "127.0.0.1:8990"},
+		{"0.0.0.0:8990", "0.0.0.0:8990"},
+		{"localhost:8990", "localhost:8990"},
+		{"[::1]:8990", "[::1]:8990"},
+	}
+	for _, tt := range tests {
+		if got := loopbackDefault(tt.addr); got != tt.want {
+			t.Errorf("loopbackDefault(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errUploadMismatch is returned, wrapped with the difference, for an object which does not match
+// the file uploaded as it
+var errUploadMismatch = errors.New("uploaded object does not match file")
+
+// verifyUploaded checks the object just uploaded at key against the file at path, by its size
+// and, where the store's ETag is a plain MD5 of its content, its checksum
+func verifyUploaded(ctx context.Context, store ObjectStore, key, path string, fi os.FileInfo, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	obj, err := store.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to verify upload: %w", err)
+	}
+	diff, err := compareObject(path, fi, obj, true)
+	if err != nil {
+		return fmt.Errorf("failed to verify upload: %w", err)
+	}
+	if diff != "" {
+		return fmt.Errorf("%w: %s", errUploadMismatch, diff)
+	}
+	return nil
+}
+
+// fanOutPending lists, by workflow and file, the destinations a file fanned out to has yet to
+// reach, such as those whose uploads are held while their remotes are down, so that it is only
+// removed or archived once it is at every one
+var (
+	fanOutPending      = make(map[string][]string)
+	fanOutPendingMutex sync.Mutex
+)
+
+// fanOutRemaining records the destinations a file fanned out to has yet to reach
+func fanOutRemaining(o Outbound, name string, destinations []string) {
+	fanOutPendingMutex.Lock()
+	defer fanOutPendingMutex.Unlock()
+	if len(destinations) == 0 {
+		delete(fanOutPending, o.Name+"\x00"+name)
+		return
+	}
+	fanOutPending[o.Name+"\x00"+name] = destinations
+}
+
+// fanOutReached records that a file has reached one of the destinations it was fanned out to, as
+// a held upload does once its remote recovers, reporting whether it is now at every one
+func fanOutReached(o Outbound, name string) bool {
+	fanOutPendingMutex.Lock()
+	defer fanOutPendingMutex.Unlock()
+	key := o.Name + "\x00" + name
+	pending, ok := fanOutPending[key]
+	if !ok {
+		return true
+	}
+	pending = slices.DeleteFunc(slices.Clone(pending), func(d string) bool { return d == o.Destination })
+	if len(pending) > 0 {
+		fanOutPending[key] = pending
+		return false
+	}
+	delete(fanOutPending, key)
+	return true
+}
+
+// afterUpload removes the file at name once it has been uploaded to each of the workflow's
+// destinations, or moves it into the archive folder, if the workflow forwards files rather than
+// mirroring them. A file changed since it was found as fi is kept, as what was uploaded may be
+// only part of it, and its next event uploads it again.
+func afterUpload(lf log.Fields, o Outbound, name string, fi os.FileInfo) {
+	if !o.DeleteAfterUpload && o.ArchiveTo == "" {
+		return
+	}
+	current, err := os.Stat(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil || current.Size() != fi.Size() || !current.ModTime().Equal(fi.ModTime()) {
+		log.WithFields(lf).WithField("name", name).Warn("file changed while it was uploaded, keeping it")
+		return
+	}
+	switch {
+	case o.ArchiveTo != "":
+		archived, err := archiveFile(o.ArchiveTo, name)
+		if err != nil {
+			log.WithFields(lf).WithField("name", name).Error(err)
+			return
+		}
+		log.WithFields(lf).WithFields(log.Fields{"name": name, "archive": archived}).Info("archived uploaded file")
+	case o.DeleteAfterUpload:
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.WithFields(lf).WithField("name", name).Error("failed to remove uploaded file: ", err)
+			return
+		}
+		log.WithFields(lf).WithField("name", name).Info("removed uploaded file")
+	}
+}
+
+// archiveFile moves an uploaded file into the archive folder, numbering its name -1, -2 and so on
+// if one of the same name is there already, and returns its new path
+func archiveFile(folder, name string) (string, error) {
+	const dirPerms = 0700
+	if err := os.MkdirAll(folder, dirPerms); err != nil {
+		return "", fmt.Errorf("failed to create archive folder: %w", err)
+	}
+	base := filepath.Base(name)
+	dest := filepath.Join(folder, base)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(dest); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if n > maxCollisionSuffix {
+			return "", fmt.Errorf("failed to archive file: no free name for %s in %s", base, folder)
+		}
+		ext := filepath.Ext(base)
+		dest = filepath.Join(folder, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), n, ext))
+	}
+	if err := moveIntoPlace(name, dest); err != nil {
+		return "", fmt.Errorf("failed to archive file: %w", err)
+	}
+	return dest, nil
+}
@@ -0,0 +1,150 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+var connections []*amqp.Connection
+
+var errNotAMQPMessage = errors.New("not an AMQP message")
+
+// amqpClientConfig identifies bucketsyncd and its build to the AMQP broker
+func amqpClientConfig() amqp.Config {
+	amqpConfig := amqp.Config{
+		Properties: amqp.NewConnectionProperties(),
+	}
+	amqpConfig.Properties.SetClientConnectionName("bucketsyncd")
+	amqpConfig.Properties["product"] = "bucketsyncd"
+	amqpConfig.Properties["version"] = version
+	amqpConfig.Properties["platform"] = runtime.Version()
+	amqpConfig.Properties["information"] = fmt.Sprintf("commit %s, built %s", gitCommit, buildTime)
+	return amqpConfig
+}
+
+// amqpSource consumes an inbound workflow's queue, bound to its exchange, on an AMQP broker
+type amqpSource struct {
+	lf       log.Fields
+	url      string
+	queue    string
+	exchange string
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+}
+
+func newAMQPSource(lf log.Fields, source, exchange, queue string) *amqpSource {
+	return &amqpSource{lf: lf, url: source, queue: queue, exchange: exchange}
+}
+
+func (s *amqpSource) Subscribe(ctx context.Context) (<-chan Message, error) {
+	conn, err := amqp.DialConfig(s.url, amqpClientConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to AMQP service: %w", err)
+	}
+	log.WithFields(s.lf).Info("successfully connected to AMQP service")
+	connections = append(connections, conn)
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	// Buffered, since the connection sends at most once on close and is not to block on it
+	connClose := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	deliveries, err := s.consume(conn)
+	if err != nil {
+		s.closeConn(conn)
+		return nil, err
+	}
+
+	messages := make(chan Message)
+	go func() {
+		defer close(messages)
+		defer s.closeConn(conn)
+		for {
+			select {
+			case d, ok := <-deliveries:
+				if !ok {
+					log.WithFields(s.lf).Warn("deliveries channel closed")
+					return
+				}
+				m := Message{Body: d.Body, Headers: amqpHeaderCarrier(d.Headers), handle: d}
+				select {
+				case messages <- m:
+				case <-ctx.Done():
+					return
+				}
+			case connErr, ok := <-connClose:
+				if ok {
+					log.WithFields(s.lf).WithFields(log.Fields{
+						"error": connErr,
+					}).Warn("AMQP connection closed")
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
+
+// consume binds the queue to the exchange and starts consuming it
+func (s *amqpSource) consume(conn *amqp.Connection) (<-chan amqp.Delivery, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare AMQP channel: %w", err)
+	}
+	if err := channel.QueueBind(s.queue, s.exchange, s.exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to bind to AMQP queue: %w", err)
+	}
+	log.WithFields(s.lf).Debug("queue bound to exchange")
+
+	deliveries, err := channel.Consume(s.queue, "bucketsyncd", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume messages from AMQP queue: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *amqpSource) closeConn(conn *amqp.Connection) {
+	if conn.IsClosed() {
+		return
+	}
+	if err := conn.Close(); err != nil {
+		log.WithFields(s.lf).Error("failed to close connection: ", err)
+	}
+}
+
+func (s *amqpSource) Ack(m Message) error {
+	d, ok := m.handle.(amqp.Delivery)
+	if !ok {
+		return errNotAMQPMessage
+	}
+	return d.Ack(false)
+}
+
+func (s *amqpSource) Nack(m Message, requeue bool) error {
+	d, ok := m.handle.(amqp.Delivery)
+	if !ok {
+		return errNotAMQPMessage
+	}
+	return d.Nack(false, requeue)
+}
+
+func (s *amqpSource) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+	if conn == nil || conn.IsClosed() {
+		return nil
+	}
+	return conn.Close()
+}
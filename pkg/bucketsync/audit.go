@@ -0,0 +1,298 @@
+package bucketsync
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Audit configures the audit trail, a record of every transfer kept apart from the operational logs
+type Audit struct {
+	File      string `yaml:"file"`
+	MaxSize   string `yaml:"max_size"`
+	HashChain bool   `yaml:"hash_chain"`
+}
+
+// auditEntry is a single line of the audit trail. With hash chaining, each entry's hash covers
+// its content and the previous entry's hash, so altering or removing an entry breaks the chain.
+type auditEntry struct {
+	Seq         uint64    `json:"seq"`
+	Time        time.Time `json:"time"`
+	Actor       string    `json:"actor"`
+	Action      string    `json:"action"`
+	Workflow    string    `json:"workflow"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum,omitempty"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	TransferID  string    `json:"transfer_id,omitempty"`
+	PrevHash    string    `json:"prev_hash,omitempty"`
+	Hash        string    `json:"hash,omitempty"`
+}
+
+// auditLog appends entries to the audit file, rotating it when it grows beyond maxSize
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	chain    bool
+	actor    string
+	f        *os.File
+	size     int64
+	seq      uint64
+	lastHash string
+}
+
+var (
+	auditMutex sync.RWMutex
+	audit      *auditLog
+)
+
+// openAuditLog starts recording transfers to the audit trail, continuing the sequence and
+// hash chain of an existing file. The returned function closes it.
+func openAuditLog(cfg Audit) (func(), error) {
+	a := &auditLog{path: cfg.File, chain: cfg.HashChain, actor: auditActor()}
+	if cfg.MaxSize != "" {
+		size, err := parseByteSize(cfg.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_size: %w", err)
+		}
+		a.maxSize = size
+	}
+	if last, err := lastAuditEntry(cfg.File); err != nil {
+		return nil, err
+	} else if last != nil {
+		a.seq, a.lastHash = last.Seq, last.Hash
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+
+	auditMutex.Lock()
+	audit = a
+	auditMutex.Unlock()
+	return func() {
+		auditMutex.Lock()
+		audit = nil
+		auditMutex.Unlock()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		_ = a.f.Close()
+	}, nil
+}
+
+func (a *auditLog) open() error {
+	const filePerms = 0600
+	// #nosec G304 - intentional: path comes from the configuration file
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerms)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	a.f, a.size = f, fi.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and starts a new one. Rotated files
+// are never removed, and the hash chain continues into the new file.
+func (a *auditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(a.path, rotatedPath(a.path, time.Now())); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *auditLog) write(e auditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxSize > 0 && a.size >= a.maxSize {
+		if err := a.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	a.seq++
+	e.Seq, e.Actor = a.seq, a.actor
+	if a.chain {
+		e.PrevHash = a.lastHash
+		hash, err := auditHash(e)
+		if err != nil {
+			return err
+		}
+		e.Hash = hash
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := a.f.Write(append(line, '\n'))
+	a.size += int64(n)
+	if err != nil {
+		return err
+	}
+	a.lastHash = e.Hash
+	return nil
+}
+
+// auditHash is the SHA-256 of the entry's JSON encoding without its own hash
+func auditHash(e auditEntry) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditTransfer adds a transfer to the audit trail, if one is configured
+func auditTransfer(rec TransferRecord) {
+	auditMutex.RLock()
+	a := audit
+	auditMutex.RUnlock()
+	if a == nil {
+		return
+	}
+
+	remote := transferLocation(rec)
+	e := auditEntry{
+		Time:        rec.Time.UTC(),
+		Action:      rec.Direction,
+		Workflow:    rec.Workflow,
+		Source:      rec.Path,
+		Destination: remote,
+		Size:        rec.Size,
+		Checksum:    rec.Checksum,
+		Result:      rec.Status,
+		Error:       rec.Error,
+		TransferID:  rec.ID,
+	}
+	if rec.Direction == directionDownload {
+		e.Source, e.Destination = remote, rec.Path
+	}
+	if err := a.write(e); err != nil {
+		log.WithFields(log.Fields{
+			"workflow":   rec.Workflow,
+			"audit_file": a.path,
+		}).Error("failed to write audit entry: ", err)
+	}
+}
+
+// transferLocation describes the remote end of a transfer as a URL
+func transferLocation(rec TransferRecord) string {
+	if rec.CID != "" {
+		return "ipfs://" + rec.CID
+	}
+	if rec.Bucket == "" {
+		return fmt.Sprintf("webdav://%s%s", rec.Remote, rec.Key)
+	}
+	return fmt.Sprintf("s3://%s/%s/%s", rec.Remote, rec.Bucket, rec.Key)
+}
+
+// auditActor identifies who is performing transfers, as user@host
+func auditActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
+}
+
+// lastAuditEntry returns the final entry of an audit file, or nil if it is missing or empty
+func lastAuditEntry(path string) (*auditEntry, error) {
+	// #nosec G304 - intentional: path comes from the configuration file
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var last *auditEntry
+	err = scanAuditEntries(f, func(e auditEntry) error {
+		last = &e
+		return nil
+	})
+	return last, err
+}
+
+func scanAuditEntries(r io.Reader, fn func(auditEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// auditChain tracks the position reached while verifying a hash chained audit trail
+type auditChain struct {
+	hash    string
+	seq     uint64
+	entries int
+}
+
+// verify checks that the entries in r are consecutive and correctly hash chained, continuing
+// from the entries already verified. The first entry is accepted whatever it follows, since
+// earlier files may have been archived.
+func (c *auditChain) verify(r io.Reader) error {
+	return scanAuditEntries(r, func(e auditEntry) error {
+		if e.Hash == "" {
+			return fmt.Errorf("entry %d is not hash chained", e.Seq)
+		}
+		if c.entries > 0 {
+			if e.Seq != c.seq+1 {
+				return fmt.Errorf("sequence jumps from %d to %d", c.seq, e.Seq)
+			}
+			if e.PrevHash != c.hash {
+				return fmt.Errorf("entry %d does not follow the previous entry", e.Seq)
+			}
+		}
+		hash, err := auditHash(e)
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("entry %d has been modified", e.Seq)
+		}
+		c.hash, c.seq = e.Hash, e.Seq
+		c.entries++
+		return nil
+	})
+}
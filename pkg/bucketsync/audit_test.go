@@ -0,0 +1,152 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestAudit(t *testing.T, cfg Audit, records ...TransferRecord) {
+	t.Helper()
+	closeAudit, err := openAuditLog(cfg)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	for _, rec := range records {
+		auditTransfer(rec)
+	}
+	closeAudit()
+}
+
+func testAuditRecord(key string) TransferRecord {
+	return TransferRecord{
+		Time:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		ID:        "0123456789abcdef",
+		Workflow:  "docs",
+		Direction: directionUpload,
+		Path:      "/src/" + key,
+		Remote:    "minio.example.com",
+		Bucket:    "bucket",
+		Key:       "docs/" + key,
+		Size:      10,
+		Checksum:  "abc",
+		Status:    transferSuccess,
+	}
+}
+
+func TestAuditHashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := Audit{File: path, HashChain: true}
+
+	// Reopening continues the sequence and chain
+	writeTestAudit(t, cfg, testAuditRecord("a.pdf"), testAuditRecord("b.pdf"))
+	writeTestAudit(t, cfg, testAuditRecord("c.pdf"))
+
+	last, err := lastAuditEntry(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last.Seq != 3 || last.Source != "/src/c.pdf" || last.Destination != "s3://minio.example.com/bucket/docs/c.pdf" {
+		t.Errorf("unexpected last entry: %+v", last)
+	}
+
+	count, err := verifyAuditFiles([]string{path})
+	if err != nil || count != 3 {
+		t.Fatalf("expected 3 verified entries, got %d, %v", count, err)
+	}
+
+	// Altering an entry breaks the chain
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(data), `"size":10`, `"size":11`, 1)
+	if err := os.WriteFile(path, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyAuditFiles([]string{path}); err == nil || !strings.Contains(err.Error(), "entry 1 has been modified") {
+		t.Errorf("expected modification to be detected, got %v", err)
+	}
+}
+
+func TestAuditRemovedEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestAudit(t, Audit{File: path, HashChain: true}, testAuditRecord("a.pdf"), testAuditRecord("b.pdf"), testAuditRecord("c.pdf"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(path, []byte(lines[0]+lines[2]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyAuditFiles([]string{path}); err == nil {
+		t.Error("expected removed entry to be detected")
+	}
+}
+
+func TestAuditRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	writeTestAudit(t, Audit{File: path, MaxSize: "1", HashChain: true}, testAuditRecord("a.pdf"), testAuditRecord("b.pdf"))
+
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil || len(rotated) != 1 {
+		t.Fatalf("expected 1 rotated file, got %v, %v", rotated, err)
+	}
+
+	// The chain continues across the rotated file
+	count, err := verifyAuditFiles([]string{rotated[0], path})
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 verified entries, got %d, %v", count, err)
+	}
+}
+
+func TestAuditDownloadDirection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	rec := testAuditRecord("a.pdf")
+	rec.Direction, rec.Path = directionDownload, "/dst/a.pdf"
+	writeTestAudit(t, Audit{File: path}, rec)
+
+	last, err := lastAuditEntry(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last.Source != "s3://minio.example.com/bucket/docs/a.pdf" || last.Destination != "/dst/a.pdf" || last.Hash != "" {
+		t.Errorf("unexpected entry: %+v", last)
+	}
+	if last.Actor == "" || !strings.Contains(last.Actor, "@") {
+		t.Errorf("expected user@host actor, got %q", last.Actor)
+	}
+}
+
+func TestAuditRotationSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	writeTestAudit(t, Audit{File: path, MaxSize: "1", HashChain: true},
+		testAuditRecord("a.pdf"), testAuditRecord("b.pdf"), testAuditRecord("c.pdf"))
+
+	// Rotations within the same second must not overwrite each other
+	files, err := filepath.Glob(filepath.Join(dir, "audit.jsonl*"))
+	if err != nil || len(files) != 3 {
+		t.Fatalf("expected 3 audit files, got %v, %v", files, err)
+	}
+}
+
+func TestTransferLocation(t *testing.T) {
+	rec := testAuditRecord("a.pdf")
+	if got := transferLocation(rec); got != "s3://minio.example.com/bucket/docs/a.pdf" {
+		t.Errorf("S3 location = %q", got)
+	}
+	rec.Bucket, rec.Key = "", "/docs/a.pdf"
+	if got := transferLocation(rec); got != "webdav://minio.example.com/docs/a.pdf" {
+		t.Errorf("WebDAV location = %q", got)
+	}
+	rec.CID = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	if got := transferLocation(rec); got != "ipfs://"+rec.CID {
+		t.Errorf("IPFS location = %q", got)
+	}
+}
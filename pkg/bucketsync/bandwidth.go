@@ -0,0 +1,74 @@
+package bucketsync
+
+import (
+	"io"
+	"strings"
+	"time"
+)
+
+// parseBandwidth parses a rate such as "10MB" or "10MB/s" into bytes per second
+func parseBandwidth(s string) (int64, error) {
+	return parseByteSize(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+}
+
+func (c *Config) maxBandwidth() int64 {
+	return bandwidthLimit(c.MaxBandwidth)
+}
+
+func (r Remote) maxBandwidth() int64 {
+	return bandwidthLimit(r.MaxBandwidth)
+}
+
+// bandwidthLimit is a max_bandwidth setting in bytes per second, or zero if unlimited
+func bandwidthLimit(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	// readConfig and NewService refuse a configuration failing Validate
+	n, _ := parseBandwidth(s)
+	return n
+}
+
+// throttle returns r limited to the job's share of the bandwidth, which changes as other
+// transfers start and finish
+func (j *transferJob) throttle(r io.Reader) io.Reader {
+	return &throttledReader{r: r, job: j}
+}
+
+// throttledReader reads no faster than its job's allocated rate, if it has one
+type throttledReader struct {
+	r   io.Reader
+	job *transferJob
+	// allowance is the number of bytes which may be read without waiting, as of last
+	allowance float64
+	last      time.Time
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	rate := t.job.rate.Load()
+	if rate <= 0 {
+		return t.r.Read(p)
+	}
+	// Read a tenth of a second's worth at a time, so that the rate is smooth
+	if burst := max(rate/10, 1); int64(len(p)) > burst {
+		p = p[:burst]
+	}
+	n, err := t.r.Read(p)
+
+	now := time.Now()
+	if !t.last.IsZero() {
+		t.allowance = min(t.allowance+now.Sub(t.last).Seconds()*float64(rate), float64(rate)/10)
+	}
+	t.last = now
+	t.allowance -= float64(n)
+	if t.allowance < 0 {
+		timer := time.NewTimer(time.Duration(-t.allowance / float64(rate) * float64(time.Second)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-t.job.ctx.Done():
+			return n, t.job.ctx.Err()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,59 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	for _, s := range []string{"10MB", "10MB/s", " 10MB/s "} {
+		if n, err := parseBandwidth(s); err != nil || n != 10_000_000 {
+			t.Errorf("parseBandwidth(%q) = %d, %v", s, n, err)
+		}
+	}
+	if _, err := parseBandwidth("fast"); err == nil {
+		t.Error("expected error for an invalid rate")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	job := &transferJob{ctx: context.Background()}
+	job.rate.Store(200 << 10)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, job.throttle(bytes.NewReader(make([]byte, 100<<10))))
+	if err != nil || n != 100<<10 {
+		t.Fatalf("io.Copy() = %d, %v", n, err)
+	}
+	// 100 KiB at 200 KiB/s takes about half a second
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("throttled copy took %v, want about 500ms", elapsed)
+	}
+
+	// An unlimited job is not held up
+	job.rate.Store(0)
+	start = time.Now()
+	if _, err := io.Copy(io.Discard, job.throttle(bytes.NewReader(make([]byte, 10<<20)))); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("unthrottled copy took %v", elapsed)
+	}
+}
+
+func TestThrottledReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &transferJob{ctx: ctx}
+	job.rate.Store(1 << 10)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := io.Copy(io.Discard, job.throttle(bytes.NewReader(make([]byte, 1<<20)))); err == nil {
+		t.Error("expected error once the transfer was cancelled")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled copy took %v", elapsed)
+	}
+}
@@ -0,0 +1,278 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Circuit states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker configures when transfers to a failing remote endpoint are suspended. It can be
+// set globally and per remote, with unset fields taken from the global setting, and then the defaults.
+type CircuitBreaker struct {
+	Disabled bool `yaml:"disabled"`
+	// FailureThreshold is how many consecutive failed transfers open the circuit
+	FailureThreshold int `yaml:"failure_threshold"`
+	// ProbeInterval is how long the circuit stays open before one transfer is let through to test the endpoint
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+}
+
+var defaultCircuitBreaker = CircuitBreaker{
+	FailureThreshold: 5,
+	ProbeInterval:    30 * time.Second,
+}
+
+// errCircuitOpen is returned for transfers not attempted because their remote's circuit is open
+var errCircuitOpen = errors.New("remote unavailable: circuit open")
+
+func (c CircuitBreaker) validate() error {
+	if c.FailureThreshold < 0 || c.ProbeInterval < 0 {
+		return errors.New("failure_threshold and probe_interval must not be negative")
+	}
+	return nil
+}
+
+// merge returns c with the fields set in override replacing its own
+func (c CircuitBreaker) merge(override CircuitBreaker) CircuitBreaker {
+	c.Disabled = c.Disabled || override.Disabled
+	if override.FailureThreshold > 0 {
+		c.FailureThreshold = override.FailureThreshold
+	}
+	if override.ProbeInterval > 0 {
+		c.ProbeInterval = override.ProbeInterval
+	}
+	return c
+}
+
+var circuitOpenGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "bucketsyncd",
+	Name:      "remote_circuit_open",
+	Help:      "Whether transfers to a remote endpoint are suspended after repeated failures (1) or not (0).",
+}, []string{"remote"})
+
+func init() {
+	metricsRegistry.MustRegister(circuitOpenGauge)
+}
+
+var (
+	breakersMutex sync.Mutex
+	breakers      = make(map[string]*circuitBreaker)
+)
+
+// circuitBreaker tracks consecutive failures for one remote endpoint. Its methods do nothing on a
+// nil breaker, which is what disabled remotes have.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	endpoint string
+	cfg      CircuitBreaker
+	state    string
+	failures int
+	// probing is set while the single transfer allowed through a half-open circuit is in progress
+	probing bool
+	// changed is closed and replaced whenever the state changes, waking anything waiting on it
+	changed chan struct{}
+	// held are transfers put off while the circuit was open, run when it next lets one through
+	held map[string]func()
+}
+
+// remoteBreaker returns the breaker for an endpoint, or nil if it is disabled
+func remoteBreaker(endpoint string, remote CircuitBreaker) *circuitBreaker {
+	configMutex.RLock()
+	global := config.CircuitBreaker
+	configMutex.RUnlock()
+	cfg := defaultCircuitBreaker.merge(global).merge(remote)
+	if cfg.Disabled {
+		return nil
+	}
+
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	b, ok := breakers[endpoint]
+	if !ok {
+		b = &circuitBreaker{
+			endpoint: endpoint,
+			state:    circuitClosed,
+			changed:  make(chan struct{}),
+			held:     make(map[string]func()),
+		}
+		breakers[endpoint] = b
+		circuitOpenGauge.WithLabelValues(endpoint).Set(0)
+	}
+	b.mu.Lock()
+	b.cfg = cfg
+	b.mu.Unlock()
+	return b
+}
+
+// inboundBreaker returns the breaker for an inbound workflow's remote
+func inboundBreaker(in Inbound) *circuitBreaker {
+	remote, ok := findRemote(in.Remote)
+	if !ok {
+		return nil
+	}
+	return remoteBreaker(remote.Endpoint, remote.CircuitBreaker)
+}
+
+// allow reports errCircuitOpen unless a transfer may be attempted, which in a half-open circuit
+// only one may at a time
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		return errCircuitOpen
+	case circuitHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record counts a transfer's outcome, opening the circuit after too many consecutive endpoint
+// failures and closing it after a success
+func (b *circuitBreaker) record(err error) {
+	if b == nil || errors.Is(err, errCircuitOpen) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !endpointFailure(err) {
+		b.failures = 0
+		if b.state != circuitClosed {
+			log.WithFields(log.Fields{"remote": b.endpoint}).Info("remote recovered, resuming transfers")
+			b.setState(circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.cfg.FailureThreshold) {
+		log.WithFields(log.Fields{
+			"remote":   b.endpoint,
+			"failures": b.failures,
+			"probe_in": b.cfg.ProbeInterval,
+		}).Warn("remote failing, suspending transfers: ", err)
+		b.setState(circuitOpen)
+		time.AfterFunc(b.cfg.ProbeInterval, b.halfOpen)
+	}
+}
+
+// halfOpen lets one transfer through an open circuit to probe the remote, trying the held ones if there are any
+func (b *circuitBreaker) halfOpen() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitOpen {
+		b.setState(circuitHalfOpen)
+	}
+}
+
+// setState changes the state, waking waiters and running held transfers unless it is now open.
+// b.mu must be held.
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	close(b.changed)
+	b.changed = make(chan struct{})
+	if state == circuitOpen {
+		circuitOpenGauge.WithLabelValues(b.endpoint).Set(1)
+		return
+	}
+	circuitOpenGauge.WithLabelValues(b.endpoint).Set(0)
+	held := b.held
+	b.held = make(map[string]func())
+	if len(held) > 0 {
+		go func() {
+			for _, transfer := range held {
+				transfer()
+			}
+		}()
+	}
+}
+
+// holdWhileOpen keeps a transfer to run once the circuit lets transfers through again, reporting
+// whether it did so
+func (b *circuitBreaker) holdWhileOpen(key string, transfer func()) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != circuitOpen && !(b.state == circuitHalfOpen && b.probing) {
+		return false
+	}
+	b.held[key] = transfer
+	return true
+}
+
+// wait blocks while the circuit is refusing transfers, or until ctx is done
+func (b *circuitBreaker) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		open := b.state == circuitOpen || (b.state == circuitHalfOpen && b.probing)
+		changed := b.changed
+		b.mu.Unlock()
+		if !open {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// endpointFailure reports whether an error suggests the endpoint itself is down, rather than the
+// request being refused, which shows that it is up
+func endpointFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// Local files failing says nothing about the remote
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+	var s3Err minio.ErrorResponse
+	if errors.As(err, &s3Err) && s3Err.StatusCode != 0 {
+		return s3Err.StatusCode >= http.StatusInternalServerError || s3Err.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// openCircuits lists the remotes whose circuits are not closed, for the status endpoint
+func openCircuits() []string {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	var open []string
+	for endpoint, b := range breakers {
+		b.mu.Lock()
+		if b.state != circuitClosed {
+			open = append(open, endpoint+": circuit "+b.state)
+		}
+		b.mu.Unlock()
+	}
+	sort.Strings(open)
+	return open
+}
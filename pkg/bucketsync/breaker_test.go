@@ -0,0 +1,139 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// testBreaker registers a fresh breaker for endpoint, removing it when the test ends
+func testBreaker(t *testing.T, endpoint string, cfg CircuitBreaker) *circuitBreaker {
+	t.Helper()
+	t.Cleanup(func() {
+		breakersMutex.Lock()
+		delete(breakers, endpoint)
+		breakersMutex.Unlock()
+	})
+	return remoteBreaker(endpoint, cfg)
+}
+
+func TestCircuitBreakerValidate(t *testing.T) {
+	if err := (CircuitBreaker{FailureThreshold: 3, ProbeInterval: time.Minute}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (CircuitBreaker{FailureThreshold: -1}).validate(); err == nil {
+		t.Error("expected error for negative failure_threshold")
+	}
+}
+
+func TestRemoteBreakerDisabled(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+	}()
+	config = Config{CircuitBreaker: CircuitBreaker{Disabled: true}}
+
+	b := testBreaker(t, "disabled.example.com", CircuitBreaker{})
+	if b != nil {
+		t.Fatal("expected no breaker when disabled globally")
+	}
+	// A nil breaker lets everything through
+	b.record(errors.New("unavailable"))
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	b := testBreaker(t, "flaky.example.com", CircuitBreaker{FailureThreshold: 2, ProbeInterval: 20 * time.Millisecond})
+	down := errors.New("connection refused")
+
+	b.record(down)
+	if err := b.allow(); err != nil {
+		t.Fatalf("circuit opened after one failure: %v", err)
+	}
+	b.record(down)
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow() = %v, want errCircuitOpen", err)
+	}
+	if circuits := openCircuits(); len(circuits) != 1 || !strings.Contains(circuits[0], "flaky.example.com") {
+		t.Errorf("openCircuits() = %v", circuits)
+	}
+
+	// Once the probe interval has passed, only one transfer is let through
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.wait(ctx); err != nil {
+		t.Fatalf("wait() = %v", err)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second transfer during probe: allow() = %v, want errCircuitOpen", err)
+	}
+
+	b.record(nil)
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() after recovery = %v", err)
+	}
+	if circuits := openCircuits(); len(circuits) != 0 {
+		t.Errorf("openCircuits() after recovery = %v", circuits)
+	}
+}
+
+func TestCircuitBreakerRunsHeldTransfers(t *testing.T) {
+	b := testBreaker(t, "held.example.com", CircuitBreaker{FailureThreshold: 1, ProbeInterval: 10 * time.Millisecond})
+	if b.holdWhileOpen("file", func() {}) {
+		t.Fatal("held a transfer while the circuit was closed")
+	}
+
+	b.record(errors.New("timeout"))
+	ran := make(chan struct{})
+	if !b.holdWhileOpen("file", func() { close(ran) }) {
+		t.Fatal("did not hold a transfer while the circuit was open")
+	}
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("held transfer not run after the probe interval")
+	}
+}
+
+func TestEndpointFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"success", nil, false},
+		{"network", errors.New("dial tcp: connection refused"), true},
+		{"server error", minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, true},
+		{"throttled", minio.ErrorResponse{StatusCode: http.StatusTooManyRequests}, true},
+		{"missing object", minio.ErrorResponse{StatusCode: http.StatusNotFound}, false},
+		{"local file", &os.PathError{Op: "open", Path: "/tmp/x", Err: os.ErrPermission}, false},
+		{"canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := endpointFailure(tt.err); got != tt.want {
+			t.Errorf("%s: endpointFailure() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryWithBackoffStopsOnOpenCircuit(t *testing.T) {
+	calls := 0
+	err := retryWithBackoff(context.Background(), Retry{Attempts: 5, InitialDelay: time.Millisecond}, func() error {
+		calls++
+		return errCircuitOpen
+	})
+	if !errors.Is(err, errCircuitOpen) || calls != 1 {
+		t.Errorf("got %v after %d calls, want errCircuitOpen after 1", err, calls)
+	}
+}
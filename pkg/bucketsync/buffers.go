@@ -0,0 +1,64 @@
+package bucketsync
+
+import (
+	"io"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// transferBufferSize is the size of the pooled buffers which transfers copy through
+const transferBufferSize = 256 << 10
+
+// multipartThreshold is the size above which minio-go uploads in parts, buffering each in memory
+const multipartThreshold = 16 << 20
+
+var transferBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, transferBufferSize)
+		return &buf
+	},
+}
+
+var transferMemoryGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "bucketsyncd",
+	Name:      "transfer_memory_bytes",
+	Help:      "Buffer memory reserved by the transfers in progress.",
+})
+
+func init() {
+	metricsRegistry.MustRegister(transferMemoryGauge)
+}
+
+// copyBuffered copies from src to dst through a pooled buffer, rather than one allocated for
+// each copy
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := transferBuffers.Get().(*[]byte)
+	defer transferBuffers.Put(buf)
+	// Hide any WriterTo and ReaderFrom, which would bring their own buffers
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// uploadMemory is the buffer space an S3 upload of size bytes needs: a part for a multipart
+// upload, or else a copy buffer's worth as the file is streamed
+func uploadMemory(size int64) int64 {
+	if size <= multipartThreshold {
+		return transferBufferSize
+	}
+	_, partSize, _, err := minio.OptimalPartInfo(size, 0)
+	if err != nil {
+		return multipartThreshold
+	}
+	return partSize
+}
+
+// maxMemory is the max_memory budget in bytes, or zero if unlimited
+func (c *Config) maxMemory() int64 {
+	if c.MaxMemory == "" {
+		return 0
+	}
+	// readConfig and NewService refuse a configuration failing Validate
+	n, _ := parseByteSize(c.MaxMemory)
+	return n
+}
@@ -0,0 +1,43 @@
+package bucketsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCopyBuffered(t *testing.T) {
+	data := strings.Repeat("bucketsyncd", transferBufferSize/4)
+	var dst bytes.Buffer
+	n, err := copyBuffered(&dst, strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || dst.String() != data {
+		t.Errorf("copyBuffered() copied %d bytes, want %d", n, len(data))
+	}
+}
+
+func TestUploadMemory(t *testing.T) {
+	if got := uploadMemory(1 << 20); got != transferBufferSize {
+		t.Errorf("uploadMemory(1MiB) = %d, want a copy buffer", got)
+	}
+	if got := uploadMemory(100 << 20); got != multipartThreshold {
+		t.Errorf("uploadMemory(100MiB) = %d, want one 16MiB part", got)
+	}
+	// Very large files are uploaded in larger parts, to stay within 10,000 parts
+	if got := uploadMemory(500 << 30); got <= multipartThreshold {
+		t.Errorf("uploadMemory(500GiB) = %d, want a part larger than 16MiB", got)
+	}
+}
+
+func TestMaxMemoryValidate(t *testing.T) {
+	c := Config{MaxMemory: "64MiB"}
+	if got := c.maxMemory(); got != 64<<20 {
+		t.Errorf("maxMemory() = %d, want %d", got, 64<<20)
+	}
+	c.MaxMemory = "lots"
+	if errs := c.Validate(); len(errs) == 0 {
+		t.Error("Validate() accepted an invalid max_memory")
+	}
+}
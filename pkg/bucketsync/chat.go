@@ -0,0 +1,273 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Chat systems with built-in notifiers
+const (
+	chatSlack    = "slack"
+	chatDiscord  = "discord"
+	chatTelegram = "telegram"
+)
+
+const (
+	defaultChatInterval = time.Minute
+	defaultChatMaxLines = 20
+	defaultTelegramAPI  = "https://api.telegram.org"
+
+	defaultChatTemplate = `{{.Event}} {{.Workflow}}{{with or .Key .Path}}: {{.}}{{end}}{{if .Error}} ({{.Error}}){{end}}`
+)
+
+// chatMessageLimits are the longest messages each system accepts, in characters
+var chatMessageLimits = map[string]int{
+	chatSlack:    40000,
+	chatDiscord:  2000,
+	chatTelegram: 4096,
+}
+
+// ChatNotifier posts transfer outcomes to a Slack, Discord or Telegram channel. Events are batched
+// so that at most one message is sent per interval, however many transfers fail during an outage.
+type ChatNotifier struct {
+	Name string `yaml:"name"`
+	// Type is slack, discord or telegram
+	Type string `yaml:"type"`
+	// URL is the incoming webhook URL for Slack and Discord, or overrides the Telegram Bot API URL
+	URL string `yaml:"url"`
+	// Token is the Telegram bot token
+	Token string `yaml:"token"`
+	// Channel overrides a Slack webhook's channel, or is the Telegram chat ID
+	Channel     string `yaml:"channel"`
+	EventFilter `yaml:",inline"`
+	// Template renders one line per event; see defaultChatTemplate
+	Template string        `yaml:"template"`
+	Interval time.Duration `yaml:"interval"`
+	MaxLines int           `yaml:"max_lines"`
+}
+
+func (n ChatNotifier) validate() error {
+	switch n.Type {
+	case chatSlack, chatDiscord:
+		if err := validateHTTPURL(n.URL); err != nil {
+			return err
+		}
+	case chatTelegram:
+		if n.Token == "" || n.Channel == "" {
+			return errors.New("telegram requires token and channel")
+		}
+		if n.URL != "" {
+			if err := validateHTTPURL(n.URL); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown type %q (expected slack, discord or telegram)", n.Type)
+	}
+	if err := n.EventFilter.validate(); err != nil {
+		return err
+	}
+	if n.Template != "" {
+		if _, err := template.New(n.Name).Parse(n.Template); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	if n.Interval < 0 || n.MaxLines < 0 {
+		return errors.New("interval and max_lines must not be negative")
+	}
+	return nil
+}
+
+// chatSender batches events for one chat notifier and posts them through a webhook sender
+type chatSender struct {
+	cfg      ChatNotifier
+	line     *template.Template
+	sender   *webhookSender
+	queue    chan LifecycleEvent
+	pending  []string
+	overflow int
+	lastSent time.Time
+	// dropped counts events which arrived while the queue was full, so the next message still reports them
+	dropped atomic.Int64
+}
+
+var (
+	chatMutex     sync.RWMutex
+	chatNotifiers []*chatSender
+)
+
+// openChatNotifiers starts batching transfer outcomes for the configured chat notifiers.
+// The returned function sends any batched events and stops the notifiers.
+func openChatNotifiers(notifiers []ChatNotifier) (func(), error) {
+	senders := make([]*chatSender, 0, len(notifiers))
+	for _, n := range notifiers {
+		if err := n.validate(); err != nil {
+			return nil, fmt.Errorf("chat notifier %q: %w", n.Name, err)
+		}
+		if n.Name == "" {
+			n.Name = n.Type
+		}
+		if n.Interval == 0 {
+			n.Interval = defaultChatInterval
+		}
+		if n.MaxLines == 0 {
+			n.MaxLines = defaultChatMaxLines
+		}
+		if n.Template == "" {
+			n.Template = defaultChatTemplate
+		}
+		url := n.URL
+		if n.Type == chatTelegram {
+			if url == "" {
+				url = defaultTelegramAPI
+			}
+			url = strings.TrimSuffix(url, "/") + "/bot" + n.Token + "/sendMessage"
+		}
+		senders = append(senders, &chatSender{
+			cfg:    n,
+			line:   template.Must(template.New(n.Name).Parse(n.Template)),
+			sender: newWebhookSender(Webhook{Name: n.Name, URL: url}),
+			queue:  make(chan LifecycleEvent, webhookQueueSize),
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run()
+		}()
+	}
+
+	chatMutex.Lock()
+	chatNotifiers = senders
+	chatMutex.Unlock()
+
+	return func() {
+		chatMutex.Lock()
+		chatNotifiers = nil
+		chatMutex.Unlock()
+		for _, s := range senders {
+			close(s.queue)
+		}
+		wg.Wait()
+	}, nil
+}
+
+// notifyChat queues an event for every chat notifier interested in it
+func notifyChat(ev LifecycleEvent) {
+	chatMutex.RLock()
+	defer chatMutex.RUnlock()
+	for _, s := range chatNotifiers {
+		if !s.cfg.wants(ev) {
+			continue
+		}
+		select {
+		case s.queue <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// run batches queued events, sending immediately if nothing was sent during the last interval
+// and otherwise waiting until the interval has passed
+func (s *chatSender) run() {
+	var timer <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-s.queue:
+			if !ok {
+				s.flush()
+				return
+			}
+			s.add(ev)
+			if timer == nil {
+				if wait := s.cfg.Interval - time.Since(s.lastSent); wait > 0 {
+					timer = time.After(wait)
+				} else {
+					s.flush()
+				}
+			}
+		case <-timer:
+			timer = nil
+			s.flush()
+		}
+	}
+}
+
+func (s *chatSender) add(ev LifecycleEvent) {
+	if len(s.pending) >= s.cfg.MaxLines {
+		s.overflow++
+		return
+	}
+	var buf bytes.Buffer
+	if err := s.line.Execute(&buf, ev); err != nil {
+		log.WithFields(log.Fields{"notifier": s.cfg.Name}).Error("failed to render chat template: ", err)
+		return
+	}
+	s.pending = append(s.pending, buf.String())
+}
+
+// flush sends the batched events as a single message
+func (s *chatSender) flush() {
+	s.overflow += int(s.dropped.Swap(0))
+	if len(s.pending) == 0 && s.overflow == 0 {
+		return
+	}
+	body, err := json.Marshal(s.payload(s.message()))
+	s.pending, s.overflow = nil, 0
+	s.lastSent = time.Now()
+	if err == nil {
+		err = s.sender.send(context.Background(), "", body)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"notifier": s.cfg.Name,
+			"type":     s.cfg.Type,
+		}).Error("failed to send chat notification: ", err)
+	}
+}
+
+// message joins the batched lines, noting how many were left out, within the system's length limit
+func (s *chatSender) message() string {
+	lines := s.pending
+	if count := len(s.pending) + s.overflow; count > 1 {
+		lines = append([]string{fmt.Sprintf("bucketsyncd: %d transfer events", count)}, lines...)
+	}
+	if s.overflow > 0 {
+		lines = append(lines, fmt.Sprintf("…and %d more", s.overflow))
+	}
+	msg := strings.Join(lines, "\n")
+	if limit := chatMessageLimits[s.cfg.Type]; len([]rune(msg)) > limit {
+		msg = string([]rune(msg)[:limit-1]) + "…"
+	}
+	return msg
+}
+
+// payload wraps a message in the request body each system expects
+func (s *chatSender) payload(msg string) map[string]string {
+	switch s.cfg.Type {
+	case chatDiscord:
+		return map[string]string{"content": msg}
+	case chatTelegram:
+		return map[string]string{"chat_id": s.cfg.Channel, "text": msg}
+	default:
+		p := map[string]string{"text": msg}
+		if s.cfg.Channel != "" {
+			p["channel"] = s.cfg.Channel
+		}
+		return p
+	}
+}
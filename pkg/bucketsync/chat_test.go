@@ -0,0 +1,130 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatNotifierValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       ChatNotifier
+		wantErr bool
+	}{
+		{"slack", ChatNotifier{Type: chatSlack, URL: "https://hooks.slack.com/services/x"}, false},
+		{"discord without url", ChatNotifier{Type: chatDiscord}, true},
+		{"telegram", ChatNotifier{Type: chatTelegram, Token: "123:abc", Channel: "-100"}, false},
+		{"telegram without chat", ChatNotifier{Type: chatTelegram, Token: "123:abc"}, true},
+		{"unknown type", ChatNotifier{Type: "irc", URL: "https://example.com/"}, true},
+		{"bad template", ChatNotifier{Type: chatSlack, URL: "https://example.com/", Template: "{{"}, true},
+		{"negative interval", ChatNotifier{Type: chatSlack, URL: "https://example.com/", Interval: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.n.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChatPayloads(t *testing.T) {
+	tests := []struct {
+		n    ChatNotifier
+		want string
+	}{
+		{ChatNotifier{Type: chatSlack}, `{"text":"hi"}`},
+		{ChatNotifier{Type: chatSlack, Channel: "#ops"}, `{"channel":"#ops","text":"hi"}`},
+		{ChatNotifier{Type: chatDiscord, Channel: "ignored"}, `{"content":"hi"}`},
+		{ChatNotifier{Type: chatTelegram, Channel: "-100"}, `{"chat_id":"-100","text":"hi"}`},
+	}
+	for _, tt := range tests {
+		body, err := json.Marshal((&chatSender{cfg: tt.n}).payload("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != tt.want {
+			t.Errorf("%s payload = %s, want %s", tt.n.Type, body, tt.want)
+		}
+	}
+}
+
+func TestChatBatching(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	closeChat, err := openChatNotifiers([]ChatNotifier{{
+		Type:     chatSlack,
+		URL:      srv.URL,
+		Interval: time.Hour,
+		MaxLines: 3,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first event is sent straight away, then the rest wait for the interval
+	notifyChat(LifecycleEvent{Event: eventFailed, Workflow: "photos", Key: "first.jpg", Error: "timeout"})
+	deadline := time.Now().Add(2 * time.Second)
+	for rec.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := range 1000 {
+		notifyChat(LifecycleEvent{Event: eventFailed, Workflow: "photos", Key: fmt.Sprintf("%d.jpg", i)})
+	}
+	time.Sleep(50 * time.Millisecond)
+	if rec.count() != 1 {
+		t.Fatalf("got %d messages before the interval, want 1", rec.count())
+	}
+	closeChat()
+
+	if rec.count() != 2 {
+		t.Fatalf("got %d messages, want 2", rec.count())
+	}
+	var first, second map[string]string
+	if err := json.Unmarshal(rec.bodies[0], &first); err != nil {
+		t.Fatal(err)
+	}
+	if want := "failed photos: first.jpg (timeout)"; first["text"] != want {
+		t.Errorf("first message = %q, want %q", first["text"], want)
+	}
+	if err := json.Unmarshal(rec.bodies[1], &second); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(second["text"], "\n")
+	if len(lines) != 5 || lines[0] != "bucketsyncd: 1000 transfer events" || lines[4] != "…and 997 more" {
+		t.Errorf("unexpected batched message:\n%s", rec.bodies[1])
+	}
+}
+
+func TestChatTelegramURL(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer srv.Close()
+
+	closeChat, err := openChatNotifiers([]ChatNotifier{{Type: chatTelegram, URL: srv.URL, Token: "123:abc", Channel: "-100"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	notifyChat(LifecycleEvent{Event: eventUploaded, Workflow: "photos", Key: "a.jpg"})
+	closeChat()
+
+	if path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %q, want /bot123:abc/sendMessage", path)
+	}
+}
+
+func TestChatMessageLimit(t *testing.T) {
+	s := &chatSender{cfg: ChatNotifier{Type: chatDiscord}, pending: []string{strings.Repeat("x", 3000)}}
+	if got := len([]rune(s.message())); got != chatMessageLimits[chatDiscord] {
+		t.Errorf("message length = %d, want %d", got, chatMessageLimits[chatDiscord])
+	}
+}
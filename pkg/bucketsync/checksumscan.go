@@ -0,0 +1,162 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checksumScanner uploads files in an outbound workflow's source folder whose content changed
+// without the watcher seeing it, such as files written over NFS or through a memory map, by
+// checksumming them every scan interval
+type checksumScanner struct {
+	o  Outbound
+	lf log.Fields
+
+	mu sync.Mutex
+	// sums holds each file's checksum as of the last scan
+	sums map[string][sha256.Size]byte
+	// uploaded holds the files uploaded since the last scan, whose new checksums are taken as
+	// uploaded rather than changed
+	uploaded map[string]bool
+	// scanned is set once the first scan has taken the files' checksums
+	scanned bool
+}
+
+// minChecksumScan keeps checksum scans from hashing the source folder continuously
+const minChecksumScan = time.Second
+
+var (
+	checksumScannersMutex sync.Mutex
+	// checksumScanners holds the running checksum scanners by workflow name
+	checksumScanners = make(map[string]*checksumScanner)
+)
+
+// newChecksumScanner creates and registers the checksum scanner for an outbound workflow
+func newChecksumScanner(lf log.Fields, o Outbound) *checksumScanner {
+	s := &checksumScanner{o: o, lf: lf, sums: make(map[string][sha256.Size]byte), uploaded: make(map[string]bool)}
+	checksumScannersMutex.Lock()
+	checksumScanners[o.Name] = s
+	checksumScannersMutex.Unlock()
+	return s
+}
+
+// checksumUploaded tells the workflow's checksum scanner, if it has one, that a file has been
+// uploaded, so that its change is not uploaded again
+func checksumUploaded(workflow, name string) {
+	checksumScannersMutex.Lock()
+	s := checksumScanners[workflow]
+	checksumScannersMutex.Unlock()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.uploaded[name] = true
+	s.mu.Unlock()
+}
+
+// run scans the source folder every interval until ctx is done, then unregisters the scanner.
+// Only the leader uploads, and files changed while the workflow is paused are held until it is
+// resumed.
+func (s *checksumScanner) run(ctx context.Context, state *workflowState) {
+	defer func() {
+		checksumScannersMutex.Lock()
+		if checksumScanners[s.o.Name] == s {
+			delete(checksumScanners, s.o.Name)
+		}
+		checksumScannersMutex.Unlock()
+	}()
+	for {
+		changed, err := s.scan(ctx)
+		if err != nil {
+			log.WithFields(s.lf).Error("failed to checksum source folder: ", err)
+			state.setHealth(healthDegraded)
+		}
+		for _, name := range changed {
+			if ctx.Err() != nil {
+				return
+			}
+			if !isLeader() {
+				continue
+			}
+			log.WithFields(s.lf).WithField("name", name).Info("content changed without an event, uploading")
+			if state.hold(name) {
+				continue
+			}
+			// Failures are logged by uploadEvent as they occur
+			_ = uploadEvent(s.lf, s.o, name)
+			// The scan has the checksum of what was uploaded already
+			s.mu.Lock()
+			delete(s.uploaded, name)
+			s.mu.Unlock()
+		}
+		if !sleepContext(ctx, s.o.ChecksumScan) {
+			return
+		}
+	}
+}
+
+// scan checksums the files in the source folder which the workflow would upload, returning those
+// which appeared or whose content changed since the last scan, and have not been uploaded since.
+// The first scan only takes their checksums, as the watcher does not upload files already there
+// either.
+func (s *checksumScanner) scan(ctx context.Context) ([]string, error) {
+	files, err := listOutboundFiles(s.o)
+	if err != nil {
+		return nil, err
+	}
+	folder := filepath.Dir(s.o.Source)
+	sums := make(map[string][sha256.Size]byte, len(files))
+	for filename := range files {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		name := filepath.Join(folder, filename)
+		sum, err := fileChecksum(name)
+		if err != nil {
+			// The file may have gone since the folder was listed
+			log.WithFields(s.lf).WithField("name", name).Debug("unable to checksum file: ", err)
+			continue
+		}
+		sums[name] = sum
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changed []string
+	if s.scanned {
+		for name, sum := range sums {
+			if previous, ok := s.sums[name]; (!ok || previous != sum) && !s.uploaded[name] {
+				changed = append(changed, name)
+			}
+		}
+	}
+	s.sums, s.scanned = sums, true
+	clear(s.uploaded)
+	return changed, nil
+}
+
+// fileChecksum returns the SHA-256 digest of a file's content
+func fileChecksum(name string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	// #nosec G304 - the path is listed from the workflow's configured source folder
+	f, err := os.Open(name)
+	if err != nil {
+		return sum, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,86 @@
+package bucketsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChecksumScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Writes over NFS or through a memory map may leave the modification time as it was
+		mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := write("a.txt", "one")
+	b := write("b.txt", "two")
+	write("c.tmp", "ignored")
+
+	o := Outbound{Name: "checksums", Source: filepath.Join(dir, "*.txt"), ChecksumScan: time.Minute}
+	s := newChecksumScanner(nil, o)
+	defer func() {
+		checksumScannersMutex.Lock()
+		delete(checksumScanners, o.Name)
+		checksumScannersMutex.Unlock()
+	}()
+	scan := func() []string {
+		t.Helper()
+		changed, err := s.scan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(changed)
+		return changed
+	}
+
+	if changed := scan(); len(changed) != 0 {
+		t.Errorf("first scan found %v changed, want only checksums taken", changed)
+	}
+	write("a.txt", "ONE")
+	if changed := scan(); !slices.Equal(changed, []string{a}) {
+		t.Errorf("changed = %v, want %s", changed, a)
+	}
+	if changed := scan(); len(changed) != 0 {
+		t.Errorf("unchanged files found changed: %v", changed)
+	}
+
+	// A change the watcher uploaded is not uploaded again, but a new file is
+	write("b.txt", "TWO")
+	checksumUploaded(o.Name, b)
+	d := write("d.txt", "new")
+	if changed := scan(); !slices.Equal(changed, []string{d}) {
+		t.Errorf("changed = %v, want %s", changed, d)
+	}
+}
+
+func TestChecksumScanValidate(t *testing.T) {
+	for _, tt := range []struct {
+		o    Outbound
+		want string
+	}{
+		{Outbound{Name: "fast", Source: "/tmp/*", Destination: "s3://h/b", ChecksumScan: time.Millisecond}, "checksum_scan: must be at least"},
+		{Outbound{Name: "fifo", Fifo: "/tmp/pipe", Destination: "s3://h/b", ChecksumScan: time.Minute}, "checksum_scan cannot be used with fifo"},
+	} {
+		cfg := Config{Outbound: []Outbound{tt.o}}
+		found := false
+		for _, err := range cfg.Validate() {
+			found = found || strings.Contains(err.Error(), tt.want)
+		}
+		if !found {
+			t.Errorf("%s: no error containing %q", tt.o.Name, tt.want)
+		}
+	}
+}
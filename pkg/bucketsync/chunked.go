@@ -0,0 +1,320 @@
+package bucketsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"path"
+	"time"
+)
+
+const (
+	defaultChunkMinFileSize = 64 << 20
+	defaultChunkSize        = 1 << 20
+	minChunkSize            = 4 << 10
+	maxChunkSize            = 64 << 20
+
+	// chunkManifestSuffix is appended to a chunked file's key to name its manifest
+	chunkManifestSuffix = ".manifest.json"
+	// defaultChunkDir holds the chunks under the destination prefix, unless prefix is set
+	defaultChunkDir     = ".chunks"
+	chunkManifestFormat = 1
+)
+
+// Chunking uploads large files as content-defined chunks, so that when a file changes only the
+// chunks around the changes are uploaded again. The file is stored as a manifest at its key with
+// ".manifest.json" appended, listing its chunks, which are stored once each by their SHA-256.
+type Chunking struct {
+	Enabled bool `yaml:"enabled"`
+	// MinFileSize is the size from which files are chunked, by default 64MB; smaller files are
+	// uploaded whole
+	MinFileSize string `yaml:"min_file_size"`
+	// ChunkSize is the average chunk size, by default 1MiB. Chunks vary from a quarter of it to
+	// four times it, cut where the content allows so that an insertion shifts no later boundaries.
+	ChunkSize string `yaml:"chunk_size"`
+	// Prefix is where chunks are stored in the bucket, by default .chunks under the destination
+	Prefix string `yaml:"prefix"`
+}
+
+func (c Chunking) validate() error {
+	if c.MinFileSize != "" {
+		if _, err := parseByteSize(c.MinFileSize); err != nil {
+			return fmt.Errorf("min_file_size: %w", err)
+		}
+	}
+	if c.ChunkSize != "" {
+		size, err := parseByteSize(c.ChunkSize)
+		if err != nil {
+			return fmt.Errorf("chunk_size: %w", err)
+		}
+		if size < minChunkSize || size > maxChunkSize {
+			return fmt.Errorf("chunk_size: must be between %s and %s", formatByteSize(minChunkSize), formatByteSize(maxChunkSize))
+		}
+	}
+	return nil
+}
+
+// chunkParams resolves the chunking settings for a file of size bytes, reporting false if it
+// should be uploaded whole
+func (c Chunking) chunkParams(size int64) (chunkSize int64, ok bool) {
+	if !c.Enabled {
+		return 0, false
+	}
+	minFileSize := int64(defaultChunkMinFileSize)
+	if c.MinFileSize != "" {
+		minFileSize, _ = parseByteSize(c.MinFileSize)
+	}
+	if size < minFileSize {
+		return 0, false
+	}
+	chunkSize = defaultChunkSize
+	if c.ChunkSize != "" {
+		chunkSize, _ = parseByteSize(c.ChunkSize)
+	}
+	return chunkSize, true
+}
+
+// chunkDir is where a workflow's chunks are stored, given its destination prefix
+func (c Chunking) chunkDir(destPrefix string) string {
+	if c.Prefix != "" {
+		return c.Prefix
+	}
+	return path.Join(destPrefix, defaultChunkDir)
+}
+
+// gearTable maps each byte to a pseudo-random value for the rolling hash. It is generated from a
+// fixed seed, since changing it would move every chunk boundary and re-upload every file.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64
+	x := uint64(0x62756b6574737963)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks using a gear rolling hash
+type chunker struct {
+	r        *bufio.Reader
+	min, max int
+	mask     uint64
+	buf      []byte
+}
+
+func newChunker(r io.Reader, avg int64) *chunker {
+	// Cut where the hash's top bits are zero, which on average happens once every avg bytes
+	shift := bits.Len64(uint64(avg)) - 1
+	return &chunker{
+		r:    bufio.NewReaderSize(r, transferBufferSize),
+		min:  int(avg / 4),
+		max:  int(avg * 4),
+		mask: ^uint64(0) << (64 - shift),
+		buf:  make([]byte, 0, avg*4),
+	}
+}
+
+// next returns the next chunk, valid until the following call, or io.EOF after the last
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+	for len(c.buf) < c.max {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		hash = hash<<1 + gearTable[b]
+		if len(c.buf) >= c.min && hash&c.mask == 0 {
+			break
+		}
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+	return c.buf, nil
+}
+
+// chunkManifest lists a chunked file's chunks in order
+type chunkManifest struct {
+	Format int    `json:"format"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Prefix is where the chunks are stored in the bucket
+	Prefix string          `json:"prefix"`
+	Chunks []manifestChunk `json:"chunks"`
+}
+
+type manifestChunk struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// chunkStats reports how much of a chunked file was uploaded
+type chunkStats struct {
+	chunks   int
+	uploaded int
+	bytes    int64
+}
+
+// chunkKey names a chunk's object by its hash, spread over subdirectories
+func chunkKey(dir, hash string) string {
+	return path.Join(dir, hash[:2], hash)
+}
+
+// putChunked uploads r to key as a manifest and the chunks which are not already stored in the
+// bucket, applying timeout to each request
+func putChunked(ctx context.Context, store ObjectStore, key, dir string, r io.Reader, avg int64, timeout time.Duration, metadata map[string]string) (chunkStats, error) {
+	var stats chunkStats
+	manifestKey := key + chunkManifestSuffix
+
+	// The chunks of the previous version need not be checked for
+	stored := make(map[string]bool)
+	if previous, err := getChunkManifest(ctx, store, manifestKey, timeout); err == nil && previous.Prefix == dir {
+		for _, c := range previous.Chunks {
+			stored[c.SHA256] = true
+		}
+	} else if err != nil && !errors.Is(err, errObjectNotFound) {
+		return stats, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	manifest := chunkManifest{Format: chunkManifestFormat, Prefix: dir}
+	whole := sha256.New()
+	c := newChunker(r, avg)
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		whole.Write(chunk)
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		manifest.Chunks = append(manifest.Chunks, manifestChunk{SHA256: hash, Size: int64(len(chunk))})
+		manifest.Size += int64(len(chunk))
+		stats.chunks++
+		if stored[hash] {
+			continue
+		}
+		uploaded, err := putChunk(ctx, store, chunkKey(dir, hash), chunk, timeout)
+		if err != nil {
+			return stats, err
+		}
+		stored[hash] = true
+		if uploaded {
+			stats.uploaded++
+			stats.bytes += int64(len(chunk))
+		}
+	}
+	manifest.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return stats, err
+	}
+	putCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := store.Put(putCtx, manifestKey, bytes.NewReader(data), int64(len(data)), metadata); err != nil {
+		return stats, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return stats, nil
+}
+
+// putChunk uploads a chunk unless an object with its hash is already stored, reporting whether it did
+func putChunk(ctx context.Context, store ObjectStore, key string, chunk []byte, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := store.Stat(ctx, key); err == nil {
+		return false, nil
+	} else if !errors.Is(err, errObjectNotFound) {
+		return false, fmt.Errorf("failed to check for chunk: %w", err)
+	}
+	if err := store.Put(ctx, key, bytes.NewReader(chunk), int64(len(chunk)), nil); err != nil {
+		return false, fmt.Errorf("failed to upload chunk: %w", err)
+	}
+	return true, nil
+}
+
+func getChunkManifest(ctx context.Context, store ObjectStore, key string, timeout time.Duration) (chunkManifest, error) {
+	var manifest chunkManifest
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	obj, _, err := store.Get(ctx, key)
+	if err != nil {
+		return manifest, err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Format != chunkManifestFormat {
+		return manifest, fmt.Errorf("unsupported manifest format %d", manifest.Format)
+	}
+	return manifest, nil
+}
+
+// restoreChunked reassembles the file described by the manifest at manifestKey, checking each
+// chunk and the whole file against their hashes, and returns its size
+func restoreChunked(ctx context.Context, store ObjectStore, manifestKey string, w io.Writer, timeout time.Duration) (int64, error) {
+	manifest, err := getChunkManifest(ctx, store, manifestKey, timeout)
+	if err != nil {
+		return 0, err
+	}
+	whole := sha256.New()
+	var size int64
+	for _, c := range manifest.Chunks {
+		n, err := restoreChunk(ctx, store, chunkKey(manifest.Prefix, c.SHA256), c, io.MultiWriter(w, whole), timeout)
+		size += n
+		if err != nil {
+			return size, err
+		}
+	}
+	if hex.EncodeToString(whole.Sum(nil)) != manifest.SHA256 {
+		return size, errors.New("reassembled file does not match its checksum")
+	}
+	return size, nil
+}
+
+func restoreChunk(ctx context.Context, store ObjectStore, key string, c manifestChunk, w io.Writer, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	obj, _, err := store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	// Buffer the chunk so that a corrupt one is not written out
+	var buf bytes.Buffer
+	if _, err := copyBuffered(&buf, obj); err != nil {
+		return 0, fmt.Errorf("failed to fetch chunk %s: %w", c.SHA256, err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if int64(buf.Len()) != c.Size || hex.EncodeToString(sum[:]) != c.SHA256 {
+		return 0, fmt.Errorf("chunk %s is corrupt", c.SHA256)
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
@@ -0,0 +1,187 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// memoryS3 is a minimal S3 server holding objects in memory, for path-style GET, HEAD and PUT
+type memoryS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	puts    int
+}
+
+func (s *memoryS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = data
+		s.puts++
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newMemoryS3(t *testing.T) (*memoryS3, *minio.Client) {
+	t.Helper()
+	s := &memoryS3{objects: make(map[string][]byte)}
+	srv := httptest.NewTLSServer(s)
+	t.Cleanup(srv.Close)
+	mc, err := minio.New(strings.TrimPrefix(srv.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("key", "secret", ""),
+		Secure:    true,
+		Transport: srv.Client().Transport,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, mc
+}
+
+func chunkBoundaries(t *testing.T, data []byte, avg int64) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(data), avg)
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(chunk)) > avg*4 {
+			t.Fatalf("chunk of %d bytes exceeds the maximum", len(chunk))
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestChunkerInsertion(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	before := chunkBoundaries(t, data, 16<<10)
+	if len(before) < 16 {
+		t.Fatalf("expected around 64 chunks, got %d", len(before))
+	}
+
+	// Insert a few bytes near the start; only the chunks around them should change
+	changed := append(append(append([]byte(nil), data[:1000]...), "inserted"...), data[1000:]...)
+	after := chunkBoundaries(t, changed, 16<<10)
+	known := make(map[string]bool)
+	for _, c := range before {
+		known[string(c)] = true
+	}
+	var differ int
+	for _, c := range after {
+		if !known[string(c)] {
+			differ++
+		}
+	}
+	if differ > 2 {
+		t.Errorf("%d of %d chunks changed after a small insertion", differ, len(after))
+	}
+}
+
+func TestChunkingParams(t *testing.T) {
+	c := Chunking{Enabled: true, MinFileSize: "1MiB", ChunkSize: "64KiB"}
+	if _, ok := c.chunkParams(1000); ok {
+		t.Error("expected a small file to be uploaded whole")
+	}
+	if size, ok := c.chunkParams(2 << 20); !ok || size != 64<<10 {
+		t.Errorf("chunkParams() = %d, %v", size, ok)
+	}
+	if _, ok := (Chunking{}).chunkParams(1 << 30); ok {
+		t.Error("expected chunking to be disabled by default")
+	}
+	if err := (Chunking{ChunkSize: "1KiB"}).validate(); err == nil {
+		t.Error("expected error for a chunk size below the minimum")
+	}
+	if got := c.chunkDir("backups"); got != "backups/.chunks" {
+		t.Errorf("chunkDir() = %q", got)
+	}
+}
+
+func TestPutChunkedRoundTrip(t *testing.T) {
+	s, mc := newMemoryS3(t)
+	store := newMinioStore(mc, "bucket", 0)
+	ctx := context.Background()
+	data := make([]byte, 512<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+
+	stats, err := putChunked(ctx, store, "dump.sql", ".chunks", bytes.NewReader(data), 16<<10, 10*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.uploaded != stats.chunks || stats.bytes != int64(len(data)) {
+		t.Errorf("first upload: %+v", stats)
+	}
+
+	// Change a few bytes in the middle and upload again
+	data[300<<10] ^= 0xff
+	s.puts = 0
+	stats, err = putChunked(ctx, store, "dump.sql", ".chunks", bytes.NewReader(data), 16<<10, 10*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.uploaded != 1 || s.puts != 2 {
+		t.Errorf("second upload sent %d chunks in %d requests, want 1 chunk and the manifest", stats.uploaded, s.puts)
+	}
+
+	var restored bytes.Buffer
+	size, err := restoreChunked(ctx, store, "dump.sql"+chunkManifestSuffix, &restored, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || !bytes.Equal(restored.Bytes(), data) {
+		t.Error("restored file does not match the original")
+	}
+
+	// Corrupt chunks are detected rather than restored. Each is corrupted, as the one the second
+	// upload replaced is still stored but no longer in the manifest.
+	for k, v := range s.objects {
+		if strings.Contains(k, "/.chunks/") {
+			v[0] ^= 0xff
+		}
+	}
+	if _, err := restoreChunked(ctx, store, "dump.sql"+chunkManifestSuffix, io.Discard, 10*time.Second); err == nil {
+		t.Error("expected error for a corrupt chunk")
+	}
+}
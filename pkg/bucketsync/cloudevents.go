@@ -0,0 +1,273 @@
+package bucketsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventsSpecVersion = "1.0"
+	// cloudEventTypePrefix is followed by the lifecycle event, e.g. io.github.rossigee.bucketsyncd.transfer.uploaded
+	cloudEventTypePrefix = "io.github.rossigee.bucketsyncd.transfer."
+
+	defaultAMQPRoutingKeyPrefix = "bucketsyncd."
+)
+
+// AMQPPublisher publishes transfer outcomes as CloudEvents to an AMQP exchange, waiting for the
+// broker to confirm each one
+type AMQPPublisher struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Exchange string `yaml:"exchange"`
+	// RoutingKey defaults to bucketsyncd.<event>, e.g. bucketsyncd.failed
+	RoutingKey  string `yaml:"routing_key"`
+	EventFilter `yaml:",inline"`
+	Retries     int           `yaml:"retries"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// cloudEvent is a CloudEvents 1.0 event in structured JSON mode
+type cloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            LifecycleEvent `json:"data"`
+}
+
+func (p AMQPPublisher) validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "" {
+		return errors.New("url must be an amqp or amqps URL")
+	}
+	if p.Exchange == "" {
+		return errors.New("exchange is required")
+	}
+	if err := p.EventFilter.validate(); err != nil {
+		return err
+	}
+	if p.Retries < 0 || p.Timeout < 0 {
+		return errors.New("retries and timeout must not be negative")
+	}
+	return nil
+}
+
+// newCloudEvent wraps a lifecycle event, identifying its source by host and workflow
+func newCloudEvent(hostname string, ev LifecycleEvent) cloudEvent {
+	source := url.URL{Scheme: "bucketsyncd", Host: hostname, Path: "/" + ev.Workflow}
+	return cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              newTransferID(),
+		Source:          source.String(),
+		Type:            cloudEventTypePrefix + ev.Event,
+		Subject:         ev.Key,
+		Time:            ev.Time,
+		DataContentType: "application/json",
+		Data:            ev,
+	}
+}
+
+// amqpPublisher delivers events to one exchange from its own queue, keeping a confirming
+// channel open between events and reconnecting after a failure
+type amqpPublisher struct {
+	cfg      AMQPPublisher
+	hostname string
+	queue    chan LifecycleEvent
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+}
+
+var (
+	publishersMutex sync.RWMutex
+	publishers      []*amqpPublisher
+)
+
+// openAMQPPublishers starts publishing transfer outcomes to the configured exchanges.
+// The returned function stops accepting events and waits for those queued to be published.
+func openAMQPPublishers(configs []AMQPPublisher) (func(), error) {
+	hostname, _ := os.Hostname()
+	pubs := make([]*amqpPublisher, 0, len(configs))
+	for _, p := range configs {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("amqp publisher %q: %w", p.Name, err)
+		}
+		if p.Name == "" {
+			p.Name = p.Exchange
+		}
+		if p.Retries == 0 {
+			p.Retries = defaultWebhookRetries
+		}
+		if p.Timeout == 0 {
+			p.Timeout = defaultWebhookTimeout
+		}
+		pubs = append(pubs, &amqpPublisher{
+			cfg:      p,
+			hostname: hostname,
+			queue:    make(chan LifecycleEvent, webhookQueueSize),
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range pubs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer p.disconnect()
+			for ev := range p.queue {
+				if err := p.publish(context.Background(), ev); err != nil {
+					log.WithFields(log.Fields{
+						"publisher":   p.cfg.Name,
+						"event":       ev.Event,
+						"transfer_id": ev.TransferID,
+					}).Error("failed to publish event: ", err)
+				}
+			}
+		}()
+	}
+
+	publishersMutex.Lock()
+	publishers = pubs
+	publishersMutex.Unlock()
+
+	return func() {
+		publishersMutex.Lock()
+		publishers = nil
+		publishersMutex.Unlock()
+		for _, p := range pubs {
+			close(p.queue)
+		}
+		wg.Wait()
+	}, nil
+}
+
+// notifyAMQP queues an event for every publisher interested in it
+func notifyAMQP(ev LifecycleEvent) {
+	publishersMutex.RLock()
+	defer publishersMutex.RUnlock()
+	for _, p := range publishers {
+		if !p.cfg.wants(ev) {
+			continue
+		}
+		select {
+		case p.queue <- ev:
+		default:
+			log.WithFields(log.Fields{
+				"publisher":   p.cfg.Name,
+				"transfer_id": ev.TransferID,
+			}).Warn("publisher queue is full, dropping event")
+		}
+	}
+}
+
+// publish sends an event as a persistent message, retrying with exponential backoff
+// until the broker confirms it
+func (p *amqpPublisher) publish(ctx context.Context, ev LifecycleEvent) error {
+	body, err := json.Marshal(newCloudEvent(p.hostname, ev))
+	if err != nil {
+		return err
+	}
+	key := p.cfg.RoutingKey
+	if key == "" {
+		key = defaultAMQPRoutingKeyPrefix + ev.Event
+	}
+	msg := amqp.Publishing{
+		ContentType:  cloudEventsContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    ev.Time,
+		AppId:        "bucketsyncd",
+		Body:         body,
+	}
+
+	delay := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.publishOnce(ctx, key, msg)
+		if err == nil {
+			return nil
+		}
+		// Start again with a fresh connection, which also clears a channel closed by the broker
+		p.disconnect()
+		if attempt >= p.cfg.Retries {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"publisher": p.cfg.Name,
+			"attempt":   attempt + 1,
+			"backoff":   delay,
+		}).Warn("failed to publish event, retrying: ", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (p *amqpPublisher) publishOnce(ctx context.Context, key string, msg amqp.Publishing) error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	confirm, err := p.channel.PublishWithDeferredConfirmWithContext(ctx, p.cfg.Exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for confirmation: %w", err)
+	}
+	if !acked {
+		return errors.New("broker rejected the event")
+	}
+	return nil
+}
+
+// connect opens a connection and a channel in confirm mode, unless one is already open
+func (p *amqpPublisher) connect() error {
+	if p.channel != nil && !p.channel.IsClosed() {
+		return nil
+	}
+	p.disconnect()
+	amqpConfig := amqpClientConfig()
+	amqpConfig.Dial = amqp.DefaultDial(p.cfg.Timeout)
+	conn, err := amqp.DialConfig(p.cfg.URL, amqpConfig)
+	if err != nil {
+		// Leave the URL, and any credentials in it, out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	p.conn, p.channel = conn, ch
+	return nil
+}
+
+func (p *amqpPublisher) disconnect() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	p.conn, p.channel = nil, nil
+}
@@ -0,0 +1,291 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCloudWatchNamespace = "Bucketsyncd"
+	defaultCloudWatchInterval  = time.Minute
+
+	// cloudWatchMaxMetrics is the most metric data PutMetricData accepts in one request
+	cloudWatchMaxMetrics = 1000
+	// cloudWatchMaxDimensions is the most dimensions a metric may have, including the workflow
+	cloudWatchMaxDimensions = 30
+)
+
+// CloudWatch configures publishing of transfer counts, bytes and failures to Amazon CloudWatch.
+// Credentials are taken from the AWS environment variables, shared credentials file or instance
+// role when not given here.
+type CloudWatch struct {
+	Enabled   bool   `yaml:"enabled"`
+	Region    string `yaml:"region"`
+	Namespace string `yaml:"namespace"`
+	// Dimensions are added to every metric, alongside the workflow
+	Dimensions map[string]string `yaml:"dimensions"`
+	Interval   time.Duration     `yaml:"interval"`
+	// Endpoint overrides https://monitoring.<region>.amazonaws.com
+	Endpoint  string `yaml:"endpoint"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+}
+
+func (c CloudWatch) validate() error {
+	if strings.HasPrefix(c.Namespace, "AWS/") {
+		return errors.New("namespace must not start with AWS/")
+	}
+	if len(c.Dimensions) >= cloudWatchMaxDimensions {
+		return fmt.Errorf("at most %d dimensions may be given", cloudWatchMaxDimensions-1)
+	}
+	if c.Endpoint != "" {
+		if err := validateHTTPURL(c.Endpoint); err != nil {
+			return fmt.Errorf("endpoint: %w", err)
+		}
+	}
+	if (c.AccessKey == "") != (c.SecretKey == "") {
+		return errors.New("accessKey and secretKey must be given together")
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	return nil
+}
+
+// cloudWatchCounts are the totals for one workflow since they were last published
+type cloudWatchCounts struct {
+	transfers int64
+	failures  int64
+	bytes     int64
+}
+
+// cloudWatchDatum is a single value in a PutMetricData request
+type cloudWatchDatum struct {
+	name       string
+	unit       string
+	value      int64
+	dimensions [][2]string
+}
+
+var (
+	cloudWatchMutex  sync.Mutex
+	cloudWatchTotals map[string]*cloudWatchCounts
+)
+
+// countCloudWatch adds a transfer to the totals published at the next interval, if CloudWatch is enabled
+func countCloudWatch(rec TransferRecord) {
+	cloudWatchMutex.Lock()
+	defer cloudWatchMutex.Unlock()
+	if cloudWatchTotals == nil {
+		return
+	}
+	c, ok := cloudWatchTotals[rec.Workflow]
+	if !ok {
+		c = &cloudWatchCounts{}
+		cloudWatchTotals[rec.Workflow] = c
+	}
+	if rec.Status == transferFailed {
+		c.failures++
+		return
+	}
+	c.transfers++
+	c.bytes += rec.Size
+}
+
+// cloudWatchPublisher sends the accumulated totals to CloudWatch once per interval
+type cloudWatchPublisher struct {
+	cfg      CloudWatch
+	endpoint string
+	creds    *credentials.Credentials
+	client   *http.Client
+}
+
+// openCloudWatch starts publishing transfer metrics. The returned function publishes the
+// final totals and stops.
+func openCloudWatch(cfg CloudWatch) (func(), error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("region is required")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultCloudWatchNamespace
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultCloudWatchInterval
+	}
+	p := &cloudWatchPublisher{
+		cfg:      cfg,
+		endpoint: cfg.Endpoint,
+		client:   &http.Client{Timeout: defaultWebhookTimeout},
+	}
+	if p.endpoint == "" {
+		p.endpoint = "https://monitoring." + cfg.Region + ".amazonaws.com/"
+	}
+	if cfg.AccessKey != "" {
+		p.creds = credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, "")
+	} else {
+		p.creds = credentials.NewChainCredentials(awsCredentialProviders())
+	}
+
+	cloudWatchMutex.Lock()
+	cloudWatchTotals = make(map[string]*cloudWatchCounts)
+	cloudWatchMutex.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				p.flush(now)
+			case <-stop:
+				p.flush(time.Now())
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		cloudWatchMutex.Lock()
+		cloudWatchTotals = nil
+		cloudWatchMutex.Unlock()
+	}, nil
+}
+
+// flush publishes and resets the totals, including zeros for running workflows with no
+// transfers so that alarms see data rather than gaps
+func (p *cloudWatchPublisher) flush(now time.Time) {
+	cloudWatchMutex.Lock()
+	totals := cloudWatchTotals
+	cloudWatchTotals = make(map[string]*cloudWatchCounts)
+	cloudWatchMutex.Unlock()
+	for _, st := range workflowStatuses() {
+		if _, ok := totals[st.Name]; !ok {
+			totals[st.Name] = &cloudWatchCounts{}
+		}
+	}
+
+	data := p.metricData(totals)
+	for len(data) > 0 {
+		n := min(len(data), cloudWatchMaxMetrics)
+		if err := p.put(context.Background(), now, data[:n]); err != nil {
+			log.WithFields(log.Fields{
+				"namespace": p.cfg.Namespace,
+				"region":    p.cfg.Region,
+			}).Error("failed to publish CloudWatch metrics: ", err)
+			return
+		}
+		data = data[n:]
+	}
+}
+
+// metricData lists the counts for each workflow, and across all workflows, sorted by workflow
+func (p *cloudWatchPublisher) metricData(totals map[string]*cloudWatchCounts) []cloudWatchDatum {
+	static := make([][2]string, 0, len(p.cfg.Dimensions))
+	for name, value := range p.cfg.Dimensions {
+		static = append(static, [2]string{name, value})
+	}
+	sort.Slice(static, func(i, j int) bool { return static[i][0] < static[j][0] })
+
+	workflowNames := make([]string, 0, len(totals))
+	var all cloudWatchCounts
+	for name, c := range totals {
+		workflowNames = append(workflowNames, name)
+		all.transfers += c.transfers
+		all.failures += c.failures
+		all.bytes += c.bytes
+	}
+	sort.Strings(workflowNames)
+
+	var data []cloudWatchDatum
+	add := func(c cloudWatchCounts, dims [][2]string) {
+		data = append(data,
+			cloudWatchDatum{name: "Transfers", unit: "Count", value: c.transfers, dimensions: dims},
+			cloudWatchDatum{name: "Failures", unit: "Count", value: c.failures, dimensions: dims},
+			cloudWatchDatum{name: "Bytes", unit: "Bytes", value: c.bytes, dimensions: dims},
+		)
+	}
+	add(all, static)
+	for _, name := range workflowNames {
+		add(*totals[name], append([][2]string{{"Workflow", name}}, static...))
+	}
+	return data
+}
+
+// put sends one PutMetricData request using the query API, signed with AWS Signature Version 4
+func (p *cloudWatchPublisher) put(ctx context.Context, now time.Time, data []cloudWatchDatum) error {
+	form := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {"2010-08-01"},
+		"Namespace": {p.cfg.Namespace},
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+	for i, d := range data {
+		prefix := "MetricData.member." + strconv.Itoa(i+1) + "."
+		form.Set(prefix+"MetricName", d.name)
+		form.Set(prefix+"Unit", d.unit)
+		form.Set(prefix+"Value", strconv.FormatInt(d.value, 10))
+		form.Set(prefix+"Timestamp", timestamp)
+		for j, dim := range d.dimensions {
+			dimPrefix := prefix + "Dimensions.member." + strconv.Itoa(j+1) + "."
+			form.Set(dimPrefix+"Name", dim[0])
+			form.Set(dimPrefix+"Value", dim[1])
+		}
+	}
+	body := form.Encode()
+
+	creds, err := p.creds.GetWithContext(&credentials.CredContext{Client: p.client})
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req = signer.SignV4WithServiceType(*req, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, p.cfg.Region, "monitoring")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("CloudWatch returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package bucketsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloudWatchValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       CloudWatch
+		wantErr bool
+	}{
+		{"empty", CloudWatch{}, false},
+		{"valid", CloudWatch{Enabled: true, Region: "eu-west-1", Dimensions: map[string]string{"Environment": "prod"}}, false},
+		{"aws namespace", CloudWatch{Namespace: "AWS/S3"}, true},
+		{"bad endpoint", CloudWatch{Endpoint: "monitoring.local"}, true},
+		{"access key only", CloudWatch{AccessKey: "AKIA"}, true},
+		{"negative interval", CloudWatch{Interval: -time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloudWatchPublish(t *testing.T) {
+	resetWorkflows(t)
+	var form url.Values
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = r.ParseForm()
+		form = r.PostForm
+	}))
+	defer srv.Close()
+
+	registerWorkflow("idle", workflowInbound)
+	closeCloudWatch, err := openCloudWatch(CloudWatch{
+		Enabled:    true,
+		Region:     "eu-west-1",
+		Endpoint:   srv.URL,
+		Dimensions: map[string]string{"Environment": "prod"},
+		Interval:   time.Hour,
+		AccessKey:  "AKIAEXAMPLE",
+		SecretKey:  "secret",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	countCloudWatch(TransferRecord{Workflow: "photos", Status: transferSuccess, Size: 100})
+	countCloudWatch(TransferRecord{Workflow: "photos", Status: transferSuccess, Size: 50})
+	countCloudWatch(TransferRecord{Workflow: "photos", Status: transferFailed, Size: 10})
+	closeCloudWatch()
+
+	if !strings.Contains(auth, "/eu-west-1/monitoring/aws4_request") {
+		t.Errorf("request not signed for CloudWatch: %q", auth)
+	}
+	if form.Get("Action") != "PutMetricData" || form.Get("Namespace") != defaultCloudWatchNamespace {
+		t.Fatalf("unexpected request: %v", form)
+	}
+
+	// Totals across all workflows come first, then each workflow in name order, idle ones included
+	want := map[string]string{
+		"MetricData.member.1.MetricName":                "Transfers",
+		"MetricData.member.1.Value":                     "2",
+		"MetricData.member.1.Dimensions.member.1.Name":  "Environment",
+		"MetricData.member.2.MetricName":                "Failures",
+		"MetricData.member.2.Value":                     "1",
+		"MetricData.member.3.MetricName":                "Bytes",
+		"MetricData.member.3.Unit":                      "Bytes",
+		"MetricData.member.3.Value":                     "150",
+		"MetricData.member.4.Dimensions.member.1.Value": "idle",
+		"MetricData.member.4.Value":                     "0",
+		"MetricData.member.7.Dimensions.member.1.Name":  "Workflow",
+		"MetricData.member.7.Dimensions.member.1.Value": "photos",
+		"MetricData.member.7.Dimensions.member.2.Value": "prod",
+		"MetricData.member.7.Value":                     "2",
+	}
+	for k, v := range want {
+		if got := form.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if form.Get("MetricData.member.10.MetricName") != "" {
+		t.Error("unexpected extra metrics")
+	}
+}
+
+func TestCountCloudWatchDisabled(t *testing.T) {
+	// Counting without CloudWatch enabled is a no-op
+	countCloudWatch(TransferRecord{Workflow: "photos", Status: transferSuccess})
+}
@@ -0,0 +1,26 @@
+package bucketsync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdAuditUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"show"}} {
+		if code := cmdAudit(args); code != exitUsage {
+			t.Errorf("cmdAudit(%v): expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestCmdAuditVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestAudit(t, Audit{File: path, HashChain: true}, testAuditRecord("a.pdf"))
+
+	if code := cmdAudit([]string{"verify", path}); code != exitOK {
+		t.Errorf("expected exit code %d, got %d", exitOK, code)
+	}
+	if code := cmdAudit([]string{"verify", filepath.Join(t.TempDir(), "missing.jsonl")}); code != exitError {
+		t.Errorf("expected exit code %d, got %d", exitError, code)
+	}
+}
@@ -0,0 +1,161 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// benchResult summarises one phase (upload or download) of a throughput benchmark
+type benchResult struct {
+	Op        string
+	Objects   int
+	Bytes     int64
+	Elapsed   time.Duration
+	Latencies []time.Duration
+}
+
+// cmdBench implements the bench subcommand, measuring upload and download throughput to a remote
+func cmdBench(args []string) int {
+	fs := newCommandFlagSet("bench")
+	remoteName := fs.String("remote", "", "Name of the remote to benchmark")
+	bucket := fs.String("bucket", "", "Bucket to write benchmark objects to")
+	prefix := fs.String("prefix", ".bucketsyncd-bench/", "Key prefix for benchmark objects")
+	size := fs.String("size", "64MB", "Total amount of data to transfer, split across parallel objects")
+	parallel := fs.Int("parallel", 4, "Number of objects transferred concurrently")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	totalSize, err := parseByteSize(*size)
+	if *remoteName == "" || *bucket == "" || *parallel < 1 || err != nil || totalSize < int64(*parallel) {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd bench -remote name -bucket bucket [-size 1GB] [-parallel 4]")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	remote, ok := findRemote(*remoteName)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: no remote named %q\n", *remoteName)
+		return exitConfig
+	}
+
+	results, err := runBench(context.Background(), remote, *bucket, *prefix, totalSize, *parallel)
+	for _, r := range results {
+		fmt.Println(r.String())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+func runBench(ctx context.Context, remote Remote, bucket, prefix string, totalSize int64, parallel int) ([]benchResult, error) {
+	mc, err := newMinioClient(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	objectSize := totalSize / int64(parallel)
+	keys := make([]string, parallel)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%sobject-%d-%d", prefix, time.Now().UnixNano(), i)
+	}
+	defer func() {
+		for _, key := range keys {
+			if err := mc.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}); err != nil {
+				log.WithFields(log.Fields{"bucket": bucket, "key": key}).Warn("failed to remove benchmark object: ", err)
+			}
+		}
+	}()
+
+	upload := runBenchPhase("upload", keys, objectSize, func(i int, key string) error {
+		// #nosec G404 - benchmark payload only needs to be incompressible, not secure
+		data := io.LimitReader(rand.NewChaCha8([32]byte{byte(i)}), objectSize)
+		_, err := mc.PutObject(ctx, bucket, key, data, objectSize, minio.PutObjectOptions{})
+		return err
+	})
+	if upload.err != nil {
+		return []benchResult{upload.benchResult}, fmt.Errorf("upload failed: %w", upload.err)
+	}
+
+	download := runBenchPhase("download", keys, objectSize, func(_ int, key string) error {
+		obj, err := mc.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = obj.Close()
+		}()
+		_, err = io.Copy(io.Discard, obj)
+		return err
+	})
+	results := []benchResult{upload.benchResult, download.benchResult}
+	if download.err != nil {
+		return results, fmt.Errorf("download failed: %w", download.err)
+	}
+	return results, nil
+}
+
+type benchPhase struct {
+	benchResult
+	err error
+}
+
+// runBenchPhase runs fn for every key concurrently, timing each call and the phase as a whole
+func runBenchPhase(op string, keys []string, objectSize int64, fn func(int, string) error) benchPhase {
+	phase := benchPhase{benchResult: benchResult{
+		Op:        op,
+		Objects:   len(keys),
+		Bytes:     objectSize * int64(len(keys)),
+		Latencies: make([]time.Duration, len(keys)),
+	}}
+	errs := make([]error, len(keys))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, key := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t := time.Now()
+			errs[i] = fn(i, key)
+			phase.Latencies[i] = time.Since(t)
+		}()
+	}
+	wg.Wait()
+	phase.Elapsed = time.Since(start)
+	phase.err = errors.Join(errs...)
+	return phase
+}
+
+// String formats the result as a single human-readable summary line
+func (r benchResult) String() string {
+	throughput := 0.0
+	if r.Elapsed > 0 {
+		throughput = float64(r.Bytes) / r.Elapsed.Seconds()
+	}
+	var minLat, maxLat, total time.Duration
+	if len(r.Latencies) > 0 {
+		minLat, maxLat = slices.Min(r.Latencies), slices.Max(r.Latencies)
+		for _, l := range r.Latencies {
+			total += l
+		}
+		total /= time.Duration(len(r.Latencies))
+	}
+	return fmt.Sprintf("%-8s %d objects, %s in %s: %s/s (per object min %s avg %s max %s)",
+		r.Op, r.Objects, formatByteSize(r.Bytes), r.Elapsed.Round(time.Millisecond),
+		formatByteSize(int64(throughput)),
+		minLat.Round(time.Millisecond), total.Round(time.Millisecond), maxLat.Round(time.Millisecond))
+}
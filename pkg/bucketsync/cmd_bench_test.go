@@ -0,0 +1,61 @@
+package bucketsync
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBenchPhase(t *testing.T) {
+	var calls atomic.Int32
+	phase := runBenchPhase("upload", []string{"a", "b", "c"}, 100, func(_ int, _ string) error {
+		calls.Add(1)
+		return nil
+	})
+
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+	if phase.err != nil {
+		t.Errorf("unexpected error: %v", phase.err)
+	}
+	if phase.Bytes != 300 || phase.Objects != 3 || len(phase.Latencies) != 3 {
+		t.Errorf("unexpected result: %+v", phase.benchResult)
+	}
+}
+
+func TestRunBenchPhaseError(t *testing.T) {
+	phase := runBenchPhase("download", []string{"a", "b"}, 1, func(i int, _ string) error {
+		if i == 1 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if phase.err == nil {
+		t.Error("expected error from failing object")
+	}
+}
+
+func TestBenchResultString(t *testing.T) {
+	r := benchResult{
+		Op:        "upload",
+		Objects:   2,
+		Bytes:     2000000,
+		Elapsed:   time.Second,
+		Latencies: []time.Duration{500 * time.Millisecond, time.Second},
+	}
+	s := r.String()
+	for _, want := range []string{"upload", "2 objects", "2.0 MB", "2.0 MB/s", "min 500ms", "avg 750ms", "max 1s"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in %q", want, s)
+		}
+	}
+}
+
+func TestCmdBenchUsage(t *testing.T) {
+	if code := cmdBench([]string{"-size", "lots"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
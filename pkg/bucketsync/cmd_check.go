@@ -0,0 +1,244 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const checkTimeout = 15 * time.Second
+
+// checkResult records the outcome of a single connectivity check
+type checkResult struct {
+	Check  string
+	Target string
+	Err    error
+}
+
+// cmdCheck implements the check subcommand, testing every configured remote and queue binding
+func cmdCheck(args []string) int {
+	fs := newCommandFlagSet("check")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+
+	configMutex.RLock()
+	remotes := make([]Remote, len(config.Remotes))
+	copy(remotes, config.Remotes)
+	outbounds := make([]Outbound, len(config.Outbound))
+	copy(outbounds, config.Outbound)
+	inbounds := make([]Inbound, len(config.Inbound))
+	copy(inbounds, config.Inbound)
+	configMutex.RUnlock()
+
+	ctx := context.Background()
+	var results []checkResult
+	for _, r := range remotes {
+		results = append(results, checkRemote(ctx, r))
+	}
+	for _, o := range outbounds {
+		results = append(results, checkOutbound(ctx, o)...)
+	}
+	for _, in := range inbounds {
+		// Inbound workflows which only poll their bucket on a schedule have no broker to check
+		if in.Source == "" {
+			continue
+		}
+		results = append(results, checkInbound(in)...)
+	}
+
+	if err := writeCheckTable(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	return checkExitCode(results)
+}
+
+// checkExitCode summarises the results, reporting rejected credentials ahead of other failures
+func checkExitCode(results []checkResult) int {
+	failed := 0
+	var lastErr error
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		failed++
+		lastErr = r.Err
+		if isAuthError(r.Err) {
+			return exitAuth
+		}
+	}
+	if failed == 0 {
+		return exitOK
+	}
+	return exitCodeFor(&batchError{Op: "checks", Failed: failed, Total: len(results), Err: lastErr})
+}
+
+// checkRemote verifies that a remote's credentials are accepted
+func checkRemote(ctx context.Context, r Remote) checkResult {
+	res := checkResult{Check: "remote auth", Target: r.Name}
+	mc, err := newMinioClient(r)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	if _, err := mc.ListBuckets(ctx); err != nil {
+		res.Err = err
+	}
+	return res
+}
+
+// checkOutbound verifies that an outbound workflow's destination buckets exist and are writable
+func checkOutbound(ctx context.Context, o Outbound) []checkResult {
+	if len(o.Destinations) > 0 {
+		var results []checkResult
+		for _, destination := range o.destinations() {
+			results = append(results, checkOutbound(ctx, o.forDestination(destination))...)
+		}
+		return results
+	}
+	u, err := url.Parse(o.Destination)
+	if err != nil {
+		return []checkResult{{Check: "destination", Target: o.Name, Err: err}}
+	}
+	if isWebDAVScheme(u.Scheme) {
+		return []checkResult{checkWebDAV(o)}
+	}
+	if isIPFSScheme(u.Scheme) {
+		return []checkResult{checkIPFS(ctx, o, u)}
+	}
+
+	endpoint, bucket, prefix, err := parseS3Destination(u)
+	if err != nil {
+		return []checkResult{{Check: "destination", Target: o.Name, Err: err}}
+	}
+	target := o.Name + " (" + bucket + ")"
+	remote, ok := outboundRemote(o, endpoint)
+	if !ok {
+		return []checkResult{{Check: "bucket exists", Target: target, Err: fmt.Errorf("no remote configured for endpoint %s", endpoint)}}
+	}
+	mc, err := newMinioClient(remote)
+	if err != nil {
+		return []checkResult{{Check: "bucket exists", Target: target, Err: err}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	exists := checkResult{Check: "bucket exists", Target: target}
+	found, err := mc.BucketExists(ctx, bucket)
+	switch {
+	case err != nil:
+		exists.Err = err
+	case !found:
+		exists.Err = fmt.Errorf("bucket %s does not exist", bucket)
+	}
+	if exists.Err != nil {
+		return []checkResult{exists}
+	}
+
+	// Write and remove a small probe object under the destination prefix
+	write := checkResult{Check: "bucket write", Target: target}
+	probeKey := strings.TrimPrefix(prefix+"/", "/") + fmt.Sprintf(".bucketsyncd-check-%d", time.Now().UnixNano())
+	probe := []byte("bucketsyncd connectivity check\n")
+	_, err = mc.PutObject(ctx, bucket, probeKey, bytes.NewReader(probe), int64(len(probe)), minio.PutObjectOptions{})
+	if err == nil {
+		err = mc.RemoveObject(ctx, bucket, probeKey, minio.RemoveObjectOptions{})
+	}
+	write.Err = err
+	return []checkResult{exists, write}
+}
+
+// checkWebDAV verifies that a WebDAV destination is reachable
+func checkWebDAV(o Outbound) checkResult {
+	res := checkResult{Check: "webdav", Target: o.Name}
+	client, err := NewWebDAVClient(o.Destination)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if _, err := client.client.Stat("/"); err != nil {
+		res.Err = err
+	}
+	return res
+}
+
+// checkIPFS verifies that an IPFS destination's node answers on its API
+func checkIPFS(ctx context.Context, o Outbound, u *url.URL) checkResult {
+	res := checkResult{Check: "ipfs", Target: o.Name}
+	target, err := outboundTarget(o, u)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	_, res.Err = target.store.(*ipfsStore).version(ctx)
+	return res
+}
+
+// checkInbound verifies that an inbound's AMQP broker is reachable and its exchange and queue exist
+func checkInbound(in Inbound) []checkResult {
+	connect := checkResult{Check: "amqp connect", Target: in.Name}
+	conn, err := amqp.DialConfig(in.Source, amqp.Config{
+		Dial: amqp.DefaultDial(checkTimeout),
+	})
+	if err != nil {
+		connect.Err = err
+		return []checkResult{connect}
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	exchange := checkResult{Check: "amqp exchange", Target: in.Name + " (" + in.Exchange + ")"}
+	exchange.Err = withChannel(conn, func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclarePassive(in.Exchange, "topic", true, false, false, false, nil)
+	})
+	queue := checkResult{Check: "amqp queue", Target: in.Name + " (" + in.Queue + ")"}
+	queue.Err = withChannel(conn, func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclarePassive(in.Queue, true, false, false, false, nil)
+		return err
+	})
+	return []checkResult{connect, exchange, queue}
+}
+
+// withChannel runs fn on a fresh channel, since a failed passive declaration closes the channel
+func withChannel(conn *amqp.Connection, fn func(*amqp.Channel) error) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = ch.Close()
+	}()
+	return fn(ch)
+}
+
+func writeCheckTable(w io.Writer, results []checkResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tTARGET\tRESULT\tDETAIL")
+	for _, r := range results {
+		result, detail := "PASS", ""
+		if r.Err != nil {
+			result, detail = "FAIL", r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Check, r.Target, result, detail)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,213 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const copyRetries = 3
+
+// cmdCopy implements the cp subcommand, copying a single file between the local
+// filesystem and a bucket using the configured remotes
+func cmdCopy(args []string) int {
+	fs := newCommandFlagSet("cp")
+	remoteName := fs.String("remote", "", "Name of the remote to use")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd cp [-remote name] <local> s3://bucket/key")
+		fmt.Fprintln(os.Stderr, "       bucketsyncd cp [-remote name] s3://bucket/key <local>")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+
+	src, dst := positional[0], positional[1]
+	switch {
+	case !isObjectURL(src) && isObjectURL(dst):
+		err = copyToBucket(context.Background(), src, dst, *remoteName)
+	case isObjectURL(src) && !isObjectURL(dst):
+		err = copyFromBucket(context.Background(), src, dst, *remoteName)
+	default:
+		err = errors.New("exactly one of source and destination must be an s3:// URL")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+func copyToBucket(ctx context.Context, localPath, objectURL, remoteName string) error {
+	remote, bucket, key, err := resolveObjectURL(objectURL, remoteName)
+	if err != nil {
+		return err
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		key += filepath.Base(localPath)
+	}
+
+	store, err := bucketStore(remote, bucket)
+	if err != nil {
+		return err
+	}
+
+	size, err := uploadFile(ctx, store, key, localPath)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"remote": remote.Name,
+		"bucket": bucket,
+		"key":    key,
+		"size":   size,
+	}).Info("uploaded to S3")
+	return nil
+}
+
+func copyFromBucket(ctx context.Context, objectURL, localPath, remoteName string) error {
+	remote, bucket, key, err := resolveObjectURL(objectURL, remoteName)
+	if err != nil {
+		return err
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid object URL %q (missing key)", objectURL)
+	}
+	// A chunked upload is copied by naming its manifest, and restored as the original file
+	chunked := strings.HasSuffix(key, chunkManifestSuffix)
+	if fi, err := os.Stat(localPath); err == nil && fi.IsDir() {
+		localPath = filepath.Join(localPath, strings.TrimSuffix(path.Base(key), chunkManifestSuffix))
+	}
+
+	store, err := bucketStore(remote, bucket)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	if chunked {
+		size, err = restoreChunkedFile(ctx, store, key, localPath)
+	} else {
+		size, err = downloadObject(ctx, store, key, localPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"remote":   remote.Name,
+		"bucket":   bucket,
+		"key":      key,
+		"filename": localPath,
+		"size":     size,
+	}).Info("retrieved remote object to local file")
+	return nil
+}
+
+// uploadFile uploads a local file to a store, retrying on failure, and returns the number of bytes sent
+func uploadFile(ctx context.Context, store ObjectStore, key, localPath string) (int64, error) {
+	// #nosec G304 - intentional: path supplied by the operator or a configured workflow
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Error("failed to close file: ", err)
+		}
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("unable to query file size: %w", err)
+	}
+
+	err = RetryOperation(func() error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		putCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		return store.Put(putCtx, key, f, fi.Size(), nil)
+	}, copyRetries)
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload file: %w", err)
+	}
+	return fi.Size(), nil
+}
+
+// downloadObject fetches an object to a local file, retrying on failure, and returns its size
+func downloadObject(ctx context.Context, store ObjectStore, key, localPath string) (int64, error) {
+	size, err := downloadToFile(localPath, func(w io.Writer) (int64, error) {
+		getCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		obj, info, err := store.Get(getCtx, key)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			_ = obj.Close()
+		}()
+		n, err := copyBuffered(w, io.LimitReader(obj, info.Size))
+		if err == nil && n < info.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to download object: %w", err)
+	}
+	return size, nil
+}
+
+// restoreChunkedFile reassembles a chunked upload from its manifest, retrying on failure, and
+// returns its size
+func restoreChunkedFile(ctx context.Context, store ObjectStore, manifestKey, localPath string) (int64, error) {
+	size, err := downloadToFile(localPath, func(w io.Writer) (int64, error) {
+		return restoreChunked(ctx, store, manifestKey, w, 30*time.Second)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore chunked object: %w", err)
+	}
+	return size, nil
+}
+
+// downloadToFile writes a file with fetch, retrying on failure. The file is written alongside
+// localPath and renamed once complete, so that a failed download leaves no partial file.
+func downloadToFile(localPath string, fetch func(io.Writer) (int64, error)) (int64, error) {
+	partPath := localPath + ".part"
+	var size int64
+	err := RetryOperation(func() error {
+		// #nosec G304 - intentional: path supplied by the operator or a configured workflow
+		f, err := os.Create(partPath)
+		if err != nil {
+			return err
+		}
+		size, err = fetch(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, copyRetries)
+	if err == nil {
+		err = os.Rename(partPath, localPath)
+	}
+	if err != nil {
+		_ = os.Remove(partPath)
+		return 0, err
+	}
+	return size, nil
+}
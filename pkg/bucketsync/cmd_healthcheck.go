@@ -0,0 +1,91 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// cmdHealthcheck implements the healthcheck subcommand, querying a running daemon's status endpoint
+func cmdHealthcheck(args []string) int {
+	fs := newCommandFlagSet("healthcheck")
+	addr := fs.String("addr", "", "Status endpoint address (default is status_listen from the configuration)")
+	timeout := fs.Duration("timeout", 3*time.Second, "How long to wait for a response")
+	strict := fs.Bool("strict", false, "Also fail when a workflow has crossed one of its alert thresholds")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd healthcheck [-addr host:port] [-timeout 3s] [-strict]")
+		return exitUsage
+	}
+	if *addr == "" {
+		if err := loadCommandConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitConfig
+		}
+		configMutex.RLock()
+		*addr = config.StatusListen
+		configMutex.RUnlock()
+		if *addr == "" {
+			fmt.Fprintln(os.Stderr, "Error: status_listen is not configured")
+			return exitConfig
+		}
+	}
+
+	report, err := checkHealth(statusURL(*addr), *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	fmt.Printf("%s (version %s, up %s)\n", report.Status, report.Version, report.Uptime)
+	for _, alert := range report.Alerts {
+		fmt.Println("  " + alert)
+	}
+	if *strict && report.Status != "ok" {
+		return exitError
+	}
+	return exitOK
+}
+
+// statusURL returns the URL of the status endpoint for a listen address, using the loopback
+// interface when the daemon listens on all interfaces
+func statusURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr + statusPath
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + statusPath
+}
+
+func checkHealth(url string, timeout time.Duration) (statusReport, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url) // #nosec G107 - intentional: URL is the configured status endpoint
+	if err != nil {
+		return statusReport{}, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return statusReport{}, fmt.Errorf("status endpoint returned %s", resp.Status)
+	}
+
+	var report statusReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return statusReport{}, fmt.Errorf("invalid status response: %w", err)
+	}
+	// A degraded daemon is still running, so only -strict treats it as unhealthy
+	if report.Status != "ok" && report.Status != statusDegraded {
+		return report, errors.New("daemon reported status " + report.Status)
+	}
+	return report, nil
+}
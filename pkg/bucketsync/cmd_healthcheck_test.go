@@ -0,0 +1,84 @@
+package bucketsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusURL(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"127.0.0.1:8989", "http://127.0.0.1:8989/status"},
+		{":8989", "http://localhost:8989/status"},
+		{"0.0.0.0:8989", "http://localhost:8989/status"},
+		{"[::]:8989", "http://localhost:8989/status"},
+		{"status.internal:80", "http://status.internal:80/status"},
+	}
+	for _, tt := range tests {
+		if got := statusURL(tt.addr); got != tt.want {
+			t.Errorf("statusURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(handleStatus))
+	defer healthy.Close()
+
+	report, err := checkHealth(healthy.URL, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Status != "ok" || report.Version != version {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	if _, err := checkHealth(failing.URL, time.Second); err == nil {
+		t.Error("expected error for unhealthy status endpoint")
+	}
+}
+
+func TestCheckHealthUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleStatus))
+	url := srv.URL
+	srv.Close()
+
+	if _, err := checkHealth(url, time.Second); err == nil {
+		t.Error("expected error when nothing is listening")
+	}
+}
+
+func TestCmdHealthcheckUsage(t *testing.T) {
+	if code := cmdHealthcheck([]string{"extra"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCmdHealthcheckStrict(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	w.setThresholds(Thresholds{StaleAfter: time.Hour})
+	w.checkThresholds(time.Now().Add(2 * time.Hour))
+
+	srv := httptest.NewServer(http.HandlerFunc(handleStatus))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	// A degraded daemon passes unless -strict is given
+	if code := cmdHealthcheck([]string{"-addr", addr}); code != exitOK {
+		t.Errorf("expected exit code %d, got %d", exitOK, code)
+	}
+	if code := cmdHealthcheck([]string{"-addr", addr, "-strict"}); code != exitError {
+		t.Errorf("expected exit code %d with -strict, got %d", exitError, code)
+	}
+}
@@ -0,0 +1,102 @@
+package bucketsync
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// cmdInventory implements the inventory subcommand, exporting the transfers recorded in the state file
+func cmdInventory(args []string) int {
+	fs := newCommandFlagSet("inventory")
+	format := fs.String("format", "csv", "Output format: csv or json")
+	workflow := fs.String("workflow", "", "Only include transfers for this workflow")
+	status := fs.String("status", "", "Only include transfers with this status (success or failed)")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd inventory [-format csv|json] [-workflow name] [-status success|failed]")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	configMutex.RLock()
+	stateFile := config.StateFile
+	configMutex.RUnlock()
+	if stateFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: no state_file configured")
+		return exitConfig
+	}
+
+	records, err := readTransferRecords(stateFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	records = filterTransferRecords(records, *workflow, *status)
+
+	if *format == "json" {
+		err = writeInventoryJSON(os.Stdout, records)
+	} else {
+		err = writeInventoryCSV(os.Stdout, records)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func filterTransferRecords(records []TransferRecord, workflow, status string) []TransferRecord {
+	filtered := []TransferRecord{}
+	for _, rec := range records {
+		if (workflow == "" || rec.Workflow == workflow) && (status == "" || rec.Status == status) {
+			filtered = append(filtered, rec)
+		}
+	}
+	return filtered
+}
+
+func writeInventoryJSON(w io.Writer, records []TransferRecord) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func writeInventoryCSV(w io.Writer, records []TransferRecord) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "workflow", "direction", "path", "remote", "bucket", "key", "size", "checksum", "status", "error", "transfer_id", "cid"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		row := []string{
+			rec.Time.UTC().Format(time.RFC3339),
+			rec.Workflow,
+			rec.Direction,
+			rec.Path,
+			rec.Remote,
+			rec.Bucket,
+			rec.Key,
+			strconv.FormatInt(rec.Size, 10),
+			rec.Checksum,
+			rec.Status,
+			rec.Error,
+			rec.ID,
+			rec.CID,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,68 @@
+package bucketsync
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func testTransferRecords() []TransferRecord {
+	when := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []TransferRecord{
+		{Time: when, Workflow: "out", Direction: directionUpload, Path: "/src/a, b.pdf", Remote: "minio", Bucket: "bucket", Key: "p/a, b.pdf", Size: 10, Checksum: "abc", Status: transferSuccess, ID: "0123456789abcdef"},
+		{Time: when, Workflow: "in", Direction: directionDownload, Path: "/dst/c", Key: "c", Status: transferFailed, Error: "boom"},
+	}
+}
+
+func TestFilterTransferRecords(t *testing.T) {
+	records := testTransferRecords()
+	if got := filterTransferRecords(records, "", ""); len(got) != 2 {
+		t.Errorf("expected all records, got %d", len(got))
+	}
+	if got := filterTransferRecords(records, "out", ""); len(got) != 1 || got[0].Workflow != "out" {
+		t.Errorf("unexpected workflow filter result: %+v", got)
+	}
+	if got := filterTransferRecords(records, "", transferFailed); len(got) != 1 || got[0].Workflow != "in" {
+		t.Errorf("unexpected status filter result: %+v", got)
+	}
+}
+
+func TestWriteInventoryCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeInventoryCSV(&buf, testTransferRecords()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 rows, got %d", len(rows))
+	}
+	if rows[0][0] != "time" || rows[1][3] != "/src/a, b.pdf" || rows[1][7] != "10" || rows[2][10] != "boom" || rows[1][11] != "0123456789abcdef" {
+		t.Errorf("unexpected CSV rows: %v", rows)
+	}
+}
+
+func TestWriteInventoryJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeInventoryJSON(&buf, testTransferRecords()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded []TransferRecord
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0].Checksum != "abc" {
+		t.Errorf("unexpected decoded records: %+v", decoded)
+	}
+}
+
+func TestCmdInventoryUsage(t *testing.T) {
+	if code := cmdInventory([]string{"-format", "xml"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,102 @@
+package bucketsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// listEntry describes an object (or common prefix) returned by the ls subcommand
+type listEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	IsPrefix     bool      `json:"is_prefix,omitempty"`
+}
+
+// cmdList implements the ls subcommand, listing objects under a bucket prefix
+func cmdList(args []string) int {
+	fs := newCommandFlagSet("ls")
+	remoteName := fs.String("remote", "", "Name of the remote to use")
+	recursive := fs.Bool("recursive", false, "List all objects under the prefix")
+	asJSON := fs.Bool("json", false, "Output as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || !isObjectURL(positional[0]) {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd ls [-remote name] [-recursive] [-json] s3://bucket/prefix/")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+
+	entries, err := listObjects(context.Background(), positional[0], *remoteName, *recursive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+
+	if *asJSON {
+		err = writeListJSON(os.Stdout, entries)
+	} else {
+		err = writeListTable(os.Stdout, entries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func listObjects(ctx context.Context, objectURL, remoteName string, recursive bool) ([]listEntry, error) {
+	remote, bucket, prefix, err := resolveObjectURL(objectURL, remoteName)
+	if err != nil {
+		return nil, err
+	}
+	store, err := bucketStore(remote, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := store.List(ctx, prefix, recursive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	entries := []listEntry{}
+	for _, obj := range objects {
+		entries = append(entries, listEntry{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+			ETag:         obj.ETag,
+			IsPrefix:     obj.IsPrefix,
+		})
+	}
+	return entries, nil
+}
+
+func writeListJSON(w io.Writer, entries []listEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func writeListTable(w io.Writer, entries []listEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		if e.IsPrefix {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", "", "PRE", "", e.Key)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", e.LastModified.UTC().Format(time.RFC3339), e.Size, e.ETag, e.Key)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,57 @@
+package bucketsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteListTable(t *testing.T) {
+	entries := []listEntry{
+		{Key: "reports/", IsPrefix: true},
+		{Key: "reports.pdf", Size: 1024, LastModified: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), ETag: "abc123"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeListTable(&buf, entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], "PRE") || !strings.HasSuffix(lines[0], "reports/") {
+		t.Errorf("unexpected prefix line: %q", lines[0])
+	}
+	for _, want := range []string{"2026-01-02T03:04:05Z", "1024", "abc123", "reports.pdf"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("expected %q in object line: %q", want, lines[1])
+		}
+	}
+}
+
+func TestWriteListJSON(t *testing.T) {
+	entries := []listEntry{{Key: "a.txt", Size: 3, ETag: "etag"}}
+
+	var buf bytes.Buffer
+	if err := writeListJSON(&buf, entries); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded []listEntry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0].Key != "a.txt" || decoded[0].Size != 3 || decoded[0].ETag != "etag" {
+		t.Errorf("unexpected decoded entries: %+v", decoded)
+	}
+}
+
+func TestCmdListUsage(t *testing.T) {
+	if code := cmdList([]string{"/local/path"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
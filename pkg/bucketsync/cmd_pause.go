@@ -0,0 +1,49 @@
+package bucketsync
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// cmdPause implements the pause subcommand, pausing a running daemon's workflow via its control socket
+func cmdPause(args []string) int {
+	return controlWorkflow("pause", args)
+}
+
+// cmdResume implements the resume subcommand, resuming a paused workflow via the control socket
+func cmdResume(args []string) int {
+	return controlWorkflow("resume", args)
+}
+
+func controlWorkflow(action string, args []string) int {
+	fs := newCommandFlagSet(action)
+	socket := fs.String("socket", "", "Control socket path (default is control_socket from the configuration)")
+	timeout := fs.Duration("timeout", 3*time.Second, "How long to wait for a response")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: bucketsyncd %s [-socket path] <workflow>\n", action)
+		return exitUsage
+	}
+	if code := resolveControlSocket(socket); code != exitOK {
+		return code
+	}
+
+	var status workflowStatus
+	path := "/api/workflows/" + url.PathEscape(positional[0]) + "/" + action
+	if err := socketRequest(socketClient(*socket, *timeout), http.MethodPost, path, &status); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	state := "running"
+	if status.Paused {
+		state = "paused"
+	}
+	fmt.Printf("%s: %s (queue %d)\n", status.Name, state, status.QueueDepth)
+	return exitOK
+}
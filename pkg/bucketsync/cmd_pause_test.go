@@ -0,0 +1,45 @@
+package bucketsync
+
+import "testing"
+
+func TestCmdPauseResume(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	socket := testSocketPath(t)
+	srv, err := startAdminSocket(socket, false)
+	if err != nil {
+		t.Fatalf("failed to start control socket: %v", err)
+	}
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	if code := cmdPause([]string{"-socket", socket, "photos"}); code != exitOK {
+		t.Errorf("pause: expected exit code %d, got %d", exitOK, code)
+	}
+	if !w.isPaused() {
+		t.Error("expected workflow to be paused")
+	}
+
+	if code := cmdResume([]string{"-socket", socket, "photos"}); code != exitOK {
+		t.Errorf("resume: expected exit code %d, got %d", exitOK, code)
+	}
+	if w.isPaused() {
+		t.Error("expected workflow to be running")
+	}
+
+	if code := cmdPause([]string{"-socket", socket, "missing"}); code != exitError {
+		t.Errorf("unknown workflow: expected exit code %d, got %d", exitError, code)
+	}
+}
+
+func TestCmdPauseUsage(t *testing.T) {
+	for _, args := range [][]string{{}, {"a", "b"}} {
+		if code := cmdPause(args); code != exitUsage {
+			t.Errorf("pause %v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+		if code := cmdResume(args); code != exitUsage {
+			t.Errorf("resume %v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// maxPresignExpiry is the longest validity period S3 accepts for a presigned URL
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// cmdPresign implements the presign subcommand, printing a time-limited download URL for an object
+func cmdPresign(args []string) int {
+	fs := newCommandFlagSet("presign")
+	remoteName := fs.String("remote", "", "Name of the remote to use")
+	expires := fs.Duration("expires", time.Hour, "How long the URL remains valid (maximum 168h)")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || !isObjectURL(positional[0]) {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd presign [-remote name] [-expires 24h] s3://bucket/key")
+		return exitUsage
+	}
+	if err := validatePresignExpiry(*expires); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+
+	u, err := presignObject(context.Background(), positional[0], *remoteName, *expires)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	fmt.Println(u)
+	return exitOK
+}
+
+func validatePresignExpiry(d time.Duration) error {
+	if d < time.Second || d > maxPresignExpiry {
+		return fmt.Errorf("expiry must be between 1s and %s", maxPresignExpiry)
+	}
+	return nil
+}
+
+func presignObject(ctx context.Context, objectURL, remoteName string, expires time.Duration) (*url.URL, error) {
+	remote, bucket, key, err := resolveObjectURL(objectURL, remoteName)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" || isPrefixURL(objectURL) {
+		return nil, fmt.Errorf("invalid object URL %q (missing key)", objectURL)
+	}
+	store, err := bucketStore(remote, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := store.Presign(ctx, key, expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign object URL: %w", err)
+	}
+	return u, nil
+}
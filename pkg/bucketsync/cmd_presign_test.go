@@ -0,0 +1,45 @@
+package bucketsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValidatePresignExpiry(t *testing.T) {
+	tests := []struct {
+		expires time.Duration
+		wantErr bool
+	}{
+		{time.Hour, false},
+		{24 * time.Hour, false},
+		{maxPresignExpiry, false},
+		{0, true},
+		{-time.Hour, true},
+		{maxPresignExpiry + time.Second, true},
+	}
+
+	for _, tt := range tests {
+		err := validatePresignExpiry(tt.expires)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validatePresignExpiry(%s) error = %v, wantErr %v", tt.expires, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPresignObjectRequiresKey(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = Config{Remotes: []Remote{{Name: "minio1", Endpoint: "minio.example.com"}}}
+
+	if _, err := presignObject(context.Background(), "s3://bucket/path/", "", time.Hour); err == nil {
+		t.Error("expected error presigning a prefix")
+	}
+}
+
+func TestCmdPresignInvalidExpiry(t *testing.T) {
+	if code := cmdPresign([]string{"-expires", "720h", "s3://bucket/key"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,155 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reconcileUpload   = "upload"
+	reconcileDownload = "download"
+	reconcileDelete   = "delete"
+	reconcileSkip     = "skip"
+)
+
+// reconcileAction is the repair planned for a single verification result
+type reconcileAction struct {
+	Action string
+	Item   verifyItem
+}
+
+// cmdReconcile implements the reconcile subcommand, repairing drift between an outbound workflow's
+// local files and its destination
+func cmdReconcile(args []string) int {
+	fs := newCommandFlagSet("reconcile")
+	workflow := fs.String("workflow", "", "Name of the outbound workflow to reconcile")
+	sizeOnly := fs.Bool("size-only", false, "Compare sizes only, skipping checksums")
+	download := fs.Bool("download", false, "Download objects which exist only in the destination")
+	deleteExtra := fs.Bool("delete", false, "Delete objects which exist only in the destination")
+	dryRun := fs.Bool("dry-run", false, "Show what would be done without changing anything")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	if *workflow == "" || (*download && *deleteExtra) {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd reconcile -workflow name [-download | -delete] [-size-only] [-dry-run]")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	o, ok := findOutbound(*workflow)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: no outbound workflow named %q\n", *workflow)
+		return exitConfig
+	}
+
+	ctx := context.Background()
+	target, err := resolveVerifyTarget(o)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	items, err := verifyOutbound(ctx, o, target, !*sizeOnly)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+
+	actions := planReconcile(items, target, *download, *deleteExtra)
+	if err := executeReconcile(ctx, os.Stdout, o, target, actions, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+// planReconcile decides what to do about each discrepancy: local files are uploaded when missing
+// or different, and objects only present remotely are downloaded, deleted or left alone
+func planReconcile(items []verifyItem, t verifyTarget, download, deleteExtra bool) []reconcileAction {
+	var actions []reconcileAction
+	for _, item := range items {
+		switch item.Status {
+		case verifyMissing, verifyMismatch:
+			actions = append(actions, reconcileAction{Action: reconcileUpload, Item: item})
+		case verifyExtra:
+			action := reconcileSkip
+			switch {
+			case download:
+				action = reconcileDownload
+				item.Path = filepath.Join(t.localFolder, path.Base(item.Key))
+			case deleteExtra:
+				action = reconcileDelete
+			}
+			actions = append(actions, reconcileAction{Action: action, Item: item})
+		}
+	}
+	return actions
+}
+
+// executeReconcile carries out the planned actions, reporting each one, and fails if any did
+func executeReconcile(ctx context.Context, w io.Writer, o Outbound, t verifyTarget, actions []reconcileAction, dryRun bool) error {
+	lf := log.Fields{
+		"workflow": o.Name,
+		"bucket":   t.bucket,
+	}
+	failed := 0
+	var lastErr error
+	for _, a := range actions {
+		desc := describeReconcileAction(a, t.bucket)
+		if dryRun || a.Action == reconcileSkip {
+			if dryRun {
+				desc = "(dry-run) " + desc
+			}
+			fmt.Fprintln(w, desc)
+			continue
+		}
+
+		var err error
+		switch a.Action {
+		case reconcileUpload:
+			_, err = uploadFile(ctx, t.store, a.Item.Key, a.Item.Path)
+		case reconcileDownload:
+			_, err = downloadObject(ctx, t.store, a.Item.Key, a.Item.Path)
+		case reconcileDelete:
+			err = t.store.Remove(ctx, a.Item.Key)
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+			log.WithFields(lf).WithFields(log.Fields{"key": a.Item.Key}).Errorf("failed to %s: %s", a.Action, err)
+			fmt.Fprintf(w, "FAILED %s: %s\n", desc, err)
+			continue
+		}
+		log.WithFields(lf).WithFields(log.Fields{"key": a.Item.Key, "name": a.Item.Path}).Infof("reconciled (%s)", a.Action)
+		fmt.Fprintln(w, desc)
+	}
+	if failed > 0 {
+		return &batchError{Op: "actions", Failed: failed, Total: len(actions), Err: lastErr}
+	}
+	if len(actions) == 0 {
+		fmt.Fprintln(w, "nothing to do")
+	}
+	return nil
+}
+
+func describeReconcileAction(a reconcileAction, bucket string) string {
+	object := fmt.Sprintf("s3://%s/%s", bucket, a.Item.Key)
+	switch a.Action {
+	case reconcileUpload:
+		return fmt.Sprintf("upload: %s -> %s (%s)", a.Item.Path, object, a.Item.Status)
+	case reconcileDownload:
+		return fmt.Sprintf("download: %s -> %s", object, a.Item.Path)
+	case reconcileDelete:
+		return fmt.Sprintf("delete: %s", object)
+	case reconcileSkip:
+		return fmt.Sprintf("skip: %s (only in destination)", object)
+	}
+	return a.Action + ": " + object
+}
@@ -0,0 +1,91 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testReconcileItems() []verifyItem {
+	return []verifyItem{
+		{Status: verifyOK, Path: "/src/a", Key: "p/a"},
+		{Status: verifyMissing, Path: "/src/b", Key: "p/b"},
+		{Status: verifyExtra, Key: "p/c"},
+		{Status: verifyMismatch, Path: "/src/d", Key: "p/d"},
+	}
+}
+
+func TestPlanReconcile(t *testing.T) {
+	target := verifyTarget{bucket: "bucket", prefix: "p", localFolder: "/src"}
+
+	tests := []struct {
+		name        string
+		download    bool
+		deleteExtra bool
+		wantExtra   string
+	}{
+		{"upload only", false, false, reconcileSkip},
+		{"download extras", true, false, reconcileDownload},
+		{"delete extras", false, true, reconcileDelete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := planReconcile(testReconcileItems(), target, tt.download, tt.deleteExtra)
+			if len(actions) != 3 {
+				t.Fatalf("expected 3 actions, got %d: %+v", len(actions), actions)
+			}
+			got := make(map[string]reconcileAction)
+			for _, a := range actions {
+				got[a.Item.Key] = a
+			}
+			if got["p/b"].Action != reconcileUpload || got["p/d"].Action != reconcileUpload {
+				t.Errorf("expected missing and mismatched files to be uploaded: %+v", actions)
+			}
+			if got["p/c"].Action != tt.wantExtra {
+				t.Errorf("expected extra object action %q, got %q", tt.wantExtra, got["p/c"].Action)
+			}
+			if tt.download && got["p/c"].Item.Path != filepath.Join("/src", "c") {
+				t.Errorf("unexpected download path %q", got["p/c"].Item.Path)
+			}
+		})
+	}
+}
+
+func TestExecuteReconcileDryRun(t *testing.T) {
+	target := verifyTarget{bucket: "bucket", prefix: "p", localFolder: "/src"}
+	actions := planReconcile(testReconcileItems(), target, false, true)
+
+	var buf bytes.Buffer
+	if err := executeReconcile(context.Background(), &buf, Outbound{Name: "test"}, target, actions, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"(dry-run) upload: /src/b -> s3://bucket/p/b (missing)",
+		"(dry-run) upload: /src/d -> s3://bucket/p/d (mismatch)",
+		"(dry-run) delete: s3://bucket/p/c",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestExecuteReconcileNothingToDo(t *testing.T) {
+	var buf bytes.Buffer
+	if err := executeReconcile(context.Background(), &buf, Outbound{}, verifyTarget{}, nil, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "nothing to do\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestCmdReconcileUsage(t *testing.T) {
+	if code := cmdReconcile([]string{"-workflow", "x", "-download", "-delete"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,45 @@
+package bucketsync
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// reloadResult is the admin API's response to a reload, naming the workflows it started
+type reloadResult struct {
+	Started []string `json:"started"`
+}
+
+// cmdReload implements the reload subcommand, having a running daemon read its configuration
+// file again via the control socket
+func cmdReload(args []string) int {
+	fs := newCommandFlagSet("reload")
+	socket := fs.String("socket", "", "Control socket path (default is control_socket from the configuration)")
+	timeout := fs.Duration("timeout", 3*time.Second, "How long to wait for a response")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd reload [-socket path]")
+		return exitUsage
+	}
+	if code := resolveControlSocket(socket); code != exitOK {
+		return code
+	}
+
+	var result reloadResult
+	if err := socketRequest(socketClient(*socket, *timeout), http.MethodPost, "/api/reload", &result); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	if len(result.Started) == 0 {
+		fmt.Println("configuration reloaded, no workflows started")
+	} else {
+		fmt.Println("configuration reloaded, started", strings.Join(result.Started, ", "))
+	}
+	return exitOK
+}
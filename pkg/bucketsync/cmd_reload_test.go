@@ -0,0 +1,35 @@
+package bucketsync
+
+import "testing"
+
+func TestCmdReload(t *testing.T) {
+	resetOutboundWorkflows(t)
+	resetWorkflows(t)
+	useReloadConfig(t, reloadTestConfig(t.TempDir(), true))
+	socket := testSocketPath(t)
+	srv, err := startAdminSocket(socket, false)
+	if err != nil {
+		t.Fatalf("failed to start control socket: %v", err)
+	}
+	defer func() {
+		_ = srv.Close()
+	}()
+
+	if code := cmdReload([]string{"-socket", socket}); code != exitOK {
+		t.Errorf("reload: expected exit code %d, got %d", exitOK, code)
+	}
+	if _, ok := findWorkflow("staged"); !ok {
+		t.Error("expected the reload to start the enabled workflow")
+	}
+
+	useReloadConfig(t, "outbound:\n  - name: broken\n")
+	if code := cmdReload([]string{"-socket", socket}); code != exitError {
+		t.Errorf("invalid configuration: expected exit code %d, got %d", exitError, code)
+	}
+}
+
+func TestCmdReloadUsage(t *testing.T) {
+	if code := cmdReload([]string{"extra"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,230 @@
+package bucketsync
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errNoTransferHistory is returned when neither a state file nor an audit trail is configured
+var errNoTransferHistory = errors.New("no state_file or audit.file configured")
+
+// cmdReplayAudit implements the replay-audit subcommand, which transfers again what a workflow
+// transferred successfully since a given time, as recorded in the state file or audit trail,
+// for instance after its destination bucket was wiped
+func cmdReplayAudit(args []string) int {
+	fs := newCommandFlagSet("replay-audit")
+	from := fs.String("from", "", "Replay transfers made since this time, as RFC 3339 or a duration ago such as 24h")
+	workflow := fs.String("workflow", "", "Name of the workflow whose transfers to replay")
+	direction := fs.String("direction", "", "Only replay uploads or downloads")
+	dryRun := fs.Bool("dry-run", false, "Show what would be replayed without transferring anything")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	since, err := parseReplayFrom(*from, time.Now())
+	if err != nil || *workflow == "" || (*direction != "" && *direction != directionUpload && *direction != directionDownload) {
+		if err != nil && *from != "" {
+			fmt.Fprintln(os.Stderr, "Error: -from:", err)
+		}
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd replay-audit -from time -workflow name [-direction upload|download] [-dry-run]")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+
+	records, err := transferHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		if errors.Is(err, errNoTransferHistory) {
+			return exitConfig
+		}
+		return exitError
+	}
+	records = replayableTransfers(records, *workflow, *direction, since)
+	if err := replayTransfers(os.Stdout, *workflow, records, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+// parseReplayFrom parses the -from time, either absolute or a duration before now
+func parseReplayFrom(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("a time is required")
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time, a date nor a duration", s)
+}
+
+// transferHistory reads the recorded transfers from the state file or, without one, the audit trail
+func transferHistory() ([]TransferRecord, error) {
+	configMutex.RLock()
+	stateFile, auditFile := config.StateFile, config.Audit.File
+	configMutex.RUnlock()
+	switch {
+	case stateFile != "":
+		records, err := readTransferRecords(stateFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return records, err
+	case auditFile != "":
+		// #nosec G304 - intentional: path comes from the configuration file
+		f, err := os.Open(auditFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		var records []TransferRecord
+		err = scanAuditEntries(f, func(e auditEntry) error {
+			records = append(records, auditTransferRecord(e))
+			return nil
+		})
+		return records, err
+	}
+	return nil, errNoTransferHistory
+}
+
+// auditTransferRecord recovers the transfer an audit entry describes, undoing transferLocation
+func auditTransferRecord(e auditEntry) TransferRecord {
+	rec := TransferRecord{
+		Time:      e.Time,
+		Workflow:  e.Workflow,
+		Direction: e.Action,
+		Path:      e.Source,
+		Size:      e.Size,
+		Checksum:  e.Checksum,
+		Status:    e.Result,
+		Error:     e.Error,
+		ID:        e.TransferID,
+	}
+	remote := e.Destination
+	if e.Action == directionDownload {
+		rec.Path, remote = e.Destination, e.Source
+	}
+	if u, err := url.Parse(remote); err == nil {
+		rec.Remote = u.Host
+		switch u.Scheme {
+		case "s3":
+			rec.Bucket, rec.Key, _ = strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		case "ipfs":
+			rec.CID = u.Host
+		default:
+			rec.Key = u.Path
+		}
+	}
+	return rec
+}
+
+// replayableTransfers picks the workflow's successful transfers since the given time, the latest
+// of each file's or object's only, oldest first
+func replayableTransfers(records []TransferRecord, workflow, direction string, since time.Time) []TransferRecord {
+	latest := make(map[string]int)
+	var picked []TransferRecord
+	for _, rec := range records {
+		if rec.Workflow != workflow || rec.Status != transferSuccess || rec.Time.Before(since) {
+			continue
+		}
+		if rec.Direction != directionUpload && rec.Direction != directionDownload {
+			continue
+		}
+		if direction != "" && rec.Direction != direction {
+			continue
+		}
+		id := rec.Direction + "\x00" + rec.Path
+		if rec.Direction == directionDownload {
+			id = rec.Direction + "\x00" + rec.Bucket + "\x00" + rec.Key
+		}
+		if i, ok := latest[id]; ok {
+			picked[i] = rec
+			continue
+		}
+		latest[id] = len(picked)
+		picked = append(picked, rec)
+	}
+	return picked
+}
+
+// replayTransfers uploads or downloads each recorded transfer again through the workflow, as its
+// watcher or consumer would, so that its checks, processing, records and notifications apply
+func replayTransfers(w io.Writer, workflow string, records []TransferRecord, dryRun bool) error {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "nothing to replay")
+		return nil
+	}
+	o, isOutbound := findOutbound(workflow)
+	in, isInbound := findInbound(workflow)
+	failed := 0
+	var lastErr error
+	for _, rec := range records {
+		desc := fmt.Sprintf("%s %s", rec.Direction, rec.Path)
+		if rec.Direction == directionDownload {
+			desc = fmt.Sprintf("download %s", transferLocation(rec))
+		}
+		var err error
+		switch {
+		case rec.Direction == directionUpload && !isOutbound:
+			err = fmt.Errorf("no outbound workflow named %q", workflow)
+		case rec.Direction == directionUpload && (o.Fifo != "" || len(o.Command) > 0):
+			err = errors.New("streamed sources cannot be replayed")
+		case rec.Direction == directionDownload && !isInbound:
+			err = fmt.Errorf("no inbound workflow named %q", workflow)
+		case rec.Direction == directionDownload && rec.Bucket == "":
+			err = errors.New("only downloads from buckets can be replayed")
+		case dryRun:
+			fmt.Fprintln(w, "(dry-run) "+desc)
+			continue
+		case rec.Direction == directionUpload:
+			err = replayUpload(w, o, rec)
+		default:
+			// Objects notified over AMQP may be in a bucket other than the one polled
+			in.Bucket = rec.Bucket
+			err = downloadPolled(workflowLogFields(in.Name, in.Labels, log.Fields{"bucket": rec.Bucket}), in, rec.Key)
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+			fmt.Fprintf(w, "FAILED %s: %s\n", desc, err)
+			continue
+		}
+		fmt.Fprintln(w, desc)
+	}
+	if failed > 0 {
+		return &batchError{Op: "transfers", Failed: failed, Total: len(records), Err: lastErr}
+	}
+	return nil
+}
+
+// replayUpload uploads a file again, noting if its content is no longer what was recorded
+func replayUpload(w io.Writer, o Outbound, rec TransferRecord) error {
+	sum, err := fileChecksum(rec.Path)
+	if err != nil {
+		return err
+	}
+	// A processor's output is uploaded, and checksummed, in the file's place
+	if o.ProcessWith == "" && rec.Checksum != "" && hex.EncodeToString(sum[:]) != rec.Checksum {
+		fmt.Fprintf(w, "note: %s has changed since it was uploaded, uploading its current content\n", rec.Path)
+	}
+	return uploadEvent(workflowLogFields(o.Name, o.Labels, nil), o, rec.Path)
+}
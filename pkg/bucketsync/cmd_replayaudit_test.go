@@ -0,0 +1,131 @@
+package bucketsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReplayFrom(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"24h", now.Add(-24 * time.Hour)},
+		{"2026-10-15T08:30:00Z", time.Date(2026, 10, 15, 8, 30, 0, 0, time.UTC)},
+		{"2026-10-01", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseReplayFrom(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseReplayFrom(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseReplayFrom("yesterday", now); err == nil {
+		t.Error("expected error for an unparseable time")
+	}
+}
+
+func TestAuditTransferRecord(t *testing.T) {
+	up := testAuditRecord("a.pdf")
+	down := testAuditRecord("b.pdf")
+	down.Direction, down.Path = directionDownload, "/dst/b.pdf"
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestAudit(t, Audit{File: path}, up, down)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var got []TransferRecord
+	if err := scanAuditEntries(f, func(e auditEntry) error {
+		got = append(got, auditTransferRecord(e))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []TransferRecord{up, down} {
+		if got[i].Path != want.Path || got[i].Remote != want.Remote || got[i].Bucket != want.Bucket || got[i].Key != want.Key || got[i].Checksum != want.Checksum {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
+
+func TestReplayableTransfers(t *testing.T) {
+	since := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	rec := func(day int, path, status string) TransferRecord {
+		return TransferRecord{
+			Time:      since.AddDate(0, 0, day),
+			Workflow:  "docs",
+			Direction: directionUpload,
+			Path:      path,
+			Status:    status,
+		}
+	}
+	records := []TransferRecord{
+		rec(-1, "/src/old.pdf", transferSuccess),
+		rec(1, "/src/a.pdf", transferSuccess),
+		rec(2, "/src/b.pdf", transferFailed),
+		rec(3, "/src/a.pdf", transferSuccess),
+		{Time: since.AddDate(0, 0, 4), Workflow: "other", Direction: directionUpload, Path: "/x", Status: transferSuccess},
+	}
+	got := replayableTransfers(records, "docs", "", since)
+	if len(got) != 1 || got[0].Path != "/src/a.pdf" || !got[0].Time.Equal(since.AddDate(0, 0, 3)) {
+		t.Errorf("unexpected transfers %+v", got)
+	}
+	if got := replayableTransfers(records, "docs", directionDownload, since); len(got) != 0 {
+		t.Errorf("expected no downloads, got %+v", got)
+	}
+}
+
+func TestReplayTransfers(t *testing.T) {
+	resetWorkflows(t)
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	_ = os.WriteFile(name, []byte("alpha"), 0600)
+	config = Config{Outbound: []Outbound{{
+		Name:        "docs",
+		Source:      filepath.Join(dir, "*.txt"),
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+	}}}
+	records := []TransferRecord{
+		{Workflow: "docs", Direction: directionUpload, Path: name, Status: transferSuccess, Checksum: "stale"},
+		{Workflow: "docs", Direction: directionUpload, Path: filepath.Join(dir, "gone.txt"), Status: transferSuccess},
+	}
+
+	var out bytes.Buffer
+	if err := replayTransfers(&out, "docs", records, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "(dry-run) upload "+name) {
+		t.Errorf("unexpected dry run:\n%s", out.String())
+	}
+
+	out.Reset()
+	err := replayTransfers(&out, "docs", records, false)
+	if exitCodeFor(err) != exitPartial {
+		t.Errorf("expected a partial failure for the missing file, got %v", err)
+	}
+	select {
+	case u := <-uploads:
+		if u.path != "/uploads/a.txt" || u.body != "alpha" {
+			t.Errorf("uploaded %+v", u)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("file was not uploaded again")
+	}
+	if !strings.Contains(out.String(), "has changed since it was uploaded") || !strings.Contains(out.String(), "FAILED upload "+filepath.Join(dir, "gone.txt")) {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,99 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cmdRemove implements the rm subcommand, removing an object or every object under a prefix
+func cmdRemove(args []string) int {
+	fs := newCommandFlagSet("rm")
+	remoteName := fs.String("remote", "", "Name of the remote to use")
+	recursive := fs.Bool("recursive", false, "Remove all objects under the prefix")
+	dryRun := fs.Bool("dry-run", false, "Show what would be removed without removing anything")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 || !isObjectURL(positional[0]) {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd rm [-remote name] [-recursive] [-dry-run] s3://bucket/key")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+
+	if err := removeObjects(context.Background(), os.Stdout, positional[0], *remoteName, *recursive, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	return exitOK
+}
+
+func removeObjects(ctx context.Context, w io.Writer, objectURL, remoteName string, recursive, dryRun bool) error {
+	remote, bucket, key, err := resolveObjectURL(objectURL, remoteName)
+	if err != nil {
+		return err
+	}
+	if !recursive && (key == "" || isPrefixURL(objectURL)) {
+		return fmt.Errorf("%q refers to a prefix, use -recursive to remove its contents", objectURL)
+	}
+	store, err := bucketStore(remote, bucket)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{key}
+	if recursive {
+		objects, err := store.List(ctx, key, true)
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		keys = nil
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	lf := log.Fields{
+		"remote": remote.Name,
+		"bucket": bucket,
+	}
+	failed := 0
+	var lastErr error
+	for _, k := range keys {
+		if dryRun {
+			fmt.Fprintf(w, "(dry-run) delete: s3://%s/%s\n", bucket, k)
+			continue
+		}
+		err := RetryOperation(func() error {
+			rmCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			return store.Remove(rmCtx, k)
+		}, copyRetries)
+		if err != nil {
+			failed++
+			lastErr = err
+			log.WithFields(lf).WithFields(log.Fields{"key": k}).Error("failed to remove object: ", err)
+			continue
+		}
+		log.WithFields(lf).WithFields(log.Fields{"key": k}).Info("removed object")
+		fmt.Fprintf(w, "delete: s3://%s/%s\n", bucket, k)
+	}
+	if failed > 0 {
+		return &batchError{Op: "object removals", Failed: failed, Total: len(keys), Err: lastErr}
+	}
+	return nil
+}
+
+// isPrefixURL reports whether an object URL refers to a prefix rather than a single object
+func isPrefixURL(objectURL string) bool {
+	return strings.HasSuffix(objectURL, "/")
+}
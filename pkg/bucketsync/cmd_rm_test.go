@@ -0,0 +1,54 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestIsPrefixURL(t *testing.T) {
+	if !isPrefixURL("s3://bucket/path/") {
+		t.Error("expected trailing slash to denote a prefix")
+	}
+	if isPrefixURL("s3://bucket/path/file.txt") {
+		t.Error("expected object URL not to be a prefix")
+	}
+}
+
+func TestRemoveObjectsRequiresRecursiveForPrefix(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = Config{Remotes: []Remote{{Name: "minio1", Endpoint: "minio.example.com"}}}
+
+	var buf bytes.Buffer
+	for _, u := range []string{"s3://bucket/path/", "s3://bucket"} {
+		if err := removeObjects(context.Background(), &buf, u, "", false, true); err == nil {
+			t.Errorf("expected error removing prefix %q without -recursive", u)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no output, got %q", buf.String())
+	}
+}
+
+func TestRemoveObjectsDryRun(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = Config{Remotes: []Remote{{Name: "minio1", Endpoint: "minio.example.com"}}}
+
+	var buf bytes.Buffer
+	if err := removeObjects(context.Background(), &buf, "s3://bucket/path/file.txt", "", false, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "(dry-run) delete: s3://bucket/path/file.txt\n" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestCmdRemoveUsage(t *testing.T) {
+	if code := cmdRemove([]string{}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
@@ -0,0 +1,58 @@
+package bucketsync
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	serviceName        = "bucketsyncd"
+	serviceDisplayName = "Bucket Sync Daemon"
+	serviceDescription = "Synchronises local folders with S3-compatible and WebDAV storage."
+)
+
+// cmdService implements the service subcommand, managing bucketsyncd as a native Windows service
+func cmdService(args []string) int {
+	fs := newCommandFlagSet("service")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd service install|uninstall|start|stop [-c config]")
+		return exitUsage
+	}
+
+	action := positional[0]
+	switch action {
+	case "install":
+		// The service is registered with the configuration it will run with, so check it first
+		if err := loadCommandConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitConfig
+		}
+	case "uninstall", "start", "stop":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown service action %q\n", action)
+		return exitUsage
+	}
+
+	if err := controlService(action); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	fmt.Printf("service %s: %s done\n", serviceName, action)
+	return exitOK
+}
+
+// serviceArgs returns the command-line options the installed service is started with
+func serviceArgs() []string {
+	args := []string{"-c", *configFilePath}
+	if *logLevelFlag != "" {
+		args = append(args, "-log-level", *logLevelFlag)
+	}
+	if logJSONFlag.set {
+		args = append(args, "-log-json="+logJSONFlag.String())
+	}
+	return args
+}
@@ -0,0 +1,45 @@
+package bucketsync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCmdServiceUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no action", nil},
+		{"too many arguments", []string{"install", "extra"}},
+		{"unknown action", []string{"restart"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := cmdService(tt.args); code != exitUsage {
+				t.Errorf("expected exit code %d, got %d", exitUsage, code)
+			}
+		})
+	}
+}
+
+func TestServiceArgs(t *testing.T) {
+	originalPath, originalLevel, originalJSON := *configFilePath, *logLevelFlag, *logJSONFlag
+	defer func() {
+		*configFilePath, *logLevelFlag, *logJSONFlag = originalPath, originalLevel, originalJSON
+	}()
+
+	*configFilePath = `C:\bucketsyncd\config.yaml`
+	*logLevelFlag = ""
+	*logJSONFlag = optionalBool{}
+	if got, want := serviceArgs(), []string{"-c", `C:\bucketsyncd\config.yaml`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	*logLevelFlag = debugLevel
+	*logJSONFlag = optionalBool{value: true, set: true}
+	want := []string{"-c", `C:\bucketsyncd\config.yaml`, "-log-level", "debug", "-log-json=true"}
+	if got := serviceArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package bucketsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// cmdStatus implements the status subcommand, summarising a running daemon's workflows
+// via its control socket
+func cmdStatus(args []string) int {
+	fs := newCommandFlagSet("status")
+	socket := fs.String("socket", "", "Control socket path (default is control_socket from the configuration)")
+	jsonOutput := fs.Bool("json", false, "Print the workflows as JSON")
+	timeout := fs.Duration("timeout", 3*time.Second, "How long to wait for a response")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd status [-socket path] [-json]")
+		return exitUsage
+	}
+	if code := resolveControlSocket(socket); code != exitOK {
+		return code
+	}
+
+	client := socketClient(*socket, *timeout)
+	var report statusReport
+	var statuses []workflowStatus
+	err = getSocketJSON(client, "/api/status", &report)
+	if err == nil {
+		err = getSocketJSON(client, "/api/workflows", &statuses)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: unable to query the daemon:", err)
+		return exitError
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(statuses)
+	} else {
+		err = printStatus(os.Stdout, report, statuses, time.Now())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// resolveControlSocket defaults an unset socket path to control_socket from the configuration
+func resolveControlSocket(socket *string) int {
+	if *socket != "" {
+		return exitOK
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	configMutex.RLock()
+	*socket = config.ControlSocket
+	configMutex.RUnlock()
+	if *socket == "" {
+		fmt.Fprintln(os.Stderr, "Error: control_socket is not configured")
+		return exitConfig
+	}
+	return exitOK
+}
+
+// socketClient returns an HTTP client which sends every request to a unix socket
+func socketClient(path string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func getSocketJSON(client *http.Client, path string, v any) error {
+	return socketRequest(client, http.MethodGet, path, v)
+}
+
+// socketRequest sends a request to the admin API over the control socket and decodes the
+// JSON response into v, returning the API's error message if it fails
+func socketRequest(client *http.Client, method, path string, v any) error {
+	// The host is ignored as every connection goes to the socket
+	req, err := http.NewRequest(method, "http://bucketsyncd"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// printStatus writes a table of workflows followed by totals since the daemon started
+func printStatus(w io.Writer, report statusReport, statuses []workflowStatus, now time.Time) error {
+	fmt.Fprintf(w, "bucketsyncd %s, up %s\n\n", report.Version, report.Uptime)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKFLOW\tKIND\tHEALTH\tQUEUE\tTRANSFERS\tERRORS\tBYTES\tLAST TRANSFER")
+	var queued int
+	var transfers, errs, bytes int64
+	for _, s := range statuses {
+		health := s.Health
+		if s.Paused {
+			health += " (paused)"
+		}
+		last := "-"
+		if s.LastTransfer != nil {
+			last = now.Sub(*s.LastTransfer).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			s.Name, s.Kind, health, s.QueueDepth, s.Transfers, s.Errors, formatByteSize(s.Bytes), last)
+		queued += s.QueueDepth
+		transfers += s.Transfers
+		errs += s.Errors
+		bytes += s.Bytes
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t\t%d\t%d\t%d\t%s\t\n", queued, transfers, errs, formatByteSize(bytes))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if err := printActiveTransfers(w, statuses); err != nil {
+		return err
+	}
+	if len(report.Alerts) > 0 {
+		fmt.Fprintln(w, "\nALERTS")
+		for _, alert := range report.Alerts {
+			fmt.Fprintln(w, "  "+alert)
+		}
+	}
+	return nil
+}
+
+// printActiveTransfers writes a table of the transfers in progress, if there are any
+func printActiveTransfers(w io.Writer, statuses []workflowStatus) error {
+	var active int
+	for _, s := range statuses {
+		active += len(s.Active)
+	}
+	if active == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nIN PROGRESS")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  WORKFLOW\tDIRECTION\tNAME\tPROGRESS\tRATE\tETA")
+	for _, s := range statuses {
+		for _, t := range s.Active {
+			progress := formatByteSize(t.Bytes)
+			if t.Size > 0 {
+				progress = fmt.Sprintf("%s of %s (%d%%)", progress, formatByteSize(t.Size), t.Bytes*100/t.Size)
+			}
+			eta := t.ETA
+			if eta == "" {
+				eta = "-"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\n", s.Name, t.Direction, t.Name, progress, formatRate(t.Rate), eta)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,120 @@
+package bucketsync
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintStatus(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	last := now.Add(-90 * time.Second)
+	statuses := []workflowStatus{
+		{Name: "backups", Kind: workflowInbound, Health: healthConnected, QueueDepth: 2, Transfers: 3, Bytes: 2000},
+		{Name: "photos", Kind: workflowOutbound, Health: healthWatching, Paused: true, Transfers: 1, Errors: 1, Bytes: 500, LastTransfer: &last},
+	}
+
+	var buf bytes.Buffer
+	if err := printStatus(&buf, statusReport{Version: "1.2.3", Uptime: "1h0m0s"}, statuses, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"bucketsyncd 1.2.3, up 1h0m0s",
+		"WORKFLOW",
+		"connected",
+		"watching (paused)",
+		"1m30s ago",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if total := strings.Fields(lines[len(lines)-1]); strings.Join(total, " ") != "TOTAL 2 4 1 2.5 kB" {
+		t.Errorf("unexpected totals line %q", lines[len(lines)-1])
+	}
+}
+
+func TestStatusOverSocket(t *testing.T) {
+	resetWorkflows(t)
+	registerWorkflow("photos", workflowOutbound).setHealth(healthWatching)
+
+	socket := testSocketPath(t)
+	srv, err := startAdminSocket(socket, false)
+	if err != nil {
+		t.Fatalf("failed to start control socket: %v", err)
+	}
+	fi, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions = %o, want 600", perm)
+	}
+
+	client := socketClient(socket, time.Second)
+	var report statusReport
+	if err := getSocketJSON(client, "/api/status", &report); err != nil || report.Status != "ok" {
+		t.Errorf("status: %+v, %v", report, err)
+	}
+	var statuses []workflowStatus
+	if err := getSocketJSON(client, "/api/workflows", &statuses); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Health != healthWatching {
+		t.Errorf("unexpected workflows: %+v", statuses)
+	}
+	if code := cmdStatus([]string{"-socket", socket}); code != exitOK {
+		t.Errorf("expected exit code %d, got %d", exitOK, code)
+	}
+	_ = srv.Close()
+
+	// A stale socket is replaced on restart
+	srv, err = startAdminSocket(socket, false)
+	if err != nil {
+		t.Fatalf("failed to restart control socket: %v", err)
+	}
+	_ = srv.Close()
+
+	if code := cmdStatus([]string{"-socket", socket}); code != exitError {
+		t.Errorf("expected exit code %d with no daemon, got %d", exitError, code)
+	}
+}
+
+// testSocketPath returns a path for a control socket in a temporary directory.
+// Unix socket paths are limited in length, so it avoids the long per-test temp directory.
+func testSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "bsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, "control.sock")
+}
+
+func TestStartAdminSocketRefusesFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "not-a-socket")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := startAdminSocket(path, false); err == nil {
+		t.Error("expected error when the path is a regular file")
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		t.Error("regular file was removed")
+	}
+}
+
+func TestCmdStatusUsage(t *testing.T) {
+	if code := cmdStatus([]string{"extra"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
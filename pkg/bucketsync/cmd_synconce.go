@@ -0,0 +1,167 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syncOnceResult tallies a workflow's transfers in a sync-once run
+type syncOnceResult struct {
+	workflow  string
+	direction string
+	files     int
+	failed    int
+	bytes     int64
+}
+
+// errNoSyncOnceWorkflow is returned for a sync-once run naming no workflow it can run
+var errNoSyncOnceWorkflow = errors.New("no outbound workflow with a source folder, or inbound workflow with a bucket, of that name")
+
+// cmdSyncOnce implements the sync-once subcommand, which uploads every outbound workflow's files
+// and downloads every inbound workflow's new and changed objects once, then exits, for running
+// from cron or a scheduled job
+func cmdSyncOnce(args []string) int {
+	fs := newCommandFlagSet("sync-once")
+	workflow := fs.String("workflow", "", "Name of the only workflow to run")
+	pushgateway := fs.String("pushgateway", "", "Pushgateway URL to push the run's metrics to, overriding pushgateway.url")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	configMutex.RLock()
+	pg := config.Pushgateway
+	configMutex.RUnlock()
+	if *pushgateway != "" {
+		pg.URL = *pushgateway
+	}
+	if err := pg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: pushgateway:", err)
+		return exitConfig
+	}
+
+	start := time.Now()
+	results, err := syncOnce(context.Background(), *workflow)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	err = reportSyncOnce(os.Stdout, results)
+	if pg.URL != "" {
+		if pushErr := pushRunMetrics(pg, results, time.Since(start), time.Now()); pushErr != nil {
+			fmt.Fprintln(os.Stderr, "Error:", pushErr)
+			if err == nil {
+				return exitError
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	return exitCodeFor(err)
+}
+
+// syncOnce runs each enabled outbound workflow with a source folder and inbound workflow with a
+// bucket once, or only the one named, returning their tallies
+func syncOnce(ctx context.Context, workflow string) ([]syncOnceResult, error) {
+	configMutex.RLock()
+	outbound, inbound := config.Outbound, config.Inbound
+	configMutex.RUnlock()
+
+	var results []syncOnceResult
+	for _, o := range outbound {
+		// Streamed sources have no files to upload
+		if !o.IsEnabled() || o.Source == "" || o.Fifo != "" || len(o.Command) > 0 || (workflow != "" && o.Name != workflow) {
+			continue
+		}
+		results = append(results, syncOutboundOnce(ctx, o))
+	}
+	for _, in := range inbound {
+		if !in.IsEnabled() || in.Bucket == "" || in.SFTP.URL != "" || (workflow != "" && in.Name != workflow) {
+			continue
+		}
+		results = append(results, syncInboundOnce(ctx, in))
+	}
+	if workflow != "" && len(results) == 0 {
+		return nil, fmt.Errorf("%w: %q", errNoSyncOnceWorkflow, workflow)
+	}
+	return results, nil
+}
+
+// syncOutboundOnce uploads every file in the workflow's source folder which it would upload
+func syncOutboundOnce(ctx context.Context, o Outbound) syncOnceResult {
+	lf := workflowLogFields(o.Name, o.Labels, nil)
+	result := syncOnceResult{workflow: o.Name, direction: directionUpload}
+	files, err := listOutboundFiles(o)
+	if err != nil {
+		log.WithFields(lf).Error(err)
+		result.failed++
+		return result
+	}
+	folder := filepath.Dir(o.Source)
+	for name, fi := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		// Failures are logged by uploadEvent as they occur
+		if err := uploadEvent(lf, o, filepath.Join(folder, name)); err != nil {
+			result.failed++
+			continue
+		}
+		result.files++
+		result.bytes += fi.Size()
+	}
+	return result
+}
+
+// syncInboundOnce downloads the objects in the workflow's bucket which are missing from its
+// destination or have changed since they were downloaded
+func syncInboundOnce(ctx context.Context, in Inbound) syncOnceResult {
+	lf := workflowLogFields(in.Name, in.Labels, log.Fields{"bucket": in.Bucket, "prefix": in.Prefix})
+	result := syncOnceResult{workflow: in.Name, direction: directionDownload}
+	keys, err := changedInbound(ctx, in)
+	if err != nil {
+		log.WithFields(lf).Error(err)
+		result.failed++
+		return result
+	}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		// Failures are logged by downloadPolled as they occur
+		if err := downloadPolled(lf, in, key); err != nil {
+			result.failed++
+			continue
+		}
+		result.files++
+		if fi, err := os.Stat(in.localPath(key)); err == nil {
+			result.bytes += fi.Size()
+		}
+	}
+	return result
+}
+
+// reportSyncOnce writes a line for each workflow run, and returns a batchError if any transfer
+// failed
+func reportSyncOnce(w io.Writer, results []syncOnceResult) error {
+	total, failed := 0, 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s: %d files (%s) %sed, %d failed\n", r.workflow, r.files, formatByteSize(r.bytes), r.direction, r.failed)
+		total += r.files + r.failed
+		failed += r.failed
+	}
+	if failed > 0 {
+		return &batchError{Op: "transfers", Failed: failed, Total: total}
+	}
+	return nil
+}
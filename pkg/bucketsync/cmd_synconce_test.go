@@ -0,0 +1,115 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncOnceUploadsSourceFiles(t *testing.T) {
+	resetWorkflows(t)
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha"), 0600)
+	_ = os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bravo!"), 0600)
+	_ = os.WriteFile(filepath.Join(dir, "c.log"), []byte("ignored"), 0600)
+	config = Config{Outbound: []Outbound{
+		{
+			Name:        "reports",
+			Source:      filepath.Join(dir, "*.txt"),
+			Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+		},
+		{Name: "other", Source: filepath.Join(dir, "*.log"), Destination: "webdav://unused.example.com/"},
+	}}
+
+	results, err := syncOnce(context.Background(), "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected one workflow run, got %+v", results)
+	}
+	if r := results[0]; r.files != 2 || r.bytes != 11 || r.failed != 0 || r.direction != directionUpload {
+		t.Errorf("unexpected result %+v", r)
+	}
+	for range 2 {
+		select {
+		case <-uploads:
+		case <-time.After(10 * time.Second):
+			t.Fatal("file was not uploaded")
+		}
+	}
+
+	if _, err := syncOnce(context.Background(), "missing"); !errors.Is(err, errNoSyncOnceWorkflow) {
+		t.Errorf("expected errNoSyncOnceWorkflow, got %v", err)
+	}
+}
+
+func TestReportSyncOnce(t *testing.T) {
+	var out bytes.Buffer
+	err := reportSyncOnce(&out, []syncOnceResult{
+		{workflow: "reports", direction: directionUpload, files: 3, bytes: 2048},
+		{workflow: "inbox", direction: directionDownload, files: 1, failed: 1},
+	})
+	if !strings.Contains(out.String(), "reports: 3 files (2.0 kB) uploaded, 0 failed") {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+	var be *batchError
+	if !errors.As(err, &be) || be.Failed != 1 || be.Total != 5 {
+		t.Errorf("expected a batch error with 1 of 5 failed, got %v", err)
+	}
+	if exitCodeFor(err) != exitPartial {
+		t.Errorf("expected exit code %d, got %d", exitPartial, exitCodeFor(err))
+	}
+}
+
+func TestPushRunMetrics(t *testing.T) {
+	type request struct{ method, path, body string }
+	requests := make(chan request, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{r.Method, r.URL.Path, string(body)}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := Pushgateway{URL: server.URL, Grouping: map[string]string{"instance": "cron1"}}
+	results := []syncOnceResult{{workflow: "reports", direction: directionUpload, files: 2, bytes: 11}}
+	if err := pushRunMetrics(p, results, time.Second, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r := <-requests
+	if r.method != http.MethodPut || r.path != "/metrics/job/bucketsyncd/instance/cron1" {
+		t.Errorf("unexpected push %s %s", r.method, r.path)
+	}
+	// The body is protobuf encoded, but metric names are written as they are
+	for _, name := range []string{"bucketsyncd_run_files", "bucketsyncd_run_last_success_timestamp_seconds"} {
+		if !strings.Contains(r.body, name) {
+			t.Errorf("push is missing %s", name)
+		}
+	}
+
+	// A run with failures adds to the group, keeping the last success's time
+	results[0].failed = 1
+	if err := pushRunMetrics(p, results, time.Second, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	r = <-requests
+	if r.method != http.MethodPost {
+		t.Errorf("expected a POST for a failed run, got %s", r.method)
+	}
+	if strings.Contains(r.body, "bucketsyncd_run_last_success_timestamp_seconds") {
+		t.Error("failed run pushed a last success time")
+	}
+}
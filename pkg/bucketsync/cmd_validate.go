@@ -0,0 +1,138 @@
+package bucketsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cmdValidate implements the validate subcommand, which checks a configuration file without
+// running it, reporting every problem found
+func cmdValidate(args []string) int {
+	fs := newCommandFlagSet("validate")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	if err := resolveConfigPath(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	cfg, problems, err := validateConfigFile(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	if !reportValidation(os.Stdout, *configFilePath, cfg, problems) {
+		return exitConfig
+	}
+	return exitOK
+}
+
+// validateConfigFile reads a configuration file and checks it as the daemon does before starting,
+// as well as for keys it does not know, which would otherwise be ignored, and for folders which
+// are missing
+func validateConfigFile(path string) (Config, []error, error) {
+	// #nosec G304 - intentional: path is given on the command line
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return Config{}, nil, err
+	}
+	var problems []error
+	// Misspelt keys leave their options unset without a word from the lenient decoding above
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var strict Config
+	var typeErr *yaml.TypeError
+	if err := dec.Decode(&strict); errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			problems = append(problems, errors.New(e))
+		}
+	}
+	cfg.applyTenants()
+	problems = append(problems, cfg.Validate()...)
+	problems = append(problems, checkConfigPaths(cfg)...)
+	return cfg, problems, nil
+}
+
+// checkConfigPaths checks that the folders enabled workflows watch exist, which Validate leaves
+// to when the workflows start, as they may be created or mounted in the meantime
+func checkConfigPaths(c Config) []error {
+	var errs []error
+	folder := func(workflow, option, path string) {
+		fi, err := os.Stat(path)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %s: %w", workflow, option, err))
+		case !fi.IsDir():
+			errs = append(errs, fmt.Errorf("%s: %s: %s is not a folder", workflow, option, path))
+		}
+	}
+	// The archive folder is created when first needed, so it or the folder it would be created
+	// in must be a folder files can be written to
+	archiveFolder := func(workflow, path string) {
+		dir := path
+		for {
+			if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) || filepath.Dir(dir) == dir {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		n := len(errs)
+		folder(workflow, "archive_to", dir)
+		if len(errs) > n {
+			return
+		}
+		probe, err := os.CreateTemp(dir, ".bucketsyncd-validate-*")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: archive_to: %s is not writable: %w", workflow, dir, err))
+			return
+		}
+		_ = probe.Close()
+		_ = os.Remove(probe.Name())
+	}
+	for _, o := range c.Outbound {
+		if !o.IsEnabled() {
+			continue
+		}
+		name := fmt.Sprintf("outbound %q", o.Name)
+		if o.Source != "" && o.Fifo == "" && len(o.Command) == 0 {
+			folder(name, "source", filepath.Dir(o.Source))
+		}
+		if o.Quarantine != "" {
+			folder(name, "quarantine", o.Quarantine)
+		}
+		if o.ArchiveTo != "" {
+			archiveFolder(name, o.ArchiveTo)
+		}
+	}
+	for _, t := range c.Sync {
+		if t.IsEnabled() && t.Local != "" {
+			folder(fmt.Sprintf("sync %q", t.Name), "local", t.Local)
+		}
+	}
+	return errs
+}
+
+// reportValidation lists the problems found in a configuration file, or summarises it if there
+// are none, reporting whether it is valid
+func reportValidation(w io.Writer, path string, cfg Config, problems []error) bool {
+	if len(problems) > 0 {
+		fmt.Fprintf(w, "%s: %d problems found\n", path, len(problems))
+		for _, p := range problems {
+			fmt.Fprintf(w, "  - %s\n", p)
+		}
+		return false
+	}
+	fmt.Fprintf(w, "%s is valid: %d remotes, %d outbound, %d inbound, %d replicate and %d sync workflows\n",
+		path, len(cfg.Remotes), len(cfg.Outbound), len(cfg.Inbound), len(cfg.Replicate), len(cfg.Sync))
+	return true
+}
@@ -0,0 +1,79 @@
+package bucketsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	if err := os.Mkdir(watched, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	write := func(yaml string) {
+		t.Helper()
+		yaml = strings.ReplaceAll(yaml, "DIR", dir)
+		if err := os.WriteFile(path, []byte(yaml), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+remotes:
+  - name: minio1
+    endpoint: minio.example.com
+outbound:
+  - name: docs
+    source: DIR/watched/*.pdf
+    destination: s3://minio.example.com/bucket/docs
+`)
+	cfg, problems, err := validateConfigFile(path)
+	if err != nil || len(problems) != 0 {
+		t.Fatalf("expected a valid file, got %v, %v", problems, err)
+	}
+	var out bytes.Buffer
+	if !reportValidation(&out, path, cfg, problems) || !strings.Contains(out.String(), "1 remotes, 1 outbound") {
+		t.Errorf("unexpected report %q", out.String())
+	}
+
+	write(`
+remotes:
+  - name: minio1
+    endpoint: minio.example.com
+outbound:
+  - name: docs
+    source: DIR/missing/*.pdf
+    destination: s3://minio.example.com/bucket/docs
+    ignore_pattern: ["*.tmp"]
+    archive_to: DIR/config.yaml/done
+    settle: 2s
+inbound:
+  - name: scans
+    source: amqp://localhost/
+    queue: scans
+    remote: minio2
+    destination: DIR/scans
+`)
+	_, problems, err = validateConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"field ignore_pattern not found in type bucketsync.outboundYAML", `unknown remote "minio2"`, `outbound "docs": source:`, `outbound "docs": archive_to:`} {
+		found := false
+		for _, p := range problems {
+			found = found || strings.Contains(p.Error(), want)
+		}
+		if !found {
+			t.Errorf("no problem containing %q in %v", want, problems)
+		}
+	}
+	out.Reset()
+	if reportValidation(&out, path, Config{}, problems) || !strings.Contains(out.String(), "4 problems found") {
+		t.Errorf("unexpected report %q", out.String())
+	}
+}
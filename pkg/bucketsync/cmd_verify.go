@@ -0,0 +1,277 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 - MD5 is only used to compare against S3 ETags
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+const (
+	verifyOK       = "ok"
+	verifyMissing  = "missing"
+	verifyExtra    = "extra"
+	verifyMismatch = "mismatch"
+)
+
+// verifyItem is the comparison result for a single local file or remote object
+type verifyItem struct {
+	Status string `json:"status"`
+	Path   string `json:"path,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Size   int64  `json:"size"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// verifyTarget is the resolved destination of an outbound workflow
+type verifyTarget struct {
+	store       ObjectStore
+	bucket      string
+	prefix      string
+	localFolder string
+}
+
+// cmdVerify implements the verify subcommand, comparing an outbound workflow's local files with its destination
+func cmdVerify(args []string) int {
+	fs := newCommandFlagSet("verify")
+	workflow := fs.String("workflow", "", "Name of the outbound workflow to verify")
+	sizeOnly := fs.Bool("size-only", false, "Compare sizes only, skipping checksums")
+	asJSON := fs.Bool("json", false, "Output as JSON")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return exitUsage
+	}
+	if *workflow == "" {
+		fmt.Fprintln(os.Stderr, "Usage: bucketsyncd verify -workflow name [-size-only] [-json]")
+		return exitUsage
+	}
+	if err := loadCommandConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitConfig
+	}
+	o, ok := findOutbound(*workflow)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: no outbound workflow named %q\n", *workflow)
+		return exitConfig
+	}
+
+	target, err := resolveVerifyTarget(o)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+	items, err := verifyOutbound(context.Background(), o, target, !*sizeOnly)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitCodeFor(err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(items)
+	} else {
+		err = writeVerifyReport(os.Stdout, items)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitError
+	}
+	return verifyExitCode(items)
+}
+
+// verifyExitCode is exitPartial when only some files differ from the destination, and exitError when none match
+func verifyExitCode(items []verifyItem) int {
+	differ := 0
+	for _, item := range items {
+		if item.Status != verifyOK {
+			differ++
+		}
+	}
+	switch {
+	case differ == 0:
+		return exitOK
+	case differ < len(items):
+		return exitPartial
+	}
+	return exitError
+}
+
+// resolveVerifyTarget determines the bucket, prefix and store for an outbound workflow's first
+// destination
+func resolveVerifyTarget(o Outbound) (verifyTarget, error) {
+	if o.Fifo != "" || len(o.Command) > 0 {
+		return verifyTarget{}, errors.New("verification of FIFO and command sources is not supported")
+	}
+	if len(o.destinations()) == 0 {
+		return verifyTarget{}, errors.New("no destination configured")
+	}
+	if o.KeyTemplate != "" {
+		// Keys rendered from the time of the upload cannot be worked out from the files
+		return verifyTarget{}, errors.New("verification of workflows with a key_template is not supported")
+	}
+	// Files are uploaded to every destination alike, so the first stands for them all
+	u, err := url.Parse(o.destinations()[0])
+	if err != nil {
+		return verifyTarget{}, fmt.Errorf("failed to parse destination URL: %w", err)
+	}
+	if isWebDAVScheme(u.Scheme) {
+		return verifyTarget{}, errors.New("verification of WebDAV destinations is not supported")
+	}
+	if isIPFSScheme(u.Scheme) {
+		return verifyTarget{}, errors.New("verification of IPFS destinations is not supported")
+	}
+	endpoint, bucket, prefix, err := parseS3Destination(u)
+	if err != nil {
+		return verifyTarget{}, err
+	}
+	remote, ok := outboundRemote(o, endpoint)
+	if !ok {
+		return verifyTarget{}, fmt.Errorf("no remote configured for endpoint %s", endpoint)
+	}
+	store, err := bucketStore(remote, bucket)
+	if err != nil {
+		return verifyTarget{}, err
+	}
+	return verifyTarget{
+		store:       store,
+		bucket:      bucket,
+		prefix:      prefix,
+		localFolder: filepath.Dir(o.Source),
+	}, nil
+}
+
+// verifyOutbound lists the workflow's destination prefix and compares it with the matching local files
+func verifyOutbound(ctx context.Context, o Outbound, t verifyTarget, checksums bool) ([]verifyItem, error) {
+	local, err := listOutboundFiles(o)
+	if err != nil {
+		return nil, err
+	}
+
+	listPrefix := outboundObjectKey(t.prefix, "")
+	objects, err := t.store.List(ctx, listPrefix, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	remote := make(map[string]StoredObject)
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, listPrefix)
+		if obj.IsPrefix || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		remote[name] = obj
+	}
+
+	var items []verifyItem
+	for name, fi := range local {
+		path := filepath.Join(t.localFolder, name)
+		key := outboundObjectKey(t.prefix, name)
+		obj, ok := remote[name]
+		if !ok {
+			items = append(items, verifyItem{Status: verifyMissing, Path: path, Key: key, Size: fi.Size()})
+			continue
+		}
+		delete(remote, name)
+		item := verifyItem{Status: verifyOK, Path: path, Key: key, Size: fi.Size()}
+		if detail, err := compareObject(path, fi, obj, checksums); err != nil {
+			return nil, err
+		} else if detail != "" {
+			item.Status, item.Detail = verifyMismatch, detail
+		}
+		items = append(items, item)
+	}
+	for _, obj := range remote {
+		items = append(items, verifyItem{Status: verifyExtra, Key: obj.Key, Size: obj.Size})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	return items, nil
+}
+
+// listOutboundFiles returns the regular files in the workflow's source folder which it would upload
+func listOutboundFiles(o Outbound) (map[string]os.FileInfo, error) {
+	folder := filepath.Dir(o.Source)
+	fileGlob := filepath.Base(o.Source)
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source folder: %w", err)
+	}
+
+	files := make(map[string]os.FileInfo)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !glob.Glob(fileGlob, e.Name()) || isIgnored(o.IgnorePatterns, e.Name()) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[e.Name()] = fi
+	}
+	return files, nil
+}
+
+// compareObject returns a description of how a local file differs from its object, or "" if they match.
+// Checksums are only compared when the ETag is a plain MD5 digest, which is not the case for multipart uploads.
+func compareObject(path string, fi os.FileInfo, obj StoredObject, checksums bool) (string, error) {
+	if fi.Size() != obj.Size {
+		return fmt.Sprintf("size %d differs from remote size %d", fi.Size(), obj.Size), nil
+	}
+	etag := strings.Trim(obj.ETag, `"`)
+	if !checksums || len(etag) != md5.Size*2 {
+		return "", nil
+	}
+	sum, err := fileMD5(path)
+	if err != nil {
+		return "", err
+	}
+	if sum != etag {
+		return fmt.Sprintf("checksum %s differs from remote ETag %s", sum, etag), nil
+	}
+	return "", nil
+}
+
+func fileMD5(path string) (string, error) {
+	// #nosec G304 - intentional: path is within a configured source folder
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := md5.New() // #nosec G401 - MD5 is only used to compare against S3 ETags
+	if _, err := copyBuffered(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeVerifyReport(w io.Writer, items []verifyItem) error {
+	counts := make(map[string]int)
+	for _, item := range items {
+		counts[item.Status]++
+		switch item.Status {
+		case verifyMissing:
+			fmt.Fprintf(w, "missing:  %s (%s)\n", item.Path, item.Key)
+		case verifyExtra:
+			fmt.Fprintf(w, "extra:    %s\n", item.Key)
+		case verifyMismatch:
+			fmt.Fprintf(w, "mismatch: %s: %s\n", item.Path, item.Detail)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d ok, %d missing, %d extra, %d mismatched\n",
+		counts[verifyOK], counts[verifyMissing], counts[verifyExtra], counts[verifyMismatch])
+	return err
+}
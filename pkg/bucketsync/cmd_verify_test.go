@@ -0,0 +1,120 @@
+package bucketsync
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListOutboundFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.pdf", "b.pdf", "c.tmp", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.pdf"), 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	files, err := listOutboundFiles(Outbound{
+		Source:         filepath.Join(dir, "*.pdf"),
+		IgnorePatterns: []string{"b.*"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 || files["a.pdf"] == nil {
+		t.Errorf("expected only a.pdf, got %v", files)
+	}
+}
+
+func TestCompareObject(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	const helloMD5 = "5d41402abc4b2a76b9719d911017c592"
+
+	tests := []struct {
+		name      string
+		obj       StoredObject
+		checksums bool
+		mismatch  bool
+	}{
+		{"match", StoredObject{Size: 5, ETag: `"` + helloMD5 + `"`}, true, false},
+		{"size differs", StoredObject{Size: 6, ETag: helloMD5}, true, true},
+		{"checksum differs", StoredObject{Size: 5, ETag: "00000000000000000000000000000000"}, true, true},
+		{"checksum skipped", StoredObject{Size: 5, ETag: "00000000000000000000000000000000"}, false, false},
+		{"multipart etag", StoredObject{Size: 5, ETag: "0123456789abcdef0123456789abcdef-2"}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail, err := compareObject(path, fi, tt.obj, tt.checksums)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (detail != "") != tt.mismatch {
+				t.Errorf("compareObject() detail = %q, want mismatch %v", detail, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestWriteVerifyReport(t *testing.T) {
+	items := []verifyItem{
+		{Status: verifyOK, Path: "/src/a", Key: "p/a"},
+		{Status: verifyMissing, Path: "/src/b", Key: "p/b"},
+		{Status: verifyExtra, Key: "p/c"},
+		{Status: verifyMismatch, Path: "/src/d", Key: "p/d", Detail: "size differs"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeVerifyReport(&buf, items); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"missing:  /src/b", "extra:    p/c", "mismatch: /src/d: size differs", "1 ok, 1 missing, 1 extra, 1 mismatched"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in report:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "/src/a") {
+		t.Error("expected matching files to be omitted from the report")
+	}
+}
+
+func TestResolveVerifyTargetWebDAV(t *testing.T) {
+	if _, err := resolveVerifyTarget(Outbound{Destination: "webdav://server/path"}); err == nil {
+		t.Error("expected error for WebDAV destination")
+	}
+}
+
+func TestCmdVerifyUsage(t *testing.T) {
+	if code := cmdVerify([]string{}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestVerifyExitCode(t *testing.T) {
+	ok := verifyItem{Status: verifyOK}
+	missing := verifyItem{Status: verifyMissing}
+
+	if code := verifyExitCode([]verifyItem{ok}); code != exitOK {
+		t.Errorf("expected %d, got %d", exitOK, code)
+	}
+	if code := verifyExitCode([]verifyItem{ok, missing}); code != exitPartial {
+		t.Errorf("expected %d, got %d", exitPartial, code)
+	}
+	if code := verifyExitCode([]verifyItem{missing}); code != exitError {
+		t.Errorf("expected %d, got %d", exitError, code)
+	}
+}
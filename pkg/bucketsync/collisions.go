@@ -0,0 +1,83 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Policies an outbound workflow's on_collision may name, deciding what becomes of a file whose
+// object key is already taken
+const (
+	// collisionOverwrite replaces the object, as uploads always have
+	collisionOverwrite = "overwrite"
+	// collisionSkip leaves the object as it is, and the file not uploaded
+	collisionSkip = "skip"
+	// collisionSuffix uploads the file under the first free key numbered -1, -2 and so on
+	collisionSuffix = "suffix"
+	// collisionFail fails the upload
+	collisionFail = "fail"
+)
+
+// maxCollisionSuffix bounds the numbered keys tried before a suffixed upload gives up
+const maxCollisionSuffix = 1000
+
+// errKeyExists is returned for an upload whose key is taken, under the fail policy or once no
+// numbered key is free
+var errKeyExists = errors.New("object already exists")
+
+func validateCollisionPolicy(policy string) error {
+	switch policy {
+	case "", collisionOverwrite, collisionSkip, collisionSuffix, collisionFail:
+		return nil
+	}
+	return errors.New("on_collision: must be overwrite, skip, suffix or fail")
+}
+
+// suffixedKey numbers key before its extension
+func suffixedKey(key string, n int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(key, ext), n, ext)
+}
+
+// resolveCollision applies the collision policy to key, returning the key to upload to, or ""
+// if the file is to be skipped. A chunked upload's key is taken if its manifest is there. Each
+// check is bounded by timeout.
+func resolveCollision(ctx context.Context, store ObjectStore, policy, key string, chunked bool, timeout time.Duration) (string, error) {
+	if policy == "" || policy == collisionOverwrite {
+		return key, nil
+	}
+	exists := func(key string) (bool, error) {
+		if chunked {
+			key += chunkManifestSuffix
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		_, err := store.Stat(ctx, key)
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	taken, err := exists(key)
+	if err != nil || !taken {
+		return key, err
+	}
+	switch policy {
+	case collisionSkip:
+		return "", nil
+	case collisionSuffix:
+		for n := 1; n <= maxCollisionSuffix; n++ {
+			candidate := suffixedKey(key, n)
+			if taken, err := exists(candidate); err != nil || !taken {
+				return candidate, err
+			}
+		}
+		return "", fmt.Errorf("%w: %s, and no free key numbered up to %d", errKeyExists, key, maxCollisionSuffix)
+	}
+	return "", fmt.Errorf("%w: %s", errKeyExists, key)
+}
@@ -0,0 +1,57 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveCollision(t *testing.T) {
+	_, mc := newMemoryS3(t)
+	store := newMinioStore(mc, "bucket", time.Second)
+	ctx := context.Background()
+	for _, key := range []string{"archive/2026.csv", "archive/2026-1.csv", "chunked/big.bin" + chunkManifestSuffix} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		policy, key string
+		chunked     bool
+		want        string
+		err         error
+	}{
+		{"", "archive/2026.csv", false, "archive/2026.csv", nil},
+		{collisionOverwrite, "archive/2026.csv", false, "archive/2026.csv", nil},
+		{collisionSkip, "archive/2026.csv", false, "", nil},
+		{collisionSkip, "archive/new.csv", false, "archive/new.csv", nil},
+		{collisionSuffix, "archive/2026.csv", false, "archive/2026-2.csv", nil},
+		{collisionFail, "archive/2026.csv", false, "", errKeyExists},
+		{collisionFail, "chunked/big.bin", true, "", errKeyExists},
+		{collisionFail, "chunked/big.bin", false, "chunked/big.bin", nil},
+	}
+	for _, tt := range tests {
+		got, err := resolveCollision(ctx, store, tt.policy, tt.key, tt.chunked, time.Second)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s %s: resolveCollision() = %q, %v, want %q, %v", tt.policy, tt.key, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestSuffixedKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"a/report.csv":    "a/report-3.csv",
+		"a/README":        "a/README-3",
+		"a.b/data.tar.gz": "a.b/data.tar-3.gz",
+	} {
+		if got := suffixedKey(key, 3); got != want {
+			t.Errorf("suffixedKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if err := validateCollisionPolicy("rename"); err == nil {
+		t.Error("unknown policy validated")
+	}
+}
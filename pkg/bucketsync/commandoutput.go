@@ -0,0 +1,97 @@
+package bucketsync
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os/exec"
+	"sync"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errCommandRunning is returned when a workflow's command is asked to run while it already is
+var errCommandRunning = errors.New("command is already running")
+
+// commandOutput reads a command's standard output, failing at its end if the command failed, so
+// that the upload of a failed command's output is abandoned rather than completed
+type commandOutput struct {
+	stdout io.Reader
+	wait   func() error
+	waited bool
+	err    error
+}
+
+func (c *commandOutput) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if errors.Is(err, io.EOF) {
+		if err := c.finish(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// finish waits for the command to exit, once
+func (c *commandOutput) finish() error {
+	if !c.waited {
+		c.waited = true
+		c.err = c.wait()
+	}
+	return c.err
+}
+
+// uploadCommandOutput runs the workflow's command, uploading its standard output as an object
+// keyed by tmpl. The command is killed if ctx is done or command_timeout passes first.
+func uploadCommandOutput(ctx context.Context, lf log.Fields, o Outbound, tmpl *template.Template) error {
+	if o.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.CommandTimeout)
+		defer cancel()
+	}
+	lf = maps.Clone(lf)
+	lf["command"] = o.Command[0]
+	// #nosec G204 - intentional: the command is named in the configuration file
+	cmd := exec.CommandContext(ctx, o.Command[0], o.Command[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+	log.WithFields(lf).Info("started command")
+
+	// Anything the command writes to standard error is logged
+	var logged sync.WaitGroup
+	logged.Go(func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.WithFields(lf).Warn(scanner.Text())
+		}
+	})
+	out := &commandOutput{stdout: stdout, wait: func() error {
+		// The pipes must be read to the end before waiting closes them
+		logged.Wait()
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("command failed: %w", err)
+		}
+		return nil
+	}}
+	// Failures are logged by uploadStream as they occur
+	err = uploadStream(lf, o, tmpl, o.Command[0], out)
+	if !out.waited {
+		// The upload ended before the output did, so the rest of it is not wanted
+		_ = cmd.Process.Kill()
+		_ = out.finish()
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestUploadCommandOutput(t *testing.T) {
+	server, uploads := newPutRecorder(t)
+	o := Outbound{
+		Name:        "command-test",
+		Command:     []string{"sh", "-c", "echo backup; echo progress >&2"},
+		Key:         "{{.Workflow}}/{{.Name}}.txt",
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+	}
+	tmpl, err := parseStreamKey(o.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadCommandOutput(context.Background(), log.Fields{}, o, tmpl); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-uploads:
+		if u.path != "/uploads/command-test/sh.txt" || u.body != "backup\n" {
+			t.Errorf("uploaded %q to %s", u.body, u.path)
+		}
+	default:
+		t.Fatal("output was not uploaded")
+	}
+}
+
+func TestUploadCommandOutputFailures(t *testing.T) {
+	server, _ := newPutRecorder(t)
+	tmpl, err := parseStreamKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		command []string
+		timeout time.Duration
+		want    string
+	}{
+		{"exit status", []string{"sh", "-c", "echo partial; exit 3"}, 0, "command failed: exit status 3"},
+		{"timeout", []string{"sleep", "10"}, 100 * time.Millisecond, "command failed: signal: killed"},
+		{"missing", []string{"/nonexistent/backup.sh"}, 0, "failed to start command"},
+	} {
+		o := Outbound{
+			Name:           "command-failure",
+			Command:        tt.command,
+			CommandTimeout: tt.timeout,
+			Destination:    strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+		}
+		err := uploadCommandOutput(context.Background(), log.Fields{}, o, tmpl)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
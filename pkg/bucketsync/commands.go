@@ -0,0 +1,257 @@
+package bucketsync
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes of the one-shot subcommands, so that scripts can branch on the outcome
+const (
+	exitOK      = 0 // success
+	exitError   = 1 // the operation failed
+	exitUsage   = 2 // invalid command-line arguments
+	exitConfig  = 3 // the configuration is missing, invalid or lacks what the command needs
+	exitAuth    = 4 // a remote or broker rejected the credentials
+	exitPartial = 5 // some, but not all, of the transfers or comparisons failed
+)
+
+// batchError reports how many operations in a batch failed, wrapping the last failure
+type batchError struct {
+	Op     string
+	Failed int
+	Total  int
+	Err    error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%d of %d %s failed", e.Failed, e.Total, e.Op)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.Err
+}
+
+// exitCodeFor classifies an operation error as an exit code
+func exitCodeFor(err error) int {
+	var batch *batchError
+	switch {
+	case err == nil:
+		return exitOK
+	case isAuthError(err):
+		return exitAuth
+	case errors.As(err, &batch) && batch.Failed < batch.Total:
+		return exitPartial
+	}
+	return exitError
+}
+
+// isAuthError reports whether an S3 or AMQP error was caused by rejected credentials
+func isAuthError(err error) bool {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Code == amqp.AccessRefused
+	}
+	var s3Err minio.ErrorResponse
+	if errors.As(err, &s3Err) {
+		switch s3Err.Code {
+		case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken":
+			return true
+		}
+		return s3Err.StatusCode == http.StatusUnauthorized || s3Err.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// runCommand dispatches a one-shot subcommand and returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "version":
+		fmt.Println(versionString())
+		return exitOK
+	case "cp":
+		return cmdCopy(args[1:])
+	case "ls":
+		return cmdList(args[1:])
+	case "rm":
+		return cmdRemove(args[1:])
+	case "presign":
+		return cmdPresign(args[1:])
+	case "validate":
+		return cmdValidate(args[1:])
+	case "check":
+		return cmdCheck(args[1:])
+	case "bench":
+		return cmdBench(args[1:])
+	case "init":
+		return cmdInit(args[1:])
+	case "verify":
+		return cmdVerify(args[1:])
+	case "reconcile":
+		return cmdReconcile(args[1:])
+	case "sync-once":
+		return cmdSyncOnce(args[1:])
+	case "replay-audit":
+		return cmdReplayAudit(args[1:])
+	case "inventory":
+		return cmdInventory(args[1:])
+	case "audit":
+		return cmdAudit(args[1:])
+	case "healthcheck":
+		return cmdHealthcheck(args[1:])
+	case "status":
+		return cmdStatus(args[1:])
+	case "pause":
+		return cmdPause(args[1:])
+	case "resume":
+		return cmdResume(args[1:])
+	case "reload":
+		return cmdReload(args[1:])
+	case "service":
+		return cmdService(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", args[0])
+		printCommandUsage()
+		return exitUsage
+	}
+}
+
+func printCommandUsage() {
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  version                         show version and build information")
+	fmt.Fprintln(os.Stderr, "  cp [-remote name] <src> <dst>   copy a file to or from a bucket")
+	fmt.Fprintln(os.Stderr, "  ls [-remote name] <url>         list objects under a bucket prefix")
+	fmt.Fprintln(os.Stderr, "  rm [-recursive] [-dry-run] <url> remove an object or prefix")
+	fmt.Fprintln(os.Stderr, "  presign [-expires 24h] <url>    print a shareable download URL")
+	fmt.Fprintln(os.Stderr, "  validate                        check the configuration file without running it")
+	fmt.Fprintln(os.Stderr, "  check                           test connectivity to remotes and queues")
+	fmt.Fprintln(os.Stderr, "  bench -remote name -bucket b    measure upload/download throughput")
+	fmt.Fprintln(os.Stderr, "  init [-o file] [-force]         write a commented starter configuration")
+	fmt.Fprintln(os.Stderr, "  verify -workflow name           compare local files with the destination")
+	fmt.Fprintln(os.Stderr, "  reconcile -workflow name        repair differences found by verify")
+	fmt.Fprintln(os.Stderr, "  sync-once [-workflow name]      upload and download once, then exit")
+	fmt.Fprintln(os.Stderr, "  replay-audit -from time -workflow name  transfer again what was recorded since a time")
+	fmt.Fprintln(os.Stderr, "  inventory [-format csv|json]    export transfers from the state file")
+	fmt.Fprintln(os.Stderr, "  audit verify [file...]          check the audit trail's hash chain")
+	fmt.Fprintln(os.Stderr, "  healthcheck [-addr host:port]   exit 0 if the running daemon reports healthy")
+	fmt.Fprintln(os.Stderr, "  status [-socket path] [-json]   show the running daemon's workflows")
+	fmt.Fprintln(os.Stderr, "  pause|resume <workflow>         pause or resume a running workflow")
+	fmt.Fprintln(os.Stderr, "  reload [-socket path]           reload the configuration, starting workflows since enabled")
+	fmt.Fprintln(os.Stderr, "  service install|uninstall|start|stop  manage the Windows service")
+}
+
+// newCommandFlagSet creates a flag set for a subcommand which also accepts the global -c and logging options
+func newCommandFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(configFilePath, "c", *configFilePath, "Configuration file location")
+	fs.StringVar(logLevelFlag, "log-level", *logLevelFlag, "Override the configured log level (debug, info, warn)")
+	fs.Var(logJSONFlag, "log-json", "Override the configured JSON log format")
+	return fs
+}
+
+// parseCommandFlags parses flags which may appear before, between or after positional arguments
+func parseCommandFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// loadCommandConfig reads the configuration file for a subcommand
+func loadCommandConfig() error {
+	if err := resolveConfigPath(); err != nil {
+		return err
+	}
+	if !validLogLevel(*logLevelFlag) {
+		return fmt.Errorf("invalid -log-level %q", *logLevelFlag)
+	}
+	if err := readConfig(*configFilePath); err != nil {
+		return err
+	}
+	configureLogging()
+	log.Debug("using configuration file ", *configFilePath)
+	return nil
+}
+
+// isObjectURL reports whether the argument refers to a bucket object rather than a local path
+func isObjectURL(s string) bool {
+	return strings.HasPrefix(s, "s3://")
+}
+
+// resolveObjectURL determines the remote, bucket and key referred to by an s3:// URL.
+// Both s3://bucket/key (with the remote chosen by name, or the only remote configured)
+// and s3://endpoint/bucket/key (as used in outbound destinations) are accepted.
+func resolveObjectURL(raw, remoteName string) (Remote, string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Remote{}, "", "", fmt.Errorf("failed to parse object URL: %w", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return Remote{}, "", "", fmt.Errorf("invalid object URL %q (expected s3://bucket/key)", raw)
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+
+	if remoteName != "" {
+		r, ok := findRemote(remoteName)
+		if !ok {
+			return Remote{}, "", "", fmt.Errorf("no remote named %q", remoteName)
+		}
+		return r, u.Host, path, nil
+	}
+
+	if r, ok := findRemoteByEndpoint(u.Host); ok {
+		bucket, key, _ := strings.Cut(path, "/")
+		if bucket == "" {
+			return Remote{}, "", "", fmt.Errorf("invalid object URL %q (missing bucket)", raw)
+		}
+		return r, bucket, key, nil
+	}
+
+	configMutex.RLock()
+	remotes := config.Remotes
+	configMutex.RUnlock()
+	if len(remotes) != 1 {
+		return Remote{}, "", "", fmt.Errorf("unable to choose a remote for %q, use -remote", raw)
+	}
+	return remotes[0], u.Host, path, nil
+}
+
+// findOutbound returns the configured outbound workflow with the given name
+func findOutbound(name string) (Outbound, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	for _, o := range config.Outbound {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return Outbound{}, false
+}
+
+// findInbound returns the configured inbound workflow with the given name
+func findInbound(name string) (Inbound, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	for _, in := range config.Inbound {
+		if in.Name == name {
+			return in, true
+		}
+	}
+	return Inbound{}, false
+}
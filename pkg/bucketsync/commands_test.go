@@ -0,0 +1,165 @@
+package bucketsync
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestParseCommandFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	remote := fs.String("remote", "", "")
+	recursive := fs.Bool("recursive", false, "")
+
+	positional, err := parseCommandFlags(fs, []string{"src", "-remote", "minio1", "dst", "-recursive"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(positional) != 2 || positional[0] != "src" || positional[1] != "dst" {
+		t.Errorf("unexpected positional arguments: %v", positional)
+	}
+	if *remote != "minio1" {
+		t.Errorf("expected remote 'minio1', got %q", *remote)
+	}
+	if !*recursive {
+		t.Error("expected recursive to be set")
+	}
+}
+
+func TestResolveObjectURL(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = Config{
+		Remotes: []Remote{
+			{Name: "minio1", Endpoint: "minio.example.com"},
+			{Name: "minio2", Endpoint: "backup.example.com"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		remote     string
+		wantRemote string
+		wantBucket string
+		wantKey    string
+		wantErr    bool
+	}{
+		{"named remote", "s3://bucket/path/file.txt", "minio2", "minio2", "bucket", "path/file.txt", false},
+		{"endpoint form", "s3://minio.example.com/bucket/path/file.txt", "", "minio1", "bucket", "path/file.txt", false},
+		{"ambiguous remote", "s3://bucket/file.txt", "", "", "", "", true},
+		{"unknown remote", "s3://bucket/file.txt", "missing", "", "", "", true},
+		{"wrong scheme", "http://bucket/file.txt", "minio1", "", "", "", true},
+		{"endpoint without bucket", "s3://minio.example.com/", "", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, bucket, key, err := resolveObjectURL(tt.url, tt.remote)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.Name != tt.wantRemote || bucket != tt.wantBucket || key != tt.wantKey {
+				t.Errorf("got (%s, %s, %s), want (%s, %s, %s)", r.Name, bucket, key, tt.wantRemote, tt.wantBucket, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestResolveObjectURLSingleRemote(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = Config{Remotes: []Remote{{Name: "only", Endpoint: "minio.example.com"}}}
+
+	r, bucket, key, err := resolveObjectURL("s3://bucket/key", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Name != "only" || bucket != "bucket" || key != "key" {
+		t.Errorf("unexpected result: %s %s %s", r.Name, bucket, key)
+	}
+}
+
+func TestRunCommandUnknown(t *testing.T) {
+	if code := runCommand([]string{"nonexistent"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestRunCommandVersion(t *testing.T) {
+	if code := runCommand([]string{"version"}); code != exitOK {
+		t.Errorf("expected exit code %d, got %d", exitOK, code)
+	}
+}
+
+func TestCmdCopyUsage(t *testing.T) {
+	if code := cmdCopy([]string{"only-one-arg"}); code != exitUsage {
+		t.Errorf("expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCmdCopyRequiresObjectURL(t *testing.T) {
+	originalPath := *configFilePath
+	defer func() { *configFilePath = originalPath }()
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(createTestConfig()), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if code := cmdCopy([]string{"-c", configFile, "a.txt", "b.txt"}); code != exitError {
+		t.Errorf("expected exit code %d, got %d", exitError, code)
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	denied := minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, exitOK},
+		{"plain error", errors.New("boom"), exitError},
+		{"S3 access denied", fmt.Errorf("upload: %w", denied), exitAuth},
+		{"S3 forbidden status", minio.ErrorResponse{StatusCode: http.StatusForbidden}, exitAuth},
+		{"S3 missing key", minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, exitError},
+		{"AMQP access refused", &amqp.Error{Code: amqp.AccessRefused}, exitAuth},
+		{"AMQP other", &amqp.Error{Code: amqp.NotFound}, exitError},
+		{"partial batch", &batchError{Op: "actions", Failed: 1, Total: 3, Err: errors.New("boom")}, exitPartial},
+		{"total batch", &batchError{Op: "actions", Failed: 3, Total: 3, Err: errors.New("boom")}, exitError},
+		{"batch of auth failures", &batchError{Op: "actions", Failed: 1, Total: 3, Err: denied}, exitAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandConfigExitCode(t *testing.T) {
+	originalPath := *configFilePath
+	defer func() { *configFilePath = originalPath }()
+
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if code := cmdVerify([]string{"-c", missing, "-workflow", "docs"}); code != exitConfig {
+		t.Errorf("expected exit code %d, got %d", exitConfig, code)
+	}
+}
@@ -0,0 +1,152 @@
+package bucketsync
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Validators an outbound workflow's validate option may name, checking that a file is complete
+// and well formed before it is uploaded
+const (
+	validateZip  = "zip"
+	validateGzip = "gzip"
+	validatePDF  = "pdf"
+	validateJSON = "json"
+)
+
+// pdfTrailerWindow is how far from the end of a PDF its %%EOF marker is looked for, allowing for
+// trailing whitespace and junk, which readers tolerate
+const pdfTrailerWindow = 1024
+
+// errInvalidContent is returned, wrapped with the reason, for a file failing its validator
+var errInvalidContent = errors.New("invalid content")
+
+// validateContentChecks checks an outbound workflow's validate and quarantine options
+func (o Outbound) validateContentChecks() error {
+	switch o.Validate {
+	case "", validateZip, validateGzip, validatePDF, validateJSON:
+	default:
+		return errors.New("validate: must be zip, gzip, pdf or json")
+	}
+	if o.Quarantine == "" {
+		return nil
+	}
+	if o.Validate == "" {
+		return errors.New("quarantine needs validate")
+	}
+	if !filepath.IsAbs(o.Quarantine) {
+		return errors.New("quarantine: must be an absolute path")
+	}
+	// Quarantined files would otherwise be found, and validated, again
+	if o.Source != "" && filepath.Clean(o.Quarantine) == filepath.Dir(o.Source) {
+		return errors.New("quarantine: must not be the source folder")
+	}
+	return nil
+}
+
+// validContent checks f with the named validator, returning an error wrapping errInvalidContent
+// if the file is corrupt or incomplete. The file is read from the start, and left wherever the
+// validator stopped.
+func validContent(validator string, f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var reason error
+	switch validator {
+	case validateZip:
+		// Reading the central directory finds truncated and mangled archives, without
+		// decompressing every member
+		_, reason = zip.NewReader(f, fi.Size())
+	case validateGzip:
+		// Decompressing the whole stream checks its length and CRC
+		var zr *gzip.Reader
+		if zr, reason = gzip.NewReader(f); reason == nil {
+			_, reason = io.Copy(io.Discard, zr)
+		}
+	case validatePDF:
+		reason = validPDF(f, fi.Size())
+	case validateJSON:
+		reason = validJSON(f)
+	default:
+		return fmt.Errorf("unknown validator %q", validator)
+	}
+	if reason != nil {
+		return fmt.Errorf("%w: not a valid %s file: %w", errInvalidContent, validator, reason)
+	}
+	return nil
+}
+
+// validPDF checks a PDF's header, and that its trailer was written
+func validPDF(f *os.File, size int64) error {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != "%PDF-" {
+		return errors.New("no %PDF- header")
+	}
+	tail := make([]byte, min(size, pdfTrailerWindow))
+	if _, err := f.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return err
+	}
+	if !bytes.Contains(tail, []byte("%%EOF")) {
+		return errors.New("no %%EOF marker, the file may be truncated")
+	}
+	return nil
+}
+
+// validJSON checks that f holds one or more JSON values, as a JSON document or JSON Lines do,
+// without reading the whole file into memory
+func validJSON(f *os.File) error {
+	dec := json.NewDecoder(f)
+	values := 0
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			values++
+		}
+	}
+	if depth != 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if values == 0 {
+		return errors.New("no JSON value")
+	}
+	return nil
+}
+
+// quarantineFile moves a file which failed validation into the quarantine folder, adding the
+// transfer ID to its name if one of the same name is there already, and returns its new path
+func quarantineFile(folder, name, transferID string) (string, error) {
+	dest := filepath.Join(folder, filepath.Base(name))
+	if _, err := os.Lstat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = strings.TrimSuffix(dest, ext) + "." + transferID + ext
+	}
+	if err := os.Rename(name, dest); err != nil {
+		return "", fmt.Errorf("failed to quarantine file: %w", err)
+	}
+	return dest, nil
+}
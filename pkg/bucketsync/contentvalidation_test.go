@@ -0,0 +1,135 @@
+package bucketsync
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidContent(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("report.csv")
+	_, _ = w.Write([]byte("a,b\n1,2\n"))
+	_ = zw.Close()
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	_, _ = gw.Write([]byte(strings.Repeat("log line\n", 100)))
+	_ = gw.Close()
+	pdf := "%PDF-1.7\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n"
+
+	tests := []struct {
+		name, validator, content string
+		valid                    bool
+	}{
+		{"zip", validateZip, zipped.String(), true},
+		{"truncated zip", validateZip, zipped.String()[:zipped.Len()-10], false},
+		{"gzip", validateGzip, gzipped.String(), true},
+		{"truncated gzip", validateGzip, gzipped.String()[:gzipped.Len()-6], false},
+		{"pdf", validatePDF, pdf, true},
+		{"truncated pdf", validatePDF, pdf[:30], false},
+		{"not pdf", validatePDF, "<html>%%EOF", false},
+		{"json", validateJSON, `{"a": [1, 2, {"b": null}]}`, true},
+		{"json lines", validateJSON, "{\"a\": 1}\n{\"a\": 2}\n", true},
+		{"truncated json", validateJSON, `{"a": [1, 2`, false},
+		{"bad json", validateJSON, `{"a": }`, false},
+		{"empty json", validateJSON, "", false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(name, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			err = validContent(tt.validator, f)
+			if tt.valid && err != nil {
+				t.Errorf("valid file failed: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, errInvalidContent) {
+				t.Errorf("invalid file: err = %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateQuarantinesInvalidFiles(t *testing.T) {
+	resetWorkflows(t)
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	quarantine := filepath.Join(dir, "quarantine")
+	if err := os.Mkdir(quarantine, 0700); err != nil {
+		t.Fatal(err)
+	}
+	o := Outbound{
+		Name:        "validated",
+		Source:      filepath.Join(dir, "*.json"),
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+		Validate:    validateJSON,
+		Quarantine:  quarantine,
+	}
+
+	good := filepath.Join(dir, "good.json")
+	bad := filepath.Join(dir, "bad.json")
+	_ = os.WriteFile(good, []byte(`{"ok": true}`), 0600)
+	_ = os.WriteFile(bad, []byte(`{"ok": tr`), 0600)
+
+	if err := uploadEvent(nil, o, good); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case u := <-uploads:
+		if u.body != `{"ok": true}` {
+			t.Errorf("uploaded %q", u.body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("valid file was not uploaded")
+	}
+
+	if err := uploadEvent(nil, o, bad); !errors.Is(err, errInvalidContent) {
+		t.Errorf("uploadEvent() = %v, want invalid content", err)
+	}
+	if _, err := os.Stat(filepath.Join(quarantine, "bad.json")); err != nil {
+		t.Errorf("invalid file not quarantined: %v", err)
+	}
+	select {
+	case u := <-uploads:
+		t.Errorf("invalid file uploaded to %s", u.path)
+	default:
+	}
+
+	// A second file of the same name is quarantined alongside the first
+	_ = os.WriteFile(bad, []byte("[1,"), 0600)
+	_ = uploadEvent(nil, o, bad)
+	if entries, _ := os.ReadDir(quarantine); len(entries) != 2 {
+		t.Errorf("%d files quarantined, want 2", len(entries))
+	}
+}
+
+func TestContentChecksValidate(t *testing.T) {
+	for _, o := range []Outbound{
+		{Validate: "xml"},
+		{Quarantine: "/srv/quarantine"},
+		{Validate: validatePDF, Quarantine: "quarantine"},
+		{Validate: validatePDF, Source: "/srv/in/*.pdf", Quarantine: "/srv/in/"},
+	} {
+		if err := o.validateContentChecks(); err == nil {
+			t.Errorf("%+v: validated", o)
+		}
+	}
+	o := Outbound{Validate: validatePDF, Source: "/srv/in/*.pdf", Quarantine: "/srv/in/quarantine"}
+	if err := o.validateContentChecks(); err != nil {
+		t.Error(err)
+	}
+}
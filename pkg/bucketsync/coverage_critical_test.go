@@ -1,4 +1,4 @@
-package main
+package bucketsync
 
 import (
 	"context"
@@ -468,6 +468,7 @@ inbound:
     exchange: test
     queue: test
     remote: default
+    destination: /tmp/destination
 `
 
 	err := os.WriteFile(tmpFile, []byte(configContent), 0o600)
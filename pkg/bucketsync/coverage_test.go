@@ -1,4 +1,4 @@
-package main
+package bucketsync
 
 import (
 	"context"
@@ -9,7 +9,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	amqp "github.com/rabbitmq/amqp091-go"
 	log "github.com/sirupsen/logrus"
 )
@@ -85,20 +84,13 @@ func testInboundWithAMQPFailure(t *testing.T) {
 
 // TestOutboundFunctionCoverage tests the outbound function with various inputs
 func TestOutboundFunctionCoverage(t *testing.T) {
-	// Save original config and watchers
+	// Save original config, and stop the workflows started here so goroutines don't race with later tests
 	originalConfig := config
-	originalWatchers := watchers
-	// Reset watchers once so we can track only those added by this test.
-	watchers = []*fsnotify.Watcher{}
+	resetOutboundWorkflows(t)
 	defer func() {
-		// Close all watchers added during this test so goroutines don't race with later tests.
-		for _, w := range watchers {
-			_ = w.Close()
-		}
 		configMutex.Lock()
 		config = originalConfig
 		configMutex.Unlock()
-		watchers = originalWatchers
 	}()
 
 	tests := []struct {
@@ -363,32 +355,6 @@ func TestInboundCloseImprovedCoverage(t *testing.T) {
 	})
 }
 
-// TestWatcherGlobalAccess tests access to the global watchers variable
-func TestWatcherGlobalAccess(t *testing.T) {
-	originalWatchers := watchers
-	defer func() { watchers = originalWatchers }()
-
-	// Test watchers initialization and access
-	initialLength := len(watchers)
-	if initialLength < 0 {
-		t.Errorf("Watchers length should not be negative: %d", initialLength)
-	}
-
-	// Test modifying watchers slice (as outbound function would do)
-	watcher, err := fsnotify.NewWatcher()
-	if err == nil {
-		watchers = append(watchers, watcher)
-		if closeErr := watcher.Close(); closeErr != nil {
-			t.Logf("Failed to close watcher: %v", closeErr)
-		}
-	}
-	newLength := len(watchers)
-
-	if newLength != initialLength+1 {
-		t.Errorf("Expected watchers length %d, got %d", initialLength+1, newLength)
-	}
-}
-
 // TestConfigGlobalAccess tests access to the global config variable
 func TestConfigGlobalAccess(t *testing.T) {
 	originalConfig := config
@@ -0,0 +1,129 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// credentialFileRefresh is how long keys read from files are used before the files are read
+// again, so that rotated secrets are picked up without restarting
+const credentialFileRefresh = 30 * time.Second
+
+// validateCredentials checks that each of the remote's keys is given once at most, inline or in
+// a file, or else read from Vault or the AWS credential chain
+func (r Remote) validateCredentials() error {
+	keys := r.AccessKey != "" || r.SecretKey != "" || r.AccessKeyFile != "" || r.SecretKeyFile != ""
+	if r.IAM && (keys || r.VaultPath != "") {
+		return errors.New("iam cannot be used with accessKey, secretKey, their files or vaultPath")
+	}
+	if r.VaultPath != "" && keys {
+		return errors.New("vaultPath cannot be used with accessKey, secretKey or their files")
+	}
+	if r.AccessKey != "" && r.AccessKeyFile != "" {
+		return errors.New("accessKey and accessKeyFile cannot both be set")
+	}
+	if r.SecretKey != "" && r.SecretKeyFile != "" {
+		return errors.New("secretKey and secretKeyFile cannot both be set")
+	}
+	return nil
+}
+
+// remoteCredentials returns the credentials requests to the remote are signed with: its keys as
+// configured, read from files if it names them, read from Vault, or from the AWS credential chain
+func remoteCredentials(r Remote) *credentials.Credentials {
+	if r.IAM {
+		return credentials.New(&awsChainCredentials{credentials.Chain{Providers: awsCredentialProviders()}})
+	}
+	if r.VaultPath != "" {
+		return credentials.New(&vaultCredentials{vault: currentVault(), path: r.VaultPath})
+	}
+	if r.AccessKeyFile == "" && r.SecretKeyFile == "" {
+		return credentials.NewStaticV4(r.AccessKey, r.SecretKey, "")
+	}
+	return credentials.New(&fileCredentials{
+		accessKey:     r.AccessKey,
+		accessKeyFile: r.AccessKeyFile,
+		secretKey:     r.SecretKey,
+		secretKeyFile: r.SecretKeyFile,
+	})
+}
+
+// awsCredentialProviders returns the providers of the AWS SDKs' default chain: the environment,
+// the shared credentials file, and the role of an IRSA web identity, ECS task or EC2 instance,
+// whose keys are renewed before they expire
+func awsCredentialProviders() []credentials.Provider {
+	return []credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: &http.Client{Timeout: defaultWebhookTimeout}},
+	}
+}
+
+// awsChainCredentials provides the keys of the first provider of the AWS credential chain to have
+// any, failing if none has rather than leaving requests unsigned as the chain alone would
+type awsChainCredentials struct {
+	credentials.Chain
+}
+
+func (p *awsChainCredentials) RetrieveWithCredContext(cc *credentials.CredContext) (credentials.Value, error) {
+	v, err := p.Chain.RetrieveWithCredContext(cc)
+	if err == nil && v.SignerType.IsAnonymous() {
+		return v, errors.New("no credentials found in the environment, shared credentials file or instance metadata")
+	}
+	return v, err
+}
+
+func (p *awsChainCredentials) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithCredContext(nil)
+}
+
+// fileCredentials provides keys read from files, such as mounted Kubernetes or Docker secrets,
+// reading them again once credentialFileRefresh has passed. Keys without a file are used as given.
+type fileCredentials struct {
+	credentials.Expiry
+	accessKey, accessKeyFile string
+	secretKey, secretKeyFile string
+}
+
+func (p *fileCredentials) RetrieveWithCredContext(_ *credentials.CredContext) (credentials.Value, error) {
+	accessKey, err := readCredentialFile(p.accessKeyFile, p.accessKey)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read access key: %w", err)
+	}
+	secretKey, err := readCredentialFile(p.secretKeyFile, p.secretKey)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read secret key: %w", err)
+	}
+	p.SetExpiration(time.Now().Add(credentialFileRefresh), 0)
+	// The keys were not in the configuration, so are not yet redacted from the log
+	addLogSecrets(accessKey, secretKey)
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (p *fileCredentials) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithCredContext(nil)
+}
+
+// readCredentialFile reads a key from a file, without the trailing newline secrets are often
+// written with, or returns value if no file is given
+func readCredentialFile(path, value string) (string, error) {
+	if path == "" {
+		return value, nil
+	}
+	// #nosec G304 - intentional: path comes from the configuration file
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,131 @@
+package bucketsync
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteCredentialsFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	accessFile, secretFile := filepath.Join(dir, "access"), filepath.Join(dir, "secret")
+	_ = os.WriteFile(accessFile, []byte("AKIA1\n"), 0600)
+	_ = os.WriteFile(secretFile, []byte("s3cret1\n"), 0600)
+	t.Cleanup(resetLoadedLogSecrets)
+
+	creds := remoteCredentials(Remote{AccessKeyFile: accessFile, SecretKeyFile: secretFile})
+	v, err := creds.GetWithContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "AKIA1" || v.SecretAccessKey != "s3cret1" {
+		t.Errorf("read %q/%q", v.AccessKeyID, v.SecretAccessKey)
+	}
+
+	// Rotated keys are used once the ones read have been used for credentialFileRefresh
+	_ = os.WriteFile(secretFile, []byte("s3cret2"), 0600)
+	if v, _ := creds.GetWithContext(nil); v.SecretAccessKey != "s3cret1" {
+		t.Errorf("keys read again too soon: %q", v.SecretAccessKey)
+	}
+	creds.Expire()
+	if v, _ := creds.GetWithContext(nil); v.SecretAccessKey != "s3cret2" {
+		t.Errorf("rotated secret key not read: %q", v.SecretAccessKey)
+	}
+	// Keys read from files are redacted from the log, rotated ones included
+	logRedactor.mu.RLock()
+	for _, key := range []string{"AKIA1", "s3cret1", "s3cret2"} {
+		if !logRedactor.loaded[key] {
+			t.Errorf("%q not redacted from the log", key)
+		}
+	}
+	logRedactor.mu.RUnlock()
+
+	p := &fileCredentials{accessKey: "inline", secretKeyFile: secretFile}
+	v, err = p.Retrieve()
+	if err != nil || v.AccessKeyID != "inline" || v.SecretAccessKey != "s3cret2" {
+		t.Errorf("Retrieve() = %+v, %v", v, err)
+	}
+	if p.IsExpired() {
+		t.Error("keys expired as soon as read")
+	}
+	p.CurrentTime = func() time.Time { return time.Now().Add(credentialFileRefresh + time.Second) }
+	if !p.IsExpired() {
+		t.Errorf("keys not expired after %s", credentialFileRefresh)
+	}
+
+	_ = os.Remove(secretFile)
+	if _, err := p.Retrieve(); err == nil || !strings.Contains(err.Error(), "secret key") {
+		t.Errorf("expected an error reading the secret key, got %v", err)
+	}
+}
+
+func TestRemoteCredentialsValidate(t *testing.T) {
+	tests := []struct {
+		remote Remote
+		want   string
+	}{
+		{Remote{Name: "a", Endpoint: "s3.example.com", AccessKey: "k", AccessKeyFile: "/run/secrets/k"}, "accessKey and accessKeyFile"},
+		{Remote{Name: "s", Endpoint: "s3.example.com", SecretKey: "s", SecretKeyFile: "/run/secrets/s"}, "secretKey and secretKeyFile"},
+		{Remote{Name: "i", Endpoint: "s3.amazonaws.com", IAM: true, AccessKey: "k"}, "iam cannot be used"},
+	}
+	for _, tt := range tests {
+		errs := (&Config{Remotes: []Remote{tt.remote}}).Validate()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.remote.Name, tt.want, errs)
+		}
+	}
+	valid := Config{Remotes: []Remote{{Name: "ok", Endpoint: "s3.example.com", AccessKey: "k", SecretKeyFile: "/run/secrets/s"}}}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Errorf("expected valid config, got %v", errs)
+	}
+}
+
+// withoutAWSCredentials clears the environment of AWS credentials, so that only the container
+// credentials endpoint at uri, if any, provides them
+func withoutAWSCredentials(t *testing.T, uri string) {
+	t.Helper()
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_AUTHORIZATION_TOKEN",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", uri)
+}
+
+func TestRemoteCredentialsIAM(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/creds" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, `{"AccessKeyId":"ASIATASK","SecretAccessKey":"tasksecret","Token":"session","Expiration":"`+expiration+`"}`)
+	}))
+	defer server.Close()
+
+	withoutAWSCredentials(t, server.URL+"/creds")
+	v, err := remoteCredentials(Remote{IAM: true}).GetWithContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "ASIATASK" || v.SecretAccessKey != "tasksecret" || v.SessionToken != "session" {
+		t.Errorf("unexpected keys %+v", v)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	if v, _ := remoteCredentials(Remote{IAM: true}).GetWithContext(nil); v.AccessKeyID != "AKIAENV" {
+		t.Errorf("expected the environment's keys first, got %q", v.AccessKeyID)
+	}
+
+	withoutAWSCredentials(t, server.URL+"/missing")
+	if _, err := remoteCredentials(Remote{IAM: true}).GetWithContext(nil); err == nil || !strings.Contains(err.Error(), "no credentials found") {
+		t.Errorf("expected an error without credentials, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// daemonEnv marks the re-executed background process so that it does not detach again
+const daemonEnv = "BUCKETSYNCD_DAEMONIZED"
+
+// isDaemonChild reports whether this process was started in the background by -daemon
+func isDaemonChild() bool {
+	return os.Getenv(daemonEnv) == "1"
+}
+
+// startDaemon re-executes the current command line as a detached background process and returns its PID.
+// Standard output and error are inherited so they can be redirected by the caller.
+func startDaemon() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("unable to locate executable: %w", err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = devNull.Close()
+	}()
+
+	attr := &os.ProcAttr{
+		Env:   append(os.Environ(), daemonEnv+"=1"),
+		Files: []*os.File{devNull, os.Stdout, os.Stderr},
+		Sys:   detachedProcAttr(),
+	}
+	p, err := os.StartProcess(exe, os.Args, attr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start background process: %w", err)
+	}
+	pid := p.Pid
+	if err := p.Release(); err != nil {
+		return 0, err
+	}
+	return pid, nil
+}
+
+// writePIDFile records the current process ID, refusing to overwrite the PID file of a running instance
+func writePIDFile(path string) error {
+	// #nosec G304 - intentional: path supplied by the operator on the command line
+	if data, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && pid != os.Getpid() && processExists(pid) {
+			return fmt.Errorf("already running with PID %d (from %s)", pid, path)
+		}
+	}
+
+	const filePerms = 0644
+	// #nosec G306 - the PID file is intentionally world-readable
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), filePerms)
+}
+
+// removePIDFile removes the PID file written by writePIDFile
+func removePIDFile(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("failed to remove PID file: ", err)
+	}
+}
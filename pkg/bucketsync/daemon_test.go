@@ -0,0 +1,63 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucketsyncd.pid")
+
+	if err := writePIDFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("PID file not written: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("expected our PID in file, got %q", data)
+	}
+
+	removePIDFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected PID file to be removed")
+	}
+
+	// Removing a missing PID file is not an error
+	removePIDFile(path)
+}
+
+func TestWritePIDFileRunningInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucketsyncd.pid")
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0600); err != nil {
+		t.Fatalf("failed to write PID file: %v", err)
+	}
+	if err := writePIDFile(path); err == nil {
+		t.Error("expected error when another instance is running")
+	}
+}
+
+func TestWritePIDFileStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucketsyncd.pid")
+	if err := os.WriteFile(path, []byte("not a pid\n"), 0600); err != nil {
+		t.Fatalf("failed to write PID file: %v", err)
+	}
+	if err := writePIDFile(path); err != nil {
+		t.Errorf("expected stale PID file to be replaced, got %v", err)
+	}
+}
+
+func TestIsDaemonChild(t *testing.T) {
+	t.Setenv(daemonEnv, "")
+	if isDaemonChild() {
+		t.Error("expected foreground process")
+	}
+	t.Setenv(daemonEnv, "1")
+	if !isDaemonChild() {
+		t.Error("expected daemon child process")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"syscall"
+)
+
+// detachedProcAttr starts the background process in a new session, away from the controlling terminal
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processExists reports whether a process with the given PID is running
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
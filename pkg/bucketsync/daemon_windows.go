@@ -0,0 +1,24 @@
+//go:build windows
+
+package bucketsync
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr starts the background process without a console window
+func detachedProcAttr() *syscall.SysProcAttr {
+	const createNoWindow = 0x08000000
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | createNoWindow}
+}
+
+// processExists reports whether a process with the given PID is running
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
@@ -0,0 +1,72 @@
+package bucketsync
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dumpStatus logs a snapshot of the daemon's runtime state and every workflow, for diagnosing
+// hosts where the admin API and metrics are unavailable
+func dumpStatus() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.WithFields(log.Fields{
+		"version":    version,
+		"uptime":     time.Since(startTime).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"heap_alloc": formatByteSize(int64(mem.HeapAlloc)), // #nosec G115 - memory sizes fit in int64
+		"heap_sys":   formatByteSize(int64(mem.HeapSys)),   // #nosec G115 - memory sizes fit in int64
+		"gc_cycles":  mem.NumGC,
+		"workflows":  len(workflowStatuses()),
+	}).Info("status dump")
+
+	for _, s := range workflowStatuses() {
+		fields := log.Fields{
+			"workflow":    s.Name,
+			"kind":        s.Kind,
+			"location":    s.Location,
+			"health":      s.Health,
+			"paused":      s.Paused,
+			"queue_depth": s.QueueDepth,
+			"transfers":   s.Transfers,
+			"errors":      s.Errors,
+			"bytes":       s.Bytes,
+		}
+		if s.LastTransfer != nil {
+			fields["last_transfer"] = s.LastTransfer.Format(time.RFC3339)
+		}
+		if s.LastError != "" {
+			fields["last_error"] = s.LastError
+			fields["last_error_time"] = s.LastErrorTime.Format(time.RFC3339)
+		}
+		log.WithFields(fields).Info("status dump: workflow")
+	}
+}
+
+var (
+	debugToggleMutex sync.Mutex
+	// debugRestoreLevel is the level to return to when debug logging was switched on at runtime
+	debugRestoreLevel *log.Level
+)
+
+// toggleDebugLogging switches between debug logging and the configured level, returning the new level
+func toggleDebugLogging() log.Level {
+	debugToggleMutex.Lock()
+	defer debugToggleMutex.Unlock()
+
+	if debugRestoreLevel != nil {
+		setLogLevel(*debugRestoreLevel)
+		debugRestoreLevel = nil
+	} else {
+		previous := getLogLevel()
+		debugRestoreLevel = &previous
+		setLogLevel(log.DebugLevel)
+	}
+	level := getLogLevel()
+	// Logged at warning level so the change is visible whichever level is now in effect
+	log.Warn("log level changed to ", level)
+	return level
+}
@@ -0,0 +1,65 @@
+package bucketsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestDumpStatus(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	w.setLocation("/srv/photos")
+	w.setHealth(healthWatching)
+	trackWorkflow("photos")(nil)
+
+	var buf bytes.Buffer
+	originalOut, originalFormatter := log.StandardLogger().Out, log.StandardLogger().Formatter
+	log.SetOutput(&buf)
+	log.SetFormatter(&log.TextFormatter{DisableColors: true})
+	defer func() {
+		log.SetOutput(originalOut)
+		log.SetFormatter(originalFormatter)
+	}()
+
+	dumpStatus()
+
+	out := buf.String()
+	for _, want := range []string{
+		`msg="status dump"`,
+		"goroutines=",
+		"heap_alloc=",
+		`msg="status dump: workflow"`,
+		"workflow=photos",
+		"location=/srv/photos",
+		"health=watching",
+		"transfers=1",
+		"last_transfer=",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status dump missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestToggleDebugLogging(t *testing.T) {
+	originalLevel := getLogLevel()
+	defer setLogLevel(originalLevel)
+	setLogLevel(log.WarnLevel)
+
+	if level := toggleDebugLogging(); level != log.DebugLevel {
+		t.Errorf("first toggle: level = %v, want debug", level)
+	}
+	if level := toggleDebugLogging(); level != log.WarnLevel {
+		t.Errorf("second toggle: level = %v, want warning", level)
+	}
+
+	// A configured debug level is restored after toggling away from and back to it
+	setLogLevel(log.DebugLevel)
+	toggleDebugLogging()
+	if level := toggleDebugLogging(); level != log.DebugLevel {
+		t.Errorf("level = %v, want debug", level)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var diagnosticSignalsOnce sync.Once
+
+// handleDiagnosticSignals dumps the daemon's status to the log on SIGUSR1, and toggles
+// debug logging on SIGUSR2. Calling it again has no effect.
+func handleDiagnosticSignals() {
+	diagnosticSignalsOnce.Do(func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+		go func() {
+			for sig := range sigs {
+				if sig == syscall.SIGUSR2 {
+					toggleDebugLogging()
+					continue
+				}
+				dumpStatus()
+			}
+		}()
+	})
+}
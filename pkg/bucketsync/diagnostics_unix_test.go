@@ -0,0 +1,73 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syncBuffer is a bytes.Buffer safe for the logger and test to use concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStatusSignal(t *testing.T) {
+	resetWorkflows(t)
+	var buf syncBuffer
+	originalOut := log.StandardLogger().Out
+	log.SetOutput(&buf)
+	defer log.SetOutput(originalOut)
+
+	handleDiagnosticSignals()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("failed to send SIGUSR1: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(buf.String(), "status dump") {
+		if time.Now().After(deadline) {
+			t.Fatal("no status dump logged after SIGUSR1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDebugSignal(t *testing.T) {
+	originalLevel := log.GetLevel()
+	defer log.SetLevel(originalLevel)
+	log.SetLevel(log.InfoLevel)
+
+	handleDiagnosticSignals()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatalf("failed to send SIGUSR2: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for log.GetLevel() != log.DebugLevel {
+		if time.Now().After(deadline) {
+			t.Fatal("log level not switched to debug after SIGUSR2")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	toggleDebugLogging()
+}
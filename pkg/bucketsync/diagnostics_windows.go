@@ -0,0 +1,7 @@
+//go:build windows
+
+package bucketsync
+
+// handleDiagnosticSignals does nothing, as Windows has no SIGUSR1 or SIGUSR2; use the admin API
+// or status command instead
+func handleDiagnosticSignals() {}
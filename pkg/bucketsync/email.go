@@ -0,0 +1,411 @@
+package bucketsync
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Email connection security
+const (
+	emailSTARTTLS = "starttls"
+	emailTLS      = "tls"
+	emailNoTLS    = "none"
+)
+
+const (
+	defaultEmailInterval = 15 * time.Minute
+	defaultEmailMaxLines = 50
+	emailTimeout         = 30 * time.Second
+
+	defaultEmailSubject = `bucketsyncd on {{.Hostname}}: ` +
+		`{{if .Stalled}}no transfers by {{.Stalled}} for {{.StallAfter}}{{else}}{{.Count}} failed transfer{{if gt .Count 1}}s{{end}}{{end}}`
+	defaultEmailBody = `{{if .Stalled}}Workflow {{.Stalled}} has not completed a transfer since {{.Since.Format "2006-01-02 15:04:05 MST"}}.
+{{else}}{{range .Failures}}{{.Time.Format "2006-01-02 15:04:05"}} {{.Workflow}}: {{or .Key .Path}}: {{.Error}}
+{{end}}{{if .Omitted}}…and {{.Omitted}} more
+{{end}}{{end}}`
+)
+
+// EmailAlert sends email over SMTP when transfers fail, and when a workflow has gone too long
+// without a successful transfer. Failures are batched so that at most one email is sent per interval.
+type EmailAlert struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	// Port defaults to 465 for implicit TLS and 587 otherwise
+	Port int `yaml:"port"`
+	// TLS is starttls (the default), tls for implicit TLS, or none
+	TLS      string   `yaml:"tls"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Workflows to alert on. All are included when empty.
+	Workflows []string `yaml:"workflows"`
+	// StallAfter alerts when a workflow has had no successful transfer for this long; zero disables it
+	StallAfter time.Duration `yaml:"stall_after"`
+	// Subject and Body are templates; see defaultEmailSubject and defaultEmailBody
+	Subject  string        `yaml:"subject"`
+	Body     string        `yaml:"body"`
+	Interval time.Duration `yaml:"interval"`
+	MaxLines int           `yaml:"max_lines"`
+}
+
+// emailMessage is the data passed to the subject and body templates
+type emailMessage struct {
+	Hostname string
+	// Failures and Omitted describe a batch of failed transfers, of which Count is the total
+	Failures []LifecycleEvent
+	Omitted  int
+	Count    int
+	// Stalled is the workflow which has had no successful transfer since Since
+	Stalled    string
+	Since      time.Time
+	StallAfter time.Duration
+}
+
+func (a EmailAlert) validate() error {
+	if a.Host == "" {
+		return errors.New("host is required")
+	}
+	if a.Port < 0 || a.Port > 65535 {
+		return errors.New("port is out of range")
+	}
+	switch a.TLS {
+	case "", emailSTARTTLS, emailTLS, emailNoTLS:
+	default:
+		return fmt.Errorf("unknown tls %q (expected starttls, tls or none)", a.TLS)
+	}
+	if _, err := mail.ParseAddress(a.From); err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	if len(a.To) == 0 {
+		return errors.New("at least one to address is required")
+	}
+	for _, to := range a.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid to address %q: %w", to, err)
+		}
+	}
+	for _, tmpl := range []string{a.Subject, a.Body} {
+		if _, err := template.New(a.Name).Parse(tmpl); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	if a.StallAfter < 0 || a.Interval < 0 || a.MaxLines < 0 {
+		return errors.New("stall_after, interval and max_lines must not be negative")
+	}
+	return nil
+}
+
+// emailSender batches failures for one email alert and watches for stalled workflows
+type emailSender struct {
+	cfg      EmailAlert
+	subject  *template.Template
+	body     *template.Template
+	hostname string
+	queue    chan LifecycleEvent
+	failures []LifecycleEvent
+	overflow int
+	lastSent time.Time
+	dropped  atomic.Int64
+
+	// started is when watching began, standing in for the last transfer of workflows which have
+	// had none, and stalled holds the workflows already alerted on until they transfer again
+	started time.Time
+	stalled map[string]bool
+}
+
+var (
+	emailMutex  sync.RWMutex
+	emailAlerts []*emailSender
+)
+
+// openEmailAlerts starts sending email alerts. The returned function sends any batched failures
+// and stops the alerts.
+func openEmailAlerts(alerts []EmailAlert) (func(), error) {
+	hostname, _ := os.Hostname()
+	senders := make([]*emailSender, 0, len(alerts))
+	for _, a := range alerts {
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("email alert %q: %w", a.Name, err)
+		}
+		if a.Name == "" {
+			a.Name = a.Host
+		}
+		if a.TLS == "" {
+			a.TLS = emailSTARTTLS
+		}
+		if a.Port == 0 {
+			a.Port = 587
+			if a.TLS == emailTLS {
+				a.Port = 465
+			}
+		}
+		if a.Subject == "" {
+			a.Subject = defaultEmailSubject
+		}
+		if a.Body == "" {
+			a.Body = defaultEmailBody
+		}
+		if a.Interval == 0 {
+			a.Interval = defaultEmailInterval
+		}
+		if a.MaxLines == 0 {
+			a.MaxLines = defaultEmailMaxLines
+		}
+		senders = append(senders, &emailSender{
+			cfg:      a,
+			subject:  template.Must(template.New(a.Name).Parse(a.Subject)),
+			body:     template.Must(template.New(a.Name).Parse(a.Body)),
+			hostname: hostname,
+			queue:    make(chan LifecycleEvent, webhookQueueSize),
+			started:  time.Now().UTC(),
+			stalled:  make(map[string]bool),
+		})
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run()
+		}()
+	}
+
+	emailMutex.Lock()
+	emailAlerts = senders
+	emailMutex.Unlock()
+
+	return func() {
+		emailMutex.Lock()
+		emailAlerts = nil
+		emailMutex.Unlock()
+		for _, s := range senders {
+			close(s.queue)
+		}
+		wg.Wait()
+	}, nil
+}
+
+// notifyEmail queues a failed transfer for every email alert interested in it
+func notifyEmail(ev LifecycleEvent) {
+	if ev.Event != eventFailed {
+		return
+	}
+	emailMutex.RLock()
+	defer emailMutex.RUnlock()
+	for _, s := range emailAlerts {
+		if !s.wants(ev.Workflow) {
+			continue
+		}
+		select {
+		case s.queue <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (s *emailSender) wants(workflow string) bool {
+	return len(s.cfg.Workflows) == 0 || slices.Contains(s.cfg.Workflows, workflow)
+}
+
+// run batches failures in the same way as the chat notifiers, and checks for stalled
+// workflows if stall_after is set
+func (s *emailSender) run() {
+	var timer, watchdog <-chan time.Time
+	if s.cfg.StallAfter > 0 {
+		ticker := time.NewTicker(min(s.cfg.StallAfter/10, time.Minute))
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	for {
+		select {
+		case ev, ok := <-s.queue:
+			if !ok {
+				s.flush()
+				return
+			}
+			s.add(ev)
+			if timer == nil {
+				if wait := s.cfg.Interval - time.Since(s.lastSent); wait > 0 {
+					timer = time.After(wait)
+				} else {
+					s.flush()
+				}
+			}
+		case <-timer:
+			timer = nil
+			s.flush()
+		case now := <-watchdog:
+			s.checkStalls(now)
+		}
+	}
+}
+
+func (s *emailSender) add(ev LifecycleEvent) {
+	if len(s.failures) >= s.cfg.MaxLines {
+		s.overflow++
+		return
+	}
+	s.failures = append(s.failures, ev)
+}
+
+// flush emails the batched failures
+func (s *emailSender) flush() {
+	s.overflow += int(s.dropped.Swap(0))
+	if len(s.failures) == 0 && s.overflow == 0 {
+		return
+	}
+	msg := emailMessage{
+		Hostname: s.hostname,
+		Failures: s.failures,
+		Omitted:  s.overflow,
+		Count:    len(s.failures) + s.overflow,
+	}
+	s.failures, s.overflow = nil, 0
+	s.lastSent = time.Now()
+	if err := s.send(msg); err != nil {
+		log.WithFields(log.Fields{"alert": s.cfg.Name}).Error("failed to send failure email: ", err)
+	}
+}
+
+// checkStalls emails once for each workflow which has had no successful transfer within stall_after,
+// and again only after it has transferred something since
+func (s *emailSender) checkStalls(now time.Time) {
+	for _, st := range workflowStatuses() {
+		if !s.wants(st.Name) || st.Paused {
+			continue
+		}
+		since := s.started
+		if st.LastTransfer != nil && st.LastTransfer.After(since) {
+			since = *st.LastTransfer
+		}
+		if now.Sub(since) < s.cfg.StallAfter {
+			delete(s.stalled, st.Name)
+			continue
+		}
+		if s.stalled[st.Name] {
+			continue
+		}
+		s.stalled[st.Name] = true
+		log.WithFields(log.Fields{
+			"alert":    s.cfg.Name,
+			"workflow": st.Name,
+			"since":    since,
+		}).Warn("workflow has stalled, sending email alert")
+		msg := emailMessage{
+			Hostname:   s.hostname,
+			Stalled:    st.Name,
+			Since:      since,
+			StallAfter: s.cfg.StallAfter,
+		}
+		if err := s.send(msg); err != nil {
+			log.WithFields(log.Fields{"alert": s.cfg.Name}).Error("failed to send stall email: ", err)
+		}
+	}
+}
+
+// send renders a message and delivers it to every recipient
+func (s *emailSender) send(msg emailMessage) error {
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, msg); err != nil {
+		return fmt.Errorf("failed to render subject: %w", err)
+	}
+	if err := s.body.Execute(&body, msg); err != nil {
+		return fmt.Errorf("failed to render body: %w", err)
+	}
+	return s.deliver(s.compose(subject.String(), body.String()))
+}
+
+// compose builds an RFC 5322 message with a plain text body
+func (s *emailSender) compose(subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	// Subjects are rendered from templates, so keep a stray newline from ending the headers
+	subject = strings.Join(strings.Fields(subject), " ")
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// deliver sends a composed message over SMTP, upgrading the connection as configured
+func (s *emailSender) deliver(msg []byte) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host, MinVersion: tls.VersionTLS12}
+	dialer := &net.Dialer{Timeout: emailTimeout}
+
+	var conn net.Conn
+	var err error
+	if s.cfg.TLS == emailTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(emailTimeout))
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if s.cfg.TLS == emailSTARTTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(s.cfg.From)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range s.cfg.To {
+		addr, _ := mail.ParseAddress(to)
+		if err := c.Rcpt(addr.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
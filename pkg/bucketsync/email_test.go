@@ -0,0 +1,231 @@
+package bucketsync
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// smtpRecorder is a minimal plain SMTP server which records the messages it receives
+type smtpRecorder struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	messages []string
+	rcpts    []string
+}
+
+func newSMTPRecorder(t *testing.T) *smtpRecorder {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &smtpRecorder{ln: ln}
+	go r.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return r
+}
+
+func (r *smtpRecorder) port() int {
+	return r.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (r *smtpRecorder) serve() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn)
+	}
+}
+
+func (r *smtpRecorder) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	reply := func(s string) {
+		_, _ = rw.WriteString(s + "\r\n")
+		_ = rw.Flush()
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			r.mu.Lock()
+			r.rcpts = append(r.rcpts, strings.TrimSpace(line[len("RCPT TO:"):]))
+			r.mu.Unlock()
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := rw.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			r.mu.Lock()
+			r.messages = append(r.messages, msg.String())
+			r.mu.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (r *smtpRecorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.messages...)
+}
+
+func TestEmailAlertValidate(t *testing.T) {
+	valid := EmailAlert{Host: "smtp.example.com", From: "bucketsyncd@example.com", To: []string{"ops@example.com"}}
+	tests := []struct {
+		name    string
+		modify  func(a *EmailAlert)
+		wantErr bool
+	}{
+		{"valid", func(a *EmailAlert) {}, false},
+		{"implicit tls", func(a *EmailAlert) { a.TLS = emailTLS }, false},
+		{"missing host", func(a *EmailAlert) { a.Host = "" }, true},
+		{"unknown tls", func(a *EmailAlert) { a.TLS = "ssl" }, true},
+		{"bad from", func(a *EmailAlert) { a.From = "not an address" }, true},
+		{"no recipients", func(a *EmailAlert) { a.To = nil }, true},
+		{"bad recipient", func(a *EmailAlert) { a.To = []string{"ops"} }, true},
+		{"bad subject", func(a *EmailAlert) { a.Subject = "{{" }, true},
+		{"negative stall", func(a *EmailAlert) { a.StallAfter = -time.Hour }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := valid
+			tt.modify(&a)
+			if err := a.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmailFailureBatching(t *testing.T) {
+	srv := newSMTPRecorder(t)
+	closeEmail, err := openEmailAlerts([]EmailAlert{{
+		Host:     "127.0.0.1",
+		Port:     srv.port(),
+		TLS:      emailNoTLS,
+		From:     "bucketsyncd@example.com",
+		To:       []string{"Ops <ops@example.com>"},
+		Interval: time.Hour,
+		MaxLines: 2,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Successful transfers are not emailed, the first failure is sent straight away and the rest are batched
+	notifyEmail(LifecycleEvent{Event: eventUploaded, Workflow: "photos", Key: "ok.jpg"})
+	notifyEmail(LifecycleEvent{Event: eventFailed, Workflow: "photos", Key: "first.jpg", Error: "timeout"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := range 5 {
+		notifyEmail(LifecycleEvent{Event: eventFailed, Workflow: "photos", Key: strconv.Itoa(i) + ".jpg", Error: "timeout"})
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(srv.received()); n != 1 {
+		t.Fatalf("got %d emails before the interval, want 1", n)
+	}
+	closeEmail()
+
+	msgs := srv.received()
+	if len(msgs) != 2 {
+		t.Fatalf("got %d emails, want 2", len(msgs))
+	}
+	if !strings.Contains(msgs[0], ": 1 failed transfer\r\n") || !strings.Contains(msgs[0], "photos: first.jpg: timeout") {
+		t.Errorf("unexpected first email:\n%s", msgs[0])
+	}
+	if !strings.Contains(msgs[1], ": 5 failed transfers\r\n") || !strings.Contains(msgs[1], "…and 3 more") {
+		t.Errorf("unexpected batched email:\n%s", msgs[1])
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.rcpts[0] != "<ops@example.com>" {
+		t.Errorf("recipient = %q, want <ops@example.com>", srv.rcpts[0])
+	}
+}
+
+func TestEmailStallAlerts(t *testing.T) {
+	resetWorkflows(t)
+	srv := newSMTPRecorder(t)
+	started := time.Now().Add(-3 * time.Hour)
+	s := &emailSender{
+		cfg: EmailAlert{
+			Host:       "127.0.0.1",
+			Port:       srv.port(),
+			TLS:        emailNoTLS,
+			From:       "bucketsyncd@example.com",
+			To:         []string{"ops@example.com"},
+			Workflows:  []string{"photos", "paused"},
+			StallAfter: 2 * time.Hour,
+		},
+		hostname: "nas",
+		started:  started,
+		stalled:  make(map[string]bool),
+	}
+	s.subject = template.Must(template.New("subject").Parse(defaultEmailSubject))
+	s.body = template.Must(template.New("body").Parse(defaultEmailBody))
+
+	registerWorkflow("photos", workflowOutbound)
+	registerWorkflow("ignored", workflowOutbound)
+	registerWorkflow("paused", workflowOutbound).pause()
+
+	// Only the stalled, unpaused workflow is alerted on, and only once
+	s.checkStalls(time.Now())
+	s.checkStalls(time.Now())
+	msgs := srv.received()
+	if len(msgs) != 1 {
+		t.Fatalf("got %d emails, want 1", len(msgs))
+	}
+	if !strings.Contains(msgs[0], "Subject: bucketsyncd on nas: no transfers by photos for 2h0m0s\r\n") {
+		t.Errorf("unexpected stall email:\n%s", msgs[0])
+	}
+
+	// A transfer clears the alert, so the next stall is reported again
+	w, _ := findWorkflow("photos")
+	w.begin()
+	w.end(nil)
+	s.checkStalls(time.Now())
+	s.checkStalls(time.Now().Add(3 * time.Hour))
+	if n := len(srv.received()); n != 2 {
+		t.Errorf("got %d emails after the workflow stalled again, want 2", n)
+	}
+}
+
+func TestEmailComposeSubjectOnOneLine(t *testing.T) {
+	s := &emailSender{cfg: EmailAlert{From: "a@example.com", To: []string{"b@example.com"}}}
+	msg := string(s.compose("two\nlines", "body\n"))
+	if !strings.Contains(msg, "Subject: two lines\r\n") {
+		t.Errorf("subject not folded onto one line:\n%s", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nbody\r\n") {
+		t.Errorf("body line endings not converted:\n%q", msg)
+	}
+}
@@ -0,0 +1,158 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Lifecycle events written to the event stream
+const (
+	eventDetected   = "detected"
+	eventQueued     = "queued"
+	eventStarted    = "started"
+	eventUploaded   = "uploaded"
+	eventDownloaded = "downloaded"
+	eventReplicated = "replicated"
+	eventFailed     = "failed"
+	eventAcked      = "acked"
+)
+
+// eventStreamBuffer is how many events may be pending before new ones are dropped, so that
+// a slow or absent reader on a named pipe cannot stall transfers
+const eventStreamBuffer = 1024
+
+// LifecycleEvent is a step in a transfer's life: detected, queued, started, uploaded, downloaded,
+// replicated, failed or acked. Each is a line of the NDJSON event stream and is passed to the event hooks.
+type LifecycleEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	TransferID string    `json:"transfer_id,omitempty"`
+	Workflow   string    `json:"workflow"`
+	Path       string    `json:"path,omitempty"`
+	Remote     string    `json:"remote,omitempty"`
+	Bucket     string    `json:"bucket,omitempty"`
+	Key        string    `json:"key,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Checksum   string    `json:"checksum,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CID        string    `json:"cid,omitempty"`
+}
+
+var (
+	eventsMutex sync.RWMutex
+	events      chan LifecycleEvent
+	eventHooks  []*func(LifecycleEvent)
+)
+
+// addEventHook calls hook with every lifecycle event until the returned function is called
+func addEventHook(hook func(LifecycleEvent)) func() {
+	h := &hook
+	eventsMutex.Lock()
+	eventHooks = append(eventHooks, h)
+	eventsMutex.Unlock()
+	return func() {
+		eventsMutex.Lock()
+		defer eventsMutex.Unlock()
+		for i, registered := range eventHooks {
+			if registered == h {
+				eventHooks = append(eventHooks[:i:i], eventHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// openEventStream starts writing lifecycle events to target, which is "-" for standard output or
+// the path of a file or named pipe. The returned function stops the stream and waits for it to drain.
+func openEventStream(target string) (func(), error) {
+	var w io.WriteCloser = os.Stdout
+	if target != "-" {
+		const filePerms = 0600
+		// O_RDWR lets a named pipe be opened before a reader connects
+		// #nosec G304 - intentional: path comes from the configuration file
+		f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_APPEND, filePerms)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	ch := make(chan LifecycleEvent, eventStreamBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writeEvents(w, ch)
+	}()
+
+	eventsMutex.Lock()
+	events = ch
+	eventsMutex.Unlock()
+
+	return func() {
+		eventsMutex.Lock()
+		events = nil
+		eventsMutex.Unlock()
+		close(ch)
+		<-done
+		if w != os.Stdout {
+			_ = w.Close()
+		}
+	}, nil
+}
+
+func writeEvents(w io.Writer, ch <-chan LifecycleEvent) {
+	enc := json.NewEncoder(w)
+	for ev := range ch {
+		if err := enc.Encode(ev); err != nil {
+			log.Error("failed to write to event stream: ", err)
+		}
+	}
+}
+
+// emitEvent passes an event to the event hooks and queues it for the event stream, if one is open
+func emitEvent(ev LifecycleEvent) {
+	eventsMutex.RLock()
+	defer eventsMutex.RUnlock()
+	if events == nil && len(eventHooks) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	for _, hook := range eventHooks {
+		(*hook)(ev)
+	}
+	if events == nil {
+		return
+	}
+	select {
+	case events <- ev:
+	default:
+		log.WithFields(log.Fields{
+			"event":       ev.Event,
+			"transfer_id": ev.TransferID,
+		}).Warn("event stream is full, dropping event")
+	}
+}
+
+// transferEvent describes a completed or failed transfer as a lifecycle event
+func transferEvent(event string, rec TransferRecord) LifecycleEvent {
+	return LifecycleEvent{
+		Event:      event,
+		TransferID: rec.ID,
+		Workflow:   rec.Workflow,
+		Path:       rec.Path,
+		Remote:     rec.Remote,
+		Bucket:     rec.Bucket,
+		Key:        rec.Key,
+		Size:       rec.Size,
+		Checksum:   rec.Checksum,
+		Error:      rec.Error,
+		CID:        rec.CID,
+	}
+}
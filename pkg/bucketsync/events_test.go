@@ -0,0 +1,70 @@
+package bucketsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	closeEvents, err := openEventStream(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: "abc", Workflow: "docs", Path: "/src/a.pdf"})
+	emitEvent(transferEvent(eventUploaded, TransferRecord{ID: "abc", Workflow: "docs", Key: "p/a.pdf", Size: 10}))
+	closeEvents()
+
+	// Events emitted after the stream is closed are discarded
+	emitEvent(LifecycleEvent{Event: eventFailed, Workflow: "docs"})
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var got []LifecycleEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var ev LifecycleEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(got))
+	}
+	if got[0].Event != eventDetected || got[0].Time.IsZero() || got[0].Path != "/src/a.pdf" {
+		t.Errorf("unexpected first event: %+v", got[0])
+	}
+	if got[1].Event != eventUploaded || got[1].TransferID != "abc" || got[1].Key != "p/a.pdf" || got[1].Size != 10 {
+		t.Errorf("unexpected second event: %+v", got[1])
+	}
+}
+
+func TestEmitEventWithoutStream(t *testing.T) {
+	// Must not block or panic when no stream is configured
+	emitEvent(LifecycleEvent{Event: eventDetected, Workflow: "docs"})
+}
+
+func TestEventHooks(t *testing.T) {
+	var got []LifecycleEvent
+	remove := addEventHook(func(ev LifecycleEvent) {
+		got = append(got, ev)
+	})
+	emitEvent(LifecycleEvent{Event: eventUploaded, Workflow: "docs"})
+	remove()
+	emitEvent(LifecycleEvent{Event: eventFailed, Workflow: "docs"})
+
+	if len(got) != 1 || got[0].Event != eventUploaded || got[0].Time.IsZero() {
+		t.Errorf("hook received %+v, want one timestamped uploaded event", got)
+	}
+}
@@ -0,0 +1,83 @@
+package bucketsync
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// fifoRetryDelay is how long to wait before opening a FIFO again after failing to
+	fifoRetryDelay = 5 * time.Second
+	// fifoWakeInterval is how often a FIFO is opened for writing on shutdown, until the reader
+	// blocked opening it has woken
+	fifoWakeInterval = 100 * time.Millisecond
+)
+
+// streamFifo uploads each stream written into the workflow's FIFO until ctx is done: a writer
+// opening the pipe, writing into it and closing it makes one object
+func streamFifo(ctx context.Context, lf log.Fields, o Outbound, tmpl *template.Template, state *workflowState) {
+	// Opening the FIFO blocks until a writer opens it too, so once ctx is done it is opened for
+	// writing until the reader has returned
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-stopped:
+			return
+		case <-ctx.Done():
+		}
+		for {
+			wakeFifo(o.Fifo)
+			select {
+			case <-stopped:
+				return
+			case <-time.After(fifoWakeInterval):
+			}
+		}
+	}()
+
+	for {
+		// A paused workflow leaves writers blocked opening the pipe until it is resumed
+		if err := state.waitWhilePaused(ctx); err != nil {
+			return
+		}
+		// #nosec G304 - intentional: the FIFO is configured
+		f, err := os.Open(o.Fifo)
+		if ctx.Err() != nil {
+			if err == nil {
+				_ = f.Close()
+			}
+			return
+		}
+		if err != nil {
+			log.WithFields(lf).Error("failed to open FIFO: ", err)
+			state.setHealth(healthDegraded)
+			if !sleepContext(ctx, fifoRetryDelay) {
+				return
+			}
+			continue
+		}
+		state.setHealth(healthWatching)
+		r := bufio.NewReaderSize(f, transferBufferSize)
+		// A writer closing the pipe without writing anything makes no object
+		if _, err := r.Peek(1); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.WithFields(lf).Error("failed to read FIFO: ", err)
+			}
+		} else {
+			// Failures are logged by uploadStream as they occur, and closing the pipe early
+			// fails the writer
+			_ = uploadStream(lf, o, tmpl, o.Fifo, r)
+		}
+		if err := f.Close(); err != nil {
+			log.WithFields(lf).Error("failed to close FIFO: ", err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// prepareFifo creates the named pipe at path unless it already exists
+func prepareFifo(path string) error {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		const fifoPerms = 0600
+		if err := syscall.Mkfifo(path, fifoPerms); err != nil {
+			return fmt.Errorf("failed to create FIFO: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+	return nil
+}
+
+// wakeFifo opens the named pipe at path for writing and closes it straight away, waking any
+// reader blocked opening it with an empty stream. Without a reader it does nothing.
+func wakeFifo(path string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err == nil {
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,93 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareFifo(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "backup.fifo")
+	if err := prepareFifo(fifo); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("%s is not a named pipe", fifo)
+	}
+	// An existing pipe is used as it is
+	if err := prepareFifo(fifo); err != nil {
+		t.Error(err)
+	}
+
+	plain := filepath.Join(dir, "plain")
+	if err := os.WriteFile(plain, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareFifo(plain); err == nil {
+		t.Error("regular file accepted as a FIFO")
+	}
+}
+
+func TestFifoOutboundUploadsEachStream(t *testing.T) {
+	server, uploads := newPutRecorder(t)
+
+	fifo := filepath.Join(t.TempDir(), "backup.fifo")
+	o := Outbound{
+		Name:        "fifo-test",
+		Fifo:        fifo,
+		Key:         "dumps/{{.Name}}-{{.Workflow}}.sql",
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+	}
+	if _, err := startOutbound(o); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the pipe blocks until the workflow is reading it
+	f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("-- dump\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-uploads:
+		if u.path != "/uploads/dumps/backup-fifo-test.sql" || u.body != "-- dump\n" {
+			t.Errorf("uploaded %q to %s", u.body, u.path)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("stream was not uploaded")
+	}
+
+	// Stopping wakes the reader blocked opening the pipe again
+	stopped := make(chan bool)
+	go func() {
+		stopped <- stopOutbound(o.Name)
+	}()
+	select {
+	case ok := <-stopped:
+		if !ok {
+			t.Error("workflow was not running")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("workflow did not stop")
+	}
+	select {
+	case u := <-uploads:
+		t.Errorf("unexpected upload to %s", u.path)
+	default:
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package bucketsync
+
+import "errors"
+
+// prepareFifo reports that named pipe sources are unavailable, Windows named pipes being another thing entirely
+func prepareFifo(string) error {
+	return errors.New("FIFO sources are not supported on Windows")
+}
+
+func wakeFifo(string) {}
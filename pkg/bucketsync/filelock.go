@@ -0,0 +1,308 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+)
+
+// File lock backends
+const (
+	fileLockBackendFile   = "file"
+	fileLockBackendBucket = "bucket"
+
+	defaultFileLockDir = ".bucketsyncd-locks"
+	defaultFileLockTTL = time.Hour
+)
+
+// FileLock lets several instances watch the same shared folder, such as an NFS or SMB export,
+// with only the one taking a file's lock uploading it
+type FileLock struct {
+	// Backend is file, for lock files in a shared directory, or bucket, for lock objects
+	Backend string `yaml:"backend"`
+	// Dir holds the lock files, by default .bucketsyncd-locks in the watched folder
+	Dir string `yaml:"dir"`
+	// Remote, Bucket and Prefix locate the lock objects
+	Remote string `yaml:"remote"`
+	Bucket string `yaml:"bucket"`
+	Prefix string `yaml:"prefix"`
+	// TTL is how long a lock keeps other instances from uploading the same file, 1h by default
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func (l FileLock) validate() error {
+	switch l.Backend {
+	case "", fileLockBackendFile:
+	case fileLockBackendBucket:
+		if l.Remote == "" || l.Bucket == "" {
+			return errors.New("remote and bucket are required for the bucket backend")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q (expected file or bucket)", l.Backend)
+	}
+	if l.TTL < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	return nil
+}
+
+// fileLockRecord is the content of a lock
+type fileLockRecord struct {
+	Holder  string    `json:"holder"`
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires"`
+}
+
+// fileLocker takes and releases the locks for one outbound workflow's files
+type fileLocker interface {
+	// tryLock takes the named lock unless another instance holds it and it has not expired
+	tryLock(ctx context.Context, name string, rec fileLockRecord) (bool, error)
+	unlock(ctx context.Context, name string) error
+}
+
+var (
+	fileLockersMutex sync.Mutex
+	fileLockers      = make(map[string]fileLocker)
+
+	// lockHolder identifies this instance in the locks it takes
+	lockHolder = func() string {
+		hostname, _ := os.Hostname()
+		return hostname + "_" + strconv.Itoa(os.Getpid())
+	}()
+)
+
+// outboundLocker returns the locker for an outbound workflow, or nil if it does not lock files
+func outboundLocker(o Outbound) (fileLocker, error) {
+	if o.Lock.Backend == "" {
+		return nil, nil
+	}
+	fileLockersMutex.Lock()
+	defer fileLockersMutex.Unlock()
+	if l, ok := fileLockers[o.Name]; ok {
+		return l, nil
+	}
+	var l fileLocker
+	switch o.Lock.Backend {
+	case fileLockBackendFile:
+		dir := o.Lock.Dir
+		if dir == "" {
+			dir = filepath.Join(filepath.Dir(o.Source), defaultFileLockDir)
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		l = &dirLocker{dir: dir}
+	case fileLockBackendBucket:
+		remote, ok := findRemote(o.Lock.Remote)
+		if !ok {
+			return nil, fmt.Errorf("no remote named %q", o.Lock.Remote)
+		}
+		mc, err := newMinioClient(remote)
+		if err != nil {
+			return nil, err
+		}
+		l = &bucketLocker{mc: mc, bucket: o.Lock.Bucket, prefix: o.Lock.Prefix}
+	}
+	fileLockers[o.Name] = l
+	return l, nil
+}
+
+// lockOutboundFile takes the lock for a version of a file, named after its path, size and
+// modification time so that a rewritten file is uploaded again. It reports false if another
+// instance has it; otherwise the returned function must be called with the upload's outcome,
+// releasing the lock if it failed so that the file can be tried again.
+func lockOutboundFile(ctx context.Context, o Outbound, name string, fi fs.FileInfo) (func(error), bool, error) {
+	l, err := outboundLocker(o)
+	if err != nil || l == nil {
+		return func(error) {}, err == nil, err
+	}
+	ttl := o.Lock.TTL
+	if ttl == 0 {
+		ttl = defaultFileLockTTL
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d", name, fi.Size(), fi.ModTime().UnixNano()))
+	lockName := hex.EncodeToString(sum[:])
+	locked, err := l.tryLock(ctx, lockName, fileLockRecord{Holder: lockHolder, Path: name, Expires: time.Now().Add(ttl)})
+	if err != nil || !locked {
+		return nil, false, err
+	}
+	return func(uploadErr error) {
+		if uploadErr == nil {
+			return
+		}
+		if err := l.unlock(context.WithoutCancel(ctx), lockName); err != nil {
+			log.WithFields(log.Fields{"workflow": o.Name, "name": name}).Error("failed to release file lock: ", err)
+		}
+	}, true, nil
+}
+
+// dirLocker keeps locks as files in a shared directory, created exclusively so that only one
+// instance can take each. Expired locks are replaced as they are found, and swept hourly.
+type dirLocker struct {
+	dir string
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func (d *dirLocker) tryLock(_ context.Context, name string, rec fileLockRecord) (bool, error) {
+	d.prune()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	lockPath := filepath.Join(d.dir, name)
+	for range 2 {
+		// #nosec G304 - lock files are named by hash within the configured directory
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err == nil, err
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return false, err
+		}
+		current, ok := readFileLock(lockPath)
+		if ok && current.Holder == rec.Holder {
+			// Ours already, as when a held file is tried again
+			return true, os.WriteFile(lockPath, data, 0600)
+		}
+		if !ok || time.Now().Before(current.Expires) {
+			return false, nil
+		}
+		// Take over a lock left by an instance which stopped before finishing
+		if err := os.Remove(lockPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// readFileLock reads a lock file, reporting false if it cannot, since it may still be being written
+func readFileLock(lockPath string) (fileLockRecord, bool) {
+	var rec fileLockRecord
+	// #nosec G304 - lock files are named by hash within the configured directory
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return rec, false
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, false
+	}
+	return rec, true
+}
+
+func (d *dirLocker) unlock(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(d.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// prune removes expired lock files, at most once an hour
+func (d *dirLocker) prune() {
+	d.mu.Lock()
+	if time.Since(d.lastPruned) < time.Hour {
+		d.mu.Unlock()
+		return
+	}
+	d.lastPruned = time.Now()
+	d.mu.Unlock()
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		lockPath := filepath.Join(d.dir, e.Name())
+		if rec, ok := readFileLock(lockPath); ok && time.Now().After(rec.Expires) {
+			_ = os.Remove(lockPath)
+		}
+	}
+}
+
+// bucketLocker keeps locks as objects, created with conditional writes so that only one instance
+// can take each. A lifecycle rule on the prefix can remove old ones.
+type bucketLocker struct {
+	mc     *minio.Client
+	bucket string
+	prefix string
+}
+
+func (b *bucketLocker) key(name string) string {
+	return path.Join(b.prefix, name)
+}
+
+func (b *bucketLocker) tryLock(ctx context.Context, name string, rec fileLockRecord) (bool, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	opts.SetMatchETagExcept("*")
+	_, err = b.mc.PutObject(ctx, b.bucket, b.key(name), bytes.NewReader(data), int64(len(data)), opts)
+	if !lockConflict(err) {
+		return err == nil, err
+	}
+
+	// Take over the lock if it is ours or has expired, unless another instance does so first
+	obj, err := b.mc.GetObject(ctx, b.bucket, b.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	info, err := obj.Stat()
+	if err != nil {
+		return false, err
+	}
+	existing, err := io.ReadAll(obj)
+	if err != nil {
+		return false, err
+	}
+	var current fileLockRecord
+	if err := json.Unmarshal(existing, &current); err != nil {
+		return false, nil
+	}
+	if current.Holder != rec.Holder && time.Now().Before(current.Expires) {
+		return false, nil
+	}
+	opts = minio.PutObjectOptions{ContentType: "application/json"}
+	opts.SetMatchETag(info.ETag)
+	_, err = b.mc.PutObject(ctx, b.bucket, b.key(name), bytes.NewReader(data), int64(len(data)), opts)
+	if lockConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *bucketLocker) unlock(ctx context.Context, name string) error {
+	return b.mc.RemoveObject(ctx, b.bucket, b.key(name), minio.RemoveObjectOptions{})
+}
+
+// lockConflict reports whether a conditional write failed because the object exists or changed
+func lockConflict(err error) bool {
+	status := minio.ToErrorResponse(err).StatusCode
+	return status == http.StatusPreconditionFailed || status == http.StatusConflict
+}
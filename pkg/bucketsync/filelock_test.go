@@ -0,0 +1,107 @@
+package bucketsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLockValidate(t *testing.T) {
+	if err := (FileLock{Backend: fileLockBackendFile, TTL: time.Hour}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (FileLock{Backend: fileLockBackendBucket, Remote: "minio1"}).validate(); err == nil {
+		t.Error("expected error for bucket backend without a bucket")
+	}
+	if err := (FileLock{Backend: "redis"}).validate(); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestDirLocker(t *testing.T) {
+	d := &dirLocker{dir: t.TempDir()}
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	if ok, err := d.tryLock(ctx, "abc", fileLockRecord{Holder: "a", Expires: expires}); !ok || err != nil {
+		t.Fatalf("tryLock() = %v, %v; want the free lock", ok, err)
+	}
+	if ok, _ := d.tryLock(ctx, "abc", fileLockRecord{Holder: "b", Expires: expires}); ok {
+		t.Error("b took a lock a holds")
+	}
+	if ok, _ := d.tryLock(ctx, "abc", fileLockRecord{Holder: "a", Expires: expires}); !ok {
+		t.Error("a could not retake its own lock")
+	}
+
+	if err := d.unlock(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := d.tryLock(ctx, "abc", fileLockRecord{Holder: "b", Expires: expires}); !ok {
+		t.Error("b could not take a released lock")
+	}
+
+	// An expired lock is taken over
+	if ok, _ := d.tryLock(ctx, "def", fileLockRecord{Holder: "a", Expires: time.Now().Add(-time.Minute)}); !ok {
+		t.Fatal("could not take a free lock")
+	}
+	if ok, _ := d.tryLock(ctx, "def", fileLockRecord{Holder: "b", Expires: expires}); !ok {
+		t.Error("b could not take over an expired lock")
+	}
+}
+
+func TestLockOutboundFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "report.pdf")
+	if err := os.WriteFile(name, []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := Outbound{Name: "shared", Source: filepath.Join(dir, "*.pdf"), Lock: FileLock{Backend: fileLockBackendFile}}
+	t.Cleanup(func() {
+		fileLockersMutex.Lock()
+		delete(fileLockers, o.Name)
+		fileLockersMutex.Unlock()
+	})
+	ctx := context.Background()
+
+	unlock, locked, err := lockOutboundFile(ctx, o, name, fi)
+	if !locked || err != nil {
+		t.Fatalf("lockOutboundFile() = %v, %v", locked, err)
+	}
+	unlock(nil)
+	lockFiles, _ := os.ReadDir(filepath.Join(dir, defaultFileLockDir))
+	if len(lockFiles) != 1 {
+		t.Fatalf("%d lock files, want 1 kept after a successful upload", len(lockFiles))
+	}
+
+	// Another instance sees the lock; a failed upload releases it
+	lockPath := filepath.Join(dir, defaultFileLockDir, lockFiles[0].Name())
+	if err := os.WriteFile(lockPath, []byte(`{"holder":"other","expires":"2999-01-01T00:00:00Z"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, locked, _ := lockOutboundFile(ctx, o, name, fi); locked {
+		t.Error("locked a file another instance holds")
+	}
+	if err := os.Remove(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	unlock, locked, _ = lockOutboundFile(ctx, o, name, fi)
+	if !locked {
+		t.Fatal("could not lock a free file")
+	}
+	unlock(os.ErrDeadlineExceeded)
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Error("lock kept after a failed upload")
+	}
+
+	// Without a lock backend, every file is uploaded
+	o.Lock = FileLock{}
+	if _, locked, err := lockOutboundFile(ctx, o, name, fi); !locked || err != nil {
+		t.Errorf("without locking: lockOutboundFile() = %v, %v", locked, err)
+	}
+}
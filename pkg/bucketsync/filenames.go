@@ -0,0 +1,167 @@
+package bucketsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// FilenameRules turns the object keys an inbound workflow downloads into local filenames which
+// the destination's file system accepts
+type FilenameRules struct {
+	// Sanitize replaces the characters, and names, which are invalid on TargetOS
+	Sanitize bool `yaml:"sanitize,omitempty"`
+	// TargetOS is the OS whose rules apply, by default the one bucketsyncd runs on. "windows"
+	// also suits SMB shares mounted elsewhere; any other OS only forbids "/" and NUL.
+	TargetOS string `yaml:"target_os,omitempty"`
+	// Replacement replaces each invalid character, by default "_", unless Strip removes them
+	Replacement string `yaml:"replacement,omitempty"`
+	Strip       bool   `yaml:"strip,omitempty"`
+	// Normalize is the Unicode normalization form filenames are converted to: "nfc", which most
+	// systems use, or "nfd", which macOS's HFS+ uses
+	Normalize string `yaml:"normalize,omitempty"`
+	// MaxLength caps the length of filenames in bytes. Longer ones are shortened, keeping their
+	// extension and gaining a hash of the whole name so that they stay distinct.
+	MaxLength int `yaml:"max_length,omitempty"`
+}
+
+const (
+	filenameNFC = "nfc"
+	filenameNFD = "nfd"
+	// minFilenameLength leaves room for the hash and some of the name when shortening
+	minFilenameLength = 32
+	// windowsInvalidChars are forbidden in Windows filenames, as are control characters
+	windowsInvalidChars = `<>:"/\|?*`
+)
+
+// windowsReservedNames are device names, which Windows refuses as filenames with any extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+func (r FilenameRules) validate() error {
+	switch r.Normalize {
+	case "", filenameNFC, filenameNFD:
+	default:
+		return errors.New("normalize: must be nfc or nfd")
+	}
+	if r.MaxLength != 0 && r.MaxLength < minFilenameLength {
+		return errors.New("max_length: must be at least 32")
+	}
+	if r.Strip && r.Replacement != "" {
+		return errors.New("replacement and strip cannot both be set")
+	}
+	if (r.Strip || r.Replacement != "" || r.TargetOS != "") && !r.Sanitize {
+		return errors.New("target_os, replacement and strip need sanitize: true")
+	}
+	if r.Replacement != "" && (strings.IndexFunc(r.Replacement, r.invalid) >= 0 || r.sanitize(r.Replacement) != r.Replacement) {
+		return errors.New("replacement: must itself be valid in filenames")
+	}
+	return nil
+}
+
+// windows reports whether Windows' filename rules apply
+func (r FilenameRules) windows() bool {
+	if r.TargetOS != "" {
+		return r.TargetOS == "windows"
+	}
+	return runtime.GOOS == "windows"
+}
+
+// invalid reports whether c cannot appear in a filename
+func (r FilenameRules) invalid(c rune) bool {
+	if c == '/' || c == 0 {
+		return true
+	}
+	return r.windows() && (c < 32 || strings.ContainsRune(windowsInvalidChars, c))
+}
+
+// sanitize replaces or strips the invalid characters in name, and renames names which are
+// invalid as a whole
+func (r FilenameRules) sanitize(name string) string {
+	replacement := r.Replacement
+	if replacement == "" && !r.Strip {
+		replacement = "_"
+	}
+	var b strings.Builder
+	for _, c := range strings.ToValidUTF8(name, replacement) {
+		if r.invalid(c) {
+			b.WriteString(replacement)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	name = b.String()
+	if r.windows() {
+		// Windows drops trailing dots and spaces, so that the file would not be found by its name
+		trimmed := strings.TrimRight(name, ". ")
+		if trimmed != name {
+			name = trimmed + strings.Repeat(replacement, len(name)-len(trimmed))
+		}
+		stem, _, _ := strings.Cut(name, ".")
+		if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+			name = "_" + name
+		}
+	}
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return name
+}
+
+// shorten cuts name to at most max bytes, keeping its extension and whole characters, and
+// adding a hash of the full name
+func shorten(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	h := sha256.Sum256([]byte(name))
+	suffix := "~" + hex.EncodeToString(h[:4])
+	ext := path.Ext(name)
+	if len(ext)+len(suffix) > max/2 {
+		// An extension this long is not worth keeping
+		ext = ""
+	}
+	stem := name[:len(name)-len(ext)]
+	keep := max - len(ext) - len(suffix)
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	return stem[:keep] + suffix + ext
+}
+
+// localName returns the local filename an object key is downloaded to, which is the key's last
+// element made valid by the rules
+func (r FilenameRules) localName(key string) string {
+	if !r.Sanitize && r.Normalize == "" && r.MaxLength == 0 {
+		return filepath.Base(key)
+	}
+	name := path.Base(key)
+	switch r.Normalize {
+	case filenameNFC:
+		name = norm.NFC.String(name)
+	case filenameNFD:
+		name = norm.NFD.String(name)
+	}
+	if r.Sanitize {
+		name = r.sanitize(name)
+	}
+	if r.MaxLength > 0 {
+		name = shorten(name, r.MaxLength)
+	}
+	return name
+}
+
+// localPath returns the path in the workflow's destination that key is downloaded to
+func (in Inbound) localPath(key string) string {
+	return filepath.Join(in.Destination, in.Filenames.localName(key))
+}
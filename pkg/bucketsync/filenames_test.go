@@ -0,0 +1,99 @@
+package bucketsync
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFilenameRules(t *testing.T) {
+	windows := FilenameRules{Sanitize: true, TargetOS: "windows"}
+	posix := FilenameRules{Sanitize: true, TargetOS: "linux"}
+	tests := []struct {
+		name  string
+		rules FilenameRules
+		key   string
+		want  string
+	}{
+		{"unset", FilenameRules{}, "reports/q3:final?.pdf", "q3:final?.pdf"},
+		{"windows characters", windows, "reports/q3:final?.pdf", "q3_final_.pdf"},
+		{"windows controls", windows, "a\tb|c.txt", "a_b_c.txt"},
+		{"posix allows", posix, "reports/q3:final?.pdf", "q3:final?.pdf"},
+		{"strip", FilenameRules{Sanitize: true, TargetOS: "windows", Strip: true}, `x/"quoted" <name>.txt`, "quoted name.txt"},
+		{"replacement", FilenameRules{Sanitize: true, TargetOS: "windows", Replacement: "-"}, "a*b.txt", "a-b.txt"},
+		{"reserved name", windows, "logs/CON.txt", "_CON.txt"},
+		{"reserved name any case", windows, "logs/lpt1", "_lpt1"},
+		{"not reserved", windows, "logs/CONSOLE.txt", "CONSOLE.txt"},
+		{"trailing dots and spaces", windows, "notes. .", "notes___"},
+		{"dot dot", posix, "a/..", "_.."},
+		{"invalid UTF-8", posix, "caf\xe9.txt", "caf_.txt"},
+		{"nfc", FilenameRules{Normalize: "nfc"}, "cafe\u0301.txt", "caf\u00e9.txt"},
+		{"nfd", FilenameRules{Normalize: "nfd"}, "caf\u00e9.txt", "cafe\u0301.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.localName(tt.key); got != tt.want {
+				t.Errorf("localName(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilenameMaxLength(t *testing.T) {
+	rules := FilenameRules{MaxLength: 40}
+	long := strings.Repeat("ä", 30) + ".csv"
+	got := rules.localName("exports/" + long)
+	if len(got) > 40 || !strings.HasSuffix(got, ".csv") || !utf8.ValidString(got) {
+		t.Errorf("localName = %q (%d bytes), want at most 40 valid bytes ending .csv", got, len(got))
+	}
+	other := rules.localName("exports/" + strings.Repeat("ä", 31) + ".csv")
+	if other == got {
+		t.Errorf("names differing past the cap both shortened to %q", got)
+	}
+	if got := rules.localName("short.csv"); got != "short.csv" {
+		t.Errorf("short name changed to %q", got)
+	}
+	if got := rules.localName(strings.Repeat("x", 20) + "." + strings.Repeat("y", 40)); len(got) > 40 {
+		t.Errorf("name with long extension shortened to %q (%d bytes)", got, len(got))
+	}
+}
+
+func TestInboundLocalPath(t *testing.T) {
+	in := Inbound{Destination: "/data/in", Filenames: FilenameRules{Sanitize: true, TargetOS: "windows"}}
+	if got, want := in.localPath("a/b:c.txt"), filepath.Join("/data/in", "b_c.txt"); got != want {
+		t.Errorf("localPath = %q, want %q", got, want)
+	}
+	// The name never escapes the destination
+	if got := in.localPath("../../etc/passwd"); filepath.Dir(got) != filepath.Clean("/data/in") {
+		t.Errorf("localPath = %q, outside the destination", got)
+	}
+	if got := path.Base(in.localPath("dir/")); got == "" {
+		t.Error("empty name for a directory key")
+	}
+}
+
+func TestFilenameRulesValidate(t *testing.T) {
+	for _, rules := range []FilenameRules{
+		{Normalize: "nfkc"},
+		{MaxLength: 8},
+		{Sanitize: true, Strip: true, Replacement: "-"},
+		{Strip: true},
+		{Sanitize: true, TargetOS: "windows", Replacement: "?"},
+		{Sanitize: true, TargetOS: "windows", Replacement: "."},
+	} {
+		if err := rules.validate(); err == nil {
+			t.Errorf("%+v: validated", rules)
+		}
+	}
+	for _, rules := range []FilenameRules{
+		{},
+		{Sanitize: true, TargetOS: "windows", Replacement: "-", Normalize: "nfc", MaxLength: 200},
+		{Sanitize: true, Strip: true},
+	} {
+		if err := rules.validate(); err != nil {
+			t.Errorf("%+v: %v", rules, err)
+		}
+	}
+}
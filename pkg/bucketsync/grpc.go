@@ -0,0 +1,179 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rossigee/bucketsyncd/controlpb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// controlServer implements the gRPC control API on top of the running workflows
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+}
+
+// startGRPCServer serves the gRPC control API on addr until the returned server is stopped.
+// When token is set, every call must present it as a bearer token in the authorization metadata.
+func startGRPCServer(addr, token string) (*grpc.Server, error) {
+	ln, err := net.Listen("tcp", loopbackDefault(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = append(opts, grpc.UnaryInterceptor(tokenInterceptor(token)))
+	}
+	srv := grpc.NewServer(opts...)
+	controlpb.RegisterControlServer(srv, &controlServer{})
+	go func() {
+		if err := srv.Serve(ln); err != nil {
+			log.Error("gRPC server failed: ", err)
+		}
+	}()
+	log.Info("serving gRPC control API on ", ln.Addr())
+	return srv, nil
+}
+
+func (controlServer) GetStatus(context.Context, *controlpb.GetStatusRequest) (*controlpb.Status, error) {
+	report := currentStatus()
+	return &controlpb.Status{
+		Status:        report.Status,
+		Version:       report.Version,
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		Outbound:      int32(report.Outbound), // #nosec G115 - workflow counts are small
+		Inbound:       int32(report.Inbound),  // #nosec G115 - workflow counts are small
+	}, nil
+}
+
+func (controlServer) ListWorkflows(context.Context, *controlpb.ListWorkflowsRequest) (*controlpb.ListWorkflowsResponse, error) {
+	resp := &controlpb.ListWorkflowsResponse{}
+	for _, s := range workflowStatuses() {
+		resp.Workflows = append(resp.Workflows, workflowMessage(s))
+	}
+	return resp, nil
+}
+
+func (controlServer) GetWorkflow(_ context.Context, req *controlpb.WorkflowRequest) (*controlpb.Workflow, error) {
+	w, err := requestedWorkflow(req)
+	if err != nil {
+		return nil, err
+	}
+	return workflowMessage(w.status()), nil
+}
+
+func (controlServer) PauseWorkflow(_ context.Context, req *controlpb.WorkflowRequest) (*controlpb.Workflow, error) {
+	w, err := requestedWorkflow(req)
+	if err != nil {
+		return nil, err
+	}
+	w.pause()
+	log.WithFields(log.Fields{"workflow": w.name}).Info("workflow paused")
+	return workflowMessage(w.status()), nil
+}
+
+func (controlServer) ResumeWorkflow(_ context.Context, req *controlpb.WorkflowRequest) (*controlpb.Workflow, error) {
+	w, err := requestedWorkflow(req)
+	if err != nil {
+		return nil, err
+	}
+	w.resume()
+	log.WithFields(log.Fields{"workflow": w.name}).Info("workflow resumed")
+	return workflowMessage(w.status()), nil
+}
+
+func (controlServer) ScanWorkflow(_ context.Context, req *controlpb.WorkflowRequest) (*controlpb.ScanWorkflowResponse, error) {
+	w, err := requestedWorkflow(req)
+	if err != nil {
+		return nil, err
+	}
+	if w.scan == nil {
+		return nil, status.Error(codes.InvalidArgument, "workflow cannot be scanned")
+	}
+	if w.isPaused() {
+		return nil, status.Error(codes.FailedPrecondition, "workflow is paused")
+	}
+	files, err := w.scan()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.WithFields(log.Fields{"workflow": w.name, "files": files}).Info("manual scan started")
+	return &controlpb.ScanWorkflowResponse{Files: int32(files)}, nil // #nosec G115 - bounded by the directory size
+}
+
+func (controlServer) ListTransfers(_ context.Context, req *controlpb.ListTransfersRequest) (*controlpb.ListTransfersResponse, error) {
+	if req.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid limit")
+	}
+	resp := &controlpb.ListTransfersResponse{}
+	for _, rec := range recentTransferRecords(int(req.GetLimit())) {
+		resp.Transfers = append(resp.Transfers, &controlpb.Transfer{
+			Time:       timestamppb.New(rec.Time),
+			TransferId: rec.ID,
+			Workflow:   rec.Workflow,
+			Direction:  rec.Direction,
+			Path:       rec.Path,
+			Remote:     rec.Remote,
+			Bucket:     rec.Bucket,
+			Key:        rec.Key,
+			Size:       rec.Size,
+			Checksum:   rec.Checksum,
+			Status:     rec.Status,
+			Error:      rec.Error,
+		})
+	}
+	return resp, nil
+}
+
+// tokenInterceptor rejects calls which do not carry the token as a bearer token
+func tokenInterceptor(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			presented, ok := strings.CutPrefix(value, "Bearer ")
+			if ok && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+}
+
+// requestedWorkflow looks up the workflow named in a request, returning a NotFound error if it is not running
+func requestedWorkflow(req *controlpb.WorkflowRequest) (*workflowState, error) {
+	w, ok := findWorkflow(req.GetName())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no workflow named %q", req.GetName())
+	}
+	return w, nil
+}
+
+func workflowMessage(s workflowStatus) *controlpb.Workflow {
+	m := &controlpb.Workflow{
+		Name:       s.Name,
+		Kind:       s.Kind,
+		Health:     s.Health,
+		Paused:     s.Paused,
+		QueueDepth: int32(s.QueueDepth), // #nosec G115 - bounded by in-flight files and messages
+		Transfers:  s.Transfers,
+		Errors:     s.Errors,
+		Bytes:      s.Bytes,
+		LastError:  s.LastError,
+	}
+	if s.LastTransfer != nil {
+		m.LastTransfer = timestamppb.New(*s.LastTransfer)
+	}
+	if s.LastErrorTime != nil {
+		m.LastErrorTime = timestamppb.New(*s.LastErrorTime)
+	}
+	return m
+}
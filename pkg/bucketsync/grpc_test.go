@@ -0,0 +1,135 @@
+package bucketsync
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/rossigee/bucketsyncd/controlpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newControlClient serves the control API over an in-memory listener and returns a client for it
+func newControlClient(t *testing.T, opts ...grpc.ServerOption) controlpb.ControlClient {
+	t.Helper()
+	ln := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(opts...)
+	controlpb.RegisterControlServer(srv, &controlServer{})
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return controlpb.NewControlClient(conn)
+}
+
+func TestControlStatus(t *testing.T) {
+	client := newControlClient(t)
+	st, err := client.GetStatus(context.Background(), &controlpb.GetStatusRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.GetStatus() != "ok" || st.GetVersion() != version {
+		t.Errorf("unexpected status: %v", st)
+	}
+}
+
+func TestControlWorkflows(t *testing.T) {
+	resetWorkflows(t)
+	photos := registerWorkflow("photos", workflowOutbound)
+	photos.scan = func() (int, error) {
+		return 2, nil
+	}
+	registerWorkflow("backups", workflowInbound)
+	trackWorkflow("photos")(nil)
+	client := newControlClient(t)
+	ctx := context.Background()
+
+	list, err := client.ListWorkflows(ctx, &controlpb.ListWorkflowsRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.GetWorkflows()) != 2 || list.GetWorkflows()[1].GetName() != "photos" {
+		t.Fatalf("unexpected workflows: %v", list)
+	}
+	if wf := list.GetWorkflows()[1]; wf.GetTransfers() != 1 || wf.GetLastTransfer() == nil {
+		t.Errorf("unexpected photos workflow: %v", wf)
+	}
+
+	wf, err := client.PauseWorkflow(ctx, &controlpb.WorkflowRequest{Name: "photos"})
+	if err != nil || !wf.GetPaused() {
+		t.Errorf("pause: %v, %v", wf, err)
+	}
+	if _, err := client.ScanWorkflow(ctx, &controlpb.WorkflowRequest{Name: "photos"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("scan while paused: got %v, want FailedPrecondition", err)
+	}
+	wf, err = client.ResumeWorkflow(ctx, &controlpb.WorkflowRequest{Name: "photos"})
+	if err != nil || wf.GetPaused() {
+		t.Errorf("resume: %v, %v", wf, err)
+	}
+
+	scan, err := client.ScanWorkflow(ctx, &controlpb.WorkflowRequest{Name: "photos"})
+	if err != nil || scan.GetFiles() != 2 {
+		t.Errorf("scan: %v, %v", scan, err)
+	}
+	if _, err := client.ScanWorkflow(ctx, &controlpb.WorkflowRequest{Name: "backups"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("scan inbound: got %v, want InvalidArgument", err)
+	}
+	if _, err := client.GetWorkflow(ctx, &controlpb.WorkflowRequest{Name: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("get missing: got %v, want NotFound", err)
+	}
+}
+
+func TestControlTransfers(t *testing.T) {
+	resetWorkflows(t)
+	rememberTransfer(TransferRecord{ID: "a1", Key: "first"})
+	rememberTransfer(TransferRecord{ID: "b2", Key: "second"})
+	client := newControlClient(t)
+
+	resp, err := client.ListTransfers(context.Background(), &controlpb.ListTransfersRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetTransfers()) != 1 || resp.GetTransfers()[0].GetTransferId() != "b2" {
+		t.Errorf("unexpected transfers: %v", resp)
+	}
+
+	if _, err := client.ListTransfers(context.Background(), &controlpb.ListTransfersRequest{Limit: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("negative limit: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestControlToken(t *testing.T) {
+	client := newControlClient(t, grpc.UnaryInterceptor(tokenInterceptor("s3cret")))
+
+	for _, header := range []string{"", "Bearer wrong"} {
+		ctx := context.Background()
+		if header != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", header)
+		}
+		if _, err := client.GetStatus(ctx, &controlpb.GetStatusRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("authorization %q: got %v, want Unauthenticated", header, err)
+		}
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer s3cret")
+	if _, err := client.GetStatus(ctx, &controlpb.GetStatusRequest{}); err != nil {
+		t.Errorf("valid token: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package bucketsync
+
+import (
+	"net/http"
+)
+
+// readiness is the response served on /readyz
+type readiness struct {
+	Status string `json:"status"`
+	// Workflows lists the workflows which are not yet watching or connected, with their health
+	Workflows map[string]string `json:"workflows,omitempty"`
+}
+
+// withHealthChecks serves the liveness and readiness probes ahead of handler, without requiring
+// the admin token, since probes from Kubernetes and load balancers cannot usually present one
+func withHealthChecks(handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.Handle("/", handler)
+	return mux
+}
+
+// handleHealthz reports that the process is alive and serving requests
+func handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether every enabled workflow is watching its folder or connected to its
+// queue, and shutdown has not begun
+func handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	report := currentReadiness()
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeAdminJSON(w, status, report)
+}
+
+func currentReadiness() readiness {
+	drainMutex.Lock()
+	stopping := draining
+	drainMutex.Unlock()
+	if stopping {
+		return readiness{Status: "shutting down"}
+	}
+
+	report := readiness{Status: "ok"}
+	workflowsMutex.RLock()
+	defer workflowsMutex.RUnlock()
+	for name, wf := range workflows {
+		wf.mu.Lock()
+		health := wf.health
+		wf.mu.Unlock()
+		if health != healthWatching && health != healthConnected {
+			if report.Workflows == nil {
+				report.Workflows = make(map[string]string)
+			}
+			report.Workflows[name] = health
+			report.Status = "not ready"
+		}
+	}
+	return report
+}
@@ -0,0 +1,43 @@
+package bucketsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthChecks(t *testing.T) {
+	resetWorkflows(t)
+	srv := httptest.NewServer(withHealthChecks(requireToken("s3cret", adminHandler(false))))
+	defer srv.Close()
+
+	// Probes need no token, unlike the rest of the API
+	getAdminJSON(t, srv.URL+"/healthz", http.StatusOK, nil)
+	getAdminJSON(t, srv.URL+"/api/workflows", http.StatusUnauthorized, nil)
+
+	photos := registerWorkflow("photos", workflowOutbound)
+	backups := registerWorkflow("backups", workflowInbound)
+	photos.setHealth(healthWatching)
+	backups.setHealth(healthConnecting)
+	var report readiness
+	getAdminJSON(t, srv.URL+"/readyz", http.StatusServiceUnavailable, &report)
+	if report.Workflows["backups"] != healthConnecting || len(report.Workflows) != 1 {
+		t.Errorf("unexpected readiness: %+v", report)
+	}
+
+	backups.setHealth(healthConnected)
+	getAdminJSON(t, srv.URL+"/readyz", http.StatusOK, &report)
+	if report.Status != "ok" {
+		t.Errorf("status = %q, want ok", report.Status)
+	}
+
+	drainMutex.Lock()
+	draining = true
+	drainMutex.Unlock()
+	defer func() {
+		drainMutex.Lock()
+		draining = false
+		drainMutex.Unlock()
+	}()
+	getAdminJSON(t, srv.URL+"/readyz", http.StatusServiceUnavailable, nil)
+}
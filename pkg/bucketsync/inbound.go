@@ -0,0 +1,537 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// S3Event represents the structure of an S3 event notification
+type S3Event struct {
+	EventName string     `json:"EventName"`
+	Records   []S3Record `json:"Records"`
+}
+
+type S3Record struct {
+	S3 S3Info `json:"s3"`
+}
+
+type S3Info struct {
+	Bucket BucketInfo `json:"bucket"`
+	Object ObjectInfo `json:"object"`
+}
+
+type BucketInfo struct {
+	Name string `json:"name"`
+}
+
+type ObjectInfo struct {
+	Key  string  `json:"key"`
+	Size float64 `json:"size"`
+}
+
+// inbound consumes the workflow's queue in the background until shutdown
+func inbound(in Inbound) {
+	go inboundWithContext(serviceCtx, in)
+}
+
+func inboundWithContext(ctx context.Context, in Inbound) {
+	state := registerWorkflow(in.Name, workflowInbound)
+	state.setThresholds(in.Thresholds)
+
+	setWorkflowLogLevel(in.Name, in.LogLevel)
+	setWorkflowSampling(in.Name, in.Sampling)
+	lf := workflowLogFields(in.Name, in.Labels, nil)
+	var schedule *cronSchedule
+	if in.Schedule != "" {
+		var err error
+		if schedule, err = parseSchedule(in.Schedule); err != nil {
+			log.WithFields(lf).Error(err)
+			state.setHealth(healthDegraded)
+			return
+		}
+	}
+	if in.Spool != "" {
+		if err := prepareSpool(lf, in); err != nil {
+			log.WithFields(lf).Error(err)
+			state.setHealth(healthDegraded)
+			return
+		}
+	}
+	if in.SFTP.URL != "" {
+		sftpInbound(ctx, lf, state, in, schedule)
+		return
+	}
+	if in.Source == "" {
+		// Without a queue to consume, the bucket is only polled
+		lf = workflowLogFields(in.Name, in.Labels, log.Fields{"bucket": in.Bucket, "prefix": in.Prefix})
+		state.setLocation(in.Remote + "/" + in.Bucket + "/" + in.Prefix)
+		state.scan = func() (int, error) {
+			return scanInbound(ctx, lf, in)
+		}
+		state.setHealth(healthWatching)
+		log.WithFields(lf).Info("configuring scheduled downloads for '", in.Description, "'")
+		runSchedule(ctx, lf, state, schedule, func(ctx context.Context) error {
+			err := pollInbound(ctx, lf, in)
+			if err != nil {
+				state.setHealth(healthDegraded)
+			} else {
+				state.setHealth(healthWatching)
+			}
+			return err
+		})
+		return
+	}
+	u, err := url.Parse(in.Source)
+	if err != nil {
+		log.WithFields(lf).Error("failed to parse AMQP connection string: ", err)
+		return
+	}
+	lf = workflowLogFields(in.Name, in.Labels, log.Fields{
+		"source":   u.Redacted(),
+		"exchange": in.Exchange,
+		"queue":    in.Queue,
+	})
+	state.setLocation(in.Queue + " on " + u.Redacted())
+	log.WithFields(lf).Info("configuring AMQP client for '", in.Description, "'")
+	if schedule != nil {
+		state.scan = func() (int, error) {
+			return scanInbound(ctx, lf, in)
+		}
+		go runSchedule(ctx, lf, state, schedule, func(ctx context.Context) error {
+			return pollInbound(ctx, lf, in)
+		})
+	}
+
+	src := newAMQPSource(lf, in.Source, in.Exchange, in.Queue)
+	consumeMessages(ctx, lf, state, src, inboundBreaker(in), func(ctx context.Context, m Message) {
+		handleMessage(ctx, lf, in, src, m)
+	})
+}
+
+// handleMessage processes a single message from src, tracing it from parsing through to
+// acknowledgement. Every log line, record and notification for the message carries the same transfer ID.
+func handleMessage(ctx context.Context, lf log.Fields, in Inbound, src MessageSource, m Message) {
+	finish, ok := startTransfer()
+	if !ok {
+		if nackErr := src.Nack(m, true); nackErr != nil {
+			log.WithFields(lf).Error("failed to nack message: ", nackErr)
+		}
+		return
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx = contextWithTransferID(ctx, id)
+	if m.Headers != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, m.Headers)
+	}
+	ctx, span := startSpan(ctx, "inbound.message",
+		attribute.String("workflow", in.Name),
+		attribute.String("queue", in.Queue),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: in.Name})
+	done := trackWorkflow(in.Name)
+	var failure error
+	defer func() {
+		done(failure)
+		endSpan(span, failure)
+	}()
+
+	logPayload(lf, in.PayloadLog, m.Body)
+
+	// Parse JSON payload
+	_, parseSpan := startSpan(ctx, "parse")
+	var s3Event S3Event
+	err := json.Unmarshal(m.Body, &s3Event)
+	endSpan(parseSpan, err)
+	if err != nil {
+		failure = err
+		emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: in.Name, Error: err.Error()})
+		log.WithFields(lf).Error("failed to parse JSON payload: ", err)
+		if nackErr := src.Nack(m, true); nackErr != nil { // Requeue for retry
+			log.WithFields(lf).Error("failed to nack message: ", nackErr)
+		}
+		return
+	}
+	span.SetAttributes(
+		attribute.String("event", s3Event.EventName),
+		attribute.Int("records", len(s3Event.Records)),
+	)
+
+	// Queue each record in the event, then process them in turn
+	for _, record := range s3Event.Records {
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+		emitEvent(LifecycleEvent{
+			Event:      eventQueued,
+			TransferID: id,
+			Workflow:   in.Name,
+			Bucket:     record.S3.Bucket.Name,
+			Key:        key,
+			Size:       int64(record.S3.Object.Size),
+		})
+	}
+	for _, record := range s3Event.Records {
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			failure = err
+			log.WithFields(lf).Errorf("invalid URL-encoded key: %s", record.S3.Object.Key)
+			if nackErr := src.Nack(m, false); nackErr != nil { // Don't requeue invalid messages
+				log.WithFields(lf).Error("failed to nack message: ", nackErr)
+			}
+			continue
+		}
+
+		log.WithFields(lf).WithFields(log.Fields{
+			"bucket": record.S3.Bucket.Name,
+			"key":    key,
+			"size":   record.S3.Object.Size,
+		}).Debugf("event '%s' received", s3Event.EventName)
+
+		if err := downloadRecord(ctx, lf, record.S3.Bucket.Name, key, in); err != nil {
+			failure = err
+			emitEvent(LifecycleEvent{
+				Event:      eventFailed,
+				TransferID: id,
+				Workflow:   in.Name,
+				Bucket:     record.S3.Bucket.Name,
+				Key:        key,
+				Error:      err.Error(),
+			})
+			log.WithFields(lf).Error("failed to process record: ", err)
+			// A rejected file would only be rejected again
+			if nackErr := src.Nack(m, !errors.Is(err, errProcessorRejected)); nackErr != nil {
+				log.WithFields(lf).Error("failed to nack message: ", nackErr)
+			}
+			continue
+		}
+
+		// Acknowledge queued message after successful processing
+		_, ackSpan := startSpan(ctx, "ack")
+		err = src.Ack(m)
+		endSpan(ackSpan, err)
+		if err != nil {
+			log.WithFields(lf).Error("failed to acknowledge message: ", err)
+			continue
+		}
+		emitEvent(LifecycleEvent{Event: eventAcked, TransferID: id, Workflow: in.Name, Bucket: record.S3.Bucket.Name, Key: key})
+	}
+}
+
+// downloadRecord fetches a single S3 object and writes it to the configured destination.
+// Extracted from the message-processing loop so defers are scoped to the function call.
+func downloadRecord(ctx context.Context, lf log.Fields, bucketName, key string, in Inbound) (err error) {
+	// Determine remote and the bucket's store
+	_, credSpan := startSpan(ctx, "credential lookup", attribute.String("remote", in.Remote))
+	remote, ok := findRemote(in.Remote)
+	if !ok {
+		err := fmt.Errorf("no credentials found for remote %q", in.Remote)
+		endSpan(credSpan, err)
+		return err
+	}
+	mc, err := remoteClient(remote)
+	endSpan(credSpan, err)
+	if err != nil {
+		return err
+	}
+	timeouts := remoteTimeouts(remote)
+	store := newMinioStore(mc, bucketName, timeouts.Stat)
+
+	ctx, span := startSpan(ctx, "transfer",
+		attribute.String("remote", remote.Endpoint),
+		attribute.String("bucket", bucketName),
+		attribute.String("key", key),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: transferIDFromContext(ctx),
+		Workflow:   in.Name,
+		Remote:     remote.Endpoint,
+		Bucket:     bucketName,
+		Key:        key,
+	})
+	// Each attempt starts the local file afresh, which is only created once the object is found.
+	// With a spool folder, it is written there and only moved into the destination once complete.
+	localFilename := in.localPath(key)
+	writeFilename := localFilename
+	if in.Spool != "" {
+		writeFilename = in.spoolPath(transferIDFromContext(ctx))
+		defer func() {
+			if err != nil {
+				_ = os.Remove(writeFilename)
+			}
+		}()
+	}
+	var localFile *os.File
+	defer func() {
+		if localFile != nil {
+			if err := localFile.Close(); err != nil {
+				log.WithFields(lf).Error("failed to close local file: ", err)
+			}
+		}
+	}()
+	h := sha256.New()
+	var size int64
+	progress := startProgress(transferIDFromContext(ctx), in.Name, directionDownload, localFilename, 0)
+	defer progress.finish()
+	start := time.Now()
+	breaker := remoteBreaker(remote.Endpoint, remote.CircuitBreaker)
+	err = retryWithBackoff(ctx, transferRetry(in.Retry, remote.Retry), func() (err error) {
+		job, err := scheduleTransfer(ctx, in.Name, in.Priority, bandwidthLimit(in.MaxBandwidth), remote, transferBufferSize)
+		if err != nil {
+			return err
+		}
+		defer job.release()
+		if err := breaker.allow(); err != nil {
+			return err
+		}
+		defer func() {
+			breaker.record(err)
+		}()
+		fetchCtx, cancel := context.WithTimeout(ctx, timeouts.Download)
+		defer cancel()
+		obj, stat, err := store.Get(fetchCtx, key)
+		if err != nil {
+			return fmt.Errorf("failed to fetch object: %w", err)
+		}
+		defer func() {
+			if err := obj.Close(); err != nil {
+				log.WithFields(lf).Error("failed to close object: ", err)
+			}
+		}()
+		size = stat.Size
+		progress.setSize(size)
+
+		if localFile == nil {
+			const filePerms = 0600
+			// #nosec G304 - This is intentional file creation in configured destination
+			if localFile, err = os.OpenFile(writeFilename, os.O_RDWR|os.O_CREATE, filePerms); err != nil {
+				return fmt.Errorf("failed to create local file: %w", err)
+			}
+		}
+		if err := localFile.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := localFile.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		h.Reset()
+		progress.restart()
+		n, err := copyBuffered(io.MultiWriter(localFile, h, progress), io.LimitReader(job.throttle(obj), stat.Size))
+		if err == nil && n < stat.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy file from reader: %w", err)
+		}
+		return nil
+	})
+	if err == nil && (in.ProcessWith != "" || in.Spool != "") {
+		// The processor reads the file by its path, and a spooled file is moved, so it must be
+		// complete and closed first
+		err = localFile.Close()
+		localFile = nil
+	}
+	if err == nil && in.ProcessWith != "" {
+		var kept bool
+		kept, err = processDownload(ctx, lf, in, key, writeFilename)
+		if err == nil && !kept {
+			return nil
+		}
+	}
+	if err == nil && in.Spool != "" {
+		err = moveIntoPlace(writeFilename, localFilename)
+	}
+
+	rec := TransferRecord{
+		ID:        transferIDFromContext(ctx),
+		Workflow:  in.Name,
+		Direction: directionDownload,
+		Path:      localFilename,
+		Remote:    remote.Endpoint,
+		Bucket:    bucketName,
+		Key:       key,
+		Size:      size,
+		Status:    transferSuccess,
+	}
+	if err != nil {
+		rec.Status, rec.Error = transferFailed, err.Error()
+		rec.Duration = time.Since(start)
+		recordTransfer(rec)
+		return err
+	}
+	rec.Checksum = hex.EncodeToString(h.Sum(nil))
+	rec.Duration = time.Since(start)
+	recordTransfer(rec)
+	emitEvent(transferEvent(eventDownloaded, rec))
+
+	log.WithFields(lf).WithFields(log.Fields{
+		"filename":   localFilename,
+		"size":       size,
+		"throughput": formatRate(transferRate(size, rec.Duration)),
+	}).Info("retrieved remote object to local file")
+
+	message := fmt.Sprintf("Downloaded %s (transfer %s)", filepath.Base(key), rec.ID)
+	SendNotification("bucketsyncd", message)
+
+	return nil
+}
+
+// pollInbound downloads, in turn, the objects in the workflow's bucket which are missing from its
+// destination or have changed since they were downloaded
+func pollInbound(ctx context.Context, lf log.Fields, in Inbound) error {
+	keys, err := changedInbound(ctx, in)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Failures are logged by downloadPolled as they occur
+		_ = downloadPolled(lf, in, key)
+	}
+	return nil
+}
+
+// scanInbound downloads, in the background, whatever pollInbound would, returning how many
+// objects there are
+func scanInbound(ctx context.Context, lf log.Fields, in Inbound) (int, error) {
+	keys, err := changedInbound(ctx, in)
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		for _, key := range keys {
+			// Failures are logged by downloadPolled as they occur
+			_ = downloadPolled(lf, in, key)
+		}
+	}()
+	return len(keys), nil
+}
+
+// changedInbound lists the keys of the objects under the workflow's bucket and prefix whose local
+// file is missing, differs in size, or was written before the object was last modified
+func changedInbound(ctx context.Context, in Inbound) ([]string, error) {
+	remote, ok := findRemote(in.Remote)
+	if !ok {
+		return nil, fmt.Errorf("no credentials found for remote %q", in.Remote)
+	}
+	mc, err := remoteClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := newMinioStore(mc, in.Bucket, remoteTimeouts(remote).Stat).List(ctx, in.Prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bucket: %w", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		fi, err := os.Stat(in.localPath(obj.Key))
+		if err == nil && fi.Size() == obj.Size && !obj.LastModified.After(fi.ModTime()) {
+			continue
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// downloadPolled downloads an object found by polling the workflow's bucket, tracing it as a
+// transfer of its own
+func downloadPolled(lf log.Fields, in Inbound, key string) (err error) {
+	finish, ok := startTransfer()
+	if !ok {
+		log.WithFields(lf).WithField("key", key).Warn("shutting down, not downloading object")
+		return nil
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx, span := startSpan(contextWithTransferID(context.Background(), id), "inbound.object",
+		attribute.String("workflow", in.Name),
+		attribute.String("key", key),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: in.Name, Bucket: in.Bucket, Key: key})
+	done := trackWorkflow(in.Name)
+	defer func() {
+		done(err)
+		if err != nil {
+			emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: in.Name, Bucket: in.Bucket, Key: key, Error: err.Error()})
+			log.WithFields(lf).WithField("key", key).Error("failed to download object: ", err)
+		}
+		endSpan(span, err)
+	}()
+	return downloadRecord(ctx, lf, in.Bucket, key, in)
+}
+
+// processDownload passes a downloaded file to the workflow's processor, replacing it with the
+// processor's output or removing it as the processor decides, and reports whether it was kept
+func processDownload(ctx context.Context, lf log.Fields, in Inbound, key, localFilename string) (bool, error) {
+	_, span := startSpan(ctx, "process")
+	result, err := processFile(in.ProcessWith, in.ProcessTimeout, filepath.Dir(localFilename), processRequest{
+		Workflow:   in.Name,
+		Direction:  directionDownload,
+		TransferID: transferIDFromContext(ctx),
+		Path:       localFilename,
+		Key:        key,
+	})
+	endSpan(span, err)
+	if err != nil {
+		return false, err
+	}
+	defer result.close()
+	switch result.Action {
+	case processSkip:
+		log.WithFields(lf).WithFields(log.Fields{
+			"filename": localFilename,
+			"reason":   result.Reason,
+		}).Info("downloaded file discarded by processor")
+		return false, os.Remove(localFilename)
+	case processReject:
+		if err := os.Remove(localFilename); err != nil {
+			log.WithFields(lf).Error("failed to remove rejected file: ", err)
+		}
+		return false, fmt.Errorf("%w: %s", errProcessorRejected, result.Reason)
+	case processReplace:
+		if err := os.Rename(result.Path, localFilename); err != nil {
+			return false, fmt.Errorf("failed to replace downloaded file: %w", err)
+		}
+	}
+	return true, nil
+}
+
+func inboundClose() {
+	for _, c := range connections {
+		if err := c.Close(); err != nil {
+			log.Errorf("unable to close AMQP connection: %s", err)
+		}
+	}
+}
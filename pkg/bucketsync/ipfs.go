@@ -0,0 +1,202 @@
+package bucketsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// IPFSOptions configures the client for ipfs:// destinations on a remote's endpoint, an IPFS
+// node's HTTP API. The remote's access and secret keys, if set, are sent as the username and
+// password of basic authentication, as proxies in front of the API commonly require.
+type IPFSOptions struct {
+	// Pin pins each file added, keeping it from the node's garbage collection
+	Pin bool `yaml:"pin,omitempty"`
+	// HTTPS reaches the API over HTTPS rather than HTTP
+	HTTPS bool `yaml:"https,omitempty"`
+}
+
+// errIPFSUnsupported is returned for store operations which content addressing has no use for
+var errIPFSUnsupported = errors.New("not supported by IPFS destinations")
+
+// isIPFSScheme checks if the scheme is that of an IPFS destination
+func isIPFSScheme(scheme string) bool {
+	return strings.ToLower(scheme) == "ipfs"
+}
+
+// validateIPFSDestination checks an ipfs:// destination, which names the node and nothing else
+func validateIPFSDestination(u *url.URL) error {
+	if u.Host == "" {
+		return errors.New("the IPFS node's API address is required, e.g. ipfs://127.0.0.1:5001")
+	}
+	if strings.Trim(u.Path, "/") != "" {
+		return errors.New("an IPFS destination cannot have a path")
+	}
+	return nil
+}
+
+// ipfsStore adds files to an IPFS node through its HTTP API. Objects are addressed by the CIDs
+// their content hashes to, rather than by key, so it only supports Put, and records the CID of
+// the last file put.
+type ipfsStore struct {
+	client *http.Client
+	api    string
+	pin    bool
+	// creds are the username and password of basic authentication
+	creds *credentials.Credentials
+	// cid is the CID of the last file put
+	cid string
+}
+
+// newIPFSStore connects to the node of an ipfs:// URL with the remote's IPFS options, credentials
+// and connect timeout
+func newIPFSStore(u *url.URL, remote Remote) (*ipfsStore, error) {
+	transport, err := remoteTransport(remoteTimeouts(remote).Connect, remote.MaxConnections)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPFS client: %w", err)
+	}
+	scheme := "http"
+	if remote.IPFS.HTTPS {
+		scheme = "https"
+	}
+	return &ipfsStore{
+		client: &http.Client{Transport: transport},
+		api:    scheme + "://" + u.Host + "/api/v0",
+		pin:    remote.IPFS.Pin,
+		creds:  remoteCredentials(remote),
+	}, nil
+}
+
+// ipfsAdded is the node's answer to an add request
+type ipfsAdded struct {
+	Name string `json:"Name"`
+	Hash string `json:"Hash"`
+}
+
+// Put adds the file, named after the key's last element, as a CIDv1, pinning it if configured
+// to. IPFS has no object metadata, so metadata is ignored.
+func (s *ipfsStore) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error {
+	body, w := io.Pipe()
+	mw := multipart.NewWriter(w)
+	go func() {
+		part, err := mw.CreateFormFile("file", path.Base(key))
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		_ = w.CloseWithError(err)
+	}()
+
+	query := url.Values{
+		"pin":         {fmt.Sprint(s.pin)},
+		"cid-version": {"1"},
+		"quieter":     {"true"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.api+"/add?"+query.Encode(), body)
+	if err != nil {
+		_ = body.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := s.do(req)
+	// The writer may still be waiting to send the file if the request failed
+	_ = body.Close()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var added ipfsAdded
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return fmt.Errorf("failed to read IPFS add response: %w", err)
+	}
+	if added.Hash == "" {
+		return errors.New("IPFS node returned no CID")
+	}
+	s.cid = added.Hash
+	return nil
+}
+
+// version asks the node for its version, checking it can be reached
+func (s *ipfsStore) version(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.api+"/version", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var v struct {
+		Version string `json:"Version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", fmt.Errorf("failed to read IPFS version response: %w", err)
+	}
+	return v.Version, nil
+}
+
+// do sends a request to the API, returning the response if it succeeded or else the node's error
+func (s *ipfsStore) do(req *http.Request) (*http.Response, error) {
+	creds, err := s.creds.GetWithContext(nil)
+	if err != nil {
+		return nil, err
+	}
+	if creds.AccessKeyID != "" || creds.SecretAccessKey != "" {
+		req.SetBasicAuth(creds.AccessKeyID, creds.SecretAccessKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	// The API describes its errors as {"Message": ..., "Code": ..., "Type": "error"}
+	var apiErr struct {
+		Message string `json:"Message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr); err == nil && apiErr.Message != "" {
+		return nil, fmt.Errorf("IPFS node returned %s: %s", resp.Status, apiErr.Message)
+	}
+	return nil, fmt.Errorf("IPFS node returned %s", resp.Status)
+}
+
+func (s *ipfsStore) Get(ctx context.Context, key string) (io.ReadCloser, StoredObject, error) {
+	return nil, StoredObject{}, errIPFSUnsupported
+}
+
+func (s *ipfsStore) Stat(ctx context.Context, key string) (StoredObject, error) {
+	return StoredObject{}, errIPFSUnsupported
+}
+
+func (s *ipfsStore) Remove(ctx context.Context, key string) error {
+	return errIPFSUnsupported
+}
+
+func (s *ipfsStore) List(ctx context.Context, prefix string, recursive bool) ([]StoredObject, error) {
+	return nil, errIPFSUnsupported
+}
+
+func (s *ipfsStore) Presign(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	return nil, errPresignUnsupported
+}
@@ -0,0 +1,137 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakeIPFSNode serves the add and version calls of an IPFS node's API, sending each file added
+// on the returned channel and answering with cid
+func newFakeIPFSNode(t *testing.T, cid string) (*httptest.Server, <-chan *http.Request) {
+	t.Helper()
+	added := make(chan *http.Request, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v0/version":
+			_, _ = io.WriteString(w, `{"Version":"0.30.0"}`)
+		case "/api/v0/add":
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			added <- r
+			_, _ = io.WriteString(w, `{"Name":"`+r.MultipartForm.File["file"][0].Filename+`","Hash":"`+cid+`","Size":"10"}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `{"Message":"unknown command","Code":0,"Type":"error"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, added
+}
+
+func TestIPFSStorePut(t *testing.T) {
+	const cid = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	server, added := newFakeIPFSNode(t, cid)
+	u, _ := url.Parse(strings.Replace(server.URL, "http://", "ipfs://", 1))
+	store, err := newIPFSStore(u, Remote{Endpoint: u.Host, IPFS: IPFSOptions{Pin: true}, AccessKey: "user", SecretKey: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(context.Background(), "/report.pdf", strings.NewReader("%PDF-1.7"), 8, nil); err != nil {
+		t.Fatal(err)
+	}
+	if store.cid != cid {
+		t.Errorf("cid = %q, want %q", store.cid, cid)
+	}
+	r := <-added
+	if q := r.URL.Query(); q.Get("pin") != "true" || q.Get("cid-version") != "1" {
+		t.Errorf("unexpected query %s", r.URL.RawQuery)
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Error("expected the remote's keys as basic authentication")
+	}
+	f, _ := r.MultipartForm.File["file"][0].Open()
+	if body, _ := io.ReadAll(f); string(body) != "%PDF-1.7" || r.MultipartForm.File["file"][0].Filename != "report.pdf" {
+		t.Errorf("added %q as %q", body, r.MultipartForm.File["file"][0].Filename)
+	}
+
+	if _, err := store.version(context.Background()); err != nil {
+		t.Errorf("version: %v", err)
+	}
+	if _, err := store.Stat(context.Background(), "/report.pdf"); !errors.Is(err, errIPFSUnsupported) {
+		t.Errorf("expected errIPFSUnsupported, got %v", err)
+	}
+
+	store.api = server.URL + "/api/v0/missing"
+	if err := store.Put(context.Background(), "/x", strings.NewReader("x"), 1, nil); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("expected the node's error, got %v", err)
+	}
+}
+
+func TestUploadToIPFSRecordsCID(t *testing.T) {
+	resetWorkflows(t)
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	const cid = "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	server, added := newFakeIPFSNode(t, cid)
+	dir := t.TempDir()
+	config = Config{StateFile: filepath.Join(dir, "state.jsonl")}
+
+	name := filepath.Join(dir, "archive.tar")
+	_ = os.WriteFile(name, []byte("archived"), 0600)
+	o := Outbound{
+		Name:        "archive",
+		Source:      filepath.Join(dir, "*.tar"),
+		Destination: strings.Replace(server.URL, "http://", "ipfs://", 1),
+	}
+	if err := uploadEvent(nil, o, name); err != nil {
+		t.Fatal(err)
+	}
+	if q := (<-added).URL.Query(); q.Get("pin") != "false" {
+		t.Errorf("expected an unpinned add, got %s", q)
+	}
+	records, err := readTransferRecords(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].CID != cid || records[0].Key != "/archive.tar" {
+		t.Errorf("unexpected records %+v", records)
+	}
+	if msg := uploadedMessage(name, o.Destination, records[0]); !strings.Contains(msg, cid) {
+		t.Errorf("notification %q does not give the CID", msg)
+	}
+}
+
+func TestIPFSDestinationValidate(t *testing.T) {
+	for _, tt := range []struct {
+		o    Outbound
+		want string
+	}{
+		{Outbound{Name: "path", Source: "/tmp/*", Destination: "ipfs://127.0.0.1:5001/archive"}, "cannot have a path"},
+		{Outbound{Name: "chunked", Source: "/tmp/*", Destination: "ipfs://127.0.0.1:5001", Chunked: Chunking{Enabled: true}}, "chunked cannot be used with an IPFS destination"},
+		{Outbound{Name: "suffix", Source: "/tmp/*", Destination: "ipfs://127.0.0.1:5001", OnCollision: collisionSuffix}, "on_collision cannot be used with an IPFS destination"},
+	} {
+		cfg := Config{Outbound: []Outbound{tt.o}}
+		found := false
+		for _, err := range cfg.Validate() {
+			found = found || strings.Contains(err.Error(), tt.want)
+		}
+		if !found {
+			t.Errorf("%s: no error containing %q", tt.o.Name, tt.want)
+		}
+	}
+	valid := Config{Outbound: []Outbound{{Name: "archive", Source: "/tmp/*", Destination: "ipfs://127.0.0.1:5001"}}}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Errorf("expected valid config, got %v", errs)
+	}
+}
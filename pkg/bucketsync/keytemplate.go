@@ -0,0 +1,96 @@
+package bucketsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// objectKeyData is what an outbound workflow's key_template is rendered with for each file
+type objectKeyData struct {
+	// Filename is the file's base name
+	Filename string
+	// Date is the day of the upload as 2006/01/02, and Time the moment it started, in UTC
+	Date         string
+	Time         time.Time
+	Hostname     string
+	WorkflowName string
+	TransferID   string
+}
+
+// parseKeyTemplate parses a key_template, checking that it renders for any file
+func parseKeyTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("key_template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Fields which are not there only fail when the template is rendered
+	sample := objectKeyData{Filename: "file", Date: "2006/01/02", Hostname: "host", WorkflowName: "workflow", TransferID: "id"}
+	if _, err := renderObjectKey(tmpl, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// parseKeyTemplates parses the outbound workflows' key templates, once the configuration is
+// loaded, so that their uploads share them
+func (c *Config) parseKeyTemplates() {
+	for i, o := range c.Outbound {
+		if o.KeyTemplate != "" {
+			// Validate has checked them
+			c.Outbound[i].keyTemplate, _ = parseKeyTemplate(o.KeyTemplate)
+		}
+	}
+}
+
+// fileObjectKey renders the key, under the destination's prefix, of the file at name
+func fileObjectKey(o Outbound, prefix, name, id string, now time.Time) (string, error) {
+	filename := filepath.Base(name)
+	if o.KeyTemplate == "" {
+		return outboundObjectKey(prefix, filename), nil
+	}
+	tmpl := o.keyTemplate
+	if tmpl == nil {
+		// Workflows built in code rather than read from a file are parsed as used
+		var err error
+		if tmpl, err = parseKeyTemplate(o.KeyTemplate); err != nil {
+			return "", fmt.Errorf("invalid key template: %w", err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	now = now.UTC()
+	key, err := renderObjectKey(tmpl, objectKeyData{
+		Filename:     filename,
+		Date:         now.Format("2006/01/02"),
+		Time:         now,
+		Hostname:     hostname,
+		WorkflowName: o.Name,
+		TransferID:   id,
+	})
+	if err != nil {
+		return "", err
+	}
+	return outboundObjectKey(prefix, key), nil
+}
+
+// renderObjectKey renders a key template, relative to the destination's prefix
+func renderObjectKey(tmpl *template.Template, data objectKeyData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render key: %w", err)
+	}
+	key := strings.TrimPrefix(buf.String(), "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("key template rendered %q, which names no object", key)
+	}
+	if slices.Contains(strings.Split(key, "/"), "..") {
+		return "", errors.New("key template rendered a key outside the destination")
+	}
+	return key, nil
+}
@@ -0,0 +1,76 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileObjectKey(t *testing.T) {
+	now := time.Date(2024, 6, 3, 23, 30, 0, 0, time.FixedZone("ICT", 7*60*60))
+	hostname, _ := os.Hostname()
+	tests := []struct {
+		template, want string
+	}{
+		{"", "backups/db.sql.gz"},
+		{"{{.Date}}/{{.Filename}}", "backups/2024/06/03/db.sql.gz"},
+		{`/{{.WorkflowName}}/{{.Time.Format "2006-01"}}/{{.Hostname}}-{{.Filename}}`, "backups/db/2024-06/" + hostname + "-db.sql.gz"},
+		{"{{.TransferID}}", "backups/abc123"},
+	}
+	for _, tt := range tests {
+		// Templates are parsed once the configuration is loaded, or else as used
+		cfg := Config{Outbound: []Outbound{{Name: "db", KeyTemplate: tt.template}}}
+		unparsed := cfg.Outbound[0]
+		cfg.parseKeyTemplates()
+		if parsed := cfg.Outbound[0].keyTemplate != nil; parsed != (tt.template != "") {
+			t.Errorf("%q: parsed %v", tt.template, parsed)
+		}
+		for _, o := range []Outbound{cfg.Outbound[0], unparsed} {
+			key, err := fileObjectKey(o, "backups", "/srv/dumps/db.sql.gz", "abc123", now)
+			if err != nil {
+				t.Errorf("%q: %v", tt.template, err)
+			} else if key != tt.want {
+				t.Errorf("%q rendered %q, want %q", tt.template, key, tt.want)
+			}
+		}
+	}
+
+	for _, template := range []string{"{{.Date}}/", "../{{.Filename}}", "{{.Name}}", "{{.Filename"} {
+		if _, err := parseKeyTemplate(template); err == nil {
+			t.Errorf("%q: expected an error", template)
+		}
+	}
+}
+
+func TestKeyTemplateUpload(t *testing.T) {
+	resetWorkflows(t)
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	config = Config{StateFile: filepath.Join(dir, "state.jsonl")}
+
+	name := filepath.Join(dir, "db.sql.gz")
+	_ = os.WriteFile(name, []byte("dump"), 0600)
+	o := Outbound{
+		Name:        "db",
+		Source:      filepath.Join(dir, "*.gz"),
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/backups",
+		KeyTemplate: "{{.WorkflowName}}/{{.Date}}/{{.Filename}}",
+	}
+	if err := uploadEvent(nil, o, name); err != nil {
+		t.Fatal(err)
+	}
+	want := "/backups/db/" + time.Now().UTC().Format("2006/01/02") + "/db.sql.gz"
+	if u := <-uploads; u.path != want {
+		t.Errorf("uploaded to %s, want %s", u.path, want)
+	}
+}
+
+func TestKeyTemplateVerify(t *testing.T) {
+	if _, err := resolveVerifyTarget(Outbound{Source: "/srv/*", Destination: "s3://minio:9000/a", KeyTemplate: "{{.Filename}}"}); err == nil {
+		t.Error("expected verification with a key_template to be refused")
+	}
+}
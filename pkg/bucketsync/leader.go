@@ -0,0 +1,329 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Leader election backends
+const (
+	leaderBackendKubernetes = "kubernetes"
+	leaderBackendBucket     = "bucket"
+
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewInterval = 5 * time.Second
+	defaultLeaseName     = "bucketsyncd"
+	defaultLockKey       = "bucketsyncd.lock"
+)
+
+// LeaderElection lets several replicas run for high availability with only the one holding a
+// lease processing outbound watchers, so that files are not uploaded twice. Inbound workflows
+// run on every replica, sharing their queues' messages between them.
+type LeaderElection struct {
+	// Backend is kubernetes, for a coordination.k8s.io Lease, or bucket, for a lock object
+	Backend string `yaml:"backend"`
+	// Identity names this replica in the lease, by default its hostname and a random suffix
+	Identity string `yaml:"identity"`
+	// LeaseDuration is how long the lease lasts without being renewed, before another replica may take it
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+	RenewInterval time.Duration `yaml:"renew_interval"`
+
+	// Namespace and Name locate the Lease; the namespace defaults to the pod's own
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+
+	// Remote, Bucket and Key locate the lock object
+	Remote string `yaml:"remote"`
+	Bucket string `yaml:"bucket"`
+	Key    string `yaml:"key"`
+}
+
+func (l LeaderElection) validate() error {
+	switch l.Backend {
+	case "", leaderBackendKubernetes:
+	case leaderBackendBucket:
+		if l.Remote == "" || l.Bucket == "" {
+			return errors.New("remote and bucket are required for the bucket backend")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q (expected kubernetes or bucket)", l.Backend)
+	}
+	if l.LeaseDuration < 0 || l.RenewInterval < 0 {
+		return errors.New("lease_duration and renew_interval must not be negative")
+	}
+	if l.withDefaults().RenewInterval >= l.withDefaults().LeaseDuration {
+		return errors.New("renew_interval must be shorter than lease_duration")
+	}
+	return nil
+}
+
+func (l LeaderElection) withDefaults() LeaderElection {
+	if l.LeaseDuration == 0 {
+		l.LeaseDuration = defaultLeaseDuration
+	}
+	if l.RenewInterval == 0 {
+		l.RenewInterval = defaultRenewInterval
+	}
+	if l.Name == "" {
+		l.Name = defaultLeaseName
+	}
+	if l.Key == "" {
+		l.Key = defaultLockKey
+	}
+	if l.Identity == "" {
+		hostname, _ := os.Hostname()
+		l.Identity = hostname + "_" + newTransferID()
+	}
+	return l
+}
+
+// following is set while another replica holds the lease, so without leader election this
+// replica always leads
+var following atomic.Bool
+
+// isLeader reports whether this replica should process outbound watchers
+func isLeader() bool {
+	return !following.Load()
+}
+
+var leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "bucketsyncd",
+	Name:      "leader",
+	Help:      "Whether this replica holds the leader election lease (1) or not (0).",
+})
+
+func init() {
+	metricsRegistry.MustRegister(leaderGauge)
+}
+
+// errLeaseConflict is returned when another replica changed the lease since it was read
+var errLeaseConflict = errors.New("lease changed by another replica")
+
+// leaderRecord is the state of the lease, as stored by a leaderLock
+type leaderRecord struct {
+	Holder      string        `json:"holder"`
+	Acquired    time.Time     `json:"acquired"`
+	Renewed     time.Time     `json:"renewed"`
+	Duration    time.Duration `json:"duration"`
+	Transitions int           `json:"transitions"`
+}
+
+// leaderLock stores the lease, updating it only if it is unchanged since it was read
+type leaderLock interface {
+	// get returns the lease and its version, which is empty if there is no lease yet
+	get(ctx context.Context) (leaderRecord, string, error)
+	// put replaces the lease at version, or creates it if version is empty, returning
+	// errLeaseConflict if it has changed since
+	put(ctx context.Context, rec leaderRecord, version string) error
+}
+
+type leaderElector struct {
+	cfg     LeaderElection
+	lock    leaderLock
+	renewed time.Time
+}
+
+// startLeaderElection campaigns for the lease until the returned function is called, which
+// releases it if held
+func startLeaderElection(cfg LeaderElection) (func(), error) {
+	cfg = cfg.withDefaults()
+	var lock leaderLock
+	var err error
+	switch cfg.Backend {
+	case leaderBackendKubernetes:
+		lock, err = newKubernetesLeaseLock(cfg)
+	case leaderBackendBucket:
+		lock, err = newBucketLock(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := &leaderElector{cfg: cfg, lock: lock}
+	// Follow until the lease is won, so that no files are uploaded by two replicas at once
+	following.Store(true)
+	leaderGauge.Set(0)
+	log.WithFields(log.Fields{"backend": cfg.Backend, "identity": cfg.Identity}).Info("campaigning for leadership")
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		e.run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-stopped
+		e.release()
+	}, nil
+}
+
+func (e *leaderElector) run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.RenewInterval)
+	defer ticker.Stop()
+	for {
+		e.renew(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew takes or renews the lease, stepping down if it cannot be renewed in time
+func (e *leaderElector) renew(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.RenewInterval)
+	defer cancel()
+	now := time.Now()
+	held, err := e.tryAcquire(ctx, now)
+	if err != nil {
+		log.WithField("identity", e.cfg.Identity).Warn("failed to renew leader election lease: ", err)
+		// Step down a renewal early, before another replica could take the lease
+		held = isLeader() && now.Sub(e.renewed) < e.cfg.LeaseDuration-e.cfg.RenewInterval
+	} else if held {
+		e.renewed = now
+	}
+	e.setLeading(held)
+}
+
+// tryAcquire takes the lease if it is free or has expired, or renews it if already held
+func (e *leaderElector) tryAcquire(ctx context.Context, now time.Time) (bool, error) {
+	rec, version, err := e.lock.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	if version != "" && rec.Holder != "" && rec.Holder != e.cfg.Identity && now.Before(rec.Renewed.Add(rec.Duration)) {
+		return false, nil
+	}
+	next := leaderRecord{
+		Holder:      e.cfg.Identity,
+		Acquired:    rec.Acquired,
+		Renewed:     now,
+		Duration:    e.cfg.LeaseDuration,
+		Transitions: rec.Transitions,
+	}
+	if rec.Holder != e.cfg.Identity {
+		next.Acquired = now
+		if version != "" {
+			next.Transitions++
+		}
+	}
+	if err := e.lock.put(ctx, next, version); err != nil {
+		if errors.Is(err, errLeaseConflict) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *leaderElector) setLeading(leading bool) {
+	if leading == isLeader() {
+		return
+	}
+	following.Store(!leading)
+	lf := log.Fields{"identity": e.cfg.Identity}
+	if leading {
+		leaderGauge.Set(1)
+		log.WithFields(lf).Info("became leader, processing outbound workflows")
+		// Files written since the last leader stopped were ignored by every replica
+		go catchUpOutbound()
+	} else {
+		leaderGauge.Set(0)
+		log.WithFields(lf).Warn("lost leadership, no longer processing outbound workflows")
+	}
+}
+
+// release gives up the lease on shutdown, so that another replica need not wait for it to expire
+func (e *leaderElector) release() {
+	if !isLeader() {
+		return
+	}
+	e.setLeading(false)
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.RenewInterval)
+	defer cancel()
+	rec, version, err := e.lock.get(ctx)
+	if err == nil && rec.Holder == e.cfg.Identity {
+		rec.Holder = ""
+		rec.Duration = 0
+		err = e.lock.put(ctx, rec, version)
+	}
+	if err != nil {
+		log.WithField("identity", e.cfg.Identity).Warn("failed to release leader election lease: ", err)
+	}
+}
+
+// bucketLock keeps the lease in an object, relying on conditional writes to update it safely.
+// The replicas' clocks must be roughly in step, since each judges expiry by its own.
+type bucketLock struct {
+	mc     *minio.Client
+	bucket string
+	key    string
+}
+
+func newBucketLock(cfg LeaderElection) (*bucketLock, error) {
+	remote, ok := findRemote(cfg.Remote)
+	if !ok {
+		return nil, fmt.Errorf("no remote named %q", cfg.Remote)
+	}
+	mc, err := newMinioClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketLock{mc: mc, bucket: cfg.Bucket, key: cfg.Key}, nil
+}
+
+func (b *bucketLock) get(ctx context.Context) (leaderRecord, string, error) {
+	var rec leaderRecord
+	obj, err := b.mc.GetObject(ctx, b.bucket, b.key, minio.GetObjectOptions{})
+	if err != nil {
+		return rec, "", err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	info, err := obj.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return rec, "", nil
+		}
+		return rec, "", err
+	}
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return rec, "", err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, "", fmt.Errorf("invalid lock object: %w", err)
+	}
+	return rec, info.ETag, nil
+}
+
+func (b *bucketLock) put(ctx context.Context, rec leaderRecord, version string) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	if version == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(version)
+	}
+	_, err = b.mc.PutObject(ctx, b.bucket, b.key, bytes.NewReader(data), int64(len(data)), opts)
+	if lockConflict(err) {
+		return errLeaseConflict
+	}
+	return err
+}
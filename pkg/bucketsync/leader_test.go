@@ -0,0 +1,245 @@
+package bucketsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryLock is a leaderLock shared in memory between electors
+type memoryLock struct {
+	mu      sync.Mutex
+	rec     leaderRecord
+	version int
+}
+
+func (m *memoryLock) get(context.Context) (leaderRecord, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.version == 0 {
+		return leaderRecord{}, "", nil
+	}
+	return m.rec, strconv.Itoa(m.version), nil
+}
+
+func (m *memoryLock) put(_ context.Context, rec leaderRecord, version string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := ""
+	if m.version != 0 {
+		current = strconv.Itoa(m.version)
+	}
+	if version != current {
+		return errLeaseConflict
+	}
+	m.rec = rec
+	m.version++
+	return nil
+}
+
+// resetLeadership restores this replica's leadership after a test has campaigned
+func resetLeadership(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		following.Store(false)
+	})
+}
+
+func TestLeaderElectionValidate(t *testing.T) {
+	if err := (LeaderElection{Backend: leaderBackendKubernetes}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (LeaderElection{Backend: leaderBackendBucket}).validate(); err == nil {
+		t.Error("expected error for bucket backend without a bucket")
+	}
+	if err := (LeaderElection{Backend: "etcd"}).validate(); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+	if err := (LeaderElection{Backend: leaderBackendKubernetes, LeaseDuration: time.Second}).validate(); err == nil {
+		t.Error("expected error for renew_interval longer than lease_duration")
+	}
+}
+
+func TestLeaderElectorTryAcquire(t *testing.T) {
+	lock := &memoryLock{}
+	cfg := LeaderElection{LeaseDuration: 10 * time.Second, RenewInterval: time.Second}
+	a := &leaderElector{cfg: cfg, lock: lock}
+	a.cfg.Identity = "a"
+	b := &leaderElector{cfg: cfg, lock: lock}
+	b.cfg.Identity = "b"
+	ctx := context.Background()
+	now := time.Now()
+
+	if held, err := a.tryAcquire(ctx, now); !held || err != nil {
+		t.Fatalf("a: tryAcquire() = %v, %v; want the free lease", held, err)
+	}
+	if held, _ := b.tryAcquire(ctx, now.Add(time.Second)); held {
+		t.Fatal("b took a lease a holds")
+	}
+	if held, _ := a.tryAcquire(ctx, now.Add(5*time.Second)); !held {
+		t.Fatal("a could not renew its lease")
+	}
+	if held, _ := b.tryAcquire(ctx, now.Add(12*time.Second)); held {
+		t.Fatal("b took the lease before the renewal expired")
+	}
+	if held, _ := b.tryAcquire(ctx, now.Add(16*time.Second)); !held {
+		t.Fatal("b could not take the expired lease")
+	}
+	if lock.rec.Holder != "b" || lock.rec.Transitions != 1 {
+		t.Errorf("lease = %+v, want held by b after 1 transition", lock.rec)
+	}
+}
+
+func TestLeaderElectorStepsDown(t *testing.T) {
+	resetLeadership(t)
+	lock := &memoryLock{}
+	e := &leaderElector{cfg: LeaderElection{Identity: "a", LeaseDuration: time.Hour, RenewInterval: time.Minute}, lock: lock}
+	following.Store(true)
+
+	e.renew(context.Background())
+	if !isLeader() {
+		t.Fatal("not leading after taking the lease")
+	}
+
+	// Another replica taking the lease leaves this one following, until that lease expires
+	lock.rec.Holder = "b"
+	lock.version++
+	e.renew(context.Background())
+	if isLeader() {
+		t.Error("still leading after another replica took the lease")
+	}
+
+	lock.rec.Renewed = time.Now().Add(-2 * time.Hour)
+	lock.version++
+	e.renew(context.Background())
+	if !isLeader() {
+		t.Fatal("not leading after taking over the expired lease")
+	}
+	e.release()
+	if isLeader() || lock.rec.Holder != "" {
+		t.Errorf("lease not released: leading %v, lease %+v", isLeader(), lock.rec)
+	}
+}
+
+func TestLeaderCatchesUp(t *testing.T) {
+	resetLeadership(t)
+	resetOutboundWorkflows(t)
+	resetWorkflows(t)
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	o := Outbound{
+		Name:        "handover",
+		Source:      filepath.Join(dir, "*.csv"),
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+	}
+	if _, err := startOutbound(o); err != nil {
+		t.Fatal(err)
+	}
+	e := &leaderElector{cfg: LeaderElection{Identity: "a"}}
+	following.Store(true)
+
+	// A file written while following is ignored, and uploaded once this replica leads
+	_ = os.WriteFile(filepath.Join(dir, "orders.csv"), []byte("1,2"), 0600)
+	select {
+	case u := <-uploads:
+		t.Fatalf("uploaded %s while following", u.path)
+	case <-time.After(200 * time.Millisecond):
+	}
+	e.setLeading(true)
+	select {
+	case u := <-uploads:
+		if u.path != "/uploads/orders.csv" {
+			t.Errorf("uploaded %s, want /uploads/orders.csv", u.path)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("file written while following not uploaded on taking the lead")
+	}
+}
+
+func TestKubernetesLeaseLock(t *testing.T) {
+	var mu sync.Mutex
+	var stored *kubernetesLease
+	resourceVersion := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		const collection = "/apis/coordination.k8s.io/v1/namespaces/apps/leases"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == collection+"/bucketsyncd":
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(stored)
+		case r.Method == http.MethodPost && r.URL.Path == collection:
+			if stored != nil {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			fallthrough
+		case r.Method == http.MethodPut && r.URL.Path == collection+"/bucketsyncd":
+			var lease kubernetesLease
+			if err := json.NewDecoder(r.Body).Decode(&lease); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if stored != nil && lease.Metadata.ResourceVersion != stored.Metadata.ResourceVersion {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			resourceVersion++
+			lease.Metadata.ResourceVersion = strconv.Itoa(resourceVersion)
+			stored = &lease
+			_ = json.NewEncoder(w).Encode(stored)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("t0ken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lock := &kubernetesLeaseLock{client: srv.Client(), server: srv.URL, tokenFile: tokenFile, namespace: "apps", name: "bucketsyncd"}
+	ctx := context.Background()
+
+	if _, version, err := lock.get(ctx); err != nil || version != "" {
+		t.Fatalf("get() = %q, %v; want no lease", version, err)
+	}
+	renewed := time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC)
+	rec := leaderRecord{Holder: "a", Acquired: renewed, Renewed: renewed, Duration: 15 * time.Second}
+	if err := lock.put(ctx, rec, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.put(ctx, rec, ""); err != errLeaseConflict {
+		t.Errorf("creating an existing lease: put() = %v, want errLeaseConflict", err)
+	}
+
+	got, version, err := lock.get(ctx)
+	if err != nil || version != "1" {
+		t.Fatalf("get() = %q, %v", version, err)
+	}
+	if got != rec {
+		t.Errorf("get() = %+v, want %+v", got, rec)
+	}
+	rec.Renewed = rec.Renewed.Add(5 * time.Second)
+	if err := lock.put(ctx, rec, version); err != nil {
+		t.Errorf("renewing: %v", err)
+	}
+	if err := lock.put(ctx, rec, version); err != errLeaseConflict {
+		t.Errorf("stale update: put() = %v, want errLeaseConflict", err)
+	}
+}
@@ -0,0 +1,179 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// leaseMicroTime is the format of a Lease's timestamps
+const leaseMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesLease is the part of a coordination.k8s.io/v1 Lease bucketsyncd uses
+type kubernetesLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+// kubernetesLeaseLock keeps the lease in a Lease object, using the pod's service account, which
+// needs get, create and update permission on leases
+type kubernetesLeaseLock struct {
+	client    *http.Client
+	server    string
+	tokenFile string
+	namespace string
+	name      string
+}
+
+func newKubernetesLeaseLock(cfg LeaderElection) (*kubernetesLeaseLock, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes leader election only works inside a pod")
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in the service account's ca.crt")
+	}
+	namespace := cfg.Namespace
+	if namespace == "" {
+		ns, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	const apiTimeout = 10 * time.Second
+	return &kubernetesLeaseLock{
+		client: &http.Client{
+			Timeout:   apiTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		},
+		server:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: filepath.Join(serviceAccountDir, "token"),
+		namespace: namespace,
+		name:      cfg.Name,
+	}, nil
+}
+
+func (k *kubernetesLeaseLock) leasesURL() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", k.server, k.namespace)
+}
+
+// do sends a request to the API server, decoding a successful response into out
+func (k *kubernetesLeaseLock) do(ctx context.Context, method, url string, in, out any) (int, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+	// The token is read afresh each time, since Kubernetes rotates it
+	token, err := os.ReadFile(k.tokenFile)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		const maxErrorBody = 512
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k *kubernetesLeaseLock) get(ctx context.Context) (leaderRecord, string, error) {
+	var rec leaderRecord
+	var lease kubernetesLease
+	status, err := k.do(ctx, http.MethodGet, k.leasesURL()+"/"+k.name, nil, &lease)
+	if status == http.StatusNotFound {
+		return rec, "", nil
+	}
+	if err != nil {
+		return rec, "", err
+	}
+	rec.Holder = lease.Spec.HolderIdentity
+	rec.Duration = time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	rec.Transitions = lease.Spec.LeaseTransitions
+	rec.Acquired, _ = time.Parse(leaseMicroTime, lease.Spec.AcquireTime)
+	rec.Renewed, _ = time.Parse(leaseMicroTime, lease.Spec.RenewTime)
+	return rec, lease.Metadata.ResourceVersion, nil
+}
+
+func (k *kubernetesLeaseLock) put(ctx context.Context, rec leaderRecord, version string) error {
+	var lease kubernetesLease
+	lease.APIVersion = "coordination.k8s.io/v1"
+	lease.Kind = "Lease"
+	lease.Metadata.Name = k.name
+	lease.Metadata.Namespace = k.namespace
+	lease.Metadata.ResourceVersion = version
+	lease.Spec.HolderIdentity = rec.Holder
+	lease.Spec.LeaseDurationSeconds = int(rec.Duration / time.Second)
+	lease.Spec.LeaseTransitions = rec.Transitions
+	if !rec.Acquired.IsZero() {
+		lease.Spec.AcquireTime = rec.Acquired.UTC().Format(leaseMicroTime)
+	}
+	if !rec.Renewed.IsZero() {
+		lease.Spec.RenewTime = rec.Renewed.UTC().Format(leaseMicroTime)
+	}
+
+	method, url := http.MethodPut, k.leasesURL()+"/"+k.name
+	if version == "" {
+		method, url = http.MethodPost, k.leasesURL()
+	}
+	// The API server refuses an update whose resourceVersion is stale, and a create if the Lease exists
+	status, err := k.do(ctx, method, url, &lease, nil)
+	if status == http.StatusConflict {
+		return errLeaseConflict
+	}
+	return err
+}
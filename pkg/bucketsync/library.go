@@ -0,0 +1,68 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+)
+
+// OutboundWorkflow watches a local folder and uploads files to a bucket as they appear
+type OutboundWorkflow = Outbound
+
+// InboundWorkflow downloads objects to a local folder as notifications of them arrive
+type InboundWorkflow = Inbound
+
+// ReplicateWorkflow copies objects from one bucket to another as they appear or change
+type ReplicateWorkflow = Replicate
+
+// SyncWorkflow keeps a local folder and a bucket in step, propagating changes both ways
+type SyncWorkflow = TwoWaySync
+
+// Hooks let an embedding program follow the service's transfers
+type Hooks struct {
+	// OnEvent is called with each lifecycle event as it happens, on the transferring goroutine, so
+	// it must return promptly and must not call back into the service
+	OnEvent func(LifecycleEvent)
+}
+
+// Service runs the workflows of a configuration, as the bucketsyncd daemon does, for programs
+// embedding the sync engine. The engine's state is global, so a process runs at most one
+// Service, once.
+type Service struct {
+	config Config
+	hooks  Hooks
+}
+
+// NewService validates cfg and prepares a service to run it
+func NewService(cfg Config, hooks Hooks) (*Service, error) {
+	cfg.applyTenants()
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	cfg.indexRemotes()
+	cfg.parseKeyTemplates()
+	return &Service{config: cfg, hooks: hooks}, nil
+}
+
+// Run starts the enabled workflows and the configured integrations and servers, and runs them
+// until ctx is done. It then stops taking on work, waits up to the configured drain timeout for
+// transfers in flight, and shuts everything down.
+func (s *Service) Run(ctx context.Context) error {
+	configMutex.Lock()
+	config = s.config
+	configMutex.Unlock()
+	// The embedding program sets up logging itself, but the configured secrets are still kept out of it
+	setLogSecrets(s.config.secrets())
+
+	if s.hooks.OnEvent != nil {
+		defer addEventHook(s.hooks.OnEvent)()
+	}
+	stop, err := startService()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	<-ctx.Done()
+	shutdownService(configuredDrainTimeout())
+	return nil
+}
@@ -0,0 +1,56 @@
+package bucketsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewServiceValidates(t *testing.T) {
+	if _, err := NewService(Config{DrainTimeout: -1}, Hooks{}); err == nil {
+		t.Error("expected error for an invalid configuration")
+	}
+}
+
+func TestServiceRun(t *testing.T) {
+	resetDrain(t)
+	resetWorkflows(t)
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+		setLogSecrets(nil)
+	}()
+
+	disabled := false
+	svc, err := NewService(Config{
+		DrainTimeout: time.Second,
+		Remotes:      []Remote{{Name: "minio", Endpoint: "minio:9000", AccessKey: "AKIALIB", SecretKey: "library-secret"}},
+		Outbound:     []OutboundWorkflow{{Name: "docs", Source: t.TempDir(), Destination: "s3://bucket", Enabled: &disabled}},
+	}, Hooks{OnEvent: func(LifecycleEvent) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		result <- svc.Run(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Run() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return once cancelled")
+	}
+	if serviceCtx.Err() == nil {
+		t.Error("expected the service to stop taking on work")
+	}
+	logRedactor.mu.RLock()
+	defer logRedactor.mu.RUnlock()
+	if logRedactor.replacer == nil || logRedactor.replacer.Replace("library-secret") != redactedValue {
+		t.Error("configured secrets not redacted from the log")
+	}
+}
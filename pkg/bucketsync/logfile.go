@@ -0,0 +1,267 @@
+package bucketsync
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is the timestamp suffix given to rotated log and audit files
+const rotatedTimeFormat = "20060102T150405Z"
+
+// LogFile configures writing the log to a file, rotating it by size and/or time and
+// removing old files, for installs without journald or logrotate
+type LogFile struct {
+	Path    string `yaml:"path"`
+	MaxSize string `yaml:"max_size"`
+	// RotateEvery starts a new file each interval, e.g. 24h for daily files, aligned to UTC
+	RotateEvery time.Duration `yaml:"rotate_every"`
+	// MaxAge and MaxBackups limit how long and how many rotated files are kept; zero keeps them all
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxBackups int           `yaml:"max_backups"`
+	Compress   bool          `yaml:"compress"`
+}
+
+func (c LogFile) validate() error {
+	if c.MaxSize != "" {
+		if _, err := parseByteSize(c.MaxSize); err != nil {
+			return fmt.Errorf("invalid max_size: %w", err)
+		}
+	}
+	if c.RotateEvery < 0 || c.MaxAge < 0 || c.MaxBackups < 0 {
+		return errors.New("rotate_every, max_age and max_backups must not be negative")
+	}
+	return nil
+}
+
+// rotatingFile is an io.Writer appending to a log file which it rotates as configured
+type rotatingFile struct {
+	mu      sync.Mutex
+	cfg     LogFile
+	maxSize int64
+	f       *os.File
+	size    int64
+	period  time.Time
+
+	// cleanupMu serialises compressing and removing rotated files, which happens in the background
+	cleanupMu sync.Mutex
+	cleanups  sync.WaitGroup
+}
+
+// openLogFile opens the log file for appending, creating its directory if need be
+func openLogFile(cfg LogFile) (*rotatingFile, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{cfg: cfg}
+	if cfg.MaxSize != "" {
+		r.maxSize, _ = parseByteSize(cfg.MaxSize)
+	}
+	const dirPerms = 0750
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), dirPerms); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	const filePerms = 0600
+	// #nosec G304 - intentional: path comes from the configuration file
+	f, err := os.OpenFile(r.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerms)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	// A file carried over from a previous run belongs to the period it was last written in
+	r.f, r.size, r.period = f, fi.Size(), time.Now().UTC()
+	if fi.Size() > 0 {
+		r.period = fi.ModTime().UTC()
+	}
+	return nil
+}
+
+// Write appends p to the file, first rotating it if p would take it past max_size or
+// a new rotate_every period has begun
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.due(time.Now().UTC(), len(p)) {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing the entry
+			fmt.Fprintln(os.Stderr, "failed to rotate log file:", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) due(now time.Time, n int) bool {
+	if r.maxSize > 0 && r.size+int64(n) > r.maxSize {
+		return true
+	}
+	return r.cfg.RotateEvery > 0 && now.Truncate(r.cfg.RotateEvery).After(r.period.Truncate(r.cfg.RotateEvery))
+}
+
+// rotate renames the current file with a timestamp suffix, starts a new one and tidies up
+// the rotated files in the background
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.cfg.Path, rotatedPath(r.cfg.Path, time.Now())); err != nil {
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.cleanups.Add(1)
+	go func() {
+		defer r.cleanups.Done()
+		r.cleanup()
+	}()
+	return nil
+}
+
+// Close closes the file after waiting for any background compression to finish
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleanups.Wait()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// cleanup compresses rotated files if configured, then removes those beyond max_backups or max_age
+func (r *rotatingFile) cleanup() {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+
+	backups := rotatedFiles(r.cfg.Path)
+	if r.cfg.Compress {
+		for i, b := range backups {
+			if strings.HasSuffix(b.path, ".gz") {
+				continue
+			}
+			if err := gzipFile(b.path); err != nil {
+				fmt.Fprintln(os.Stderr, "failed to compress rotated log file:", err)
+				continue
+			}
+			backups[i].path += ".gz"
+		}
+	}
+
+	cutoff := time.Now().Add(-r.cfg.MaxAge)
+	for i, b := range backups {
+		if (r.cfg.MaxBackups > 0 && i >= r.cfg.MaxBackups) || (r.cfg.MaxAge > 0 && b.rotated.Before(cutoff)) {
+			if err := os.Remove(b.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintln(os.Stderr, "failed to remove old log file:", err)
+			}
+		}
+	}
+}
+
+// rotatedPath returns a name for path rotated at t which is not already taken
+func rotatedPath(path string, t time.Time) string {
+	suffix := t.UTC().Format(rotatedTimeFormat)
+	rotated := path + "." + suffix
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); errors.Is(err, os.ErrNotExist) {
+			if _, err := os.Stat(rotated + ".gz"); errors.Is(err, os.ErrNotExist) {
+				return rotated
+			}
+		}
+		rotated = fmt.Sprintf("%s.%s.%d", path, suffix, i)
+	}
+}
+
+type rotatedFile struct {
+	path    string
+	rotated time.Time
+	// seq tells apart files rotated within the same second, the later having the higher number
+	seq int
+}
+
+// rotatedFiles lists the rotated copies of path, newest first
+func rotatedFiles(path string) []rotatedFile {
+	matches, _ := filepath.Glob(path + ".*")
+	var files []rotatedFile
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, path+".")
+		if len(suffix) < len(rotatedTimeFormat) {
+			continue
+		}
+		t, err := time.Parse(rotatedTimeFormat, suffix[:len(rotatedTimeFormat)])
+		if err != nil {
+			continue
+		}
+		seq, _ := strconv.Atoi(strings.TrimPrefix(strings.TrimSuffix(suffix[len(rotatedTimeFormat):], ".gz"), "."))
+		files = append(files, rotatedFile{path: m, rotated: t, seq: seq})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].rotated.Equal(files[j].rotated) {
+			return files[i].seq > files[j].seq
+		}
+		return files[i].rotated.After(files[j].rotated)
+	})
+	return files
+}
+
+// gzipFile replaces a file with a gzip-compressed copy named path.gz
+func gzipFile(path string) (err error) {
+	// #nosec G304 - intentional: a rotated log file
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	const filePerms = 0600
+	// #nosec G304 - intentional: a rotated log file
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerms)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(path + ".gz")
+		}
+	}()
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
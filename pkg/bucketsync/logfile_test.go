@@ -0,0 +1,138 @@
+package bucketsync
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFileValidate(t *testing.T) {
+	if err := (LogFile{Path: "x.log", MaxSize: "10MB", RotateEvery: 24 * time.Hour}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (LogFile{MaxSize: "10 parsecs"}).validate(); err == nil {
+		t.Error("expected error for invalid max_size")
+	}
+	if err := (LogFile{MaxBackups: -1}).validate(); err == nil {
+		t.Error("expected error for negative max_backups")
+	}
+}
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "bucketsyncd.log")
+	w, err := openLogFile(LogFile{Path: path, MaxSize: "100"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	line := strings.Repeat("x", 59) + "\n"
+	for range 3 {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write would take the file past 100 bytes, so each is in its own file
+	if backups := rotatedFiles(path); len(backups) != 2 {
+		t.Fatalf("got %d rotated files, want 2", len(backups))
+	}
+	if data, _ := os.ReadFile(path); string(data) != line {
+		t.Errorf("current file = %q, want one line", data)
+	}
+}
+
+func TestLogFileRotatesByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bucketsyncd.log")
+	w, err := openLogFile(LogFile{Path: path, RotateEvery: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = w.Close()
+	}()
+	_, _ = w.Write([]byte("today\n"))
+	if len(rotatedFiles(path)) != 0 {
+		t.Fatal("rotated within the period")
+	}
+
+	w.period = w.period.Add(-24 * time.Hour)
+	_, _ = w.Write([]byte("tomorrow\n"))
+	if backups := rotatedFiles(path); len(backups) != 1 {
+		t.Fatalf("got %d rotated files, want 1", len(backups))
+	}
+}
+
+func TestLogFileCompressesAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bucketsyncd.log")
+	// A rotated file older than max_age, which the next rotation removes
+	old := path + "." + time.Now().Add(-48*time.Hour).UTC().Format(rotatedTimeFormat)
+	if err := os.WriteFile(old, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := openLogFile(LogFile{Path: path, MaxSize: "10", MaxAge: 24 * time.Hour, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := rotatedFiles(path)
+	if len(backups) != 2 {
+		t.Fatalf("got %d rotated files, want 2: %v", len(backups), backups)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("file older than max_age was not removed")
+	}
+
+	// The newest backup holds the third line, compressed
+	if !strings.HasSuffix(backups[0].path, ".gz") {
+		t.Fatalf("backup %s is not compressed", backups[0].path)
+	}
+	f, err := os.Open(backups[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "third line\n" {
+		t.Errorf("newest backup = %q, want the third line", data)
+	}
+}
+
+func TestRotatedFilesOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bucketsyncd.log")
+	stamp := "20260101T000000Z"
+	for _, name := range []string{"20251231T000000Z.gz", stamp + ".gz", stamp + ".1.gz", stamp + ".2", "other"} {
+		if err := os.WriteFile(path+"."+name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, f := range rotatedFiles(path) {
+		got = append(got, strings.TrimPrefix(f.path, path+"."))
+	}
+	want := []string{stamp + ".2", stamp + ".1.gz", stamp + ".gz", "20251231T000000Z.gz"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("rotatedFiles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package bucketsync
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Log outputs selected with log_output
+const (
+	logOutputStderr   = "stderr"
+	logOutputSyslog   = "syslog"
+	logOutputJournald = "journald"
+)
+
+// Syslog configures the syslog log output
+type Syslog struct {
+	// Address is a remote syslog server such as udp://logs.example.com:514; the local syslog daemon
+	// is used when empty
+	Address  string `yaml:"address"`
+	Facility string `yaml:"facility"`
+	Tag      string `yaml:"tag"`
+}
+
+// syslogFacilities are the facility codes accepted in the syslog configuration
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Syslog severities, shared by syslog and the journal's PRIORITY field
+const (
+	severityCrit    = 2
+	severityErr     = 3
+	severityWarning = 4
+	severityInfo    = 6
+	severityDebug   = 7
+)
+
+func validateLogOutput(output string, s Syslog) error {
+	switch output {
+	case "", logOutputStderr, logOutputJournald:
+	case logOutputSyslog:
+		if s.Facility != "" {
+			if _, ok := syslogFacilities[s.Facility]; !ok {
+				return fmt.Errorf("unknown syslog facility %q", s.Facility)
+			}
+		}
+		if s.Address != "" {
+			u, err := url.Parse(s.Address)
+			if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+				return fmt.Errorf("syslog address must be udp://host:port or tcp://host:port")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown log_output %q (expected stderr, syslog or journald)", output)
+	}
+	return nil
+}
+
+// openLogOutput sends log entries to syslog or the journal instead of standard error, returning
+// a function which closes the connection. A log_file set afterwards still receives them too.
+func openLogOutput(output string, s Syslog) (func(), error) {
+	if err := validateLogOutput(output, s); err != nil {
+		return nil, err
+	}
+	if output == "" || output == logOutputStderr {
+		return func() {}, nil
+	}
+	hook, err := newLogHook(output, s)
+	if err != nil {
+		return nil, err
+	}
+	addLogHook(hook)
+	log.SetOutput(io.Discard)
+	return func() {
+		removeLogHook(hook)
+		log.SetOutput(os.Stderr)
+		_ = hook.Close()
+	}, nil
+}
+
+// logHook is a log output which receives entries through a logrus hook
+type logHook interface {
+	log.Hook
+	io.Closer
+}
+
+// removeLogHook stops a hook added to the standard logger from receiving entries
+func removeLogHook(hook log.Hook) {
+	hooks := make(log.LevelHooks)
+	for level, levelHooks := range log.StandardLogger().Hooks {
+		for _, h := range levelHooks {
+			if h != hook && h != (levelFilterHook{hook}) {
+				hooks[level] = append(hooks[level], h)
+			}
+		}
+	}
+	log.StandardLogger().ReplaceHooks(hooks)
+}
+
+// logSeverity maps a log level to its syslog severity
+func logSeverity(level log.Level) int {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return severityCrit
+	case log.ErrorLevel:
+		return severityErr
+	case log.WarnLevel:
+		return severityWarning
+	case log.InfoLevel:
+		return severityInfo
+	default:
+		return severityDebug
+	}
+}
+
+// logLine renders an entry as its message followed by its fields as sorted key=value pairs,
+// for outputs which record the time and level themselves
+func logLine(entry *log.Entry) string {
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(entry.Message)
+	for _, k := range keys {
+		v := fmt.Sprint(entry.Data[k])
+		if strings.ContainsAny(v, " \"=\n") || v == "" {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" " + k + "=" + v)
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package bucketsync
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestValidateLogOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		syslog  Syslog
+		wantErr bool
+	}{
+		{"default", "", Syslog{}, false},
+		{"journald", logOutputJournald, Syslog{}, false},
+		{"remote syslog", logOutputSyslog, Syslog{Address: "udp://logs:514", Facility: "local0"}, false},
+		{"unknown output", "kafka", Syslog{}, true},
+		{"unknown facility", logOutputSyslog, Syslog{Facility: "local9"}, true},
+		{"bad address", logOutputSyslog, Syslog{Address: "logs:514"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateLogOutput(tt.output, tt.syslog); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLogSeverity(t *testing.T) {
+	want := map[log.Level]int{
+		log.PanicLevel: 2, log.FatalLevel: 2, log.ErrorLevel: 3, log.WarnLevel: 4,
+		log.InfoLevel: 6, log.DebugLevel: 7, log.TraceLevel: 7,
+	}
+	for level, severity := range want {
+		if got := logSeverity(level); got != severity {
+			t.Errorf("logSeverity(%s) = %d, want %d", level, got, severity)
+		}
+	}
+}
+
+func TestLogLine(t *testing.T) {
+	entry := &log.Entry{Message: "uploaded", Data: log.Fields{"workflow": "photos", "key": "a b.jpg"}}
+	if got, want := logLine(entry), `uploaded key="a b.jpg" workflow=photos`; got != want {
+		t.Errorf("logLine() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// journalSocket is where journald accepts entries using its native protocol
+var journalSocket = "/run/systemd/journal/socket"
+
+const defaultSyslogTag = "bucketsyncd"
+
+func newLogHook(output string, s Syslog) (logHook, error) {
+	if output == logOutputJournald {
+		return newJournalHook()
+	}
+	return newSyslogHook(s)
+}
+
+// syslogHook writes entries to syslog at the severity matching their level
+type syslogHook struct {
+	w *syslog.Writer
+}
+
+func newSyslogHook(s Syslog) (*syslogHook, error) {
+	facility := syslogFacilities["daemon"]
+	if s.Facility != "" {
+		facility = syslogFacilities[s.Facility]
+	}
+	tag := s.Tag
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	var network, addr string
+	if s.Address != "" {
+		u, err := url.Parse(s.Address)
+		if err != nil {
+			return nil, err
+		}
+		network, addr = u.Scheme, u.Host
+	}
+	// #nosec G115 - facility codes are at most 23
+	w, err := syslog.Dial(network, addr, syslog.Priority(facility<<3)|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogHook{w: w}, nil
+}
+
+func (h *syslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *log.Entry) error {
+	line := logLine(entry)
+	switch logSeverity(entry.Level) {
+	case severityCrit:
+		return h.w.Crit(line)
+	case severityErr:
+		return h.w.Err(line)
+	case severityWarning:
+		return h.w.Warning(line)
+	case severityInfo:
+		return h.w.Info(line)
+	default:
+		return h.w.Debug(line)
+	}
+}
+
+func (h *syslogHook) Close() error {
+	return h.w.Close()
+}
+
+// journalHook sends entries to journald with their fields as journal fields, so that they can be
+// matched with journalctl, e.g. journalctl WORKFLOW=photos
+type journalHook struct {
+	conn *net.UnixConn
+}
+
+func newJournalHook() (*journalHook, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	return &journalHook{conn: conn}, nil
+}
+
+func (h *journalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *journalHook) Fire(entry *log.Entry) error {
+	if _, err := h.conn.Write(journalEntry(entry)); err != nil {
+		// Entries too large for a datagram would need passing as a file descriptor; fall
+		// back to standard error rather than lose them
+		fmt.Fprintln(os.Stderr, logLine(entry))
+		return err
+	}
+	return nil
+}
+
+func (h *journalHook) Close() error {
+	return h.conn.Close()
+}
+
+// journalEntry encodes an entry in journald's native protocol
+func journalEntry(entry *log.Entry) []byte {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", entry.Message)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(logSeverity(entry.Level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", defaultSyslogTag)
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if name := journalFieldName(k); name != "" {
+			writeJournalField(&b, name, fmt.Sprint(entry.Data[k]))
+		}
+	}
+	return b.Bytes()
+}
+
+// writeJournalField appends NAME=value, or the length-prefixed form for values spanning lines
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName converts a log field name to a journal field name, which may only contain
+// upper case letters, digits and underscores, and may not start with an underscore or digit
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	const maxFieldName = 64
+	if len(name) > maxFieldName {
+		name = name[:maxFieldName]
+	}
+	return name
+}
@@ -0,0 +1,103 @@
+//go:build !windows
+
+package bucketsync
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestJournalHook(t *testing.T) {
+	// Unix socket paths are limited in length, so avoid the long test temp dir
+	dir, err := os.MkdirTemp("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	saved := journalSocket
+	journalSocket = filepath.Join(dir, "socket")
+	defer func() {
+		journalSocket = saved
+	}()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	hook, err := newLogHook(logOutputJournald, Syslog{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = hook.Close()
+	}()
+	entry := &log.Entry{Level: log.WarnLevel, Message: "retrying", Data: log.Fields{"workflow": "photos", "transfer-id": 7, "err": "line one\nline two"}}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	for _, want := range []string{"MESSAGE=retrying\n", "PRIORITY=4\n", "SYSLOG_IDENTIFIER=bucketsyncd\n", "WORKFLOW=photos\n", "TRANSFER_ID=7\n", "ERR\n\x11\x00\x00\x00\x00\x00\x00\x00line one\nline two\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("journal entry %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestSyslogHook(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	hook, err := newLogHook(logOutputSyslog, Syslog{Address: "udp://" + conn.LocalAddr().String(), Facility: "local0", Tag: "sync"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = hook.Close()
+	}()
+	if err := hook.Fire(&log.Entry{Level: log.ErrorLevel, Message: "upload failed", Data: log.Fields{"workflow": "photos"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0 (16) * 8 + err (3)
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "<131>") || !strings.Contains(got, "sync[") || !strings.Contains(got, "upload failed workflow=photos") {
+		t.Errorf("unexpected syslog message %q", got)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for key, want := range map[string]string{"workflow": "WORKFLOW", "transfer-id": "TRANSFER_ID", "_secret": "SECRET", "2fa": "FA"} {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
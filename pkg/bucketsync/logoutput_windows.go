@@ -0,0 +1,10 @@
+//go:build windows
+
+package bucketsync
+
+import "errors"
+
+// newLogHook fails, as Windows has neither syslog nor journald; use log_file or the event log
+func newLogHook(output string, _ Syslog) (logHook, error) {
+	return nil, errors.New("log_output " + output + " is not supported on Windows")
+}
@@ -0,0 +1,521 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"os"
+
+	"flag"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	version   = "v0.4.2"
+	buildTime = "unknown"
+	gitCommit = "unknown"
+)
+
+// SetBuildInfo records the version, commit and build time embedded in the binary, for the version
+// output and the identity reported to brokers. Empty values are left at their defaults.
+func SetBuildInfo(v, commit, built string) {
+	if v != "" {
+		version = v
+	}
+	if commit != "" {
+		gitCommit = commit
+	}
+	if built != "" {
+		buildTime = built
+	}
+}
+
+const (
+	debugLevel = "debug"
+	infoLevel  = "info"
+	warnLevel  = "warn"
+	errorLevel = "error"
+)
+
+var (
+	configFilePath = flag.String("c", "", "Configuration file location")
+	help           = flag.Bool("h", false, "Usage information")
+	showVersion    = flag.Bool("version", false, "Show version information")
+	logLevelFlag   = flag.String("log-level", "", "Override the configured log level (debug, info, warn)")
+	logJSONFlag    = optionalBoolFlag(flag.CommandLine, "log-json", "Override the configured JSON log format")
+	pidFile        = flag.String("pidfile", "", "Write the process ID to this file while running")
+	daemonize      = flag.Bool("daemon", false, "Run in the background (default is to stay in the foreground)")
+)
+
+// optionalBool is a boolean flag which records whether it was given, so that
+// an explicit -log-json=false can override the configuration
+type optionalBool struct {
+	value bool
+	set   bool
+}
+
+func optionalBoolFlag(fs *flag.FlagSet, name, usage string) *optionalBool {
+	b := &optionalBool{}
+	fs.Var(b, name, usage)
+	return b
+}
+
+func (b *optionalBool) String() string {
+	if b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.value, b.set = v, true
+	return nil
+}
+
+func (b *optionalBool) IsBoolFlag() bool {
+	return true
+}
+
+// Main runs bucketsyncd as its command line asks: a subcommand, or the service itself
+func Main() {
+	// Parse command line arguments and handle help/usage
+	if !parseCommandLine() {
+		return
+	}
+
+	// Run a one-shot subcommand instead of the service if one was given
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
+	// Read YAML config file
+	err := readConfig(*configFilePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitConfig)
+	}
+
+	// Detach into the background once the configuration is known to be readable
+	if *daemonize && !isDaemonChild() {
+		pid, err := startDaemon()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitError)
+		}
+		fmt.Println("bucketsyncd started in the background with PID", pid)
+		return
+	}
+
+	configMutex.RLock()
+	log.Info(fmt.Sprintf("Loaded %d remotes", len(config.Remotes)))
+	configMutex.RUnlock()
+
+	// Configure logging
+	configureLogging()
+	configMutex.RLock()
+	logFile, logOutput, syslogConfig := config.LogFile, config.LogOutput, config.Syslog
+	configMutex.RUnlock()
+	closeLogOutput, err := openLogOutput(logOutput, syslogConfig)
+	if err != nil {
+		log.Fatal("failed to open log output: ", err)
+	}
+	defer closeLogOutput()
+	if logFile.Path != "" {
+		w, err := openLogFile(logFile)
+		if err != nil {
+			log.Fatal("failed to open log file: ", err)
+		}
+		log.SetOutput(w)
+		defer func() {
+			log.SetOutput(os.Stderr)
+			_ = w.Close()
+		}()
+	}
+
+	log.Info("starting bucketsyncd")
+	log.Info("using configuration file ", *configFilePath)
+	log.Info(fmt.Sprintf("build info: version=%s build_time=%s git_commit=%s go_version=%s", version, buildTime, gitCommit, runtime.Version()))
+
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			log.Fatal("failed to write PID file: ", err)
+		}
+		defer removePIDFile(*pidFile)
+	}
+
+	// Start processing, under the Windows service control manager if it started us
+	if isWindowsService() {
+		runWindowsService()
+		return
+	}
+	runService()
+}
+
+func parseCommandLine() bool {
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(versionString())
+		return false
+	}
+
+	if !validLogLevel(*logLevelFlag) {
+		fmt.Println("Error: invalid -log-level", *logLevelFlag)
+		return false
+	}
+
+	// Subcommands check for the configuration file themselves
+	if flag.NArg() > 0 && !*help {
+		return true
+	}
+
+	// Fall back to the default locations when -c is omitted
+	if !*help {
+		if err := resolveConfigPath(); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+	if *help || *configFilePath == "" {
+		fmt.Println("Usage:", os.Args[0], " [-c <config_file_path>] [-h] [-version] [-log-level level] [-log-json] [-pidfile file] [-daemon] [command [args]]")
+		printCommandUsage()
+		return false
+	}
+	return true
+}
+
+// versionString describes the build, as embedded via -ldflags at build time
+func versionString() string {
+	return fmt.Sprintf("bucketsyncd %s (commit %s, built %s, %s %s/%s)",
+		version, gitCommit, buildTime, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// validLogLevel reports whether level is empty or one of the supported log levels
+func validLogLevel(level string) bool {
+	switch level {
+	case "", debugLevel, infoLevel, warnLevel, errorLevel:
+		return true
+	}
+	return false
+}
+
+func configureLogging() {
+	configMutex.RLock()
+	logLevel := config.LogLevel
+	logJSON := config.LogJSON
+	configMutex.RUnlock()
+
+	// Command-line flags take precedence over the configuration file
+	if *logLevelFlag != "" {
+		logLevel = *logLevelFlag
+	}
+	if logJSONFlag.set {
+		logJSON = logJSONFlag.value
+	}
+
+	// Entries below their workflow's own log_level are dropped by the formatter and output hooks
+	if logJSON {
+		log.SetFormatter(levelFilterFormatter{&log.JSONFormatter{}})
+	} else {
+		log.SetFormatter(levelFilterFormatter{&log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		}})
+	}
+	setLogLevel(parseLogLevel(logLevel))
+
+	configMutex.RLock()
+	secrets := config.secrets()
+	configMutex.RUnlock()
+	setLogSecrets(secrets)
+}
+
+// shutdownSignals receives termination requests, either from the OS or from a service manager
+var shutdownSignals = make(chan os.Signal, 2)
+
+func runService() {
+	// Stops the program from exiting prematurely
+	done := make(chan bool)
+
+	stop, err := startService()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop()
+	defer reportPanic(nil)
+
+	handleDiagnosticSignals()
+
+	drainTimeout := configuredDrainTimeout()
+
+	// Handle termination gracefully: stop taking on work and let in-flight transfers finish,
+	// unless a second signal arrives
+	signal.Notify(shutdownSignals, os.Interrupt, syscall.SIGTERM)
+	// SIGHUP reloads the configuration, starting workflows enabled since
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go handleReloadSignals(reloads)
+	go func() {
+		<-shutdownSignals
+		log.WithField("drain_timeout", drainTimeout).Info("termination signal received, finishing in-flight transfers")
+		go func() {
+			<-shutdownSignals
+			log.Warn("second termination signal received, exiting immediately")
+			os.Exit(1)
+		}()
+
+		shutdownService(drainTimeout)
+		done <- true
+	}()
+
+	<-done
+}
+
+// configuredDrainTimeout is how long shutdown waits for in-flight transfers
+func configuredDrainTimeout() time.Duration {
+	configMutex.RLock()
+	drainTimeout := config.DrainTimeout
+	configMutex.RUnlock()
+	if drainTimeout == 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	return drainTimeout
+}
+
+// shutdownService stops taking on work, waits up to drainTimeout for in-flight transfers and
+// closes the AMQP connections, returning any unacknowledged messages to their queues
+func shutdownService(drainTimeout time.Duration) {
+	if drain(drainTimeout) {
+		log.Info("in-flight transfers finished")
+	} else {
+		log.Warn("drain timeout reached, abandoning in-flight transfers")
+	}
+	inboundClose()
+}
+
+// startService sets up the configured integrations and servers and starts every enabled
+// workflow, returning a function which closes what was set up in reverse order
+func startService() (func(), error) {
+	var closers []func()
+	stop := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	fail := func(err error) (func(), error) {
+		stop()
+		return nil, err
+	}
+	// Processors start as workflows first use them, and are stopped after everything else
+	closers = append(closers, closeProcessors, closeWasmProcessors)
+
+	configMutex.RLock()
+	tracing := config.Tracing
+	eventStream := config.EventStream
+	auditConfig := config.Audit
+	notifications := config.Notifications
+	cloudWatch := config.CloudWatch
+	sentry := config.Sentry
+	configMutex.RUnlock()
+	// Set up first, so that failures setting up everything else are reported
+	if sentry.DSN != "" {
+		closeSentry, err := openSentry(sentry)
+		if err != nil {
+			return fail(fmt.Errorf("failed to set up Sentry: %w", err))
+		}
+		closers = append(closers, closeSentry)
+	}
+	if notifications.configured() {
+		closeNotifications, err := openNotifications(notifications)
+		if err != nil {
+			return fail(fmt.Errorf("failed to set up notifications: %w", err))
+		}
+		closers = append(closers, closeNotifications)
+	}
+	if auditConfig.File != "" {
+		closeAudit, err := openAuditLog(auditConfig)
+		if err != nil {
+			return fail(fmt.Errorf("failed to open audit log: %w", err))
+		}
+		closers = append(closers, closeAudit)
+	}
+	if eventStream != "" {
+		closeEvents, err := openEventStream(eventStream)
+		if err != nil {
+			return fail(fmt.Errorf("failed to open event stream: %w", err))
+		}
+		closers = append(closers, closeEvents)
+	}
+	if cloudWatch.Enabled {
+		closeCloudWatch, err := openCloudWatch(cloudWatch)
+		if err != nil {
+			return fail(fmt.Errorf("failed to set up CloudWatch metrics: %w", err))
+		}
+		closers = append(closers, closeCloudWatch)
+	}
+	if tracing.Enabled {
+		shutdown, err := setupTracing(context.Background(), tracing)
+		if err != nil {
+			return fail(fmt.Errorf("failed to set up tracing: %w", err))
+		}
+		closers = append(closers, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				log.Error("failed to flush traces: ", err)
+			}
+		})
+	}
+
+	configMutex.RLock()
+	outboundConfigs := make([]Outbound, len(config.Outbound))
+	copy(outboundConfigs, config.Outbound)
+	inboundConfigs := make([]Inbound, len(config.Inbound))
+	copy(inboundConfigs, config.Inbound)
+	replicateConfigs := make([]Replicate, len(config.Replicate))
+	copy(replicateConfigs, config.Replicate)
+	syncConfigs := make([]TwoWaySync, len(config.Sync))
+	copy(syncConfigs, config.Sync)
+	configMutex.RUnlock()
+
+	pingURLs := make(map[string]string)
+	watchingThresholds := false
+	for _, o := range outboundConfigs {
+		if o.PingURL != "" && o.IsEnabled() {
+			pingURLs[o.Name] = o.PingURL
+		}
+		watchingThresholds = watchingThresholds || (o.IsEnabled() && o.Thresholds.enabled())
+	}
+	for _, in := range inboundConfigs {
+		if in.PingURL != "" && in.IsEnabled() {
+			pingURLs[in.Name] = in.PingURL
+		}
+		watchingThresholds = watchingThresholds || (in.IsEnabled() && in.Thresholds.enabled())
+	}
+	for _, r := range replicateConfigs {
+		if r.PingURL != "" && r.IsEnabled() {
+			pingURLs[r.Name] = r.PingURL
+		}
+		watchingThresholds = watchingThresholds || (r.IsEnabled() && r.Thresholds.enabled())
+	}
+	for _, t := range syncConfigs {
+		if t.PingURL != "" && t.IsEnabled() {
+			pingURLs[t.Name] = t.PingURL
+		}
+		watchingThresholds = watchingThresholds || (t.IsEnabled() && t.Thresholds.enabled())
+	}
+	if len(pingURLs) > 0 {
+		closers = append(closers, openPingers(pingURLs))
+	}
+	if watchingThresholds {
+		stopThresholds := make(chan struct{})
+		go watchThresholds(stopThresholds)
+		closers = append(closers, func() {
+			close(stopThresholds)
+		})
+	}
+
+	configMutex.RLock()
+	leaderElection := config.LeaderElection
+	configMutex.RUnlock()
+	if leaderElection.Backend != "" {
+		stopElection, err := startLeaderElection(leaderElection)
+		if err != nil {
+			return fail(fmt.Errorf("failed to set up leader election: %w", err))
+		}
+		closers = append(closers, stopElection)
+	}
+
+	// Set up watcher for each outbound source
+	for i := 0; i < len(outboundConfigs); i++ {
+		o := outboundConfigs[i]
+		if !o.IsEnabled() {
+			log.WithFields(log.Fields{"workflow": o.Name}).Info("outbound workflow disabled, skipping")
+			continue
+		}
+		outbound(o)
+	}
+
+	// Set up watcher for each inbound source
+	for i := 0; i < len(inboundConfigs); i++ {
+		in := inboundConfigs[i]
+		if !in.IsEnabled() {
+			log.WithFields(log.Fields{"workflow": in.Name}).Info("inbound workflow disabled, skipping")
+			continue
+		}
+		inbound(in)
+	}
+
+	// Start copying between the buckets of each replicate workflow
+	for i := 0; i < len(replicateConfigs); i++ {
+		r := replicateConfigs[i]
+		if !r.IsEnabled() {
+			log.WithFields(log.Fields{"workflow": r.Name}).Info("replicate workflow disabled, skipping")
+			continue
+		}
+		replicate(r)
+	}
+
+	// Start keeping each sync workflow's folder and bucket in step
+	for i := 0; i < len(syncConfigs); i++ {
+		t := syncConfigs[i]
+		if !t.IsEnabled() {
+			log.WithFields(log.Fields{"workflow": t.Name}).Info("sync workflow disabled, skipping")
+			continue
+		}
+		twoWay(t)
+	}
+
+	configMutex.RLock()
+	statusListen := config.StatusListen
+	adminListen := config.AdminListen
+	adminToken := config.AdminToken
+	adminPprof := config.AdminPprof
+	grpcListen := config.GRPCListen
+	controlSocket := config.ControlSocket
+	configMutex.RUnlock()
+	if statusListen != "" {
+		srv, err := startStatusServer(statusListen)
+		if err != nil {
+			return fail(fmt.Errorf("failed to start status server: %w", err))
+		}
+		closers = append(closers, func() {
+			_ = srv.Close()
+		})
+	}
+	if adminListen != "" {
+		srv, err := startAdminServer(adminListen, adminToken, adminPprof)
+		if err != nil {
+			return fail(fmt.Errorf("failed to start admin API: %w", err))
+		}
+		closers = append(closers, func() {
+			_ = srv.Close()
+		})
+	}
+	if grpcListen != "" {
+		srv, err := startGRPCServer(grpcListen, adminToken)
+		if err != nil {
+			return fail(fmt.Errorf("failed to start gRPC control API: %w", err))
+		}
+		closers = append(closers, srv.Stop)
+	}
+	if controlSocket != "" {
+		srv, err := startAdminSocket(controlSocket, adminPprof)
+		if err != nil {
+			return fail(fmt.Errorf("failed to start control socket: %w", err))
+		}
+		closers = append(closers, func() {
+			_ = srv.Close()
+		})
+	}
+	return stop, nil
+}
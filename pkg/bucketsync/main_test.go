@@ -0,0 +1,119 @@
+package bucketsync
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected bool
+	}{
+		{"version flag", []string{"-version"}, false},
+		{"valid config", []string{"-c", "/tmp/config.yaml"}, true},
+		{"default config", []string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reset flags
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			configFilePath = flag.String("c", "/etc/bucketsyncd/config.yaml", "Configuration file location")
+			showVersion = flag.Bool("version", false, "Show version information")
+			help = flag.Bool("h", false, "Usage information")
+
+			// Set args
+			os.Args = append([]string{"bucketsyncd"}, tt.args...)
+
+			result := parseCommandLine()
+			if result != tt.expected {
+				t.Errorf("parseCommandLine() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVersionString(t *testing.T) {
+	originalVersion, originalCommit, originalTime := version, gitCommit, buildTime
+	defer func() { version, gitCommit, buildTime = originalVersion, originalCommit, originalTime }()
+
+	version, gitCommit, buildTime = "v1.2.3", "abc1234", "2026-01-02T03:04:05Z"
+	s := versionString()
+	for _, want := range []string{"bucketsyncd v1.2.3", "commit abc1234", "built 2026-01-02T03:04:05Z", runtime.Version()} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in %q", want, s)
+		}
+	}
+}
+
+func TestOptionalBoolFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	b := optionalBoolFlag(fs, "json", "")
+	if b.set {
+		t.Error("expected flag to be unset before parsing")
+	}
+
+	if err := fs.Parse([]string{"-json=false"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !b.set || b.value {
+		t.Errorf("expected explicit false, got %+v", *b)
+	}
+
+	if err := fs.Parse([]string{"-json"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !b.set || !b.value {
+		t.Errorf("expected true, got %+v", *b)
+	}
+}
+
+func TestConfigureLoggingOverrides(t *testing.T) {
+	originalConfig := config
+	originalLevel := *logLevelFlag
+	originalJSON := *logJSONFlag
+	originalLogLevel := getLogLevel()
+	originalFormatter := log.StandardLogger().Formatter
+	defer func() {
+		config = originalConfig
+		*logLevelFlag = originalLevel
+		*logJSONFlag = originalJSON
+		setLogLevel(originalLogLevel)
+		log.SetFormatter(originalFormatter)
+	}()
+
+	config = Config{LogLevel: infoLevel, LogJSON: true}
+	*logLevelFlag = debugLevel
+	*logJSONFlag = optionalBool{value: false, set: true}
+
+	configureLogging()
+
+	if log.GetLevel() != log.DebugLevel {
+		t.Errorf("expected debug level from flag, got %s", log.GetLevel())
+	}
+	f, ok := log.StandardLogger().Formatter.(levelFilterFormatter)
+	if !ok {
+		t.Fatalf("expected the level filter formatter, got %T", log.StandardLogger().Formatter)
+	}
+	if _, ok := f.Formatter.(*log.TextFormatter); !ok {
+		t.Errorf("expected text formatter from flag, got %T", f.Formatter)
+	}
+}
+
+func TestValidLogLevel(t *testing.T) {
+	for _, level := range []string{"", debugLevel, infoLevel, warnLevel, errorLevel} {
+		if !validLogLevel(level) {
+			t.Errorf("expected %q to be valid", level)
+		}
+	}
+	if validLogLevel("verbose") {
+		t.Error("expected 'verbose' to be invalid")
+	}
+}
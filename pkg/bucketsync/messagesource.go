@@ -0,0 +1,132 @@
+package bucketsync
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// MessageSource delivers the object notifications which an inbound workflow downloads. Each
+// transport implements it, so that parsing, filtering, downloading and retries are shared.
+type MessageSource interface {
+	// Subscribe connects and delivers messages until the connection is lost or ctx is done, when
+	// the channel is closed
+	Subscribe(ctx context.Context) (<-chan Message, error)
+	// Ack confirms that a message has been handled
+	Ack(m Message) error
+	// Nack gives up on a message, returning it to be delivered again if requeue is set
+	Nack(m Message, requeue bool) error
+	Close() error
+}
+
+// Message is a notification taken from a source, to be acknowledged once handled
+type Message struct {
+	Body []byte
+	// Headers carry trace context from the publisher, if the transport has headers
+	Headers propagation.TextMapCarrier
+	// handle identifies the message to its source
+	handle any
+}
+
+// reconnectDelay is how long to wait before subscribing again after losing a connection
+var reconnectDelay = 5 * time.Second
+
+// consumeMessages subscribes to src and passes its messages to handle one at a time until ctx is
+// done, subscribing again whenever the connection is lost. Messages wait while the workflow is
+// paused and while breaker's circuit is open.
+func consumeMessages(ctx context.Context, lf log.Fields, state *workflowState, src MessageSource, breaker *circuitBreaker, handle func(context.Context, Message)) {
+	defer func() {
+		if err := src.Close(); err != nil {
+			log.WithFields(lf).Error("failed to close message source: ", err)
+		}
+	}()
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			log.WithFields(lf).Info("inbound cancelled")
+			return
+		}
+
+		messages, err := src.Subscribe(ctx)
+		if err != nil {
+			// Exponential backoff capped at 5 minutes, avoiding int→uint overflow
+			backoffSeconds := 1
+			for i := 0; i < attempt && backoffSeconds < 300; i++ {
+				backoffSeconds *= 2
+			}
+			if backoffSeconds > 300 {
+				backoffSeconds = 300
+			}
+			log.WithFields(lf).WithFields(log.Fields{
+				"attempt": attempt + 1,
+				"backoff": backoffSeconds,
+				"error":   err,
+			}).Error("failed to subscribe to message source, retrying")
+			state.setHealth(healthDegraded)
+			if !sleepContext(ctx, time.Duration(backoffSeconds)*time.Second) {
+				log.WithFields(lf).Info("inbound cancelled")
+				return
+			}
+			continue
+		}
+		attempt = 0
+		log.WithFields(lf).Info("consumer started, processing messages")
+		state.setHealth(healthConnected)
+
+		if !handleMessages(ctx, lf, state, src, messages, breaker, handle) {
+			return
+		}
+		state.setHealth(healthConnecting)
+		log.WithFields(lf).Infof("subscribing again in %s", reconnectDelay)
+		if !sleepContext(ctx, reconnectDelay) {
+			log.WithFields(lf).Info("inbound stopped, no longer taking messages")
+			return
+		}
+	}
+}
+
+// handleMessages handles messages until the subscription ends, reporting false if the workflow
+// should stop rather than subscribe again
+func handleMessages(ctx context.Context, lf log.Fields, state *workflowState, src MessageSource, messages <-chan Message, breaker *circuitBreaker, handle func(context.Context, Message)) bool {
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				log.WithFields(lf).Warn("message source disconnected")
+				return ctx.Err() == nil
+			}
+
+			// Hold further messages unacknowledged while the workflow is paused, and while its
+			// remote's circuit is open, returning them to the queue on shutdown
+			err := state.waitWhilePaused(ctx)
+			if err == nil {
+				err = breaker.wait(ctx)
+			}
+			if err != nil {
+				if nackErr := src.Nack(m, true); nackErr != nil {
+					log.WithFields(lf).Error("failed to nack message: ", nackErr)
+				}
+				return false
+			}
+			// A message already taken is seen through, even once shutdown begins
+			handle(context.WithoutCancel(ctx), m)
+
+		case <-ctx.Done():
+			log.WithFields(lf).Info("inbound stopped, no longer taking messages")
+			return false
+		}
+	}
+}
+
+// sleepContext waits for d, reporting false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
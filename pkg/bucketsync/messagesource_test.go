@@ -0,0 +1,105 @@
+package bucketsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeSource hands out the subscriptions queued on it and records what is acknowledged
+type fakeSource struct {
+	subscriptions chan chan Message
+
+	mu       sync.Mutex
+	acked    int
+	requeued int
+	dropped  int
+	closed   bool
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{subscriptions: make(chan chan Message, 4)}
+}
+
+func (s *fakeSource) Subscribe(ctx context.Context) (<-chan Message, error) {
+	select {
+	case messages := <-s.subscriptions:
+		return messages, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *fakeSource) Ack(Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked++
+	return nil
+}
+
+func (s *fakeSource) Nack(_ Message, requeue bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if requeue {
+		s.requeued++
+	} else {
+		s.dropped++
+	}
+	return nil
+}
+
+func (s *fakeSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestConsumeMessagesResubscribes(t *testing.T) {
+	originalDelay := reconnectDelay
+	defer func() {
+		reconnectDelay = originalDelay
+	}()
+	reconnectDelay = time.Millisecond
+
+	src := newFakeSource()
+	first, second := make(chan Message), make(chan Message)
+	src.subscriptions <- first
+	src.subscriptions <- second
+
+	in := Inbound{Name: "fake-source", Queue: "queue"}
+	state := registerWorkflow(in.Name, workflowInbound)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumeMessages(ctx, log.Fields{}, state, src, nil, func(ctx context.Context, m Message) {
+			handleMessage(ctx, log.Fields{}, in, src, m)
+		})
+		close(done)
+	}()
+
+	// An unparseable message is returned to the queue, then the connection is lost
+	first <- Message{Body: []byte("not json")}
+	close(first)
+	// The source is subscribed to again
+	second <- Message{Body: []byte(`{"Records": []}`)}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumeMessages did not stop once cancelled")
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.requeued != 1 || src.dropped != 0 {
+		t.Errorf("requeued %d and dropped %d messages, want 1 requeued", src.requeued, src.dropped)
+	}
+	if !src.closed {
+		t.Error("expected the source to be closed")
+	}
+}
@@ -0,0 +1,46 @@
+package bucketsync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	transferDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bucketsyncd",
+		Name:      "transfer_duration_seconds",
+		Help:      "Time taken by successful uploads and downloads.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"workflow", "remote", "direction"})
+
+	transferSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bucketsyncd",
+		Name:      "transfer_size_bytes",
+		Help:      "Size of successfully uploaded and downloaded objects.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"workflow", "remote", "direction"})
+)
+
+// metricsRegistry holds the metrics served on the status endpoint's /metrics path
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(transferDuration, transferSize)
+}
+
+// observeTransfer records a successful transfer's duration and size, unless its workflow has
+// turned histograms off
+func observeTransfer(rec TransferRecord) {
+	if rec.Status != transferSuccess || !getWorkflowSampling(rec.Workflow).RecordsHistograms() {
+		return
+	}
+	labels := prometheus.Labels{
+		"workflow":  rec.Workflow,
+		"remote":    rec.Remote,
+		"direction": rec.Direction,
+	}
+	transferDuration.With(labels).Observe(rec.Duration.Seconds())
+	transferSize.With(labels).Observe(float64(rec.Size))
+	if rate := transferRate(rec.Size, rec.Duration); rate > 0 {
+		transferThroughput.With(labels).Observe(rate)
+	}
+}
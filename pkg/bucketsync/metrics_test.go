@@ -0,0 +1,44 @@
+package bucketsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveTransfer(t *testing.T) {
+	transferDuration.Reset()
+	transferSize.Reset()
+
+	observeTransfer(TransferRecord{
+		Workflow:  "docs",
+		Remote:    "minio.example.com",
+		Direction: directionUpload,
+		Size:      2048,
+		Duration:  250 * time.Millisecond,
+		Status:    transferSuccess,
+	})
+	observeTransfer(TransferRecord{
+		Workflow:  "docs",
+		Remote:    "minio.example.com",
+		Direction: directionUpload,
+		Status:    transferFailed,
+	})
+
+	if n := testutil.CollectAndCount(transferDuration); n != 1 {
+		t.Errorf("expected 1 duration series, got %d", n)
+	}
+	if n := testutil.CollectAndCount(transferSize, "bucketsyncd_transfer_size_bytes"); n != 1 {
+		t.Errorf("expected 1 size series, got %d", n)
+	}
+
+	// Failed transfers are not observed
+	count, err := testutil.GatherAndCount(metricsRegistry, "bucketsyncd_transfer_duration_seconds")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 gathered series, got %d", count)
+	}
+}
@@ -0,0 +1,114 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// S3's limits on the parts of a multipart upload, and on an object put in a single request
+const (
+	minPartSize      = 5 << 20
+	maxPartSize      = 5 << 30
+	maxSinglePutSize = 5 << 30
+)
+
+// multipartUpload is how an upload to S3 is split into parts: not at all if disabled, or else
+// into parts of partSize, of which threads are uploaded at once. Zero values leave the choice to
+// minio-go, which uploads one part at a time, sized for the file.
+type multipartUpload struct {
+	disabled bool
+	partSize uint64
+	threads  uint
+}
+
+// validateMultipart checks the workflow's part_size, multipart_threshold and concurrent_parts
+func (o Outbound) validateMultipart() error {
+	if o.PartSize != "" {
+		size, err := parseByteSize(o.PartSize)
+		if err != nil {
+			return fmt.Errorf("part_size: %w", err)
+		}
+		if size < minPartSize || size > maxPartSize {
+			return fmt.Errorf("part_size: must be between %s and %s", formatByteSize(minPartSize), formatByteSize(maxPartSize))
+		}
+	}
+	if o.MultipartThreshold != "" {
+		size, err := parseByteSize(o.MultipartThreshold)
+		if err != nil {
+			return fmt.Errorf("multipart_threshold: %w", err)
+		}
+		if size > maxSinglePutSize {
+			return fmt.Errorf("multipart_threshold: must be at most %s", formatByteSize(maxSinglePutSize))
+		}
+	}
+	if o.ConcurrentParts < 0 {
+		return errors.New("concurrent_parts: must not be negative")
+	}
+	return nil
+}
+
+// tunesMultipart reports whether any of the workflow's multipart options are set
+func (o Outbound) tunesMultipart() bool {
+	return o.PartSize != "" || o.MultipartThreshold != "" || o.ConcurrentParts != 0
+}
+
+// multipartUpload resolves the workflow's multipart options for a file of size bytes. Files up to
+// the threshold are put in a single request. Larger ones use the part size, grown if need be to
+// stay within 10,000 parts, or with parts uploaded at once, the part size minio-go would choose.
+func (o Outbound) multipartUpload(size int64) multipartUpload {
+	if !o.tunesMultipart() {
+		return multipartUpload{}
+	}
+	threshold := int64(multipartThreshold)
+	if o.MultipartThreshold != "" {
+		// readConfig and NewService refuse a configuration failing Validate
+		threshold, _ = parseByteSize(o.MultipartThreshold)
+	}
+	if size <= threshold {
+		return multipartUpload{disabled: true}
+	}
+	var configured int64
+	if o.PartSize != "" {
+		configured, _ = parseByteSize(o.PartSize)
+	}
+	p := multipartUpload{partSize: uint64(configured)} // #nosec G115 - validated as positive
+	if o.ConcurrentParts > 1 {
+		p.threads = uint(o.ConcurrentParts)
+	}
+	if configured != 0 || p.threads > 0 {
+		// Parts uploaded at once are buffered, so need a size fitted to the file
+		_, partSize, _, err := minio.OptimalPartInfo(size, uint64(configured)) // #nosec G115
+		if err != nil {
+			_, partSize, _, _ = minio.OptimalPartInfo(size, 0)
+		}
+		p.partSize = uint64(partSize) // #nosec G115 - part sizes are positive
+	}
+	return p
+}
+
+// memory is the buffer space the upload of a file of size bytes needs: a part for each of those
+// uploaded at once, or a copy buffer's worth for a file put in a single request
+func (p multipartUpload) memory(size int64) int64 {
+	switch {
+	case p.disabled:
+		return transferBufferSize
+	case p.partSize == 0:
+		return uploadMemory(size)
+	}
+	return int64(p.partSize) * int64(max(p.threads, 1)) // #nosec G115 - bounded by maxPartSize
+}
+
+// options sets the multipart upload's part size and concurrency in opts
+func (p multipartUpload) options(opts minio.PutObjectOptions) minio.PutObjectOptions {
+	opts.DisableMultipart = p.disabled
+	opts.PartSize = p.partSize
+	if p.threads > 1 {
+		// The file is read through a reader without ReadAt, so parts can only be uploaded at
+		// once by buffering each
+		opts.NumThreads = p.threads
+		opts.ConcurrentStreamParts = true
+	}
+	return opts
+}
@@ -0,0 +1,101 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	if p := (Outbound{}).multipartUpload(1 << 30); p != (multipartUpload{}) {
+		t.Errorf("untuned upload = %+v, want minio-go's choice", p)
+	}
+	o := Outbound{PartSize: "8MiB", MultipartThreshold: "64MiB", ConcurrentParts: 4}
+	if p := o.multipartUpload(64 << 20); !p.disabled || p.memory(64<<20) != transferBufferSize {
+		t.Errorf("upload at the threshold = %+v, want a single request", p)
+	}
+	p := o.multipartUpload(1 << 30)
+	if p.disabled || p.partSize != 8<<20 || p.threads != 4 {
+		t.Errorf("upload of 1GiB = %+v, want 4 parts of 8MiB at once", p)
+	}
+	if got := p.memory(1 << 30); got != 32<<20 {
+		t.Errorf("memory = %d, want 4 parts", got)
+	}
+	// Parts are grown to keep within 10,000 of them, and sized for the file if not given
+	if p := o.multipartUpload(500 << 30); p.partSize <= 8<<20 {
+		t.Errorf("upload of 500GiB in parts of %d, want larger ones", p.partSize)
+	}
+	if p := (Outbound{ConcurrentParts: 2}).multipartUpload(100 << 20); p.partSize != multipartThreshold {
+		t.Errorf("upload of 100MiB in parts of %d, want 16MiB", p.partSize)
+	}
+}
+
+// fakeMultipartS3 accepts S3 uploads, recording the size of each object put and part uploaded
+type fakeMultipartS3 struct {
+	mutex sync.Mutex
+	puts  []int
+	parts []int
+}
+
+func (s *fakeMultipartS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		_, _ = io.WriteString(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>disk.img</Key><UploadId>upload1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPost:
+		_, _ = io.WriteString(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>disk.img</Key><ETag>"done-3"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodPut && q.Has("partNumber"):
+		s.parts = append(s.parts, len(body))
+		w.Header().Set("ETag", fmt.Sprintf(`"part%s"`, q.Get("partNumber")))
+	case r.Method == http.MethodPut:
+		s.puts = append(s.puts, len(body))
+		w.Header().Set("ETag", `"whole"`)
+	}
+}
+
+func TestMultipartSent(t *testing.T) {
+	fake := &fakeMultipartS3{}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+	mc, err := minio.New(strings.TrimPrefix(srv.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("key", "secret", ""),
+		Secure:    true,
+		Transport: srv.Client().Transport,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := Outbound{PartSize: "5MiB", MultipartThreshold: "8MiB", ConcurrentParts: 2}
+	for _, size := range []int{6 << 20, 12 << 20} {
+		store := newMinioStore(mc, "bucket", 0).withMultipart(o.multipartUpload(int64(size)))
+		// A reader without ReadAt, as uploads read through the throttle and checksum
+		r := io.MultiReader(bytes.NewReader(make([]byte, size)))
+		if err := store.Put(context.Background(), "disk.img", r, int64(size), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.puts) != 1 || fake.puts[0] != 6<<20 {
+		t.Errorf("put %v, want the file under the threshold in one request", fake.puts)
+	}
+	total := 0
+	for _, n := range fake.parts {
+		total += n
+	}
+	if len(fake.parts) != 3 || total != 12<<20 {
+		t.Errorf("uploaded parts of %v, want 3 parts of the 12MiB file", fake.parts)
+	}
+}
@@ -0,0 +1,250 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectStore is a storage backend which workflows and commands transfer objects to and from by
+// key. Each backend implements it, so that the watcher and consumer logic need not know which
+// one they are talking to.
+type ObjectStore interface {
+	// Put stores size bytes read from r at key, with metadata where the backend supports it
+	Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error
+	// Get opens the object at key, along with its metadata
+	Get(ctx context.Context, key string) (io.ReadCloser, StoredObject, error)
+	Stat(ctx context.Context, key string) (StoredObject, error)
+	Remove(ctx context.Context, key string) error
+	// List returns the objects under prefix, or with recursive unset, those directly under it and
+	// its subdirectories as prefixes
+	List(ctx context.Context, prefix string, recursive bool) ([]StoredObject, error)
+	// Presign returns a URL from which the object can be downloaded without credentials until
+	// expiry
+	Presign(ctx context.Context, key string, expiry time.Duration) (*url.URL, error)
+}
+
+// StoredObject describes an object in a store
+type StoredObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	// IsPrefix marks a subdirectory in a listing which is not recursive
+	IsPrefix bool
+}
+
+var (
+	// errObjectNotFound is returned, possibly wrapped, for a key with no object
+	errObjectNotFound     = errors.New("object not found")
+	errPresignUnsupported = errors.New("presigned URLs are not supported by this backend")
+)
+
+// storeTarget is where a workflow transfers its objects: the store, the prefix of its keys and
+// the remote whose limits, retries, timeouts and circuit breaker apply
+type storeTarget struct {
+	store  ObjectStore
+	kind   string
+	prefix string
+	remote Remote
+	// bucket is the S3 bucket, for logs and transfer records
+	bucket string
+}
+
+// outboundTarget resolves an outbound workflow's destination URL to its store
+func outboundTarget(o Outbound, u *url.URL) (storeTarget, error) {
+	if isWebDAVScheme(u.Scheme) {
+		// A remote for the server is optional, supplying the client's options as well as limits
+		remote, ok := outboundRemote(o, u.Host)
+		if !ok {
+			remote = Remote{Endpoint: u.Host}
+		}
+		store, err := newWebDAVStore(u, remote)
+		if err != nil {
+			return storeTarget{}, err
+		}
+		return storeTarget{
+			store:  store,
+			kind:   "WebDAV",
+			prefix: strings.TrimSuffix(u.Path, "/"),
+			remote: remote,
+		}, nil
+	}
+	if isIPFSScheme(u.Scheme) {
+		// As with WebDAV, a remote for the node is optional
+		remote, ok := outboundRemote(o, u.Host)
+		if !ok {
+			remote = Remote{Endpoint: u.Host}
+		}
+		store, err := newIPFSStore(u, remote)
+		if err != nil {
+			return storeTarget{}, err
+		}
+		return storeTarget{
+			store:  store,
+			kind:   "IPFS",
+			remote: remote,
+		}, nil
+	}
+	endpoint, bucket, prefix, err := parseS3Destination(u)
+	if err != nil {
+		return storeTarget{}, err
+	}
+	remote, ok := outboundRemote(o, endpoint)
+	if !ok {
+		return storeTarget{}, fmt.Errorf("no S3 credentials found for endpoint: %s", endpoint)
+	}
+	mc, err := remoteClient(remote)
+	if err != nil {
+		return storeTarget{}, err
+	}
+	return storeTarget{
+		store:  newMinioStore(mc, bucket, remoteTimeouts(remote).Stat),
+		kind:   "S3",
+		prefix: prefix,
+		remote: remote,
+		bucket: bucket,
+	}, nil
+}
+
+// contentID returns the CID of the file last put to an IPFS destination, or nothing for other
+// stores, whose objects are addressed by key
+func (t storeTarget) contentID() string {
+	if s, ok := t.store.(*ipfsStore); ok {
+		return s.cid
+	}
+	return ""
+}
+
+// bucketStore opens a bucket on a remote with a client of its own, for the subcommands
+func bucketStore(remote Remote, bucket string) (*minioStore, error) {
+	mc, err := newMinioClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	return newMinioStore(mc, bucket, remoteTimeouts(remote).Stat), nil
+}
+
+// minioStore is a bucket on an S3-compatible remote
+type minioStore struct {
+	client *minio.Client
+	bucket string
+	// statTimeout bounds fetching an object's metadata when it is opened, if set
+	statTimeout time.Duration
+	// tags are given to the objects put, if any
+	tags map[string]string
+	// multipart splits the objects put into parts, if set
+	multipart multipartUpload
+}
+
+func newMinioStore(mc *minio.Client, bucket string, statTimeout time.Duration) *minioStore {
+	return &minioStore{client: mc, bucket: bucket, statTimeout: statTimeout}
+}
+
+// minioError translates a missing object to errObjectNotFound
+func minioError(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchObject":
+		return fmt.Errorf("%w: %w", errObjectNotFound, err)
+	}
+	return err
+}
+
+func (s *minioStore) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error {
+	opts := s.multipart.options(minio.PutObjectOptions{UserMetadata: metadata, UserTags: s.tags})
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, opts)
+	return err
+}
+
+// withMultipart returns a copy of the store which splits the objects it puts into parts as given
+func (s *minioStore) withMultipart(p multipartUpload) *minioStore {
+	split := *s
+	split.multipart = p
+	return &split
+}
+
+// withTags returns a copy of the store which tags the objects it puts
+func (s *minioStore) withTags(tags map[string]string) *minioStore {
+	tagged := *s
+	tagged.tags = tags
+	return &tagged
+}
+
+func (s *minioStore) Get(ctx context.Context, key string) (io.ReadCloser, StoredObject, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		cancel()
+		return nil, StoredObject{}, minioError(err)
+	}
+	var info minio.ObjectInfo
+	if s.statTimeout > 0 {
+		info, err = statWithin(obj, s.statTimeout, cancel)
+	} else {
+		info, err = obj.Stat()
+	}
+	if err != nil {
+		_ = obj.Close()
+		cancel()
+		return nil, StoredObject{}, minioError(err)
+	}
+	return &minioObject{Object: obj, cancel: cancel}, minioObjectInfo(info), nil
+}
+
+// minioObject releases its request's context once closed
+type minioObject struct {
+	*minio.Object
+	cancel context.CancelFunc
+}
+
+func (o *minioObject) Close() error {
+	defer o.cancel()
+	return o.Object.Close()
+}
+
+func (s *minioStore) Stat(ctx context.Context, key string) (StoredObject, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return StoredObject{}, minioError(err)
+	}
+	return minioObjectInfo(info), nil
+}
+
+func (s *minioStore) Remove(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *minioStore) List(ctx context.Context, prefix string, recursive bool) ([]StoredObject, error) {
+	infos := []StoredObject{}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: recursive,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		info := minioObjectInfo(obj)
+		info.IsPrefix = strings.HasSuffix(obj.Key, "/") && obj.ETag == ""
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *minioStore) Presign(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	return s.client.PresignedGetObject(ctx, s.bucket, key, expiry, url.Values{})
+}
+
+func minioObjectInfo(info minio.ObjectInfo) StoredObject {
+	return StoredObject{
+		Key:          info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         strings.Trim(info.ETag, `"`),
+	}
+}
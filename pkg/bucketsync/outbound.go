@@ -0,0 +1,917 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"os"
+	"path/filepath"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// outboundWorkflow owns a running outbound workflow's watcher and the goroutines handling its
+// events and schedule, so that each workflow can be stopped on its own
+type outboundWorkflow struct {
+	o  Outbound
+	lf log.Fields
+
+	// watcher is nil when the workflow only uploads on its schedule
+	watcher *fsnotify.Watcher
+	// uploads uploads the files the watcher reports, scans and scheduled runs find, and those
+	// held while a remote was down. It is nil for workflows streaming a FIFO or command.
+	uploads *uploadPool
+	// cancel stops the scheduled uploads
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	// done is closed once the event loop and scheduled uploads have returned
+	done chan struct{}
+}
+
+var (
+	outboundWorkflowsMutex sync.Mutex
+	// outboundWorkflows holds the running outbound workflows by name
+	outboundWorkflows = make(map[string]*outboundWorkflow)
+)
+
+// outbound starts watching an outbound workflow's source folder, logging any failure
+func outbound(o Outbound) {
+	if _, err := startOutbound(o); err != nil {
+		log.WithFields(workflowLogFields(o.Name, o.Labels, nil)).Error(err)
+	}
+}
+
+// startOutbound starts an outbound workflow and registers it, stopping any already running
+// under the same name
+func startOutbound(o Outbound) (*outboundWorkflow, error) {
+	setWorkflowLogLevel(o.Name, o.LogLevel)
+	setWorkflowSampling(o.Name, o.Sampling)
+	lf := workflowLogFields(o.Name, o.Labels, nil)
+	log.WithFields(lf).Info("configuring watcher for '", o.Description, "'")
+
+	// Set up file locking before watching, so that creating the lock directory is not seen as a new file
+	if _, err := outboundLocker(o); err != nil {
+		return nil, fmt.Errorf("failed to set up file locking: %w", err)
+	}
+
+	var schedule *cronSchedule
+	if o.Schedule != "" {
+		var err error
+		if schedule, err = parseSchedule(o.Schedule); err != nil {
+			return nil, err
+		}
+	}
+	// A workflow reading a FIFO or running a command streams its output, rather than watching a
+	// folder
+	streamed := o.Fifo != "" || len(o.Command) > 0
+	var key *template.Template
+	if streamed {
+		var err error
+		if key, err = parseStreamKey(o.Key); err != nil {
+			return nil, fmt.Errorf("invalid key template: %w", err)
+		}
+	}
+	if o.Fifo != "" {
+		if err := prepareFifo(o.Fifo); err != nil {
+			return nil, err
+		}
+	}
+	var watcher *fsnotify.Watcher
+	if !streamed && o.IsWatched() {
+		var err error
+		if watcher, err = fsnotify.NewWatcher(); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(serviceCtx)
+	w := &outboundWorkflow{o: o, lf: lf, watcher: watcher, cancel: cancel, done: make(chan struct{})}
+	if !streamed {
+		w.uploads = newUploadPool(o.MaxConcurrentUploads, func(name string) {
+			// Failures are logged by uploadEvent as they occur
+			_ = uploadEvent(lf, o, name)
+		})
+	}
+
+	state := registerWorkflow(o.Name, workflowOutbound)
+	state.setThresholds(o.Thresholds)
+	// Runs of a command are one at a time, whether scheduled or asked for through the admin API
+	var running sync.Mutex
+	scheduled := func(context.Context) error {
+		return w.uploadScheduled(state)
+	}
+	switch {
+	case len(o.Command) > 0:
+		scheduled = func(ctx context.Context) error {
+			running.Lock()
+			defer running.Unlock()
+			return uploadCommandOutput(ctx, lf, o, key)
+		}
+		state.scan = func() (int, error) {
+			if !running.TryLock() {
+				return 0, errCommandRunning
+			}
+			go func() {
+				defer running.Unlock()
+				// Failures are logged by uploadStream as they occur
+				_ = uploadCommandOutput(ctx, lf, o, key)
+			}()
+			return 1, nil
+		}
+	case o.Fifo == "":
+		state.scan = w.scan
+		state.upload = w.uploads.add
+	}
+
+	// Extract folder to watch, and file glob to filter on
+	localFolder := filepath.Dir(o.Source)
+	fileGlob := filepath.Base(o.Source)
+	switch {
+	case o.Fifo != "":
+		state.setLocation(o.Fifo)
+	case len(o.Command) > 0:
+		state.setLocation(o.Command[0])
+	default:
+		state.setLocation(localFolder)
+	}
+	log.WithFields(lf).WithFields(log.Fields{
+		"folder":   localFolder,
+		"fileglob": fileGlob,
+	}).Debug("")
+
+	var loops sync.WaitGroup
+	if w.uploads != nil {
+		w.uploads.run(ctx, &loops)
+	}
+	if watcher != nil {
+		loops.Go(func() {
+			w.run(state, fileGlob)
+		})
+	}
+	if o.Fifo != "" {
+		loops.Go(func() {
+			streamFifo(ctx, lf, o, key, state)
+		})
+	}
+	if schedule != nil {
+		loops.Go(func() {
+			runSchedule(ctx, lf, state, schedule, scheduled)
+		})
+	}
+	if o.ChecksumScan > 0 && !streamed {
+		scanner := newChecksumScanner(lf, o)
+		loops.Go(func() {
+			scanner.run(ctx, state)
+		})
+	}
+	go func() {
+		loops.Wait()
+		close(w.done)
+	}()
+
+	// Start watching folder
+	if watcher != nil {
+		if err := watcher.Add(localFolder); err != nil {
+			state.setHealth(healthDegraded)
+			w.stop()
+			return nil, fmt.Errorf("failed to start watching folder: %w", err)
+		}
+	}
+	state.setHealth(healthWatching)
+
+	outboundWorkflowsMutex.Lock()
+	previous := outboundWorkflows[o.Name]
+	outboundWorkflows[o.Name] = w
+	outboundWorkflowsMutex.Unlock()
+	if previous != nil {
+		previous.stop()
+	}
+	return w, nil
+}
+
+// run handles the watcher's events until it is closed
+// nolint:gocognit // This function handles the main file watching and upload logic
+func (w *outboundWorkflow) run(state *workflowState, fileGlob string) {
+	o, lf := w.o, w.lf
+	// With a rename window or settle period, uploads wait for files to be left alone, and follow
+	// them when renamed
+	var pending *pendingUploads
+	var settled <-chan string
+	if window := o.holdBack(); window > 0 {
+		pending = newPendingUploads(window)
+		settled = pending.settled
+		defer pending.stop()
+	}
+	// seen holds the modification times of the files events were last acted on for, telling the
+	// Chmod events of files written without Write events from those merely re-permissioned
+	seen := make(map[string]time.Time)
+	started := time.Now()
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+
+			log.WithFields(lf).Info(fmt.Sprintf("Event received: name=%s op=%d", event.Name, event.Op))
+
+			op := event.Op
+			var info os.FileInfo
+			if op.Has(fsnotify.Rename) || op.Has(fsnotify.Chmod) {
+				if fi, err := os.Stat(event.Name); err == nil && fi.Mode().IsRegular() {
+					info = fi
+				}
+			}
+			if op.Has(fsnotify.Rename) && info != nil {
+				// Some platforms report a file renamed within the folder, as producers writing
+				// a temporary file rename it into place, by its new name alone
+				op |= fsnotify.Create
+			}
+
+			// Ignore events other than writes, creations and changes of attributes
+			if op&(fsnotify.Write|fsnotify.Create) == 0 && (info == nil || !op.Has(fsnotify.Chmod)) {
+				if pending != nil {
+					// A file renamed away or removed is uploaded under its new name, if at all
+					pending.forget(event.Name)
+				}
+				delete(seen, event.Name)
+				log.WithFields(lf).Info(fmt.Sprintf("Ignoring event: name=%s op=%d", event.Name, event.Op))
+				continue
+			}
+
+			// Does filename match the fileglob?
+			filename := filepath.Base(event.Name)
+			if !glob.Glob(fileGlob, filename) {
+				log.WithFields(lf).WithFields(log.Fields{
+					"name": event.Name,
+					"op":   event.Op,
+				}).Debug("Ignoring write event due to glob mismatch")
+				continue
+			}
+
+			// Skip ignored files
+			if isIgnored(o.IgnorePatterns, filename) {
+				log.WithFields(lf).WithFields(log.Fields{
+					"name": event.Name,
+					"op":   event.Op,
+				}).Debug("Ignoring file due to ignore pattern")
+				continue
+			}
+
+			if info == nil {
+				if fi, err := os.Stat(event.Name); err == nil {
+					info = fi
+				}
+			}
+			if op&(fsnotify.Write|fsnotify.Create) == 0 {
+				// Producers which set a file's mode once done writing it, or write it without
+				// Write events, leave a Chmod event with the file modified since last seen
+				last, ok := seen[event.Name]
+				if info.ModTime().Equal(last) || (!ok && info.ModTime().Before(started)) {
+					log.WithFields(lf).WithField("name", event.Name).Debug("Ignoring attribute change of unmodified file")
+					continue
+				}
+			}
+			if info != nil {
+				seen[event.Name] = info.ModTime()
+			}
+
+			if pending != nil {
+				if renamedFrom, ok := pending.touch(event.Name); renamedFrom != "" {
+					log.WithFields(lf).WithFields(log.Fields{
+						"name": event.Name,
+						"from": renamedFrom,
+					}).Debug("File renamed before upload, uploading under its new name")
+				} else if !ok {
+					log.WithFields(lf).WithField("name", event.Name).Debug("File gone before upload")
+				}
+				continue
+			}
+			w.uploadWatched(state, event.Name)
+
+		case name := <-settled:
+			if pending.settle(name) {
+				w.uploadWatched(state, name)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.WithFields(lf).Println("error:", err)
+			state.setHealth(healthDegraded)
+		}
+	}
+}
+
+// uploadWatched queues a file the watcher reported for upload, unless the instance is not the
+// leader or the workflow is paused
+func (w *outboundWorkflow) uploadWatched(state *workflowState, name string) {
+	// Only the leader uploads, so that replicas don't upload the same files
+	if !isLeader() {
+		log.WithFields(w.lf).WithFields(log.Fields{
+			"name": name,
+		}).Debug("Ignoring file while not the leader")
+		return
+	}
+
+	if state.hold(name) {
+		log.WithFields(w.lf).WithFields(log.Fields{
+			"name": name,
+		}).Debug("Holding file until workflow is resumed")
+		return
+	}
+
+	w.uploads.add(name)
+}
+
+// close stops the workflow watching for files and running its schedule, leaving any upload in
+// progress to finish and dropping those queued
+func (w *outboundWorkflow) close() {
+	w.closeOnce.Do(func() {
+		w.cancel()
+		if w.watcher == nil {
+			return
+		}
+		if err := w.watcher.Close(); err != nil {
+			log.WithFields(w.lf).Error("failed to close watcher: ", err)
+		}
+	})
+}
+
+// stop closes the workflow's watcher and waits for its event loop and schedule to return
+func (w *outboundWorkflow) stop() {
+	w.close()
+	<-w.done
+}
+
+// stopOutbound stops and unregisters the named outbound workflow, reporting whether it was running
+func stopOutbound(name string) bool {
+	outboundWorkflowsMutex.Lock()
+	w, ok := outboundWorkflows[name]
+	delete(outboundWorkflows, name)
+	outboundWorkflowsMutex.Unlock()
+	if ok {
+		w.stop()
+	}
+	return ok
+}
+
+// closeOutboundWorkflows stops every outbound workflow watching for files, without waiting for
+// uploads in progress
+func closeOutboundWorkflows() {
+	outboundWorkflowsMutex.Lock()
+	defer outboundWorkflowsMutex.Unlock()
+	for _, w := range outboundWorkflows {
+		w.close()
+	}
+}
+
+// uploadEvent uploads a file from the watched folder to the workflow's destination, tracing each stage.
+// Extracted from the event loop so defers are scoped to a single file. Failures are logged where they occur.
+func uploadEvent(lf log.Fields, o Outbound, name string) (err error) {
+	finish, ok := startTransfer()
+	if !ok {
+		log.WithFields(lf).WithField("name", name).Warn("shutting down, not uploading file")
+		return nil
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx, span := startSpan(contextWithTransferID(context.Background(), id), "outbound.file",
+		attribute.String("workflow", o.Name),
+		attribute.String("file.path", name),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: o.Name, Path: name})
+	done := trackWorkflow(o.Name)
+	defer func() {
+		done(err)
+		if err != nil {
+			emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: o.Name, Path: name, Error: err.Error()})
+		} else {
+			checksumUploaded(o.Name, name)
+		}
+		endSpan(span, err)
+	}()
+
+	// Once uploaded, the file may be removed, after the handle to it below is closed
+	var uploaded bool
+	var fi os.FileInfo
+	defer func() {
+		if err == nil && uploaded {
+			afterUpload(lf, o, name, fi)
+		}
+	}()
+
+	// Open the file and prepare to read it
+	// #nosec G304 - intentional: path comes from fsnotify watching a configured directory
+	f, err := os.Open(name)
+	if err != nil {
+		log.WithFields(lf).WithFields(log.Fields{
+			"name": name,
+		}).Error("failed to open file: ", err)
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			log.WithFields(lf).Error("failed to close file: ", closeErr)
+		}
+	}()
+
+	// Another instance watching the same shared folder may have the file already
+	fi, err = f.Stat()
+	if err != nil {
+		log.WithFields(lf).WithFields(log.Fields{
+			"name": name,
+		}).Error("unable to query file: ", err)
+		return err
+	}
+	unlock, locked, err := lockOutboundFile(ctx, o, name, fi)
+	if err != nil {
+		log.WithFields(lf).WithFields(log.Fields{
+			"name": name,
+		}).Error("failed to lock file: ", err)
+		return err
+	}
+	if !locked {
+		log.WithFields(lf).WithFields(log.Fields{
+			"name": name,
+		}).Info("file locked by another instance, skipping")
+		return nil
+	}
+	defer func() {
+		unlock(err)
+	}()
+
+	// Determine destination type and handle accordingly
+	_, parseSpan := startSpan(ctx, "parse destination")
+	u, err := url.Parse(o.Destination)
+	endSpan(parseSpan, err)
+	if err != nil {
+		log.WithFields(lf).Error("failed to parse destination URL: ", err)
+		return err
+	}
+
+	// Check the file is complete and well formed, quarantining it if not
+	if o.Validate != "" {
+		if err = validContent(o.Validate, f); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			log.WithFields(lf).WithField("name", name).Error("file failed validation: ", err)
+			if o.Quarantine != "" && errors.Is(err, errInvalidContent) {
+				if moved, qerr := quarantineFile(o.Quarantine, name, id); qerr != nil {
+					log.WithFields(lf).WithField("name", name).Error(qerr)
+				} else {
+					log.WithFields(lf).WithFields(log.Fields{"name": name, "quarantine": moved}).Warn("file quarantined")
+				}
+			}
+			return err
+		}
+	}
+
+	// Let the workflow's processor check the file, or replace it with what to upload instead
+	var metadata map[string]string
+	if o.ProcessWith != "" {
+		_, processSpan := startSpan(ctx, "process")
+		result, err := processFile(o.ProcessWith, o.ProcessTimeout, "", processRequest{
+			Workflow:   o.Name,
+			Direction:  directionUpload,
+			TransferID: id,
+			Path:       name,
+		})
+		endSpan(processSpan, err)
+		if err != nil {
+			log.WithFields(lf).WithField("name", name).Error("failed to process file: ", err)
+			return err
+		}
+		defer result.close()
+		switch result.Action {
+		case processSkip:
+			log.WithFields(lf).WithFields(log.Fields{
+				"name":   name,
+				"reason": result.Reason,
+			}).Info("file skipped by processor")
+			return nil
+		case processReject:
+			err = fmt.Errorf("%w: %s", errProcessorRejected, result.Reason)
+			log.WithFields(lf).WithField("name", name).Error(err)
+			return err
+		case processReplace:
+			// #nosec G304 - the processor's output is a temporary file created for it
+			replacement, err := os.Open(result.Path)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = replacement.Close()
+			}()
+			f = replacement
+		}
+		metadata = result.Metadata
+	}
+
+	if len(o.Destinations) > 0 {
+		uploaded, err = uploadFanOut(ctx, lf, o, name, f.Name(), metadata)
+	} else {
+		uploaded, err = uploadObject(ctx, lf, o, u, name, f, metadata)
+		if o.fannedOut {
+			// A held upload from a fan-out, which other destinations may still be owed
+			uploaded = uploaded && fanOutReached(o, name)
+		}
+	}
+	return err
+}
+
+// uploadFanOut uploads the file at name, read from path, to each of the workflow's destinations
+// at once. Each upload is retried, held while its remote is down and recorded on its own, and
+// the failures are returned together. The file counts as uploaded only once it is at every one,
+// including through uploads held and tried again later.
+func uploadFanOut(ctx context.Context, lf log.Fields, o Outbound, name, path string, metadata map[string]string) (bool, error) {
+	destinations := o.destinations()
+	errs := make([]error, len(destinations))
+	uploaded := make([]bool, len(destinations))
+	var uploads sync.WaitGroup
+	for i, destination := range destinations {
+		uploads.Go(func() {
+			uploaded[i], errs[i] = uploadToDestination(ctx, lf, o.forDestination(destination), name, path, metadata)
+		})
+	}
+	uploads.Wait()
+	// Held uploads are tried again later on their own, and the file is only removed or archived
+	// once the destinations it has yet to reach are done
+	var remaining []string
+	for i, destination := range destinations {
+		if !uploaded[i] {
+			remaining = append(remaining, destination)
+		}
+	}
+	fanOutRemaining(o, name, remaining)
+	return len(remaining) == 0, errors.Join(errs...)
+}
+
+// uploadToDestination uploads the file at name, read from path through a handle of its own, to
+// the workflow's one destination
+func uploadToDestination(ctx context.Context, lf log.Fields, o Outbound, name, path string, metadata map[string]string) (bool, error) {
+	lf = maps.Clone(lf)
+	if lf == nil {
+		lf = log.Fields{}
+	}
+	u, err := url.Parse(o.Destination)
+	if err != nil {
+		log.WithFields(lf).Error("failed to parse destination URL: ", err)
+		return false, err
+	}
+	lf["destination"] = u.Redacted()
+	// #nosec G304 - path is the watched file, or the processor's replacement for it
+	f, err := os.Open(path)
+	if err != nil {
+		log.WithFields(lf).WithField("name", name).Error("failed to open file: ", err)
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return uploadObject(ctx, lf, o, u, name, f, metadata)
+}
+
+// uploadObject uploads the file at name, read from f, to the workflow's destination, whichever
+// store that is, with metadata added to the object's, reporting whether it was uploaded rather
+// than held or skipped
+func uploadObject(ctx context.Context, lf log.Fields, o Outbound, u *url.URL, name string, f *os.File, metadata map[string]string) (bool, error) {
+	// Determine the destination's store and the remote to use with it
+	_, credSpan := startSpan(ctx, "credential lookup", attribute.String("remote", u.Host))
+	target, err := outboundTarget(o, u)
+	endSpan(credSpan, err)
+	if err != nil {
+		log.WithFields(lf).Error(err)
+		return false, err
+	}
+	key, err := fileObjectKey(o, target.prefix, name, transferIDFromContext(ctx), time.Now())
+	if err != nil {
+		log.WithFields(lf).WithField("name", name).Error(err)
+		return false, err
+	}
+	if len(o.Tags) > 0 {
+		objTags, err := objectTags(o.Tags, name)
+		if err != nil {
+			log.WithFields(lf).WithField("name", name).Error(err)
+			return false, err
+		}
+		if s, ok := target.store.(*minioStore); ok && objTags != nil {
+			target.store = s.withTags(objTags)
+		}
+	}
+	log.WithFields(lf).WithFields(target.keyFields(key)).WithFields(log.Fields{
+		"name":     name,
+		"endpoint": u.Host,
+	}).Debug("uploading to " + target.kind)
+
+	// Rather than pile more failures onto a remote which is down, keep the file until it recovers
+	remote := target.remote
+	breaker := remoteBreaker(remote.Endpoint, remote.CircuitBreaker)
+	timeouts := remoteTimeouts(remote)
+	if holdUpload(lf, o, name, breaker) {
+		return false, nil
+	}
+
+	fs, err := f.Stat()
+	if err != nil {
+		log.WithFields(lf).WithFields(target.keyFields(key)).WithFields(log.Fields{
+			"name": name,
+		}).Error("unable to query file size: ", err)
+		return false, err
+	}
+	// Large files may be uploaded as chunks, holding at most the largest chunk in memory, or
+	// else in parts sized as the workflow asks
+	chunkSize, chunked := o.Chunked.chunkParams(fs.Size())
+	multipart := o.multipartUpload(fs.Size())
+	if s, ok := target.store.(*minioStore); ok && !chunked && o.tunesMultipart() {
+		target.store = s.withMultipart(multipart)
+	}
+
+	// An object already at the key may be kept, or the file uploaded beside it
+	resolved, err := resolveCollision(ctx, target.store, o.OnCollision, key, chunked, timeouts.Stat)
+	if err != nil {
+		log.WithFields(lf).WithFields(target.keyFields(key)).WithField("name", name).Error(err)
+		return false, err
+	}
+	if resolved == "" {
+		log.WithFields(lf).WithFields(target.keyFields(key)).WithField("name", name).Info("object already exists, skipping file")
+		return false, nil
+	}
+	key = resolved
+
+	ctx, span := startSpan(ctx, "transfer",
+		attribute.String("remote", u.Host),
+		attribute.String("bucket", target.bucket),
+		attribute.String("key", key),
+		attribute.Int64("size", fs.Size()),
+	)
+	// The transfer ID is stored as x-amz-meta-transfer-id on S3, linking the object back to the logs
+	transferID := transferIDFromContext(ctx)
+	metadata = maps.Clone(metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	if o.RecordsProvenance() {
+		// f may be the processor's replacement, so the modification time is the original file's
+		origin := fs
+		if fi, err := os.Stat(name); err == nil {
+			origin = fi
+		}
+		// Metadata the processor gave the object is left as it is
+		for k, v := range provenanceMetadata(o, name, origin) {
+			if _, ok := metadata[k]; !ok {
+				metadata[k] = v
+			}
+		}
+	}
+	metadata["Transfer-Id"] = transferID
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: transferID,
+		Workflow:   o.Name,
+		Path:       name,
+		Remote:     u.Host,
+		Bucket:     target.bucket,
+		Key:        key,
+		Size:       fs.Size(),
+	})
+	var checksum string
+	var chunks chunkStats
+	memory := multipart.memory(fs.Size())
+	if chunked {
+		memory = chunkSize * 4
+	}
+	progress := startProgress(transferID, o.Name, directionUpload, name, fs.Size())
+	defer progress.finish()
+	start := time.Now()
+	err = retryWithBackoff(ctx, transferRetry(o.Retry, remote.Retry), func() error {
+		job, err := scheduleTransfer(ctx, o.Name, o.Priority, bandwidthLimit(o.MaxBandwidth), remote, memory)
+		if err != nil {
+			return err
+		}
+		defer job.release()
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := breaker.allow(); err != nil {
+			return err
+		}
+		h := sha256.New()
+		progress.restart()
+		r := io.TeeReader(job.throttle(f), io.MultiWriter(h, progress))
+		if chunked {
+			// Each chunk is bounded by the upload timeout, rather than the whole file
+			chunks, err = putChunked(ctx, target.store, key, o.Chunked.chunkDir(target.prefix), r, chunkSize, timeouts.Upload, metadata)
+		} else {
+			ctx, cancel := context.WithTimeout(ctx, timeouts.Upload)
+			defer cancel()
+			err = target.store.Put(ctx, key, r, fs.Size(), metadata)
+		}
+		breaker.record(err)
+		if err == nil {
+			checksum = hex.EncodeToString(h.Sum(nil))
+		}
+		return err
+	})
+	endSpan(span, err)
+	if errors.Is(err, errCircuitOpen) && holdUpload(lf, o, name, breaker) {
+		return false, nil
+	}
+	if err == nil && o.VerifyUpload && !chunked {
+		err = verifyUploaded(ctx, target.store, key, f.Name(), fs, timeouts.Stat)
+	}
+	rec := TransferRecord{
+		ID:        transferID,
+		Workflow:  o.Name,
+		Direction: directionUpload,
+		Path:      name,
+		Remote:    u.Host,
+		Bucket:    target.bucket,
+		Key:       key,
+		Size:      fs.Size(),
+		Checksum:  checksum,
+		Duration:  time.Since(start),
+		Status:    transferSuccess,
+	}
+	if chunked {
+		rec.Key = key + chunkManifestSuffix
+	}
+	if err != nil {
+		rec.Status, rec.Error = transferFailed, err.Error()
+	} else {
+		rec.CID = target.contentID()
+	}
+	recordTransfer(rec)
+	if err != nil {
+		log.WithFields(lf).WithFields(target.keyFields(key)).WithFields(log.Fields{
+			"name": name,
+		}).Errorf("failed to upload file to %s after retries: %s", target.kind, err)
+		return false, err
+	}
+	emitEvent(transferEvent(eventUploaded, rec))
+	fields := log.Fields{
+		"name":       name,
+		"size":       fs.Size(),
+		"throughput": formatRate(transferRate(rec.Size, rec.Duration)),
+	}
+	if chunked {
+		fields["chunks"] = chunks.chunks
+		fields["chunks_uploaded"] = chunks.uploaded
+		fields["bytes_uploaded"] = chunks.bytes
+	}
+	if rec.CID != "" {
+		fields["cid"] = rec.CID
+	}
+	log.WithFields(lf).WithFields(target.keyFields(rec.Key)).WithFields(fields).Info("uploaded to " + target.kind)
+
+	SendNotification("bucketsyncd", uploadedMessage(name, o.Destination, rec))
+	return true, nil
+}
+
+// uploadedMessage is the desktop notification of an upload, giving the CID of a file added to IPFS
+func uploadedMessage(name, destination string, rec TransferRecord) string {
+	if rec.CID != "" {
+		return fmt.Sprintf("Uploaded %s to %s as %s (transfer %s)", name, destination, rec.CID, rec.ID)
+	}
+	return fmt.Sprintf("Uploaded %s to %s (transfer %s)", name, destination, rec.ID)
+}
+
+// keyFields names an object in log entries, with the fields each backend has always used
+func (t storeTarget) keyFields(key string) log.Fields {
+	if t.bucket == "" {
+		return log.Fields{"remote_path": key}
+	}
+	return log.Fields{"awsBucket": t.bucket, "awsFileKey": key}
+}
+
+// scan queues every file already in the source folder which the workflow would upload,
+// returning how many were found
+func (w *outboundWorkflow) scan() (int, error) {
+	files, err := listOutboundFiles(w.o)
+	if err != nil {
+		return 0, err
+	}
+	folder := filepath.Dir(w.o.Source)
+	for name := range files {
+		w.uploads.add(filepath.Join(folder, name))
+	}
+	return len(files), nil
+}
+
+// catchUp queues the files in the source folder as if the watcher had reported them, for those
+// written while no replica was leading
+func (w *outboundWorkflow) catchUp(state *workflowState) (int, error) {
+	files, err := listOutboundFiles(w.o)
+	if err != nil {
+		return 0, err
+	}
+	folder := filepath.Dir(w.o.Source)
+	for name := range files {
+		w.uploadWatched(state, filepath.Join(folder, name))
+	}
+	return len(files), nil
+}
+
+// catchUpOutbound has every watched outbound workflow catch up on the files written while this
+// replica was not leading, once it leads
+func catchUpOutbound() {
+	outboundWorkflowsMutex.Lock()
+	running := slices.Collect(maps.Values(outboundWorkflows))
+	outboundWorkflowsMutex.Unlock()
+	for _, w := range running {
+		state, ok := findWorkflow(w.o.Name)
+		if w.watcher == nil || !ok {
+			continue
+		}
+		n, err := w.catchUp(state)
+		if err != nil {
+			log.WithFields(w.lf).Error("failed to list files written during the leader handover: ", err)
+			continue
+		}
+		log.WithFields(w.lf).WithField("files", n).Info("queued files written before this replica led")
+	}
+}
+
+// uploadScheduled queues every file in the source folder which the workflow would upload, as
+// its schedule comes round
+func (w *outboundWorkflow) uploadScheduled(state *workflowState) error {
+	if _, err := w.scan(); err != nil {
+		state.setHealth(healthDegraded)
+		return err
+	}
+	state.setHealth(healthWatching)
+	return nil
+}
+
+// holdUpload puts off uploading a file while its remote's circuit is open, reporting whether it did
+func holdUpload(lf log.Fields, o Outbound, name string, breaker *circuitBreaker) bool {
+	held := breaker.holdWhileOpen(o.Name+"\x00"+name, func() {
+		queueHeldUpload(lf, o, name)
+	})
+	if held {
+		log.WithFields(lf).WithField("name", name).Warn("remote unavailable, holding file until it recovers")
+	}
+	return held
+}
+
+// queueHeldUpload queues a file held while its remote was down with the workflow's other
+// uploads, or uploads it at once if the workflow is not running, as when run by sync-once
+func queueHeldUpload(lf log.Fields, o Outbound, name string) {
+	upload := func() {
+		// Failures are logged by uploadEvent as they occur
+		_ = uploadEvent(lf, o, name)
+	}
+	outboundWorkflowsMutex.Lock()
+	w := outboundWorkflows[o.Name]
+	outboundWorkflowsMutex.Unlock()
+	if w == nil || w.uploads == nil {
+		upload()
+		return
+	}
+	w.uploads.addFunc(name, upload)
+}
+
+// parseS3Destination splits an s3://endpoint/bucket/prefix destination into its components
+func parseS3Destination(u *url.URL) (endpoint, bucket, prefix string, err error) {
+	tokens := strings.Split(u.Path, "/")
+	const minTokens = 2
+	if len(tokens) < minTokens {
+		return "", "", "", fmt.Errorf("invalid S3 path: %s", u.Path)
+	}
+	return u.Host, tokens[1], strings.Join(tokens[2:], "/"), nil
+}
+
+// outboundObjectKey returns the object key an outbound workflow uploads a file to
+func outboundObjectKey(prefix, filename string) string {
+	return prefix + "/" + filename
+}
+
+// isIgnored reports whether filename matches any of the ignore patterns
+func isIgnored(patterns []string, filename string) bool {
+	for _, pattern := range patterns {
+		if glob.Glob(pattern, filename) {
+			return true
+		}
+	}
+	return false
+}
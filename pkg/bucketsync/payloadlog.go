@@ -0,0 +1,123 @@
+package bucketsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Payload logging modes
+const (
+	payloadLogNone   = "none"
+	payloadLogParsed = "parsed"
+	payloadLogFull   = "full"
+
+	defaultPayloadLogMaxBytes = 1024
+	redactedValue             = "[redacted]"
+)
+
+// PayloadLog controls how much of each inbound message is logged at debug level. Message bodies
+// can name private documents and be large, so nothing is logged unless asked for.
+type PayloadLog struct {
+	// Mode is none (the default), parsed to log only the fields bucketsyncd uses, or full
+	Mode string `yaml:"mode"`
+	// MaxBytes truncates the logged payload, 1024 by default
+	MaxBytes int `yaml:"max_bytes"`
+	// Redact replaces the values at these dotted JSON paths, such as Records.s3.object.key;
+	// arrays are searched element by element and * matches any key
+	Redact []string `yaml:"redact"`
+}
+
+func (p PayloadLog) validate() error {
+	switch p.Mode {
+	case "", payloadLogNone, payloadLogParsed, payloadLogFull:
+	default:
+		return fmt.Errorf("unknown mode %q (expected none, parsed or full)", p.Mode)
+	}
+	if p.MaxBytes < 0 {
+		return errors.New("max_bytes must not be negative")
+	}
+	for _, path := range p.Redact {
+		if slices.Contains(strings.Split(path, "."), "") {
+			return fmt.Errorf("invalid redact path %q", path)
+		}
+	}
+	return nil
+}
+
+// logPayload logs a message body as configured, if debug logging is on
+func logPayload(lf log.Fields, cfg PayloadLog, body []byte) {
+	if cfg.Mode == "" || cfg.Mode == payloadLogNone || !log.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	log.WithFields(lf).WithField("payload", payloadLogLine(cfg, body)).Debug("message received")
+}
+
+// payloadLogLine renders a message body for the log, redacted and truncated
+func payloadLogLine(cfg PayloadLog, body []byte) string {
+	if cfg.Mode == payloadLogFull && len(cfg.Redact) == 0 {
+		return truncatePayload(string(body), cfg.MaxBytes)
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		// Without a structure there is nothing to redact or parse, so leave the body out
+		return fmt.Sprintf("(%d bytes, not JSON)", len(body))
+	}
+	if cfg.Mode == payloadLogParsed {
+		var event S3Event
+		_ = json.Unmarshal(body, &event)
+		parsed, _ := json.Marshal(event)
+		_ = json.Unmarshal(parsed, &doc)
+	}
+	for _, path := range cfg.Redact {
+		redactPath(doc, strings.Split(path, "."))
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(doc)
+	return truncatePayload(strings.TrimSuffix(b.String(), "\n"), cfg.MaxBytes)
+}
+
+// redactPath replaces the values found at path within v
+func redactPath(v any, path []string) {
+	switch v := v.(type) {
+	case []any:
+		for _, elem := range v {
+			redactPath(elem, path)
+		}
+	case map[string]any:
+		for k, child := range v {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				v[k] = redactedValue
+			} else {
+				redactPath(child, path[1:])
+			}
+		}
+	}
+}
+
+// truncatePayload shortens s to at most maxBytes, noting how long it was
+func truncatePayload(s string, maxBytes int) string {
+	if maxBytes == 0 {
+		maxBytes = defaultPayloadLogMaxBytes
+	}
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s... (%d bytes)", s[:cut], len(s))
+}
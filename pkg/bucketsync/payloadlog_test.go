@@ -0,0 +1,87 @@
+package bucketsync
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testPayload = `{"EventName":"s3:ObjectCreated:Put","Records":[{"s3":{"bucket":{"name":"photos"},"object":{"key":"passport%20scan.pdf","size":1024,"eTag":"abc"}},"userIdentity":{"principalId":"alice"}}]}`
+
+func TestPayloadLogValidate(t *testing.T) {
+	if err := (PayloadLog{Mode: payloadLogFull, MaxBytes: 512, Redact: []string{"Records.s3.object.key"}}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (PayloadLog{Mode: "verbose"}).validate(); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if err := (PayloadLog{Redact: []string{"Records..key"}}).validate(); err == nil {
+		t.Error("expected error for empty path segment")
+	}
+}
+
+func TestPayloadLogLine(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PayloadLog
+		body string
+		want string
+	}{
+		{
+			"full",
+			PayloadLog{Mode: payloadLogFull},
+			testPayload,
+			testPayload,
+		},
+		{
+			"parsed",
+			PayloadLog{Mode: payloadLogParsed},
+			testPayload,
+			`{"EventName":"s3:ObjectCreated:Put","Records":[{"s3":{"bucket":{"name":"photos"},"object":{"key":"passport%20scan.pdf","size":1024}}}]}`,
+		},
+		{
+			"full redacted",
+			PayloadLog{Mode: payloadLogFull, Redact: []string{"Records.userIdentity"}},
+			testPayload,
+			`{"EventName":"s3:ObjectCreated:Put","Records":[{"s3":{"bucket":{"name":"photos"},"object":{"eTag":"abc","key":"passport%20scan.pdf","size":1024}},"userIdentity":"[redacted]"}]}`,
+		},
+		{
+			"redacted",
+			PayloadLog{Mode: payloadLogParsed, Redact: []string{"Records.s3.object.key", "Records.*.bucket"}},
+			testPayload,
+			`{"EventName":"s3:ObjectCreated:Put","Records":[{"s3":{"bucket":"[redacted]","object":{"key":"[redacted]","size":1024}}}]}`,
+		},
+		{
+			"truncated",
+			PayloadLog{Mode: payloadLogFull, MaxBytes: 14},
+			testPayload,
+			`{"EventName":"... (` + strconv.Itoa(len(testPayload)) + ` bytes)`,
+		},
+		{
+			"not JSON",
+			PayloadLog{Mode: payloadLogFull},
+			"<html>",
+			"<html>",
+		},
+		{
+			"not JSON with redaction",
+			PayloadLog{Mode: payloadLogFull, Redact: []string{"key"}},
+			"<html>",
+			"(6 bytes, not JSON)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := payloadLogLine(tt.cfg, []byte(tt.body)); got != tt.want {
+				t.Errorf("payloadLogLine() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncatePayloadRuneBoundary(t *testing.T) {
+	got := truncatePayload("héllo", 2)
+	if !strings.HasPrefix(got, "h... ") {
+		t.Errorf("truncatePayload() = %q, want the cut before the two-byte rune", got)
+	}
+}
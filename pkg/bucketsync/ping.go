@@ -0,0 +1,131 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pinger reports a workflow's transfers to a dead man's switch service such as healthchecks.io,
+// hitting its URL after each success and URL/fail after each failure. Outcomes arriving while
+// a ping is in progress are coalesced so that only the latest is sent.
+type pinger struct {
+	workflow string
+	url      string
+	client   *http.Client
+	signal   chan struct{}
+
+	mu     sync.Mutex
+	failed string
+}
+
+var (
+	pingersMutex sync.RWMutex
+	pingers      map[string]*pinger
+)
+
+// openPingers starts pinging the URLs configured for each workflow, keyed by workflow name.
+// The returned function sends any pending ping and stops the pingers.
+func openPingers(urls map[string]string) func() {
+	ps := make(map[string]*pinger, len(urls))
+	var wg sync.WaitGroup
+	for workflow, u := range urls {
+		p := &pinger{
+			workflow: workflow,
+			url:      u,
+			client:   &http.Client{Timeout: defaultWebhookTimeout},
+			signal:   make(chan struct{}, 1),
+		}
+		ps[workflow] = p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range p.signal {
+				p.send()
+			}
+		}()
+	}
+
+	pingersMutex.Lock()
+	pingers = ps
+	pingersMutex.Unlock()
+
+	return func() {
+		pingersMutex.Lock()
+		pingers = nil
+		pingersMutex.Unlock()
+		for _, p := range ps {
+			close(p.signal)
+		}
+		wg.Wait()
+	}
+}
+
+// pingTransfer queues a ping for the outcome of a transfer, if its workflow has a ping URL
+func pingTransfer(rec TransferRecord) {
+	pingersMutex.RLock()
+	defer pingersMutex.RUnlock()
+	p, ok := pingers[rec.Workflow]
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	p.failed = ""
+	if rec.Status == transferFailed {
+		p.failed = rec.Error
+		if p.failed == "" {
+			p.failed = "transfer failed"
+		}
+	}
+	p.mu.Unlock()
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
+
+// send hits the ping URL for the latest outcome, including the error in the body of a failure ping
+func (p *pinger) send() {
+	p.mu.Lock()
+	failed := p.failed
+	p.mu.Unlock()
+
+	target, body := p.url, ""
+	if failed != "" {
+		target, body = pingFailURL(p.url), failed
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target, strings.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("User-Agent", "bucketsyncd/"+version)
+		var resp *http.Response
+		if resp, err = p.client.Do(req); err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = errors.New("ping returned " + resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		// Ping URLs identify the check, so leave them out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		log.WithFields(log.Fields{"workflow": p.workflow}).Warn("failed to send ping: ", err)
+	}
+}
+
+// pingFailURL appends /fail to the path of a ping URL, keeping any query string
+func pingFailURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.JoinPath("fail").String()
+}
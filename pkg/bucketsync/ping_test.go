@@ -0,0 +1,72 @@
+package bucketsync
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPingFailURL(t *testing.T) {
+	tests := map[string]string{
+		"https://hc-ping.com/abc":          "https://hc-ping.com/abc/fail",
+		"https://hc-ping.com/abc/":         "https://hc-ping.com/abc/fail",
+		"https://example.com/ping?rid=1":   "https://example.com/ping/fail?rid=1",
+		"https://example.com/ping/key/job": "https://example.com/ping/key/job/fail",
+	}
+	for in, want := range tests {
+		if got := pingFailURL(in); got != want {
+			t.Errorf("pingFailURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPingTransfer(t *testing.T) {
+	var mu sync.Mutex
+	var pings []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pings = append(pings, strings.TrimSpace(r.URL.Path+" "+string(body)))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), pings...)
+	}
+
+	closePingers := openPingers(map[string]string{"photos": srv.URL + "/uuid"})
+
+	// Workflows without a ping URL are ignored
+	pingTransfer(TransferRecord{Workflow: "other", Status: transferSuccess})
+	pingTransfer(TransferRecord{Workflow: "photos", Status: transferSuccess})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	pingTransfer(TransferRecord{Workflow: "photos", Status: transferFailed, Error: "access denied"})
+	closePingers()
+
+	got := received()
+	want := []string{"/uuid", "/uuid/fail access denied"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("pings = %q, want %q", got, want)
+	}
+}
+
+func TestPingURLValidation(t *testing.T) {
+	c := Config{
+		Remotes:  []Remote{{Name: "r", Endpoint: "s3.example.com"}},
+		Outbound: []Outbound{{Name: "out", Source: "/tmp", Destination: "s3://r/b", PingURL: "hc-ping.com/abc"}},
+		Inbound:  []Inbound{{Name: "in", Source: "amqp://localhost/", Queue: "q", Remote: "r", Destination: "/tmp", PingURL: "https://hc-ping.com/abc"}},
+	}
+	errs := c.Validate()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `outbound "out": ping_url`) {
+		t.Errorf("Validate() = %v, want one ping_url error", errs)
+	}
+}
@@ -0,0 +1,311 @@
+package bucketsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A processor is a program named by a workflow's process_with which checks or transforms each file
+// before it is uploaded, or after it is downloaded. It is started once and kept running, and
+// speaks a line-delimited JSON protocol on its standard input and output:
+//
+//  1. On starting, the processor writes a handshake: {"protocol":"bucketsyncd-processor","version":1}
+//  2. bucketsyncd writes a processRequest for each file, one at a time
+//  3. The processor writes a processResponse with the same id, choosing an action
+//
+// Anything the processor writes to standard error is logged. It should exit when its standard
+// input is closed.
+const (
+	processorProtocol        = "bucketsyncd-processor"
+	processorProtocolVersion = 1
+	// defaultProcessTimeout bounds starting a processor and each file it processes, unless
+	// process_timeout is set
+	defaultProcessTimeout = time.Minute
+)
+
+// Actions a processor may choose for a file
+const (
+	// processAccept transfers the file as it is
+	processAccept = "accept"
+	// processReplace transfers what the processor wrote to the request's output path instead
+	processReplace = "replace"
+	// processSkip leaves the file out, without counting it as a failure
+	processSkip = "skip"
+	// processReject fails the file's transfer, giving the reason
+	processReject = "reject"
+)
+
+// errProcessorRejected is returned, wrapped with the processor's reason, for a rejected file
+var errProcessorRejected = errors.New("rejected by processor")
+
+type processorHandshake struct {
+	Protocol string `json:"protocol"`
+	Version  int    `json:"version"`
+}
+
+// processRequest asks a processor to handle a file
+type processRequest struct {
+	ID         uint64 `json:"id"`
+	Workflow   string `json:"workflow"`
+	Direction  string `json:"direction"`
+	TransferID string `json:"transfer_id"`
+	// Path is the local file, to be read but not changed
+	Path string `json:"path"`
+	// Key is the object's key, for downloads
+	Key string `json:"key,omitempty"`
+	// Output is an empty file to write a replacement to, for the replace action
+	Output string `json:"output"`
+}
+
+type processResponse struct {
+	ID     uint64 `json:"id"`
+	Action string `json:"action"`
+	// Reason explains a skip or rejection
+	Reason string `json:"reason,omitempty"`
+	// Metadata is stored with an uploaded object, where the backend supports it
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// processResult is a processor's decision on a file. Path is the file to transfer, which is a
+// replacement if the processor made one; close removes it once it is no longer needed.
+type processResult struct {
+	processResponse
+	Path     string
+	replaced bool
+}
+
+func (r processResult) close() {
+	if r.replaced {
+		_ = os.Remove(r.Path)
+	}
+}
+
+// processor is a running processor program, handling one file at a time
+type processor struct {
+	command string
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan []byte
+	// readers finishes once the processor's output has been read to the end
+	readers *sync.WaitGroup
+	id      uint64
+}
+
+var (
+	processorsMutex sync.Mutex
+	processors      = make(map[string]*processor)
+)
+
+// processorFor returns the processor running command, which workflows naming it share
+func processorFor(command string) *processor {
+	processorsMutex.Lock()
+	defer processorsMutex.Unlock()
+	p, ok := processors[command]
+	if !ok {
+		p = &processor{command: command}
+		processors[command] = p
+	}
+	return p
+}
+
+// closeProcessors asks every processor to exit by closing its standard input, and stops any which
+// do not within a few seconds
+func closeProcessors() {
+	processorsMutex.Lock()
+	defer processorsMutex.Unlock()
+	for command, p := range processors {
+		p.mu.Lock()
+		p.stop(5 * time.Second)
+		p.mu.Unlock()
+		delete(processors, command)
+	}
+}
+
+// processFile passes a file to the processor running command, writing any replacement to a
+// temporary file in outputDir, or the system's temporary directory if it is empty
+func processFile(command string, timeout time.Duration, outputDir string, req processRequest) (processResult, error) {
+	if timeout == 0 {
+		timeout = defaultProcessTimeout
+	}
+	if outputDir == "" {
+		outputDir = os.TempDir()
+	}
+	out, err := os.CreateTemp(outputDir, "."+filepath.Base(req.Path)+".processing-*")
+	if err != nil {
+		return processResult{}, fmt.Errorf("failed to create processor output: %w", err)
+	}
+	_ = out.Close()
+	req.Output = out.Name()
+
+	var resp processResponse
+	if isWasmProcessor(command) {
+		resp, err = wasmProcessorFor(command).process(req, timeout)
+	} else {
+		resp, err = processorFor(command).process(req, timeout)
+	}
+	result := processResult{processResponse: resp, Path: req.Path}
+	if err == nil && resp.Action == processReplace {
+		result.Path, result.replaced = req.Output, true
+	} else {
+		_ = os.Remove(req.Output)
+	}
+	return result, err
+}
+
+// process sends req to the processor, starting it if it is not running. A processor which fails
+// or times out is stopped, to be started afresh for the next file.
+func (p *processor) process(req processRequest, timeout time.Duration) (processResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(timeout); err != nil {
+			return processResponse{}, err
+		}
+	}
+	p.id++
+	req.ID = p.id
+	resp, err := p.roundTrip(req, timeout)
+	if err != nil {
+		p.stop(0)
+		return resp, fmt.Errorf("processor %s: %w", p.command, err)
+	}
+	return resp, nil
+}
+
+func (p *processor) roundTrip(req processRequest, timeout time.Duration) (processResponse, error) {
+	var resp processResponse
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		return resp, err
+	}
+	line, err := p.readLine(timeout)
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return resp, fmt.Errorf("invalid response: %w", err)
+	}
+	if resp.ID != req.ID {
+		return resp, fmt.Errorf("response for request %d, expected %d", resp.ID, req.ID)
+	}
+	switch resp.Action {
+	case processAccept, processReplace, processSkip, processReject:
+	default:
+		return resp, fmt.Errorf("unknown action %q", resp.Action)
+	}
+	return resp, nil
+}
+
+func (p *processor) readLine(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			return nil, errors.New("processor exited")
+		}
+		return line, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no response within %s", timeout)
+	}
+}
+
+// start runs the processor and waits for its handshake
+func (p *processor) start(timeout time.Duration) error {
+	lf := log.Fields{"processor": p.command}
+	// #nosec G204 - intentional: the processor is named in the configuration file
+	cmd := exec.Command(p.command)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start processor %s: %w", p.command, err)
+	}
+	log.WithFields(lf).Info("started processor")
+
+	lines := make(chan []byte)
+	readers := &sync.WaitGroup{}
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+	go func() {
+		defer readers.Done()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.WithFields(lf).Warn(scanner.Text())
+		}
+	}()
+	p.cmd, p.stdin, p.lines, p.readers = cmd, stdin, lines, readers
+
+	line, err := p.readLine(timeout)
+	var handshake processorHandshake
+	if err == nil {
+		err = json.Unmarshal(line, &handshake)
+	}
+	if err == nil && (handshake.Protocol != processorProtocol || handshake.Version != processorProtocolVersion) {
+		err = fmt.Errorf("unsupported protocol %q version %d", handshake.Protocol, handshake.Version)
+	}
+	if err != nil {
+		p.stop(0)
+		return fmt.Errorf("processor %s handshake: %w", p.command, err)
+	}
+	return nil
+}
+
+// stop closes the processor's standard input and waits up to grace for it to exit before
+// killing it
+func (p *processor) stop(grace time.Duration) {
+	if p.cmd == nil {
+		return
+	}
+	cmd, stdin, lines, readers := p.cmd, p.stdin, p.lines, p.readers
+	p.cmd, p.stdin, p.lines, p.readers = nil, nil, nil, nil
+	_ = stdin.Close()
+
+	exited := make(chan struct{})
+	go func() {
+		// Drain output so that the processor is not blocked writing it
+		for range lines {
+		}
+		readers.Wait()
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(grace):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+}
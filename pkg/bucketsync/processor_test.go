@@ -0,0 +1,147 @@
+package bucketsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary stand in for a processor, when run as one by the tests below
+func TestMain(m *testing.M) {
+	if os.Getenv("BUCKETSYNCD_TEST_PROCESSOR") != "" {
+		runTestProcessor()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runTestProcessor decides on each file by its content: "skip", "reject", "upper" to replace it
+// with its upper-cased content, "hang" to never answer, or anything else to accept it
+func runTestProcessor() {
+	fmt.Printf(`{"protocol":%q,"version":%d}`+"\n", processorProtocol, processorProtocolVersion)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req processRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		data, _ := os.ReadFile(req.Path)
+		resp := processResponse{ID: req.ID, Action: processAccept, Metadata: map[string]string{"Checked": req.Direction}}
+		switch content := strings.TrimSpace(string(data)); content {
+		case "skip", "reject":
+			resp = processResponse{ID: req.ID, Action: content, Reason: "test " + content}
+		case "upper":
+			_ = os.WriteFile(req.Output, []byte(strings.ToUpper(content)), 0600)
+			resp.Action = processReplace
+		case "hang":
+			select {}
+		}
+		out, _ := json.Marshal(resp)
+		fmt.Println(string(out))
+	}
+}
+
+// testProcessor returns the command which runs the test binary as a processor
+func testProcessor(t *testing.T) string {
+	t.Helper()
+	t.Setenv("BUCKETSYNCD_TEST_PROCESSOR", "1")
+	command, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeProcessors)
+	return command
+}
+
+func TestProcessFile(t *testing.T) {
+	command := testProcessor(t)
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		content string
+		action  string
+		want    string
+	}{
+		{"plain", processAccept, "plain"},
+		{"upper", processReplace, "UPPER"},
+		{"skip", processSkip, ""},
+		{"reject", processReject, ""},
+	} {
+		t.Run(tt.content, func(t *testing.T) {
+			path := filepath.Join(dir, tt.content+".txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			result, err := processFile(command, 10*time.Second, "", processRequest{Workflow: "docs", Direction: directionUpload, Path: path})
+			if err != nil {
+				t.Fatalf("processFile() = %v", err)
+			}
+			defer result.close()
+			if result.Action != tt.action {
+				t.Errorf("action = %q, want %q", result.Action, tt.action)
+			}
+			if tt.want != "" {
+				data, _ := os.ReadFile(result.Path)
+				if string(data) != tt.want {
+					t.Errorf("file to transfer holds %q, want %q", data, tt.want)
+				}
+			}
+			if tt.action == processAccept && result.Metadata["Checked"] != directionUpload {
+				t.Errorf("metadata = %v", result.Metadata)
+			}
+		})
+	}
+}
+
+func TestProcessFileTimeout(t *testing.T) {
+	command := testProcessor(t)
+	dir := t.TempDir()
+	hang := filepath.Join(dir, "hang.txt")
+	plain := filepath.Join(dir, "plain.txt")
+	_ = os.WriteFile(hang, []byte("hang"), 0600)
+	_ = os.WriteFile(plain, []byte("plain"), 0600)
+
+	if _, err := processFile(command, 500*time.Millisecond, "", processRequest{Path: hang}); err == nil {
+		t.Fatal("expected an error from a processor which does not answer")
+	}
+	// The processor is started afresh for the next file
+	result, err := processFile(command, 10*time.Second, "", processRequest{Path: plain})
+	if err != nil || result.Action != processAccept {
+		t.Errorf("processFile() after a timeout = %+v, %v", result, err)
+	}
+}
+
+func TestProcessDownload(t *testing.T) {
+	command := testProcessor(t)
+	dir := t.TempDir()
+	in := Inbound{Name: "in", ProcessWith: command, ProcessTimeout: 10 * time.Second}
+
+	upper := filepath.Join(dir, "upper.txt")
+	_ = os.WriteFile(upper, []byte("upper"), 0600)
+	if kept, err := processDownload(t.Context(), nil, in, "upper.txt", upper); !kept || err != nil {
+		t.Fatalf("processDownload() = %v, %v", kept, err)
+	}
+	if data, _ := os.ReadFile(upper); string(data) != "UPPER" {
+		t.Errorf("downloaded file holds %q, want the replacement", data)
+	}
+
+	rejected := filepath.Join(dir, "rejected.txt")
+	_ = os.WriteFile(rejected, []byte("reject"), 0600)
+	if _, err := processDownload(t.Context(), nil, in, "rejected.txt", rejected); !errors.Is(err, errProcessorRejected) {
+		t.Errorf("processDownload() = %v, want errProcessorRejected", err)
+	}
+	if _, err := os.Stat(rejected); !os.IsNotExist(err) {
+		t.Error("expected the rejected file to be removed")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only the replaced file to be left, found %d files", len(entries))
+	}
+}
@@ -0,0 +1,201 @@
+package bucketsync
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// progressWindow is the period over which a transfer's current rate is measured
+const progressWindow = time.Second
+
+// transferProgress follows an upload or download as its bytes are copied, measuring its rate.
+// It is an io.Writer, so that it can be fed from a TeeReader or MultiWriter.
+type transferProgress struct {
+	mu        sync.Mutex
+	id        string
+	workflow  string
+	direction string
+	name      string
+	size      int64
+	started   time.Time
+	bytes     int64
+	// rate is measured over the last complete window, which began at windowStart with windowBytes
+	rate        float64
+	windowStart time.Time
+	windowBytes int64
+}
+
+// transferProgressStatus is a snapshot of a transfer in progress
+type transferProgressStatus struct {
+	ID        string `json:"transfer_id"`
+	Direction string `json:"direction"`
+	Name      string `json:"name"`
+	Bytes     int64  `json:"bytes"`
+	// Size is zero until known
+	Size    int64     `json:"size,omitempty"`
+	Started time.Time `json:"started"`
+	// Rate is the current rate, and AverageRate the rate since the transfer started, in bytes per second
+	Rate        float64 `json:"rate"`
+	AverageRate float64 `json:"average_rate"`
+	// ETA is the estimated time remaining, once the size and a rate are known
+	ETA string `json:"eta,omitempty"`
+}
+
+var (
+	progressMutex   sync.Mutex
+	activeTransfers = make(map[string]*transferProgress)
+)
+
+var transferThroughput = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "bucketsyncd",
+	Name:      "transfer_throughput_bytes_per_second",
+	Help:      "Average throughput of successful uploads and downloads.",
+	Buckets:   prometheus.ExponentialBuckets(16*1024, 4, 10),
+}, []string{"workflow", "remote", "direction"})
+
+// transferRateDesc describes the current rate of each workflow's transfers in progress, which is
+// measured when scraped
+var transferRateDesc = prometheus.NewDesc("bucketsyncd_transfer_rate_bytes_per_second",
+	"Current combined rate of the transfers in progress.", []string{"workflow", "direction"}, nil)
+
+type transferRateCollector struct{}
+
+func (transferRateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- transferRateDesc
+}
+
+func (transferRateCollector) Collect(ch chan<- prometheus.Metric) {
+	type key struct{ workflow, direction string }
+	rates := make(map[key]float64)
+	progressMutex.Lock()
+	for _, p := range activeTransfers {
+		s := p.status(time.Now())
+		rates[key{p.workflow, p.direction}] += s.Rate
+	}
+	progressMutex.Unlock()
+	for k, rate := range rates {
+		ch <- prometheus.MustNewConstMetric(transferRateDesc, prometheus.GaugeValue, rate, k.workflow, k.direction)
+	}
+}
+
+func init() {
+	metricsRegistry.MustRegister(transferThroughput, transferRateCollector{})
+}
+
+// startProgress follows a transfer until finish is called, with size zero if not yet known
+func startProgress(id, workflow, direction, name string, size int64) *transferProgress {
+	if id == "" {
+		id = newTransferID()
+	}
+	now := time.Now()
+	p := &transferProgress{
+		id:          id,
+		workflow:    workflow,
+		direction:   direction,
+		name:        name,
+		size:        size,
+		started:     now,
+		windowStart: now,
+	}
+	progressMutex.Lock()
+	activeTransfers[id] = p
+	progressMutex.Unlock()
+	return p
+}
+
+// finish stops following the transfer
+func (p *transferProgress) finish() {
+	progressMutex.Lock()
+	delete(activeTransfers, p.id)
+	progressMutex.Unlock()
+}
+
+// restart counts from zero again, for a retried attempt
+func (p *transferProgress) restart() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes, p.windowBytes, p.rate = 0, 0, 0
+	p.windowStart = time.Now()
+}
+
+func (p *transferProgress) setSize(size int64) {
+	p.mu.Lock()
+	p.size = size
+	p.mu.Unlock()
+}
+
+// Write counts the bytes copied so far
+func (p *transferProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bytes += int64(len(b))
+	if now := time.Now(); now.Sub(p.windowStart) >= progressWindow {
+		p.rate = float64(p.bytes-p.windowBytes) / now.Sub(p.windowStart).Seconds()
+		p.windowStart, p.windowBytes = now, p.bytes
+	}
+	return len(b), nil
+}
+
+func (p *transferProgress) status(now time.Time) transferProgressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := transferProgressStatus{
+		ID:        p.id,
+		Direction: p.direction,
+		Name:      p.name,
+		Bytes:     p.bytes,
+		Size:      p.size,
+		Started:   p.started,
+		Rate:      p.rate,
+	}
+	if elapsed := now.Sub(p.started).Seconds(); elapsed > 0 {
+		s.AverageRate = float64(p.bytes) / elapsed
+	}
+	// A transfer which has stalled since its last window has no current rate
+	if now.Sub(p.windowStart) > 2*progressWindow {
+		s.Rate = 0
+	}
+	rate := s.Rate
+	if rate == 0 {
+		rate = s.AverageRate
+	}
+	if p.size > 0 && rate > 0 {
+		remaining := time.Duration(float64(p.size-p.bytes) / rate * float64(time.Second))
+		s.ETA = remaining.Round(time.Second).String()
+	}
+	return s
+}
+
+// workflowProgress returns the workflow's transfers in progress, oldest first
+func workflowProgress(workflow string) []transferProgressStatus {
+	now := time.Now()
+	progressMutex.Lock()
+	var statuses []transferProgressStatus
+	for _, p := range activeTransfers {
+		if p.workflow == workflow {
+			statuses = append(statuses, p.status(now))
+		}
+	}
+	progressMutex.Unlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Started.Before(statuses[j].Started)
+	})
+	return statuses
+}
+
+// transferRate is a transfer's average rate, or zero if it took no measurable time
+func transferRate(size int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(size) / d.Seconds()
+}
+
+// formatRate renders a rate in bytes per second using decimal units, e.g. "1.5 MB/s"
+func formatRate(rate float64) string {
+	return fmt.Sprintf("%s/s", formatByteSize(int64(rate)))
+}
@@ -0,0 +1,78 @@
+package bucketsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransferProgress(t *testing.T) {
+	p := startProgress("abc", "photos", directionUpload, "/srv/photos/big.raw", 1000)
+	defer p.finish()
+	start := p.started
+
+	// Backdate the window so that the next write completes it
+	p.windowStart = start.Add(-2 * progressWindow)
+	_, _ = p.Write(make([]byte, 400))
+	if p.rate <= 0 {
+		t.Fatal("rate not measured once a window completed")
+	}
+	p.rate = 100
+
+	s := p.status(p.windowStart.Add(progressWindow / 2))
+	if s.Bytes != 400 || s.Size != 1000 {
+		t.Errorf("status() = %d of %d bytes", s.Bytes, s.Size)
+	}
+	if s.ETA != "6s" {
+		t.Errorf("ETA = %q, want 6s at 100 B/s with 600 bytes left", s.ETA)
+	}
+
+	// A stalled transfer has no current rate, so the ETA falls back to the average rate
+	s = p.status(p.windowStart.Add(10 * progressWindow))
+	if s.Rate != 0 {
+		t.Errorf("stalled transfer's rate = %v, want 0", s.Rate)
+	}
+	if s.AverageRate <= 0 || s.ETA == "" {
+		t.Errorf("stalled transfer: average rate %v, ETA %q", s.AverageRate, s.ETA)
+	}
+
+	p.restart()
+	if s := p.status(time.Now()); s.Bytes != 0 {
+		t.Errorf("bytes after restart = %d, want 0", s.Bytes)
+	}
+
+	if active := workflowProgress("photos"); len(active) != 1 || active[0].ID != "abc" {
+		t.Errorf("workflowProgress() = %+v", active)
+	}
+	p.finish()
+	if active := workflowProgress("photos"); len(active) != 0 {
+		t.Errorf("finished transfer still listed: %+v", active)
+	}
+}
+
+func TestPrintActiveTransfers(t *testing.T) {
+	statuses := []workflowStatus{
+		{Name: "photos", Active: []transferProgressStatus{
+			{Direction: directionUpload, Name: "big.raw", Bytes: 250_000, Size: 1_000_000, Rate: 50_000, ETA: "15s"},
+		}},
+		{Name: "scans", Active: []transferProgressStatus{
+			{Direction: directionDownload, Name: "scan.pdf", Bytes: 1000},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := printActiveTransfers(&buf, statuses); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"IN PROGRESS", "250.0 kB of 1.0 MB (25%)", "50.0 kB/s", "15s", "scan.pdf"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	if err := printActiveTransfers(&buf, []workflowStatus{{Name: "idle"}}); err != nil || buf.Len() != 0 {
+		t.Errorf("printed %q with no transfers in progress", buf.String())
+	}
+}
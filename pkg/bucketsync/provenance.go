@@ -0,0 +1,37 @@
+package bucketsync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// provenanceMetadata returns the metadata tracing an uploaded object back to the host, file,
+// workflow and build it came from. Characters which HTTP headers cannot carry are
+// percent-encoded.
+func provenanceMetadata(o Outbound, name string, fi os.FileInfo) map[string]string {
+	hostname, _ := os.Hostname()
+	return map[string]string{
+		"Origin-Host":         hostname,
+		"Origin-Path":         headerSafe(name),
+		"Origin-Mtime":        fi.ModTime().UTC().Format(time.RFC3339),
+		"Workflow":            headerSafe(o.Name),
+		"Bucketsyncd-Version": version,
+	}
+}
+
+// headerSafe percent-encodes the bytes of s outside printable ASCII, and percent signs so that
+// the encoding can be reversed
+func headerSafe(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvenanceMetadata(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "café 100%.csv")
+	if err := os.WriteFile(name, []byte("a,b"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2026, 10, 16, 7, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+
+	md := provenanceMetadata(Outbound{Name: "exports"}, name, fi)
+	want := map[string]string{
+		"Origin-Host":         hostname,
+		"Origin-Path":         filepath.Join(filepath.Dir(name), "caf%C3%A9 100%25.csv"),
+		"Origin-Mtime":        "2026-10-16T07:30:00Z",
+		"Workflow":            "exports",
+		"Bucketsyncd-Version": version,
+	}
+	for k, v := range want {
+		if md[k] != v {
+			t.Errorf("%s = %q, want %q", k, md[k], v)
+		}
+	}
+
+	off := false
+	if (Outbound{Provenance: &off}).RecordsProvenance() || !(Outbound{}).RecordsProvenance() {
+		t.Error("provenance not recorded by default only")
+	}
+}
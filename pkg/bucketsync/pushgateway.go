@@ -0,0 +1,95 @@
+package bucketsync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// defaultPushgatewayJob is the job one-shot runs' metrics are grouped under, unless job is set
+const defaultPushgatewayJob = "bucketsyncd"
+
+// Pushgateway is where one-shot runs push their metrics before exiting, since there is no
+// long-lived process to scrape
+type Pushgateway struct {
+	URL string `yaml:"url"`
+	Job string `yaml:"job"`
+	// Grouping labels are added to the job's grouping key, e.g. the host or environment
+	Grouping map[string]string `yaml:"grouping"`
+}
+
+func (p Pushgateway) validate() error {
+	if p.URL == "" {
+		return nil
+	}
+	return validateHTTPURL(p.URL)
+}
+
+// pushRunMetrics pushes the results of a one-shot run, with the transfer histograms recorded
+// during it, replacing whatever the previous run pushed under the same grouping key
+func pushRunMetrics(p Pushgateway, results []syncOnceResult, duration time.Duration, finished time.Time) error {
+	labels := []string{"workflow", "direction"}
+	files := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "run_files",
+		Help:      "Files transferred by the last one-shot run.",
+	}, labels)
+	bytes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "run_bytes",
+		Help:      "Bytes transferred by the last one-shot run.",
+	}, labels)
+	failures := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "run_failures",
+		Help:      "Transfers which failed in the last one-shot run.",
+	}, labels)
+	runDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "run_duration_seconds",
+		Help:      "Time taken by the last one-shot run.",
+	})
+	lastSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "run_last_success_timestamp_seconds",
+		Help:      "When the last one-shot run without failures finished.",
+	})
+
+	failed := 0
+	for _, r := range results {
+		files.WithLabelValues(r.workflow, r.direction).Set(float64(r.files))
+		bytes.WithLabelValues(r.workflow, r.direction).Set(float64(r.bytes))
+		failures.WithLabelValues(r.workflow, r.direction).Set(float64(r.failed))
+		failed += r.failed
+	}
+	runDuration.Set(duration.Seconds())
+
+	job := p.Job
+	if job == "" {
+		job = defaultPushgatewayJob
+	}
+	pusher := push.New(p.URL, job).
+		Gatherer(metricsRegistry).
+		Collector(files).
+		Collector(bytes).
+		Collector(failures).
+		Collector(runDuration)
+	for name, value := range p.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	// A failed run leaves the last success's time as it was, by not replacing it
+	if failed == 0 {
+		lastSuccess.Set(float64(finished.Unix()))
+		pusher = pusher.Collector(lastSuccess)
+		if err := pusher.Push(); err != nil {
+			return fmt.Errorf("failed to push metrics: %w", err)
+		}
+		return nil
+	}
+	if err := pusher.Add(); err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	return nil
+}
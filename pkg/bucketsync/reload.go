@@ -0,0 +1,71 @@
+package bucketsync
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// reloadMutex makes reloads take turns, so that no workflow is started by two at once
+	reloadMutex sync.Mutex
+	// reloadStarted names the workflows started by reloads, as inbound, replicate and sync
+	// workflows only register once their goroutine runs
+	reloadStarted = map[string]bool{}
+)
+
+// reloadConfig reads the configuration file again and runs with it, starting the workflows it
+// enables which are not running yet, such as those staged with `enabled: false` and since
+// switched on. Changes to running workflows, disabling them included, take effect once the
+// daemon is restarted. A file which cannot be read or is invalid is refused, leaving the
+// daemon as it was. It returns the names of the workflows started.
+func reloadConfig() ([]string, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	if *configFilePath == "" {
+		return nil, errors.New("no configuration file to reload")
+	}
+	cfg, err := loadConfig(*configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	configMutex.Lock()
+	config = cfg
+	configMutex.Unlock()
+	setLogSecrets(cfg.secrets())
+
+	started := []string{}
+	start := func(name string, enabled bool, run func()) {
+		if _, running := findWorkflow(name); running || reloadStarted[name] || !enabled {
+			return
+		}
+		run()
+		reloadStarted[name] = true
+		started = append(started, name)
+	}
+	for _, o := range cfg.Outbound {
+		start(o.Name, o.IsEnabled(), func() { outbound(o) })
+	}
+	for _, in := range cfg.Inbound {
+		start(in.Name, in.IsEnabled(), func() { inbound(in) })
+	}
+	for _, r := range cfg.Replicate {
+		start(r.Name, r.IsEnabled(), func() { replicate(r) })
+	}
+	for _, t := range cfg.Sync {
+		start(t.Name, t.IsEnabled(), func() { twoWay(t) })
+	}
+	log.WithField("started", started).Info("configuration reloaded")
+	return started, nil
+}
+
+// handleReloadSignals reloads the configuration each time reloads receives a signal
+func handleReloadSignals(reloads <-chan os.Signal) {
+	for range reloads {
+		if _, err := reloadConfig(); err != nil {
+			log.Error("failed to reload configuration: ", err)
+		}
+	}
+}
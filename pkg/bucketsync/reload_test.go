@@ -0,0 +1,118 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useReloadConfig points the configuration file at one written with contents, restoring the
+// running configuration and the workflows started by reloads when the test ends
+func useReloadConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	originalPath := *configFilePath
+	configMutex.RLock()
+	originalConfig := config
+	configMutex.RUnlock()
+	reloadMutex.Lock()
+	originalStarted := reloadStarted
+	reloadStarted = map[string]bool{}
+	reloadMutex.Unlock()
+	t.Cleanup(func() {
+		*configFilePath = originalPath
+		configMutex.Lock()
+		config = originalConfig
+		configMutex.Unlock()
+		reloadMutex.Lock()
+		reloadStarted = originalStarted
+		reloadMutex.Unlock()
+	})
+	*configFilePath = path
+	return path
+}
+
+func reloadTestConfig(dir string, enabled bool) string {
+	state := "false"
+	if enabled {
+		state = "true"
+	}
+	return `outbound:
+  - name: staged
+    source: ` + filepath.Join(dir, "*.txt") + `
+    destination: s3://localhost:9000/bucket
+    enabled: ` + state + "\n"
+}
+
+func TestReloadConfigStartsEnabledWorkflows(t *testing.T) {
+	resetOutboundWorkflows(t)
+	resetWorkflows(t)
+	dir := t.TempDir()
+	path := useReloadConfig(t, reloadTestConfig(dir, false))
+	if err := readConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	started, err := reloadConfig()
+	if err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	if len(started) != 0 {
+		t.Errorf("expected a disabled workflow to stay stopped, started %v", started)
+	}
+
+	if err := os.WriteFile(path, []byte(reloadTestConfig(dir, true)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	started, err = reloadConfig()
+	if err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	if len(started) != 1 || started[0] != "staged" {
+		t.Errorf("expected the enabled workflow to start, started %v", started)
+	}
+	if _, ok := findWorkflow("staged"); !ok {
+		t.Error("expected the enabled workflow to be running")
+	}
+
+	// Reloading again leaves the running workflow be
+	started, err = reloadConfig()
+	if err != nil {
+		t.Fatalf("reloadConfig() failed: %v", err)
+	}
+	if len(started) != 0 {
+		t.Errorf("expected nothing more to start, started %v", started)
+	}
+}
+
+func TestReloadConfigRefusesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	path := useReloadConfig(t, reloadTestConfig(dir, false))
+	if err := readConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("outbound:\n  - name: broken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "invalid configuration") {
+		t.Errorf("expected an invalid configuration error, got %v", err)
+	}
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	if len(config.Outbound) != 1 || config.Outbound[0].Name != "staged" {
+		t.Errorf("expected the previous configuration to be kept, got %+v", config.Outbound)
+	}
+}
+
+func TestReloadConfigWithoutFile(t *testing.T) {
+	useReloadConfig(t, "")
+	*configFilePath = ""
+	if _, err := reloadConfig(); err == nil {
+		t.Error("expected reloading without a configuration file to fail")
+	}
+}
@@ -0,0 +1,145 @@
+package bucketsync
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// remoteIndex looks up remotes by name and endpoint. Where several remotes share an endpoint,
+// the first configured is used.
+type remoteIndex struct {
+	byName map[string]Remote
+	// byEndpoint only finds a tenant's remotes for that tenant
+	byEndpoint map[tenantEndpoint]Remote
+}
+
+// tenantEndpoint is an endpoint as seen by a tenant's workflows, or by the others with no tenant
+type tenantEndpoint struct {
+	tenant, endpoint string
+}
+
+func newRemoteIndex(remotes []Remote) *remoteIndex {
+	idx := &remoteIndex{
+		byName:     make(map[string]Remote, len(remotes)),
+		byEndpoint: make(map[tenantEndpoint]Remote, len(remotes)),
+	}
+	for _, r := range remotes {
+		if _, ok := idx.byName[r.Name]; !ok {
+			idx.byName[r.Name] = r
+		}
+		key := tenantEndpoint{r.tenant, r.Endpoint}
+		if _, ok := idx.byEndpoint[key]; !ok {
+			idx.byEndpoint[key] = r
+		}
+	}
+	return idx
+}
+
+// indexRemotes builds the remote lookups, once the configuration is loaded
+func (c *Config) indexRemotes() {
+	c.remotes = newRemoteIndex(c.Remotes)
+}
+
+// remoteLookup returns the index of the configured remotes. configMutex must be held.
+func remoteLookup() *remoteIndex {
+	if config.remotes == nil {
+		// Configurations built in code rather than read from a file are indexed as used
+		return newRemoteIndex(config.Remotes)
+	}
+	return config.remotes
+}
+
+// findRemote returns the configured remote with the given name
+func findRemote(name string) (Remote, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	r, ok := remoteLookup().byName[name]
+	return r, ok
+}
+
+// findRemoteByEndpoint returns the configured remote whose endpoint matches
+func findRemoteByEndpoint(endpoint string) (Remote, bool) {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	r, ok := remoteLookup().byEndpoint[tenantEndpoint{"", endpoint}]
+	return r, ok
+}
+
+// outboundRemote returns the remote an outbound workflow uploads to endpoint with: the one it
+// names, or else the one configured for the endpoint, among its tenant's if it has one
+func outboundRemote(o Outbound, endpoint string) (Remote, bool) {
+	if o.Remote != "" {
+		return findRemote(o.Remote)
+	}
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	r, ok := remoteLookup().byEndpoint[tenantEndpoint{o.tenant, endpoint}]
+	return r, ok
+}
+
+// newMinioClient creates a MinIO client using the remote's endpoint, credentials,
+// connect timeout and connection limit
+func newMinioClient(r Remote) (*minio.Client, error) {
+	transport, err := remoteTransport(remoteTimeouts(r).Connect, r.MaxConnections)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
+	}
+	mc, err := minio.New(r.Endpoint, &minio.Options{
+		Creds:     remoteCredentials(r),
+		Secure:    true,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
+	}
+	return mc, nil
+}
+
+// minioClientKey identifies the client for a remote's endpoint, credentials, connect timeout and
+// connection limit, so that a remote given new settings gets a new client
+type minioClientKey struct {
+	endpoint, accessKey, secretKey          string
+	accessKeyFile, secretKeyFile, vaultPath string
+	iam                                     bool
+	connect                                 time.Duration
+	maxConns                                int
+}
+
+var (
+	minioClientsMutex sync.Mutex
+	minioClients      = make(map[minioClientKey]*minio.Client)
+)
+
+// remoteClientKey returns the key of the remote's client
+func remoteClientKey(r Remote) minioClientKey {
+	return minioClientKey{
+		endpoint:      r.Endpoint,
+		accessKey:     r.AccessKey,
+		secretKey:     r.SecretKey,
+		accessKeyFile: r.AccessKeyFile,
+		secretKeyFile: r.SecretKeyFile,
+		vaultPath:     r.VaultPath,
+		iam:           r.IAM,
+		connect:       remoteTimeouts(r).Connect,
+		maxConns:      r.MaxConnections,
+	}
+}
+
+// remoteClient returns a MinIO client for the remote, shared by every transfer with it
+func remoteClient(r Remote) (*minio.Client, error) {
+	key := remoteClientKey(r)
+	minioClientsMutex.Lock()
+	defer minioClientsMutex.Unlock()
+	if mc, ok := minioClients[key]; ok {
+		return mc, nil
+	}
+	mc, err := newMinioClient(r)
+	if err != nil {
+		return nil, err
+	}
+	minioClients[key] = mc
+	return mc, nil
+}
@@ -0,0 +1,92 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoteIndex(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+	}()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `remotes:
+  - name: primary
+    endpoint: s3.example.com
+    accessKey: first
+  - name: secondary
+    endpoint: s3.example.com
+    accessKey: second
+`
+	if err := os.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config = Config{}
+	if err := readConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if config.remotes == nil {
+		t.Fatal("remotes not indexed when the configuration was read")
+	}
+
+	if r, ok := findRemote("secondary"); !ok || r.AccessKey != "second" {
+		t.Errorf("findRemote(secondary) = %+v, %v", r, ok)
+	}
+	// The first remote with an endpoint is used, not the last
+	if r, ok := findRemoteByEndpoint("s3.example.com"); !ok || r.Name != "primary" {
+		t.Errorf("findRemoteByEndpoint() = %+v, %v; want primary", r, ok)
+	}
+	if _, ok := findRemote("missing"); ok {
+		t.Error("found a remote which is not configured")
+	}
+
+	// Configurations built in code are looked up too
+	config = Config{Remotes: []Remote{{Name: "local", Endpoint: "localhost:9000"}}}
+	if r, ok := findRemoteByEndpoint("localhost:9000"); !ok || r.Name != "local" {
+		t.Errorf("findRemoteByEndpoint() without an index = %+v, %v", r, ok)
+	}
+}
+
+func TestRemoteClient(t *testing.T) {
+	r := Remote{Name: "primary", Endpoint: "s3.example.com", AccessKey: "key", SecretKey: "secret"}
+	first, err := remoteClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := remoteClient(r); again != first {
+		t.Error("remoteClient() created a second client for the same remote")
+	}
+	r.SecretKey = "rotated"
+	if rotated, _ := remoteClient(r); rotated == first {
+		t.Error("remoteClient() reused a client after the credentials changed")
+	}
+}
+
+func TestOutboundRemote(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+	}()
+	config = Config{Remotes: []Remote{
+		{Name: "plain", Endpoint: "s3.example.com"},
+		{Name: "tls", Endpoint: "s3.example.com:443"},
+	}}
+
+	if r, ok := outboundRemote(Outbound{}, "s3.example.com"); !ok || r.Name != "plain" {
+		t.Errorf("by endpoint: outboundRemote() = %+v, %v", r, ok)
+	}
+	if r, ok := outboundRemote(Outbound{Remote: "tls"}, "s3.example.com"); !ok || r.Name != "tls" {
+		t.Errorf("by name: outboundRemote() = %+v, %v; want tls", r, ok)
+	}
+	if _, ok := outboundRemote(Outbound{Remote: "missing"}, "s3.example.com"); ok {
+		t.Error("outboundRemote() fell back to the endpoint for an unknown remote")
+	}
+
+	config.Outbound = []Outbound{{Name: "o", Source: "/tmp/*", Destination: "s3://s3.example.com/b", Remote: "missing"}}
+	if errs := config.Validate(); len(errs) == 0 {
+		t.Error("Validate() accepted an outbound workflow naming an unknown remote")
+	}
+}
@@ -0,0 +1,115 @@
+package bucketsync
+
+import (
+	"os"
+	"time"
+)
+
+// pendingUploads holds back a watched workflow's uploads until each file has been left alone for
+// the workflow's rename window, following files renamed meanwhile by their identity, so that a
+// file written under a temporary name and renamed into place is uploaded once, under its final
+// name. It is only used from the workflow's event loop.
+type pendingUploads struct {
+	window time.Duration
+	// settled receives the names of files whose window may have ended
+	settled chan string
+	// stopped is closed once the event loop returns, releasing timers waiting to send
+	stopped chan struct{}
+	files   map[string]*pendingUpload
+}
+
+// pendingUpload is a file waiting out its window
+type pendingUpload struct {
+	info    os.FileInfo
+	touched time.Time
+	timer   *time.Timer
+}
+
+func newPendingUploads(window time.Duration) *pendingUploads {
+	return &pendingUploads{
+		window:  window,
+		settled: make(chan string),
+		stopped: make(chan struct{}),
+		files:   make(map[string]*pendingUpload),
+	}
+}
+
+// statIdentity stats a file, taking its identity straight away: on Windows it is otherwise only
+// looked up by os.SameFile, by path, which is no longer the file's once it is renamed
+func statIdentity(name string) (os.FileInfo, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	os.SameFile(info, info)
+	return info, nil
+}
+
+// touch starts, or starts again, the window of a file created or written, reporting whether the
+// file is still there. A file pending under another name with the same identity has been renamed
+// to this one, and is no longer uploaded under its old name.
+func (p *pendingUploads) touch(name string) (renamedFrom string, ok bool) {
+	info, err := statIdentity(name)
+	if err != nil {
+		p.forget(name)
+		return "", false
+	}
+	for other, f := range p.files {
+		if other != name && os.SameFile(f.info, info) {
+			p.forget(other)
+			renamedFrom = other
+		}
+	}
+	now := time.Now()
+	if f, ok := p.files[name]; ok {
+		f.info, f.touched = info, now
+		f.timer.Reset(p.window)
+		return renamedFrom, true
+	}
+	p.files[name] = &pendingUpload{info: info, touched: now, timer: time.AfterFunc(p.window, func() {
+		select {
+		case p.settled <- name:
+		case <-p.stopped:
+		}
+	})}
+	return renamedFrom, true
+}
+
+// forget drops a file renamed or removed before its window ended
+func (p *pendingUploads) forget(name string) {
+	if f, ok := p.files[name]; ok {
+		f.timer.Stop()
+		delete(p.files, name)
+	}
+}
+
+// settle takes a file whose window has ended off the pending list, reporting whether it is to be
+// uploaded: it is not if it was touched again since its timer was started, or has gone or been
+// replaced by another file. A file whose size or modification time changed without it being
+// touched, as writes whose events were coalesced or lost leave it, waits out another window.
+func (p *pendingUploads) settle(name string) bool {
+	f, ok := p.files[name]
+	if !ok || time.Since(f.touched) < p.window {
+		return false
+	}
+	info, err := statIdentity(name)
+	if err != nil || !os.SameFile(f.info, info) {
+		delete(p.files, name)
+		return false
+	}
+	if info.Size() != f.info.Size() || !info.ModTime().Equal(f.info.ModTime()) {
+		f.info, f.touched = info, time.Now()
+		f.timer.Reset(p.window)
+		return false
+	}
+	delete(p.files, name)
+	return true
+}
+
+// stop drops every pending file, once the event loop has returned
+func (p *pendingUploads) stop() {
+	close(p.stopped)
+	for name := range p.files {
+		p.forget(name)
+	}
+}
@@ -0,0 +1,176 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenameWindowUploadsOnce(t *testing.T) {
+	resetOutboundWorkflows(t)
+	resetWorkflows(t)
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	o := Outbound{
+		Name:         "atomic",
+		Source:       filepath.Join(dir, "*"),
+		Destination:  strings.Replace(server.URL, "http://", "webdav://", 1) + "/uploads",
+		RenameWindow: 200 * time.Millisecond,
+	}
+	if _, err := startOutbound(o); err != nil {
+		t.Fatal(err)
+	}
+
+	// An atomic writer writes a temporary file in several goes, then renames it into place
+	tmp := filepath.Join(dir, "report.csv.tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a,b\n", "1,2\n", "3,4\n"} {
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "report.csv")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-uploads:
+		if u.path != "/uploads/report.csv" || u.body != "a,b\n1,2\n3,4\n" {
+			t.Errorf("uploaded %q to %s", u.body, u.path)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("renamed file was not uploaded")
+	}
+	select {
+	case u := <-uploads:
+		t.Errorf("unexpected upload of %q to %s", u.body, u.path)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestSettleUploadsOnceComplete(t *testing.T) {
+	resetOutboundWorkflows(t)
+	resetWorkflows(t)
+	server, uploads := newPutRecorder(t)
+	dir := t.TempDir()
+	o := Outbound{
+		Name:        "chunked",
+		Source:      filepath.Join(dir, "*.sql"),
+		Destination: strings.Replace(server.URL, "http://", "webdav://", 1) + "/dumps",
+		Settle:      200 * time.Millisecond,
+	}
+	if _, err := startOutbound(o); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is written in place in several goes, each raising a write event
+	f, err := os.Create(filepath.Join(dir, "dump.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"part 1\n", "part 2\n", "part 3\n"} {
+		if _, err := f.WriteString(part); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case u := <-uploads:
+		if u.body != "part 1\npart 2\npart 3\n" {
+			t.Errorf("uploaded %q, want the complete file", u.body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("file was not uploaded")
+	}
+	select {
+	case u := <-uploads:
+		t.Errorf("unexpected upload of %q to %s", u.body, u.path)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestPendingUploads(t *testing.T) {
+	dir := t.TempDir()
+	p := newPendingUploads(time.Hour)
+	defer p.stop()
+
+	a, b := filepath.Join(dir, "a.tmp"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.touch(a); !ok {
+		t.Fatal("touch() found no file")
+	}
+	if err := os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if from, ok := p.touch(b); !ok || from != a {
+		t.Errorf("touch() = %q, %v, want renamed from %s", from, ok, a)
+	}
+	if _, ok := p.files[a]; ok {
+		t.Error("file still pending under its old name")
+	}
+
+	// A file touched again since its timer started waits for the timer started again
+	if p.settle(b) {
+		t.Error("settle() uploads a file before its window ends")
+	}
+	p.files[b].touched = time.Now().Add(-2 * time.Hour)
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	if p.settle(b) {
+		t.Error("settle() uploads a file removed meanwhile")
+	}
+
+	if _, ok := p.touch(filepath.Join(dir, "missing")); ok {
+		t.Error("touch() found a missing file")
+	}
+}
+
+func TestPendingUploadsWaitForQuiescence(t *testing.T) {
+	dir := t.TempDir()
+	p := newPendingUploads(time.Hour)
+	defer p.stop()
+
+	name := filepath.Join(dir, "dump.sql")
+	if err := os.WriteFile(name, []byte("part 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.touch(name); !ok {
+		t.Fatal("touch() found no file")
+	}
+
+	// A write whose event was lost still holds the upload back for another window
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("part 2\n")
+	_ = f.Close()
+	p.files[name].touched = time.Now().Add(-2 * time.Hour)
+	if p.settle(name) {
+		t.Error("settle() uploads a file written since it was touched")
+	}
+	if _, ok := p.files[name]; !ok {
+		t.Fatal("file written meanwhile is no longer pending")
+	}
+
+	p.files[name].touched = time.Now().Add(-2 * time.Hour)
+	if !p.settle(name) {
+		t.Error("settle() holds back a file left alone for its window")
+	}
+}
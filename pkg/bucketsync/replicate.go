@@ -0,0 +1,526 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultReplicatePollInterval is how often a replicate workflow without a message source lists
+// its source bucket
+const defaultReplicatePollInterval = 5 * time.Minute
+
+// maxCopySize is the largest object S3 copies in a single request; larger objects are copied in parts
+const maxCopySize = 5 << 30
+
+// replicator copies a replicate workflow's objects from one bucket to another
+type replicator struct {
+	r        Replicate
+	from, to *minioStore
+	// fromRemote and toRemote supply the limits, retries, timeouts and circuit breakers
+	fromRemote, toRemote Remote
+}
+
+// newReplicator resolves a replicate workflow's remotes to the buckets it copies between
+func newReplicator(r Replicate) (*replicator, error) {
+	rep := &replicator{r: r}
+	for _, side := range []struct {
+		loc    BucketLocation
+		store  **minioStore
+		remote *Remote
+	}{{r.From, &rep.from, &rep.fromRemote}, {r.To, &rep.to, &rep.toRemote}} {
+		remote, ok := findRemote(side.loc.Remote)
+		if !ok {
+			return nil, fmt.Errorf("no credentials found for remote %q", side.loc.Remote)
+		}
+		mc, err := remoteClient(remote)
+		if err != nil {
+			return nil, err
+		}
+		*side.store = newMinioStore(mc, side.loc.Bucket, remoteTimeouts(remote).Stat)
+		*side.remote = remote
+	}
+	return rep, nil
+}
+
+// serverSide reports whether objects can be copied by the remote itself, without passing through
+// bucketsyncd, which they can when both buckets are reached with the same client
+func (rep *replicator) serverSide() bool {
+	return rep.from.client == rep.to.client
+}
+
+// destinationKey returns the key a source object is copied to, reporting false for keys outside
+// the source prefix
+func (rep *replicator) destinationKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, rep.r.From.Prefix)
+	if !ok {
+		return "", false
+	}
+	return rep.r.To.Prefix + rest, true
+}
+
+// location describes where the workflow copies from and to, for the status command and admin API
+func (rep *replicator) location() string {
+	return rep.fromRemote.Endpoint + "/" + rep.r.From.Bucket + "/" + rep.r.From.Prefix +
+		" to " + rep.toRemote.Endpoint + "/" + rep.r.To.Bucket + "/" + rep.r.To.Prefix
+}
+
+// replicate copies a replicate workflow's objects in the background until shutdown
+func replicate(r Replicate) {
+	go replicateWithContext(serviceCtx, r)
+}
+
+func replicateWithContext(ctx context.Context, r Replicate) {
+	state := registerWorkflow(r.Name, workflowReplicate)
+	state.setThresholds(r.Thresholds)
+
+	setWorkflowLogLevel(r.Name, r.LogLevel)
+	setWorkflowSampling(r.Name, r.Sampling)
+	lf := workflowLogFields(r.Name, r.Labels, log.Fields{
+		"from_bucket": r.From.Bucket,
+		"to_bucket":   r.To.Bucket,
+	})
+	rep, err := newReplicator(r)
+	if err != nil {
+		log.WithFields(lf).Error(err)
+		state.setHealth(healthDegraded)
+		return
+	}
+	state.setLocation(rep.location())
+	state.scan = func() (int, error) {
+		return rep.scan(ctx, lf)
+	}
+	log.WithFields(lf).WithField("server_side", rep.serverSide()).Info("configuring replication for '", r.Description, "'")
+
+	var schedule *cronSchedule
+	if r.Schedule != "" {
+		if schedule, err = parseSchedule(r.Schedule); err != nil {
+			log.WithFields(lf).Error(err)
+			state.setHealth(healthDegraded)
+			return
+		}
+	}
+	if r.Source == "" {
+		if schedule != nil {
+			state.setHealth(healthWatching)
+			runSchedule(ctx, lf, state, schedule, func(ctx context.Context) error {
+				err := rep.sync(ctx, lf)
+				if err != nil {
+					state.setHealth(healthDegraded)
+				} else {
+					state.setHealth(healthWatching)
+				}
+				return err
+			})
+			return
+		}
+		rep.poll(ctx, lf, state)
+		return
+	}
+	u, err := url.Parse(r.Source)
+	if err != nil {
+		log.WithFields(lf).Error("failed to parse AMQP connection string: ", err)
+		state.setHealth(healthDegraded)
+		return
+	}
+	lf = workflowLogFields(r.Name, r.Labels, log.Fields{
+		"from_bucket": r.From.Bucket,
+		"to_bucket":   r.To.Bucket,
+		"source":      u.Redacted(),
+		"exchange":    r.Exchange,
+		"queue":       r.Queue,
+	})
+	if schedule != nil {
+		go runSchedule(ctx, lf, state, schedule, func(ctx context.Context) error {
+			return rep.sync(ctx, lf)
+		})
+	}
+	src := newAMQPSource(lf, r.Source, r.Exchange, r.Queue)
+	breaker := remoteBreaker(rep.toRemote.Endpoint, rep.toRemote.CircuitBreaker)
+	consumeMessages(ctx, lf, state, src, breaker, func(ctx context.Context, m Message) {
+		rep.handleMessage(ctx, lf, src, m)
+	})
+}
+
+// poll copies new and changed objects every poll interval until ctx is done. Only the leader
+// polls, so that replicas don't copy the same objects.
+func (rep *replicator) poll(ctx context.Context, lf log.Fields, state *workflowState) {
+	interval := rep.r.PollInterval
+	if interval == 0 {
+		interval = defaultReplicatePollInterval
+	}
+	state.setHealth(healthWatching)
+	breaker := remoteBreaker(rep.toRemote.Endpoint, rep.toRemote.CircuitBreaker)
+	for {
+		if err := state.waitWhilePaused(ctx); err != nil {
+			break
+		}
+		if err := breaker.wait(ctx); err != nil {
+			break
+		}
+		if isLeader() {
+			if err := rep.sync(ctx, lf); err != nil {
+				log.WithFields(lf).Error("failed to compare buckets: ", err)
+				state.setHealth(healthDegraded)
+			} else {
+				state.setHealth(healthWatching)
+			}
+		}
+		if !sleepContext(ctx, interval) {
+			break
+		}
+	}
+	log.WithFields(lf).Info("replication stopped, no longer polling")
+}
+
+// sync copies every object which is missing from the destination, or differs from it, in turn
+func (rep *replicator) sync(ctx context.Context, lf log.Fields) error {
+	keys, err := rep.changed(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Failures are logged by replicatePolled as they occur
+		_ = rep.replicatePolled(lf, key)
+	}
+	return nil
+}
+
+// scan copies, in the background, every object which polling would copy, returning how many
+// there are
+func (rep *replicator) scan(ctx context.Context, lf log.Fields) (int, error) {
+	keys, err := rep.changed(ctx)
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		for _, key := range keys {
+			// Failures are logged by replicatePolled as they occur
+			_ = rep.replicatePolled(lf, key)
+		}
+	}()
+	return len(keys), nil
+}
+
+// changed lists the source objects which are missing from the destination, differ from it in
+// size, or were modified after it
+func (rep *replicator) changed(ctx context.Context) ([]string, error) {
+	sources, err := rep.from.List(ctx, rep.r.From.Prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source bucket: %w", err)
+	}
+	replicas, err := rep.to.List(ctx, rep.r.To.Prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list destination bucket: %w", err)
+	}
+	existing := make(map[string]StoredObject, len(replicas))
+	for _, obj := range replicas {
+		existing[obj.Key] = obj
+	}
+	var keys []string
+	for _, obj := range sources {
+		key, ok := rep.destinationKey(obj.Key)
+		if !ok || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		replica, found := existing[key]
+		if found && replica.Size == obj.Size && !obj.LastModified.After(replica.LastModified) {
+			continue
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+// replicatePolled copies a single object found by polling, tracing it as a transfer of its own.
+// Failures are logged where they occur.
+func (rep *replicator) replicatePolled(lf log.Fields, key string) (err error) {
+	finish, ok := startTransfer()
+	if !ok {
+		log.WithFields(lf).WithField("key", key).Warn("shutting down, not replicating object")
+		return nil
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx, span := startSpan(contextWithTransferID(context.Background(), id), "replicate.object",
+		attribute.String("workflow", rep.r.Name),
+		attribute.String("key", key),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: rep.r.Name, Bucket: rep.r.From.Bucket, Key: key})
+	done := trackWorkflow(rep.r.Name)
+	defer func() {
+		done(err)
+		if err != nil {
+			emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: rep.r.Name, Bucket: rep.r.From.Bucket, Key: key, Error: err.Error()})
+		}
+		endSpan(span, err)
+	}()
+	return rep.replicateObject(ctx, lf, key)
+}
+
+// handleMessage copies the objects in a single notification from src, acknowledging it once
+// they have all been copied
+func (rep *replicator) handleMessage(ctx context.Context, lf log.Fields, src MessageSource, m Message) {
+	finish, ok := startTransfer()
+	if !ok {
+		if nackErr := src.Nack(m, true); nackErr != nil {
+			log.WithFields(lf).Error("failed to nack message: ", nackErr)
+		}
+		return
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx = contextWithTransferID(ctx, id)
+	if m.Headers != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, m.Headers)
+	}
+	ctx, span := startSpan(ctx, "replicate.message",
+		attribute.String("workflow", rep.r.Name),
+		attribute.String("queue", rep.r.Queue),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: rep.r.Name})
+	done := trackWorkflow(rep.r.Name)
+	var failure error
+	defer func() {
+		done(failure)
+		endSpan(span, failure)
+	}()
+
+	var s3Event S3Event
+	if err := json.Unmarshal(m.Body, &s3Event); err != nil {
+		failure = err
+		emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: rep.r.Name, Error: err.Error()})
+		log.WithFields(lf).Error("failed to parse JSON payload: ", err)
+		// A message which cannot be parsed never will be
+		if nackErr := src.Nack(m, false); nackErr != nil {
+			log.WithFields(lf).Error("failed to nack message: ", nackErr)
+		}
+		return
+	}
+	// Deletions are not replicated, so that the destination can serve as a backup
+	if strings.Contains(s3Event.EventName, "ObjectRemoved") {
+		log.WithFields(lf).Debugf("ignoring event '%s'", s3Event.EventName)
+		rep.ack(ctx, lf, src, m)
+		return
+	}
+
+	for _, record := range s3Event.Records {
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+		if _, ok := rep.destinationKey(key); !ok || record.S3.Bucket.Name != rep.r.From.Bucket {
+			log.WithFields(lf).WithFields(log.Fields{
+				"bucket": record.S3.Bucket.Name,
+				"key":    key,
+			}).Debug("ignoring object outside the source bucket and prefix")
+			continue
+		}
+		emitEvent(LifecycleEvent{
+			Event:      eventQueued,
+			TransferID: id,
+			Workflow:   rep.r.Name,
+			Bucket:     record.S3.Bucket.Name,
+			Key:        key,
+			Size:       int64(record.S3.Object.Size),
+		})
+		err = rep.replicateObject(ctx, lf, key)
+		if errors.Is(err, errObjectNotFound) {
+			// Removed again since the notification, which copying later would not change
+			log.WithFields(lf).WithField("key", key).Warn("source object no longer exists, skipping")
+			continue
+		}
+		if err != nil {
+			failure = err
+			emitEvent(LifecycleEvent{
+				Event:      eventFailed,
+				TransferID: id,
+				Workflow:   rep.r.Name,
+				Bucket:     record.S3.Bucket.Name,
+				Key:        key,
+				Error:      err.Error(),
+			})
+			// Objects already copied are copied again when the message is redelivered
+			if nackErr := src.Nack(m, true); nackErr != nil {
+				log.WithFields(lf).Error("failed to nack message: ", nackErr)
+			}
+			return
+		}
+	}
+	rep.ack(ctx, lf, src, m)
+}
+
+func (rep *replicator) ack(ctx context.Context, lf log.Fields, src MessageSource, m Message) {
+	_, ackSpan := startSpan(ctx, "ack")
+	err := src.Ack(m)
+	endSpan(ackSpan, err)
+	if err != nil {
+		log.WithFields(lf).Error("failed to acknowledge message: ", err)
+		return
+	}
+	emitEvent(LifecycleEvent{Event: eventAcked, TransferID: transferIDFromContext(ctx), Workflow: rep.r.Name})
+}
+
+// replicateObject copies a single object to the destination bucket, by server-side copy where
+// both buckets share a client and by streaming it through otherwise
+func (rep *replicator) replicateObject(ctx context.Context, lf log.Fields, key string) (err error) {
+	destKey, _ := rep.destinationKey(key)
+	ctx, span := startSpan(ctx, "transfer",
+		attribute.String("remote", rep.toRemote.Endpoint),
+		attribute.String("bucket", rep.r.To.Bucket),
+		attribute.String("key", destKey),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	transferID := transferIDFromContext(ctx)
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: transferID,
+		Workflow:   rep.r.Name,
+		Remote:     rep.toRemote.Endpoint,
+		Bucket:     rep.r.To.Bucket,
+		Key:        destKey,
+	})
+	fromTimeouts, toTimeouts := remoteTimeouts(rep.fromRemote), remoteTimeouts(rep.toRemote)
+	fromBreaker := remoteBreaker(rep.fromRemote.Endpoint, rep.fromRemote.CircuitBreaker)
+	toBreaker := remoteBreaker(rep.toRemote.Endpoint, rep.toRemote.CircuitBreaker)
+	serverSide := rep.serverSide()
+	var size int64
+	var checksum string
+	progress := startProgress(transferID, rep.r.Name, directionReplicate, key, 0)
+	defer progress.finish()
+	start := time.Now()
+	err = retryWithBackoff(ctx, transferRetry(rep.r.Retry, rep.toRemote.Retry), func() error {
+		if err := fromBreaker.allow(); err != nil {
+			return err
+		}
+		statCtx, cancel := context.WithTimeout(ctx, fromTimeouts.Stat)
+		defer cancel()
+		info, err := rep.from.Stat(statCtx, key)
+		fromBreaker.record(err)
+		if err != nil {
+			return fmt.Errorf("failed to query source object: %w", err)
+		}
+		size = info.Size
+		progress.setSize(size)
+
+		memory := int64(transferBufferSize)
+		if !serverSide {
+			memory = uploadMemory(size)
+		}
+		job, err := scheduleTransfer(ctx, rep.r.Name, rep.r.Priority, bandwidthLimit(rep.r.MaxBandwidth), rep.toRemote, memory)
+		if err != nil {
+			return err
+		}
+		defer job.release()
+		if err := toBreaker.allow(); err != nil {
+			return err
+		}
+		if serverSide {
+			copyCtx, cancel := context.WithTimeout(ctx, toTimeouts.Upload)
+			defer cancel()
+			err = rep.to.copyFrom(copyCtx, rep.from, key, destKey, size)
+			toBreaker.record(err)
+			if err != nil {
+				return fmt.Errorf("failed to copy object: %w", err)
+			}
+			return nil
+		}
+
+		getCtx, cancelGet := context.WithTimeout(ctx, fromTimeouts.Download)
+		defer cancelGet()
+		obj, _, err := rep.from.Get(getCtx, key)
+		fromBreaker.record(err)
+		if err != nil {
+			return fmt.Errorf("failed to fetch object: %w", err)
+		}
+		defer func() {
+			if err := obj.Close(); err != nil {
+				log.WithFields(lf).Error("failed to close object: ", err)
+			}
+		}()
+		h := sha256.New()
+		progress.restart()
+		r := io.TeeReader(job.throttle(io.LimitReader(obj, size)), io.MultiWriter(h, progress))
+		putCtx, cancelPut := context.WithTimeout(ctx, toTimeouts.Upload)
+		defer cancelPut()
+		err = rep.to.Put(putCtx, destKey, r, size, map[string]string{"Transfer-Id": transferID})
+		toBreaker.record(err)
+		if err == nil {
+			checksum = hex.EncodeToString(h.Sum(nil))
+		}
+		return err
+	})
+
+	rec := TransferRecord{
+		ID:        transferID,
+		Workflow:  rep.r.Name,
+		Direction: directionReplicate,
+		Path:      "s3://" + rep.fromRemote.Endpoint + "/" + rep.r.From.Bucket + "/" + key,
+		Remote:    rep.toRemote.Endpoint,
+		Bucket:    rep.r.To.Bucket,
+		Key:       destKey,
+		Size:      size,
+		Checksum:  checksum,
+		Duration:  time.Since(start),
+		Status:    transferSuccess,
+	}
+	if err != nil {
+		rec.Status, rec.Error = transferFailed, err.Error()
+	}
+	recordTransfer(rec)
+	fields := log.Fields{
+		"key":      key,
+		"dest_key": destKey,
+		"size":     size,
+	}
+	if err != nil {
+		log.WithFields(lf).WithFields(fields).Error("failed to replicate object after retries: ", err)
+		return err
+	}
+	emitEvent(transferEvent(eventReplicated, rec))
+	fields["server_side"] = serverSide
+	fields["throughput"] = formatRate(transferRate(size, rec.Duration))
+	log.WithFields(lf).WithFields(fields).Info("replicated object")
+
+	message := fmt.Sprintf("Replicated %s to %s (transfer %s)", key, rep.r.To.Bucket, transferID)
+	SendNotification("bucketsyncd", message)
+	return nil
+}
+
+// copyFrom copies an object of size bytes from src, on the same endpoint, without the data
+// leaving the remote. Objects too large for a single copy request are copied in parts.
+func (s *minioStore) copyFrom(ctx context.Context, src *minioStore, srcKey, key string, size int64) error {
+	dst := minio.CopyDestOptions{Bucket: s.bucket, Object: key}
+	source := minio.CopySrcOptions{Bucket: src.bucket, Object: srcKey}
+	var err error
+	if size <= maxCopySize {
+		_, err = s.client.CopyObject(ctx, dst, source)
+	} else {
+		_, err = s.client.ComposeObject(ctx, dst, source)
+	}
+	return minioError(err)
+}
@@ -0,0 +1,94 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Retry configures how failed transfers are retried. It can be set globally, per remote and per
+// workflow; each field left unset is taken from the next level out, and finally defaultRetry.
+type Retry struct {
+	// Attempts is how many times a transfer is tried in all
+	Attempts int `yaml:"attempts"`
+	// InitialDelay is the wait before the first retry, doubling for each retry up to MaxDelay
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	MaxDelay     time.Duration `yaml:"max_delay"`
+	// Jitter varies each delay randomly by up to this fraction either way, e.g. 0.2 for ±20%,
+	// so that workflows failing together don't retry in lockstep
+	Jitter float64 `yaml:"jitter"`
+}
+
+var defaultRetry = Retry{
+	Attempts:     3,
+	InitialDelay: time.Second,
+	MaxDelay:     time.Minute,
+}
+
+func (r Retry) validate() error {
+	if r.Attempts < 0 || r.InitialDelay < 0 || r.MaxDelay < 0 {
+		return errors.New("attempts, initial_delay and max_delay must not be negative")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// merge returns r with the fields set in override replacing its own
+func (r Retry) merge(override Retry) Retry {
+	if override.Attempts > 0 {
+		r.Attempts = override.Attempts
+	}
+	if override.InitialDelay > 0 {
+		r.InitialDelay = override.InitialDelay
+	}
+	if override.MaxDelay > 0 {
+		r.MaxDelay = override.MaxDelay
+	}
+	if override.Jitter > 0 {
+		r.Jitter = override.Jitter
+	}
+	return r
+}
+
+// transferRetry resolves the retry settings for a workflow's transfers with a remote
+func transferRetry(workflow, remote Retry) Retry {
+	configMutex.RLock()
+	global := config.Retry
+	configMutex.RUnlock()
+	return defaultRetry.merge(global).merge(remote).merge(workflow)
+}
+
+// delay is the wait before the given retry, counting from zero
+func (r Retry) delay(retry int) time.Duration {
+	d := r.InitialDelay
+	for i := 0; i < retry && (r.MaxDelay == 0 || d < r.MaxDelay); i++ {
+		d *= 2
+	}
+	if r.MaxDelay > 0 && d > r.MaxDelay {
+		d = r.MaxDelay
+	}
+	if r.Jitter > 0 {
+		// #nosec G404 - jitter needs no cryptographic randomness
+		d = time.Duration(float64(d) * (1 + r.Jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// retryWithBackoff calls operation until it succeeds, it has been tried r.Attempts times, ctx is
+// done or the remote's circuit opens, returning the last error
+func retryWithBackoff(ctx context.Context, r Retry, operation func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = operation(); err == nil || attempt+1 >= r.Attempts || errors.Is(err, errCircuitOpen) {
+			return err
+		}
+		select {
+		case <-time.After(r.delay(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryValidate(t *testing.T) {
+	if err := (Retry{Attempts: 10, InitialDelay: 5 * time.Second, MaxDelay: 5 * time.Minute, Jitter: 0.2}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (Retry{Attempts: -1}).validate(); err == nil {
+		t.Error("expected error for negative attempts")
+	}
+	if err := (Retry{Jitter: 1.5}).validate(); err == nil {
+		t.Error("expected error for jitter above 1")
+	}
+}
+
+func TestTransferRetry(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+	}()
+	config = Config{Retry: Retry{Attempts: 10, MaxDelay: 5 * time.Minute}}
+
+	got := transferRetry(Retry{InitialDelay: 10 * time.Second}, Retry{Attempts: 20, Jitter: 0.1})
+	want := Retry{Attempts: 20, InitialDelay: 10 * time.Second, MaxDelay: 5 * time.Minute, Jitter: 0.1}
+	if got != want {
+		t.Errorf("transferRetry() = %+v, want %+v", got, want)
+	}
+	if got := transferRetry(Retry{}, Retry{}); got.InitialDelay != defaultRetry.InitialDelay {
+		t.Errorf("unset initial_delay = %v, want the default", got.InitialDelay)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	r := Retry{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := r.delay(i); got != want {
+			t.Errorf("delay(%d) = %v, want %v", i, got, want)
+		}
+	}
+
+	r.Jitter = 0.2
+	for range 100 {
+		if d := r.delay(0); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("delay with 20%% jitter = %v, want within 0.8s-1.2s", d)
+		}
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	calls := 0
+	err := retryWithBackoff(context.Background(), Retry{Attempts: 4, InitialDelay: time.Millisecond}, func() error {
+		calls++
+		return errors.New("unavailable")
+	})
+	if err == nil || calls != 4 {
+		t.Errorf("got %d calls and error %v, want 4 calls and an error", calls, err)
+	}
+
+	// Cancellation stops retrying without waiting out the delay
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	start := time.Now()
+	_ = retryWithBackoff(ctx, Retry{Attempts: 5, InitialDelay: time.Hour}, func() error {
+		calls++
+		return errors.New("unavailable")
+	})
+	if calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("got %d calls in %v after cancellation, want 1 straight away", calls, time.Since(start))
+	}
+}
@@ -0,0 +1,79 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Sampling bounds the tracing and metrics overhead of a busy workflow
+type Sampling struct {
+	// TraceRatio is the fraction of the workflow's transfers traced, instead of
+	// tracing.sample_ratio; zero traces none of them
+	TraceRatio *float64 `yaml:"trace_ratio,omitempty"`
+	// Histograms set to false stops recording the workflow's transfers in the duration, size
+	// and throughput histograms
+	Histograms *bool `yaml:"histograms,omitempty"`
+}
+
+func (s Sampling) validate() error {
+	if s.TraceRatio != nil && (*s.TraceRatio < 0 || *s.TraceRatio > 1) {
+		return errors.New("trace_ratio: must be between 0 and 1")
+	}
+	return nil
+}
+
+// RecordsHistograms reports whether the workflow's transfers are recorded in the histograms,
+// which they are unless histograms is false
+func (s Sampling) RecordsHistograms() bool {
+	return s.Histograms == nil || *s.Histograms
+}
+
+var (
+	workflowSamplingMutex sync.RWMutex
+	// workflowSampling holds the sampling of workflows which set any
+	workflowSampling = make(map[string]Sampling)
+)
+
+// setWorkflowSampling sets a workflow's sampling, as its transfers' spans and metrics look it up
+// by the workflow's name
+func setWorkflowSampling(name string, s Sampling) {
+	workflowSamplingMutex.Lock()
+	defer workflowSamplingMutex.Unlock()
+	if s == (Sampling{}) {
+		delete(workflowSampling, name)
+	} else {
+		workflowSampling[name] = s
+	}
+}
+
+func getWorkflowSampling(name string) Sampling {
+	workflowSamplingMutex.RLock()
+	defer workflowSamplingMutex.RUnlock()
+	return workflowSampling[name]
+}
+
+// workflowSampler samples traces started for a workflow's transfers at the workflow's
+// trace_ratio, and other traces, or those of workflows without one, as fallback does
+type workflowSampler struct {
+	fallback sdktrace.Sampler
+}
+
+func (s workflowSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, attr := range p.Attributes {
+		if attr.Key != "workflow" {
+			continue
+		}
+		if ratio := getWorkflowSampling(attr.Value.AsString()).TraceRatio; ratio != nil {
+			return sdktrace.TraceIDRatioBased(*ratio).ShouldSample(p)
+		}
+		break
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s workflowSampler) Description() string {
+	return fmt.Sprintf("WorkflowSampler{%s}", s.fallback.Description())
+}
@@ -0,0 +1,65 @@
+package bucketsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSamplingValidate(t *testing.T) {
+	half, over := 0.5, 1.5
+	if err := (Sampling{TraceRatio: &half}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (Sampling{TraceRatio: &over}).validate(); err == nil {
+		t.Error("expected error for a trace_ratio over 1")
+	}
+}
+
+func TestWorkflowSampler(t *testing.T) {
+	none := 0.0
+	setWorkflowSampling("busy", Sampling{TraceRatio: &none})
+	defer setWorkflowSampling("busy", Sampling{})
+
+	sampler := sdktrace.ParentBased(workflowSampler{fallback: sdktrace.AlwaysSample()})
+	params := func(workflow string) sdktrace.SamplingParameters {
+		return sdktrace.SamplingParameters{
+			ParentContext: context.Background(),
+			TraceID:       trace.TraceID{0x01},
+			Name:          "outbound.file",
+			Attributes:    []attribute.KeyValue{attribute.String("workflow", workflow)},
+		}
+	}
+	if got := sampler.ShouldSample(params("busy")).Decision; got != sdktrace.Drop {
+		t.Errorf("busy workflow's trace was kept, decision %v", got)
+	}
+	if got := sampler.ShouldSample(params("quiet")).Decision; got != sdktrace.RecordAndSample {
+		t.Errorf("quiet workflow's trace was dropped, decision %v", got)
+	}
+}
+
+func TestObserveTransferWithoutHistograms(t *testing.T) {
+	transferDuration.Reset()
+	off := false
+	setWorkflowSampling("busy", Sampling{Histograms: &off})
+	defer setWorkflowSampling("busy", Sampling{})
+
+	for _, workflow := range []string{"busy", "quiet"} {
+		observeTransfer(TransferRecord{
+			Workflow:  workflow,
+			Remote:    "minio.example.com",
+			Direction: directionUpload,
+			Size:      2048,
+			Duration:  time.Second,
+			Status:    transferSuccess,
+		})
+	}
+	if n := testutil.CollectAndCount(transferDuration); n != 1 {
+		t.Errorf("expected only the quiet workflow's series, got %d", n)
+	}
+}
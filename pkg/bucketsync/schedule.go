@@ -0,0 +1,192 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduleSearchLimit bounds how far ahead a schedule's next time is looked for, so that one
+// which can never fire, such as the 31st of February, is reported rather than searched forever
+const scheduleSearchLimit = 5 * 366 * 24 * time.Hour
+
+// scheduleDescriptors are the shorthands accepted in place of a cron expression
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// cronSchedule is a parsed five-field cron expression, with each field held as a bit per value
+type cronSchedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day of month or week. As in cron, when both are restricted a
+	// day matching either runs.
+	domAny, dowAny bool
+}
+
+// parseSchedule parses a cron expression ("minute hour day-of-month month day-of-week") or one of
+// the @daily style shorthands
+func parseSchedule(spec string) (*cronSchedule, error) {
+	expr := strings.TrimSpace(spec)
+	if d, ok := scheduleDescriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	const cronFields = 5
+	if len(fields) != cronFields {
+		return nil, fmt.Errorf("%q: expected 5 fields (minute hour day-of-month month day-of-week)", spec)
+	}
+	s := &cronSchedule{spec: spec, domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("%q: minute: %w", spec, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("%q: hour: %w", spec, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("%q: day of month: %w", spec, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("%q: month: %w", spec, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("%q: day of week: %w", spec, err)
+	}
+	// Sunday is either 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%q: never runs", spec)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps, such as "1-5",
+// "*/15" or "mon,wed,fri", into a bit per value. Names, if given, stand for the values from min.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(first, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(last, min, max, names); err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, fmt.Errorf("invalid range %q", rng)
+				}
+			} else if hasStep {
+				// "5/15" means every 15 from 5
+				hi = max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// cronValue parses a single number or name within min and max
+func cronValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is outside %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// next returns the first time after t that the schedule runs, in t's location, or the zero time
+// if it never does
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(scheduleSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// runSchedule calls run each time the schedule comes round until ctx is done. Runs falling while
+// the workflow is paused, or while this instance is not the leader, are skipped.
+func runSchedule(ctx context.Context, lf log.Fields, state *workflowState, s *cronSchedule, run func(context.Context) error) {
+	for {
+		next := s.next(time.Now())
+		if next.IsZero() || !sleepContext(ctx, time.Until(next)) {
+			return
+		}
+		if state.isPaused() {
+			log.WithFields(lf).Info("workflow paused, skipping scheduled run")
+			continue
+		}
+		// Only the leader runs, so that replicas don't transfer the same files
+		if !isLeader() {
+			continue
+		}
+		log.WithFields(lf).WithField("schedule", s.spec).Info("starting scheduled run")
+		if err := run(ctx); err != nil {
+			log.WithFields(lf).Error("scheduled run failed: ", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package bucketsync
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Friday
+	from := time.Date(2026, 10, 16, 14, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 16, 15, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 14, 45, 0, 0, time.UTC)},
+		{"31 14 * * *", time.Date(2026, 10, 16, 14, 31, 0, 0, time.UTC)},
+		{"30 14 * * *", time.Date(2026, 10, 17, 14, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// A restricted day of month and day of week run on either
+		{"0 0 1 * sat", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 12 20-25/2 * *", time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if got := s.next(from); !got.Equal(tt.want) {
+			t.Errorf("%s: next = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"0 2 * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"0 0 0 * *",
+		"* * * 13 *",
+		"0 0 * * fun",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"0 0 31 2 *",
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("%q: parsed", spec)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	unwatched := false
+	cfg := Config{
+		Remotes: []Remote{{Name: "minio", Endpoint: "minio.example.com"}},
+		Outbound: []Outbound{
+			{Name: "nightly", Source: "/tmp/*", Destination: "s3://minio.example.com/bucket", Schedule: "0 2 * * *", Watch: &unwatched},
+			{Name: "unwatched", Source: "/tmp/*", Destination: "s3://minio.example.com/bucket", Watch: &unwatched},
+		},
+		Inbound: []Inbound{
+			{Name: "polled", Remote: "minio", Destination: "/tmp", Schedule: "@hourly", Bucket: "scans"},
+			{Name: "bucketless", Remote: "minio", Destination: "/tmp", Schedule: "@hourly"},
+		},
+		Sync: []TwoWaySync{{
+			Name:         "documents",
+			Local:        "/tmp/documents",
+			Bucket:       BucketLocation{Remote: "minio", Bucket: "documents"},
+			Schedule:     "0 25 * * *",
+			PollInterval: time.Minute,
+		}},
+	}
+	var msgs []string
+	for _, err := range cfg.Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	joined := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		`outbound "unwatched": schedule or checksum_scan is required with watch: false`,
+		`inbound "bucketless": bucket is required with schedule`,
+		`sync "documents": schedule: "0 25 * * *": hour: 25 is outside 0-23`,
+		`sync "documents": poll_interval and schedule cannot both be set`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing error %q in:\n%s", want, joined)
+		}
+	}
+	if len(msgs) != 4 {
+		t.Errorf("Validate returned %d errors, want 4:\n%s", len(msgs), joined)
+	}
+}
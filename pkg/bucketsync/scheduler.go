@@ -0,0 +1,298 @@
+package bucketsync
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// transferScheduler admits transfers from every workflow, keeping within the global
+// max_concurrent_transfers and max_memory, and each remote's own limit. Transfers waiting for a slot are queued
+// per workflow and admitted from each workflow in turn, weighted by the workflows' priorities, so
+// that one busy workflow cannot starve the others. It also divides max_bandwidth between the
+// transfers in progress by the same weights.
+type transferScheduler struct {
+	mu               sync.Mutex
+	active           int
+	activeByRemote   map[string]int
+	activeByWorkflow map[string]int
+	running          map[*transferJob]struct{}
+	// memory is the buffer space reserved by the transfers in progress
+	memory int64
+	queues map[string][]*transferJob
+	// order lists the workflows with queued transfers, the next to be served first
+	order []string
+}
+
+// transferJob is a transfer waiting for, or holding, a slot
+type transferJob struct {
+	ctx         context.Context
+	scheduler   *transferScheduler
+	workflow    string
+	priority    int
+	remote      string
+	limit       int
+	remoteLimit int
+	// memory is the buffer space the transfer needs, within maxMemory if set
+	memory    int64
+	maxMemory int64
+	// bandwidth, remoteBandwidth and workflowBandwidth are the global, remote and workflow limits
+	// in bytes per second, of which the transfer is allocated rate; zero is unlimited
+	bandwidth         int64
+	remoteBandwidth   int64
+	workflowBandwidth int64
+	rate              atomic.Int64
+	// ready is closed once the transfer is admitted
+	ready chan struct{}
+}
+
+var scheduler = newTransferScheduler()
+
+var (
+	transfersActiveGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "transfers_active",
+		Help:      "Number of transfers in progress.",
+	})
+	transfersQueuedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "bucketsyncd",
+		Name:      "transfers_queued",
+		Help:      "Number of transfers waiting for a slot under max_concurrent_transfers.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(transfersActiveGauge, transfersQueuedGauge)
+}
+
+func newTransferScheduler() *transferScheduler {
+	return &transferScheduler{
+		activeByRemote:   make(map[string]int),
+		activeByWorkflow: make(map[string]int),
+		running:          make(map[*transferJob]struct{}),
+		queues:           make(map[string][]*transferJob),
+	}
+}
+
+// scheduleTransfer waits for a slot to transfer to or from remote on behalf of workflow, needing
+// memory bytes of buffers. The transfer should read through the returned job's throttle, and
+// release the job once done. priority weighs the workflow's share of slots and bandwidth, with
+// zero counting as one, and bandwidth caps the workflow's transfers together, if not zero.
+func scheduleTransfer(ctx context.Context, workflow string, priority int, bandwidth int64, remote Remote, memory int64) (*transferJob, error) {
+	configMutex.RLock()
+	limit := config.MaxConcurrentTransfers
+	maxMemory := config.maxMemory()
+	globalBandwidth := config.maxBandwidth()
+	configMutex.RUnlock()
+	if maxMemory > 0 && memory > maxMemory {
+		// A transfer needing more than the whole budget runs alone rather than never
+		memory = maxMemory
+	}
+	job := &transferJob{
+		ctx:               ctx,
+		workflow:          workflow,
+		priority:          priority,
+		remote:            remote.Endpoint,
+		limit:             limit,
+		remoteLimit:       remote.MaxConcurrentTransfers,
+		memory:            memory,
+		maxMemory:         maxMemory,
+		bandwidth:         globalBandwidth,
+		remoteBandwidth:   remote.maxBandwidth(),
+		workflowBandwidth: bandwidth,
+		ready:             make(chan struct{}),
+	}
+	if _, err := scheduler.acquire(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// release frees the job's slot once its transfer is done
+func (j *transferJob) release() {
+	j.scheduler.release(j)
+}
+
+// weight is the job's share of slots and bandwidth relative to other workflows' transfers
+func (j *transferJob) weight() int {
+	if j.priority <= 0 {
+		return 1
+	}
+	return j.priority
+}
+
+func (s *transferScheduler) acquire(ctx context.Context, job *transferJob) (func(), error) {
+	job.scheduler = s
+	release := func() {
+		s.release(job)
+	}
+	s.mu.Lock()
+	if len(s.queues) == 0 && s.fits(job) {
+		s.admit(job)
+		s.mu.Unlock()
+		return release, nil
+	}
+	if _, ok := s.queues[job.workflow]; !ok {
+		s.order = append(s.order, job.workflow)
+	}
+	s.queues[job.workflow] = append(s.queues[job.workflow], job)
+	transfersQueuedGauge.Inc()
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-job.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-job.ready:
+		// Admitted while giving up, so pass the slot on
+		s.releaseLocked(job)
+	default:
+		s.dequeue(job)
+	}
+	return nil, ctx.Err()
+}
+
+func (s *transferScheduler) release(job *transferJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(job)
+}
+
+// releaseLocked frees a slot for the next queued transfer. s.mu must be held.
+func (s *transferScheduler) releaseLocked(job *transferJob) {
+	s.active--
+	s.memory -= job.memory
+	s.activeByRemote[job.remote]--
+	if s.activeByRemote[job.remote] == 0 {
+		delete(s.activeByRemote, job.remote)
+	}
+	s.activeByWorkflow[job.workflow]--
+	if s.activeByWorkflow[job.workflow] == 0 {
+		delete(s.activeByWorkflow, job.workflow)
+	}
+	delete(s.running, job)
+	transfersActiveGauge.Dec()
+	transferMemoryGauge.Set(float64(s.memory))
+	s.dispatch()
+	s.allocate()
+}
+
+// fits reports whether job can start without exceeding a limit. s.mu must be held.
+func (s *transferScheduler) fits(job *transferJob) bool {
+	if job.limit > 0 && s.active >= job.limit {
+		return false
+	}
+	if job.maxMemory > 0 && s.memory+job.memory > job.maxMemory {
+		return false
+	}
+	return job.remoteLimit <= 0 || s.activeByRemote[job.remote] < job.remoteLimit
+}
+
+// admit counts job as started. s.mu must be held.
+func (s *transferScheduler) admit(job *transferJob) {
+	s.active++
+	s.memory += job.memory
+	s.activeByRemote[job.remote]++
+	s.activeByWorkflow[job.workflow]++
+	s.running[job] = struct{}{}
+	transfersActiveGauge.Inc()
+	transferMemoryGauge.Set(float64(s.memory))
+	s.allocate()
+}
+
+// allocate divides the bandwidth limits between the transfers in progress in proportion to their
+// weights. s.mu must be held.
+func (s *transferScheduler) allocate() {
+	var total int
+	byRemote := make(map[string]int)
+	byWorkflow := make(map[string]int)
+	for job := range s.running {
+		total += job.weight()
+		byRemote[job.remote] += job.weight()
+		byWorkflow[job.workflow]++
+	}
+	for job := range s.running {
+		var rate int64
+		if job.bandwidth > 0 {
+			rate = max(job.bandwidth*int64(job.weight())/int64(total), 1)
+		}
+		if job.remoteBandwidth > 0 {
+			share := max(job.remoteBandwidth*int64(job.weight())/int64(byRemote[job.remote]), 1)
+			if rate == 0 || share < rate {
+				rate = share
+			}
+		}
+		if job.workflowBandwidth > 0 {
+			// A workflow's transfers share the same weight, so share its limit equally
+			share := max(job.workflowBandwidth/int64(byWorkflow[job.workflow]), 1)
+			if rate == 0 || share < rate {
+				rate = share
+			}
+		}
+		job.rate.Store(rate)
+	}
+}
+
+// dispatch admits queued transfers while slots are free. Each slot goes to the workflow with the
+// fewest transfers in progress for its weight, and between equals to the one served least
+// recently. A transfer waiting on a busy remote does not hold up other workflows. s.mu must be
+// held.
+func (s *transferScheduler) dispatch() {
+	for {
+		i := -1
+		var least float64
+		for j, workflow := range s.order {
+			job := s.queues[workflow][0]
+			if !s.fits(job) {
+				continue
+			}
+			if share := float64(s.activeByWorkflow[workflow]) / float64(job.weight()); i < 0 || share < least {
+				i, least = j, share
+			}
+		}
+		if i < 0 {
+			return
+		}
+		workflow := s.order[i]
+		job := s.queues[workflow][0]
+		s.admit(job)
+		close(job.ready)
+		transfersQueuedGauge.Dec()
+		s.queues[workflow] = s.queues[workflow][1:]
+		// Serve the other workflows before this one again
+		s.order = slices.Delete(s.order, i, i+1)
+		if len(s.queues[workflow]) == 0 {
+			delete(s.queues, workflow)
+		} else {
+			s.order = append(s.order, workflow)
+		}
+	}
+}
+
+// dequeue removes a transfer which gave up waiting. s.mu must be held.
+func (s *transferScheduler) dequeue(job *transferJob) {
+	queue := s.queues[job.workflow]
+	i := slices.Index(queue, job)
+	if i < 0 {
+		return
+	}
+	transfersQueuedGauge.Dec()
+	if queue = slices.Delete(queue, i, i+1); len(queue) > 0 {
+		s.queues[job.workflow] = queue
+	} else {
+		delete(s.queues, job.workflow)
+		if j := slices.Index(s.order, job.workflow); j >= 0 {
+			s.order = slices.Delete(s.order, j, j+1)
+		}
+	}
+	// A transfer queued behind this one may now be first in line and fit
+	s.dispatch()
+}
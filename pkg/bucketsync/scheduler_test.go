@@ -0,0 +1,244 @@
+package bucketsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestJob(workflow, remote string, limit, remoteLimit int) *transferJob {
+	return &transferJob{workflow: workflow, remote: remote, limit: limit, remoteLimit: remoteLimit, ready: make(chan struct{})}
+}
+
+// acquireAsync starts acquiring a slot, returning a channel given the release function once admitted
+func acquireAsync(ctx context.Context, s *transferScheduler, job *transferJob) <-chan func() {
+	admitted := make(chan func(), 1)
+	go func() {
+		if release, err := s.acquire(ctx, job); err == nil {
+			admitted <- release
+		}
+	}()
+	return admitted
+}
+
+func expectAdmitted(t *testing.T, admitted <-chan func(), what string) func() {
+	t.Helper()
+	select {
+	case release := <-admitted:
+		return release
+	case <-time.After(time.Second):
+		t.Fatalf("%s not admitted", what)
+		return nil
+	}
+}
+
+func expectWaiting(t *testing.T, admitted <-chan func(), what string) {
+	t.Helper()
+	select {
+	case <-admitted:
+		t.Fatalf("%s admitted over the limit", what)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitQueued waits until n transfers are queued
+func waitQueued(t *testing.T, s *transferScheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		queued := 0
+		for _, q := range s.queues {
+			queued += len(q)
+		}
+		s.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d transfers never queued", n)
+}
+
+func TestSchedulerGlobalLimit(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+
+	first := expectAdmitted(t, acquireAsync(ctx, s, newTestJob("a", "s3.example.com", 2, 0)), "first transfer")
+	expectAdmitted(t, acquireAsync(ctx, s, newTestJob("b", "other.example.com", 2, 0)), "second transfer")
+	third := acquireAsync(ctx, s, newTestJob("c", "s3.example.com", 2, 0))
+	expectWaiting(t, third, "third transfer")
+
+	first()
+	expectAdmitted(t, third, "third transfer after a release")
+}
+
+func TestSchedulerRemoteLimit(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+
+	release := expectAdmitted(t, acquireAsync(ctx, s, newTestJob("a", "slow.example.com", 0, 1)), "first transfer")
+	queued := acquireAsync(ctx, s, newTestJob("a", "slow.example.com", 0, 1))
+	expectWaiting(t, queued, "second transfer to the same remote")
+
+	// Another remote is not held up by the busy one
+	expectAdmitted(t, acquireAsync(ctx, s, newTestJob("b", "fast.example.com", 0, 1)), "transfer to another remote")
+
+	release()
+	expectAdmitted(t, queued, "second transfer after a release")
+}
+
+func TestSchedulerServesWorkflowsInTurn(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+	release := expectAdmitted(t, acquireAsync(ctx, s, newTestJob("busy", "s3.example.com", 1, 0)), "first transfer")
+
+	// A busy workflow queues several transfers before a quiet one queues its first
+	var busy []<-chan func()
+	for i := range 3 {
+		busy = append(busy, acquireAsync(ctx, s, newTestJob("busy", "s3.example.com", 1, 0)))
+		waitQueued(t, s, i+1)
+	}
+	quiet := acquireAsync(ctx, s, newTestJob("quiet", "s3.example.com", 1, 0))
+	waitQueued(t, s, 4)
+
+	release()
+	release = expectAdmitted(t, busy[0], "busy workflow's first queued transfer")
+	expectWaiting(t, quiet, "quiet workflow's transfer")
+	release()
+	expectAdmitted(t, quiet, "quiet workflow's transfer ahead of the busy workflow's backlog")
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newTransferScheduler()
+	release := expectAdmitted(t, acquireAsync(context.Background(), s, newTestJob("a", "s3.example.com", 1, 0)), "first transfer")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := newTestJob("b", "s3.example.com", 1, 0)
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.acquire(ctx, job)
+		result <- err
+	}()
+	waitQueued(t, s, 1)
+	cancel()
+	if err := <-result; err == nil {
+		t.Fatal("acquire() succeeded after its context was cancelled")
+	}
+	waitQueued(t, s, 0)
+
+	release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != 0 {
+		t.Errorf("%d transfers active, want none", s.active)
+	}
+}
+
+func TestSchedulerMemoryLimit(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+	job := func(workflow string, memory int64) *transferJob {
+		j := newTestJob(workflow, "s3.example.com", 0, 0)
+		j.memory, j.maxMemory = memory, 64<<20
+		return j
+	}
+
+	release := expectAdmitted(t, acquireAsync(ctx, s, job("a", 48<<20)), "first transfer")
+	large := acquireAsync(ctx, s, job("b", 32<<20))
+	expectWaiting(t, large, "transfer beyond max_memory")
+	expectAdmitted(t, acquireAsync(ctx, s, job("c", 16<<20)), "transfer within max_memory")
+
+	release()
+	expectAdmitted(t, large, "transfer after memory is released")
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+	job := func(workflow string, priority int) *transferJob {
+		j := newTestJob(workflow, "s3.example.com", 4, 0)
+		j.priority = priority
+		return j
+	}
+
+	// The bulk workflow holds every slot and has a backlog when an urgent transfer arrives
+	var releases []func()
+	for range 4 {
+		releases = append(releases, expectAdmitted(t, acquireAsync(ctx, s, job("bulk", 1)), "bulk transfer"))
+	}
+	bulk := acquireAsync(ctx, s, job("bulk", 1))
+	waitQueued(t, s, 1)
+	urgent := acquireAsync(ctx, s, job("urgent", 10))
+	waitQueued(t, s, 2)
+
+	releases[0]()
+	expectAdmitted(t, urgent, "urgent transfer ahead of the bulk backlog")
+	expectWaiting(t, bulk, "bulk transfer")
+}
+
+func TestSchedulerBandwidthShares(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+	job := func(workflow, remote string, priority int) *transferJob {
+		j := newTestJob(workflow, remote, 0, 0)
+		j.priority = priority
+		j.bandwidth, j.remoteBandwidth = 40<<20, 8<<20
+		return j
+	}
+
+	urgent := job("urgent", "s3.example.com", 3)
+	bulk := job("bulk", "s3.example.com", 1)
+	other := job("other", "other.example.com", 0)
+	expectAdmitted(t, acquireAsync(ctx, s, urgent), "urgent transfer")
+	release := expectAdmitted(t, acquireAsync(ctx, s, bulk), "bulk transfer")
+	expectAdmitted(t, acquireAsync(ctx, s, other), "other transfer")
+
+	// The shared remote's 8 MiB/s is split 3:1, within the global 40 MiB/s split 3:1:1
+	if got := urgent.rate.Load(); got != 6<<20 {
+		t.Errorf("urgent rate = %d, want %d", got, 6<<20)
+	}
+	if got := bulk.rate.Load(); got != 2<<20 {
+		t.Errorf("bulk rate = %d, want %d", got, 2<<20)
+	}
+	if got := other.rate.Load(); got != 8<<20 {
+		t.Errorf("other rate = %d, want %d", got, 8<<20)
+	}
+
+	// A finished transfer's share goes to the others
+	release()
+	if got := urgent.rate.Load(); got != 8<<20 {
+		t.Errorf("urgent rate after release = %d, want %d", got, 8<<20)
+	}
+}
+
+func TestSchedulerWorkflowBandwidth(t *testing.T) {
+	s := newTransferScheduler()
+	ctx := context.Background()
+	job := func(workflow string, limit int64) *transferJob {
+		j := newTestJob(workflow, "s3.example.com", 0, 0)
+		j.bandwidth, j.workflowBandwidth = 40<<20, limit
+		return j
+	}
+
+	backup1, backup2 := job("backup", 4<<20), job("backup", 4<<20)
+	invoices := job("invoices", 0)
+	release := expectAdmitted(t, acquireAsync(ctx, s, backup1), "first backup transfer")
+	expectAdmitted(t, acquireAsync(ctx, s, backup2), "second backup transfer")
+	expectAdmitted(t, acquireAsync(ctx, s, invoices), "invoice transfer")
+
+	// The backup workflow's 4 MiB/s is split between its transfers, within their global shares
+	for _, j := range []*transferJob{backup1, backup2} {
+		if got := j.rate.Load(); got != 2<<20 {
+			t.Errorf("backup rate = %d, want %d", got, 2<<20)
+		}
+	}
+	if got := invoices.rate.Load(); got != (40<<20)/3 {
+		t.Errorf("invoices rate = %d, want a third of the global limit", got)
+	}
+
+	release()
+	if got := backup2.rate.Load(); got != 4<<20 {
+		t.Errorf("backup rate after release = %d, want %d", got, 4<<20)
+	}
+}
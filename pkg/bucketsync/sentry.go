@@ -0,0 +1,387 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sentryLogField marks the reporter's own log entries, which are never reported
+const sentryLogField = "sentry"
+
+// Sentry configures reporting of errors and panics to Sentry, or a compatible service such as
+// GlitchTip, so that failures are captured even when nobody is watching the logs
+type Sentry struct {
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+	// Level is the least severe log level reported, error by default
+	Level string `yaml:"level"`
+}
+
+func (c Sentry) validate() error {
+	if _, _, err := parseSentryDSN(c.DSN); err != nil {
+		return err
+	}
+	if c.Level != "" {
+		level, err := log.ParseLevel(c.Level)
+		if err != nil {
+			return err
+		}
+		if level > log.WarnLevel {
+			return errors.New("level must be warning or more severe")
+		}
+	}
+	return nil
+}
+
+// parseSentryDSN splits a DSN such as https://key@o1.ingest.sentry.io/42 into the envelope
+// endpoint and the public key
+func parseSentryDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", errors.New("dsn must be an http(s) URL")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("dsn has no public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return "", "", errors.New("dsn has no project ID")
+	}
+	endpoint = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: path[:i] + "/api/" + path[i+1:] + "/envelope/"}).String()
+	return endpoint, u.User.Username(), nil
+}
+
+// sentryEvent is an event in Sentry's JSON format
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Release     string            `json:"release"`
+	Environment string            `json:"environment,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	LogEntry    *sentryLogEntry   `json:"logentry,omitempty"`
+	Exception   *sentryExceptions `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Contexts    map[string]any    `json:"contexts,omitempty"`
+}
+
+type sentryLogEntry struct {
+	Formatted string `json:"formatted"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+	Mechanism  *sentryMechanism  `json:"mechanism,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryMechanism struct {
+	Type    string `json:"type"`
+	Handled bool   `json:"handled"`
+}
+
+// sentryTags are the log fields reported as tags, so that events can be searched by them;
+// other fields are reported as extra data
+var sentryTags = []string{"workflow", "transfer_id", "webhook", "remote"}
+
+var (
+	sentryMutex    sync.RWMutex
+	sentryReporter *sentryClient
+)
+
+type sentryClient struct {
+	cfg      Sentry
+	endpoint string
+	key      string
+	level    log.Level
+	hostname string
+	client   *http.Client
+	queue    chan sentryEvent
+}
+
+// openSentry starts reporting log entries at the configured level or above, and panics caught by
+// reportPanic. The returned function sends any queued events and stops.
+func openSentry(cfg Sentry) (func(), error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	endpoint, key, _ := parseSentryDSN(cfg.DSN)
+	level := log.ErrorLevel
+	if cfg.Level != "" {
+		level, _ = log.ParseLevel(cfg.Level)
+	}
+	hostname, _ := os.Hostname()
+	c := &sentryClient{
+		cfg:      cfg,
+		endpoint: endpoint,
+		key:      key,
+		level:    level,
+		hostname: hostname,
+		client:   &http.Client{Timeout: defaultWebhookTimeout},
+		queue:    make(chan sentryEvent, webhookQueueSize),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range c.queue {
+			if err := c.send(context.Background(), ev); err != nil {
+				log.WithFields(log.Fields{sentryLogField: true}).Warn("failed to report error to Sentry: ", err)
+			}
+		}
+	}()
+
+	sentryMutex.Lock()
+	sentryReporter = c
+	sentryMutex.Unlock()
+	hook := &sentryHook{client: c}
+	addLogHook(hook)
+
+	return func() {
+		removeLogHook(hook)
+		sentryMutex.Lock()
+		sentryReporter = nil
+		sentryMutex.Unlock()
+		close(c.queue)
+		wg.Wait()
+	}, nil
+}
+
+// sentryHook reports log entries to Sentry
+type sentryHook struct {
+	client *sentryClient
+}
+
+func (h *sentryHook) Levels() []log.Level {
+	return log.AllLevels[:h.client.level+1]
+}
+
+func (h *sentryHook) Fire(entry *log.Entry) error {
+	if _, ok := entry.Data[sentryLogField]; ok {
+		return nil
+	}
+	ev := h.client.event(entry.Level, entry.Data)
+	ev.LogEntry = &sentryLogEntry{Formatted: entry.Message}
+	if err, ok := entry.Data[log.ErrorKey].(error); ok {
+		ev.Exception = &sentryExceptions{Values: []sentryException{{Type: fmt.Sprintf("%T", err), Value: err.Error()}}}
+	}
+	// The process exits straight after fatal entries, so report those before returning
+	if entry.Level <= log.FatalLevel {
+		return h.client.sendNow(ev)
+	}
+	select {
+	case h.client.queue <- ev:
+	default:
+		// Don't let a burst of errors hold up the code logging them
+	}
+	return nil
+}
+
+// event starts an event at level, tagged with the workflow and transfer from fields
+func (c *sentryClient) event(level log.Level, fields log.Fields) sentryEvent {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	ev := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       sentryLevel(level),
+		Platform:    "go",
+		Release:     "bucketsyncd@" + version,
+		Environment: c.cfg.Environment,
+		ServerName:  c.hostname,
+		Tags:        map[string]string{},
+		Extra:       map[string]string{},
+		Contexts: map[string]any{
+			"runtime": map[string]string{"name": "go", "version": runtime.Version()},
+			"os":      map[string]string{"name": runtime.GOOS},
+		},
+	}
+	for k, v := range fields {
+		if slices.Contains(sentryTags, k) {
+			ev.Tags[k] = fmt.Sprint(v)
+		} else {
+			ev.Extra[k] = fmt.Sprint(v)
+		}
+	}
+	return ev
+}
+
+func sentryLevel(level log.Level) string {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return "fatal"
+	case log.ErrorLevel:
+		return "error"
+	case log.WarnLevel:
+		return "warning"
+	case log.InfoLevel:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// reportPanic, when deferred, reports a panic to Sentry with the given log fields before letting
+// it continue. It does nothing unless Sentry is configured.
+func reportPanic(lf log.Fields) {
+	sentryMutex.RLock()
+	c := sentryReporter
+	sentryMutex.RUnlock()
+	if c == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	ev := c.event(log.FatalLevel, lf)
+	ev.Exception = &sentryExceptions{Values: []sentryException{{
+		Type:       "panic",
+		Value:      fmt.Sprint(r),
+		Stacktrace: panicStacktrace(),
+		Mechanism:  &sentryMechanism{Type: "panic", Handled: false},
+	}}}
+	if err := c.sendNow(ev); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to report panic to Sentry:", err)
+	}
+	panic(r)
+}
+
+// panicStacktrace returns the stack of a panicking goroutine from the call to panic outwards, in
+// the oldest-first order Sentry expects
+func panicStacktrace() *sentryStacktrace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(0, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []sentryFrame
+	panicking := false
+	for {
+		f, more := frames.Next()
+		if panicking {
+			stack = append(stack, sentryFrame{
+				Function: f.Function,
+				Filename: f.File,
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    strings.HasPrefix(f.Function, "main.") || strings.HasPrefix(f.Function, "github.com/rossigee/bucketsyncd"),
+			})
+		}
+		panicking = panicking || f.Function == "runtime.gopanic"
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return &sentryStacktrace{Frames: stack}
+}
+
+// sendNow sends an event without queueing, giving up after the request timeout
+func (c *sentryClient) sendNow(ev sentryEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWebhookTimeout)
+	defer cancel()
+	return c.post(ctx, ev)
+}
+
+func (c *sentryClient) send(ctx context.Context, ev sentryEvent) error {
+	delay := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.post(ctx, ev)
+		var statusErr *sentryStatusError
+		if err == nil || attempt >= defaultWebhookRetries || (errors.As(err, &statusErr) && !statusErr.retry()) {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+type sentryStatusError struct {
+	status int
+}
+
+func (e *sentryStatusError) Error() string {
+	return fmt.Sprintf("Sentry returned %d %s", e.status, http.StatusText(e.status))
+}
+
+func (e *sentryStatusError) retry() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= http.StatusInternalServerError
+}
+
+// post sends an event as a Sentry envelope
+func (c *sentryClient) post(ctx context.Context, ev sentryEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]string{
+		"event_id": ev.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	body.Write(header)
+	body.WriteByte('\n')
+	body.Write(item)
+	body.WriteByte('\n')
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("User-Agent", "bucketsyncd/"+version)
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=bucketsyncd/"+version+", sentry_key="+c.key)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return &sentryStatusError{status: resp.StatusCode}
+}
@@ -0,0 +1,119 @@
+package bucketsync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sentryRecorder is a fake Sentry which keeps the events it receives
+type sentryRecorder struct {
+	mu     sync.Mutex
+	auth   []string
+	events []sentryEvent
+}
+
+func (s *sentryRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	lines := bytes.SplitN(body, []byte("\n"), 3)
+	var ev sentryEvent
+	if r.URL.Path != "/api/42/envelope/" || len(lines) != 3 || json.Unmarshal(lines[2], &ev) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = append(s.auth, r.Header.Get("X-Sentry-Auth"))
+	s.events = append(s.events, ev)
+}
+
+func TestParseSentryDSN(t *testing.T) {
+	endpoint, key, err := parseSentryDSN("https://abc123@o1.ingest.sentry.io/42")
+	if err != nil || endpoint != "https://o1.ingest.sentry.io/api/42/envelope/" || key != "abc123" {
+		t.Errorf("parseSentryDSN() = %q, %q, %v", endpoint, key, err)
+	}
+	endpoint, _, _ = parseSentryDSN("https://abc123@glitchtip.example.com/sentry/7")
+	if endpoint != "https://glitchtip.example.com/sentry/api/7/envelope/" {
+		t.Errorf("endpoint with a path prefix = %q", endpoint)
+	}
+	for _, dsn := range []string{"https://o1.ingest.sentry.io/42", "https://abc@o1.ingest.sentry.io/", "ftp://abc@host/1"} {
+		if _, _, err := parseSentryDSN(dsn); err == nil {
+			t.Errorf("parseSentryDSN(%q) succeeded", dsn)
+		}
+	}
+}
+
+func TestSentryReportsErrors(t *testing.T) {
+	rec := &sentryRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	closeSentry, err := openSentry(Sentry{DSN: strings.Replace(srv.URL, "://", "://key@", 1) + "/42", Environment: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	log.WithFields(log.Fields{"workflow": "photos", "transfer_id": "abc"}).WithError(errors.New("access denied")).Error("upload failed")
+	log.WithFields(log.Fields{"workflow": "photos"}).Warn("retrying")
+	closeSentry()
+
+	// Once closed, errors are no longer reported
+	log.Error("after close")
+
+	if len(rec.events) != 1 {
+		t.Fatalf("got %d events, want 1", len(rec.events))
+	}
+	ev := rec.events[0]
+	if ev.Level != "error" || ev.Release != "bucketsyncd@"+version || ev.Environment != "test" || ev.LogEntry.Formatted != "upload failed" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if ev.Tags["workflow"] != "photos" || ev.Tags["transfer_id"] != "abc" {
+		t.Errorf("tags = %v, want the workflow and transfer ID", ev.Tags)
+	}
+	if ev.Exception == nil || ev.Exception.Values[0].Value != "access denied" {
+		t.Errorf("exception = %+v, want the logged error", ev.Exception)
+	}
+	if !strings.Contains(rec.auth[0], "sentry_key=key") {
+		t.Errorf("auth header %q lacks the key", rec.auth[0])
+	}
+}
+
+func TestReportPanic(t *testing.T) {
+	rec := &sentryRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	closeSentry, err := openSentry(Sentry{DSN: strings.Replace(srv.URL, "://", "://key@", 1) + "/42"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer closeSentry()
+
+	recovered := func() (r any) {
+		defer func() {
+			r = recover()
+		}()
+		defer reportPanic(log.Fields{"workflow": "photos"})
+		panic("nil map")
+	}()
+	if recovered != "nil map" {
+		t.Fatalf("panic was not passed on, recovered %v", recovered)
+	}
+
+	if len(rec.events) != 1 {
+		t.Fatalf("got %d events, want 1", len(rec.events))
+	}
+	ev := rec.events[0]
+	if ev.Level != "fatal" || ev.Tags["workflow"] != "photos" || ev.Exception == nil {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+	frames := ev.Exception.Values[0].Stacktrace.Frames
+	if len(frames) == 0 || !strings.HasPrefix(frames[len(frames)-1].Function, "github.com/rossigee/bucketsyncd/pkg/bucketsync.TestReportPanic") {
+		t.Errorf("innermost frame is not the panicking function: %+v", frames)
+	}
+}
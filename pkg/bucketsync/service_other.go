@@ -0,0 +1,18 @@
+//go:build !windows
+
+package bucketsync
+
+import "errors"
+
+// isWindowsService reports whether the process was started by the Windows service control manager
+func isWindowsService() bool {
+	return false
+}
+
+func runWindowsService() {
+	runService()
+}
+
+func controlService(string) error {
+	return errors.New("the service command is only supported on Windows")
+}
@@ -0,0 +1,180 @@
+//go:build windows
+
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	eventLogID         = 1
+	serviceStopTimeout = 30 * time.Second
+)
+
+// isWindowsService reports whether the process was started by the Windows service control manager
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// runWindowsService runs the service under the service control manager, sending log output to the event log
+func runWindowsService() {
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		log.Warn("failed to open event log: ", err)
+	} else {
+		defer func() {
+			_ = elog.Close()
+		}()
+		addLogHook(&eventLogHook{elog: elog})
+	}
+
+	if err := svc.Run(serviceName, windowsService{}); err != nil {
+		log.Fatal("failed to run as a Windows service: ", err)
+	}
+}
+
+// windowsService adapts runService to the service control manager's start/stop protocol
+type windowsService struct{}
+
+func (windowsService) Execute(_ []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	stopped := make(chan struct{})
+	go func() {
+		runService()
+		close(stopped)
+	}()
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s <- svc.Status{State: svc.StopPending}
+				shutdownSignals <- os.Interrupt
+				<-stopped
+				return false, 0
+			}
+		case <-stopped:
+			return false, 0
+		}
+	}
+}
+
+// eventLogHook copies log entries to the Windows event log
+type eventLogHook struct {
+	elog *eventlog.Log
+}
+
+func (h *eventLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *eventLogHook) Fire(entry *log.Entry) error {
+	msg, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.elog.Error(eventLogID, msg)
+	case log.WarnLevel:
+		return h.elog.Warning(eventLogID, msg)
+	default:
+		return h.elog.Info(eventLogID, msg)
+	}
+}
+
+func controlService(action string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+
+	if action == "install" {
+		return installService(m)
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+
+	switch action {
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		return eventlog.Remove(serviceName)
+	case "start":
+		return s.Start()
+	case "stop":
+		return stopService(s)
+	}
+	return fmt.Errorf("unknown service action %q", action)
+}
+
+func installService(m *mgr.Mgr) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if s, err := m.OpenService(serviceName); err == nil {
+		_ = s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, serviceArgs()...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.Close()
+	}()
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+	return nil
+}
+
+// stopService asks the service to stop and waits for it to do so
+func stopService(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the service to stop")
+		}
+		time.Sleep(time.Second)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package bucketsync
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// newFakeSFTP returns a connection to an SFTP server serving root, in which relative paths are
+// resolved
+func newFakeSFTP(t *testing.T, root string) *sftpConnection {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn, sftp.WithServerWorkingDirectory(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve()
+	}()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &sftpConnection{Client: client, conn: server}
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestSFTPPoller(t *testing.T) {
+	root := t.TempDir()
+	dest := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "outbox", "done"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.csv", "b.csv", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(root, "outbox", name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Larger than the reads the client has in flight at once
+	big := make([]byte, 5<<20+1234)
+	rand.New(rand.NewSource(1)).Read(big)
+	if err := os.WriteFile(filepath.Join(root, "outbox", "big.csv"), big, 0600); err != nil {
+		t.Fatal(err)
+	}
+	newPoller := func(after, moveTo string) *sftpPoller {
+		return &sftpPoller{
+			in: Inbound{
+				Name:        "sftp",
+				Destination: dest,
+				SFTP:        SFTPSource{Pattern: "*.csv", After: after, MoveTo: moveTo},
+			},
+			dir:    "outbox",
+			remote: Remote{Name: "sftp.example.com", Endpoint: "sftp.example.com:22"},
+			dial: func() (*sftpConnection, error) {
+				return newFakeSFTP(t, root), nil
+			},
+		}
+	}
+
+	// Kept files are downloaded once, then again only when changed
+	p := newPoller("", "")
+	if err := p.poll(t.Context(), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.csv", "b.csv"} {
+		if got, err := os.ReadFile(filepath.Join(dest, name)); err != nil || string(got) != name {
+			t.Errorf("%s: downloaded %q, %v", name, got, err)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "big.csv")); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("big.csv: downloaded %d bytes, %v, want %d matching", len(got), err, len(big))
+	}
+	if _, err := os.Stat(filepath.Join(dest, "notes.txt")); err == nil {
+		t.Error("notes.txt downloaded despite the pattern")
+	}
+	if files, err := p.changed(); err != nil || len(files) != 0 {
+		t.Errorf("changed after downloading = %+v, %v", files, err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "outbox", "a.csv"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := p.changed(); err != nil || len(files) != 1 || files[0].Name() != "a.csv" {
+		t.Errorf("changed after modifying a.csv = %+v, %v", files, err)
+	}
+	p.close()
+
+	// Moved files are downloaded and moved out of the directory
+	p = newPoller(sftpAfterMove, "done")
+	if err := p.poll(t.Context(), nil); err != nil {
+		t.Fatal(err)
+	}
+	p.close()
+	for _, name := range []string{"a.csv", "b.csv"} {
+		if _, err := os.Stat(filepath.Join(root, "outbox", "done", name)); err != nil {
+			t.Errorf("%s not moved: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "outbox", "notes.txt")); err != nil {
+		t.Errorf("notes.txt moved: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dest, "*"+syncPartSuffix)); len(matches) != 0 {
+		t.Errorf("partial files left behind: %v", matches)
+	}
+}
+
+func TestSFTPValidate(t *testing.T) {
+	cfg := Config{
+		Inbound: []Inbound{
+			{Name: "ok", Destination: "/tmp", SFTP: SFTPSource{URL: "sftp://user@sftp.example.com/outbox", PrivateKey: "/etc/bucketsyncd/id_ed25519", After: "delete"}},
+			{Name: "scheme", Destination: "/tmp", SFTP: SFTPSource{URL: "ftp://user:pw@sftp.example.com/outbox"}},
+			{Name: "credentials", Destination: "/tmp", SFTP: SFTPSource{URL: "sftp://user@sftp.example.com/outbox"}},
+			{Name: "move", Destination: "/tmp", SFTP: SFTPSource{URL: "sftp://user:pw@sftp.example.com/outbox", After: "move"}},
+			{Name: "both", Destination: "/tmp", Source: "amqp://localhost", Queue: "q", SFTP: SFTPSource{URL: "sftp://user:pw@sftp.example.com/"}},
+		},
+	}
+	var msgs []string
+	for _, err := range cfg.Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	want := []string{
+		`inbound "scheme": sftp: url: must be sftp://user@host/path`,
+		`inbound "credentials": sftp: a password or private_key is required`,
+		`inbound "move": sftp: move_to is required with after: move`,
+		`inbound "both": source and sftp cannot both be set`,
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("Validate returned %q, want %q", msgs, want)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Errorf("error %d = %q, want %q", i, msgs[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSFTPPollInterval is how often an SFTP directory is listed
+const defaultSFTPPollInterval = time.Minute
+
+// sftpDialTimeout bounds connecting and logging in to an SFTP server
+const sftpDialTimeout = 30 * time.Second
+
+// What becomes of a file on an SFTP server once downloaded
+const (
+	sftpAfterKeep   = "keep"
+	sftpAfterDelete = "delete"
+	sftpAfterMove   = "move"
+)
+
+func (s SFTPSource) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil || u.Scheme != "sftp" || u.Hostname() == "" {
+		return errors.New("url: must be sftp://user@host/path")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return errors.New("url: user is required")
+	}
+	if _, hasPassword := u.User.Password(); !hasPassword && s.PrivateKey == "" {
+		return errors.New("a password or private_key is required")
+	}
+	if s.Pattern != "" {
+		if _, err := path.Match(s.Pattern, ""); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+	}
+	if s.PollInterval < 0 {
+		return errors.New("poll_interval: must not be negative")
+	}
+	switch s.After {
+	case "", sftpAfterKeep, sftpAfterDelete:
+		if s.MoveTo != "" {
+			return errors.New("move_to can only be used with after: move")
+		}
+	case sftpAfterMove:
+		if s.MoveTo == "" {
+			return errors.New("move_to is required with after: move")
+		}
+	default:
+		return fmt.Errorf("after: must be %s, %s or %s", sftpAfterKeep, sftpAfterDelete, sftpAfterMove)
+	}
+	return nil
+}
+
+// sftpPoller downloads an inbound workflow's files from a directory on an SFTP server. The
+// connection is made when first needed and kept between polls, being made again if it fails.
+type sftpPoller struct {
+	// mu is held while polling, so that scans and polls take turns with the connection
+	mu     sync.Mutex
+	in     Inbound
+	dir    string
+	remote Remote
+	dial   func() (*sftpConnection, error)
+	client *sftpConnection
+}
+
+// sftpConnection is a session with an SFTP server, closing the SSH connection it runs over along
+// with it
+type sftpConnection struct {
+	*sftp.Client
+	conn io.Closer
+}
+
+func (c *sftpConnection) Close() error {
+	err := c.Client.Close()
+	if c.conn != nil {
+		if closeErr := c.conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// newSFTPPoller prepares to poll the workflow's SFTP directory
+func newSFTPPoller(in Inbound) (*sftpPoller, error) {
+	u, err := url.Parse(in.SFTP.URL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "."
+	}
+	p := &sftpPoller{
+		in:  in,
+		dir: dir,
+		// Transfers are scheduled, and the breaker kept, per server as for a remote
+		remote: Remote{Name: u.Hostname(), Endpoint: addr},
+	}
+	p.dial = func() (*sftpConnection, error) {
+		return dialSFTP(addr, u.User, in.SFTP)
+	}
+	return p, nil
+}
+
+// dialSFTP logs in to an SFTP server, checking its host key against the known hosts
+func dialSFTP(addr string, user *url.Userinfo, s SFTPSource) (*sftpConnection, error) {
+	var auth []ssh.AuthMethod
+	if s.PrivateKey != "" {
+		// #nosec G304 - intentional: the key is named by the configuration
+		pem, err := os.ReadFile(s.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		var signer ssh.Signer
+		if s.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(s.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password, ok := user.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+	knownHosts := s.KnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find known_hosts: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to start sftp on %s: %w", addr, err)
+	}
+	return &sftpConnection{Client: client, conn: conn}, nil
+}
+
+// connect returns the connection to the server, making it if need be
+func (p *sftpPoller) connect() (*sftpConnection, error) {
+	if p.client == nil {
+		c, err := p.dial()
+		if err != nil {
+			return nil, err
+		}
+		p.client = c
+	}
+	return p.client, nil
+}
+
+// disconnect drops the connection after a failure, so that the next request makes it afresh
+func (p *sftpPoller) disconnect() {
+	if p.client != nil {
+		_ = p.client.Close()
+		p.client = nil
+	}
+}
+
+// location describes the directory polled, for the status command and admin API
+func (p *sftpPoller) location() string {
+	return "sftp://" + p.remote.Endpoint + path.Join("/", p.dir)
+}
+
+// run polls the directory every poll interval until ctx is done
+func (p *sftpPoller) run(ctx context.Context, lf log.Fields, state *workflowState) {
+	interval := p.in.SFTP.PollInterval
+	if interval == 0 {
+		interval = defaultSFTPPollInterval
+	}
+	state.setHealth(healthWatching)
+	for {
+		if err := state.waitWhilePaused(ctx); err != nil {
+			break
+		}
+		if isLeader() {
+			if err := p.poll(ctx, lf); err != nil {
+				log.WithFields(lf).Error("failed to poll SFTP directory: ", err)
+				state.setHealth(healthDegraded)
+			} else {
+				state.setHealth(healthWatching)
+			}
+		}
+		if !sleepContext(ctx, interval) {
+			break
+		}
+	}
+	log.WithFields(lf).Info("inbound stopped, no longer polling")
+}
+
+// poll downloads, in turn, the files in the directory which are to be downloaded
+func (p *sftpPoller) poll(ctx context.Context, lf log.Fields) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	files, err := p.changed()
+	if err != nil {
+		return err
+	}
+	p.downloadAll(ctx, lf, files)
+	return nil
+}
+
+// scan downloads, in the background, whatever poll would, returning how many files there are
+func (p *sftpPoller) scan(ctx context.Context, lf log.Fields) (int, error) {
+	p.mu.Lock()
+	files, err := p.changed()
+	if err != nil {
+		p.mu.Unlock()
+		return 0, err
+	}
+	go func() {
+		defer p.mu.Unlock()
+		p.downloadAll(ctx, lf, files)
+	}()
+	return len(files), nil
+}
+
+// close drops the connection once polling has stopped
+func (p *sftpPoller) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disconnect()
+}
+
+func (p *sftpPoller) downloadAll(ctx context.Context, lf log.Fields, files []os.FileInfo) {
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		// Failures are logged by download as they occur
+		_ = p.download(lf, f)
+	}
+}
+
+// changed lists the files in the directory matching the workflow's pattern which are to be
+// downloaded. Files left on the server are downloaded only if their local copy is missing,
+// differs in size, or was written before the file was last modified; others are downloaded
+// whenever found, since they are removed once they have been.
+func (p *sftpPoller) changed() ([]os.FileInfo, error) {
+	c, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.ReadDir(p.dir)
+	if err != nil {
+		p.disconnect()
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+	keep := p.in.SFTP.After == "" || p.in.SFTP.After == sftpAfterKeep
+	var files []os.FileInfo
+	for _, f := range entries {
+		// Servers which don't give file types leave them looking like regular files
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if p.in.SFTP.Pattern != "" {
+			if ok, _ := path.Match(p.in.SFTP.Pattern, f.Name()); !ok {
+				continue
+			}
+		}
+		if keep {
+			fi, err := os.Stat(p.in.localPath(f.Name()))
+			if err == nil && fi.Size() == f.Size() && !f.ModTime().After(fi.ModTime()) {
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// download fetches a file from the server, tracing it as a transfer of its own. The file is
+// written alongside its destination, or in the spool folder, and moved once complete and its size checked, so that a
+// failed download leaves no partial file, and only then removed or moved on the server.
+func (p *sftpPoller) download(lf log.Fields, f os.FileInfo) (err error) {
+	in := p.in
+	remotePath := path.Join(p.dir, f.Name())
+	finish, ok := startTransfer()
+	if !ok {
+		log.WithFields(lf).WithField("path", remotePath).Warn("shutting down, not downloading file")
+		return nil
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx, span := startSpan(contextWithTransferID(context.Background(), id), "inbound.sftp",
+		attribute.String("workflow", in.Name),
+		attribute.String("remote", p.remote.Endpoint),
+		attribute.String("key", remotePath),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: in.Name, Remote: p.remote.Endpoint, Key: remotePath})
+	done := trackWorkflow(in.Name)
+	defer func() {
+		done(err)
+		if err != nil {
+			emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: in.Name, Remote: p.remote.Endpoint, Key: remotePath, Error: err.Error()})
+			log.WithFields(lf).WithField("path", remotePath).Error("failed to download file: ", err)
+		}
+		endSpan(span, err)
+	}()
+
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: id,
+		Workflow:   in.Name,
+		Remote:     p.remote.Endpoint,
+		Key:        remotePath,
+		Size:       f.Size(),
+	})
+	localFilename := in.localPath(f.Name())
+	partPath := localFilename + syncPartSuffix
+	if in.Spool != "" {
+		partPath = in.spoolPath(id)
+	}
+	var size int64
+	var checksum string
+	progress := startProgress(id, in.Name, directionDownload, localFilename, f.Size())
+	defer progress.finish()
+	start := time.Now()
+	breaker := remoteBreaker(p.remote.Endpoint, p.remote.CircuitBreaker)
+	err = retryWithBackoff(ctx, transferRetry(in.Retry, Retry{}), func() (err error) {
+		job, err := scheduleTransfer(ctx, in.Name, in.Priority, bandwidthLimit(in.MaxBandwidth), p.remote, transferBufferSize)
+		if err != nil {
+			return err
+		}
+		defer job.release()
+		if err := breaker.allow(); err != nil {
+			return err
+		}
+		defer func() {
+			breaker.record(err)
+		}()
+		c, err := p.connect()
+		if err != nil {
+			return err
+		}
+		// The file may have changed since the directory was listed
+		remote, err := c.Open(remotePath)
+		if err != nil {
+			p.disconnect()
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer func() {
+			_ = remote.Close()
+		}()
+		stat, err := remote.Stat()
+		if err != nil {
+			p.disconnect()
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		progress.setSize(stat.Size())
+		progress.restart()
+		const filePerms = 0600
+		// #nosec G304 - intentional: path is within the configured destination
+		local, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerms)
+		if err != nil {
+			return fmt.Errorf("failed to create local file: %w", err)
+		}
+		// The client reads ahead as it writes what it has read, so it is piped through the
+		// throttle
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := remote.WriteTo(pw)
+			pw.CloseWithError(err)
+		}()
+		h := sha256.New()
+		n, err := copyBuffered(io.MultiWriter(local, h, progress), job.throttle(pr))
+		_ = pr.CloseWithError(err)
+		if closeErr := local.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			p.disconnect()
+			return fmt.Errorf("failed to copy file: %w", err)
+		}
+		if n != stat.Size() {
+			// It is still being written
+			return fmt.Errorf("read %d bytes of %s, which is %d bytes", n, remotePath, stat.Size())
+		}
+		size, checksum = n, hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err == nil {
+		err = moveIntoPlace(partPath, localFilename)
+	}
+	if err != nil {
+		_ = os.Remove(partPath)
+	}
+	kept := true
+	if err == nil && in.ProcessWith != "" {
+		kept, err = processDownload(ctx, lf, in, remotePath, localFilename)
+	}
+
+	rec := TransferRecord{
+		ID:        id,
+		Workflow:  in.Name,
+		Direction: directionDownload,
+		Path:      localFilename,
+		Remote:    p.remote.Endpoint,
+		Key:       remotePath,
+		Size:      size,
+		Checksum:  checksum,
+		Duration:  time.Since(start),
+		Status:    transferSuccess,
+	}
+	if err != nil {
+		rec.Status, rec.Error, rec.Checksum = transferFailed, err.Error(), ""
+		recordTransfer(rec)
+		return err
+	}
+	// A file the processor discarded has still been dealt with, so it is removed or moved too
+	p.afterDownload(lf, remotePath)
+	if !kept {
+		return nil
+	}
+	recordTransfer(rec)
+	emitEvent(transferEvent(eventDownloaded, rec))
+	log.WithFields(lf).WithFields(log.Fields{
+		"path":       remotePath,
+		"filename":   localFilename,
+		"size":       size,
+		"throughput": formatRate(transferRate(size, rec.Duration)),
+	}).Info("retrieved remote file to local file")
+
+	message := fmt.Sprintf("Downloaded %s (transfer %s)", f.Name(), id)
+	SendNotification("bucketsyncd", message)
+	return nil
+}
+
+// afterDownload removes or moves a downloaded file on the server, as the workflow asks. A failure
+// is only logged, since the file has been downloaded, and it is downloaded again on the next poll.
+func (p *sftpPoller) afterDownload(lf log.Fields, remotePath string) {
+	c, err := p.connect()
+	if err != nil {
+		log.WithFields(lf).Error("failed to reconnect to SFTP server: ", err)
+		return
+	}
+	switch p.in.SFTP.After {
+	case sftpAfterDelete:
+		err = c.Remove(remotePath)
+	case sftpAfterMove:
+		moveTo := p.in.SFTP.MoveTo
+		if !path.IsAbs(moveTo) {
+			moveTo = path.Join(p.dir, moveTo)
+		}
+		err = c.Rename(remotePath, path.Join(moveTo, path.Base(remotePath)))
+	}
+	if err != nil {
+		log.WithFields(lf).WithField("path", remotePath).Errorf("failed to %s file on SFTP server: %s", p.in.SFTP.After, err)
+	}
+}
+
+// sftpInbound polls the workflow's SFTP directory, on its schedule if it has one, until ctx is done
+func sftpInbound(ctx context.Context, lf log.Fields, state *workflowState, in Inbound, schedule *cronSchedule) {
+	p, err := newSFTPPoller(in)
+	if err != nil {
+		log.WithFields(lf).Error("failed to parse SFTP URL: ", err)
+		state.setHealth(healthDegraded)
+		return
+	}
+	defer p.close()
+	lf = workflowLogFields(in.Name, in.Labels, log.Fields{"source": p.location()})
+	state.setLocation(p.location())
+	state.scan = func() (int, error) {
+		return p.scan(ctx, lf)
+	}
+	log.WithFields(lf).Info("configuring SFTP downloads for '", in.Description, "'")
+	if schedule == nil {
+		p.run(ctx, lf, state)
+		return
+	}
+	state.setHealth(healthWatching)
+	runSchedule(ctx, lf, state, schedule, func(ctx context.Context) error {
+		err := p.poll(ctx, lf)
+		if err != nil {
+			state.setHealth(healthDegraded)
+		} else {
+			state.setHealth(healthWatching)
+		}
+		return err
+	})
+}
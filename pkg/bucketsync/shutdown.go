@@ -0,0 +1,62 @@
+package bucketsync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultDrainTimeout is how long shutdown waits for in-flight transfers unless drain_timeout is set
+const defaultDrainTimeout = 30 * time.Second
+
+var (
+	// serviceCtx is cancelled when shutdown begins, stopping workflows taking on more work
+	serviceCtx, stopAccepting = context.WithCancel(context.Background())
+
+	drainMutex sync.Mutex
+	draining   bool
+	inFlight   int
+	// drained is closed once draining has begun and nothing is in flight
+	drained chan struct{}
+)
+
+// startTransfer counts a file or message as in flight until the returned function is called,
+// reporting false instead once shutdown has begun
+func startTransfer() (func(), bool) {
+	drainMutex.Lock()
+	defer drainMutex.Unlock()
+	if draining {
+		return nil, false
+	}
+	inFlight++
+	return func() {
+		drainMutex.Lock()
+		defer drainMutex.Unlock()
+		inFlight--
+		if draining && inFlight == 0 {
+			close(drained)
+		}
+	}, true
+}
+
+// drain stops new files and messages being taken on and waits up to timeout for those in flight,
+// reporting whether they all finished
+func drain(timeout time.Duration) bool {
+	drainMutex.Lock()
+	draining = true
+	drained = make(chan struct{})
+	if inFlight == 0 {
+		close(drained)
+	}
+	finished := drained
+	drainMutex.Unlock()
+	stopAccepting()
+	closeOutboundWorkflows()
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package bucketsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// resetDrain lets a test drain without stopping the workflows of the tests after it
+func resetDrain(t *testing.T) {
+	t.Helper()
+	resetOutboundWorkflows(t)
+	t.Cleanup(func() {
+		drainMutex.Lock()
+		draining = false
+		drainMutex.Unlock()
+		serviceCtx, stopAccepting = context.WithCancel(context.Background())
+	})
+}
+
+func TestDrainWaitsForTransfers(t *testing.T) {
+	resetDrain(t)
+	finish, ok := startTransfer()
+	if !ok {
+		t.Fatal("transfer refused before shutdown")
+	}
+
+	result := make(chan bool)
+	go func() {
+		result <- drain(time.Second)
+	}()
+	select {
+	case <-serviceCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("service context not cancelled when draining")
+	}
+	if _, ok := startTransfer(); ok {
+		t.Error("transfer started while draining")
+	}
+
+	finish()
+	if !<-result {
+		t.Error("drain() = false, want true once in-flight transfers finish")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	resetDrain(t)
+	finish, _ := startTransfer()
+	defer finish()
+
+	start := time.Now()
+	if drain(20 * time.Millisecond) {
+		t.Error("drain() = true with a transfer still in flight")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("drain took %v, want about the timeout", elapsed)
+	}
+}
@@ -0,0 +1,96 @@
+package bucketsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// spoolSuffix ends the names of partial downloads in a spool folder
+const spoolSuffix = ".part"
+
+// spoolPrefix begins the names of a workflow's partial downloads in its spool folder, so that
+// workflows may share one without cleaning up each other's downloads
+func spoolPrefix(workflow string) string {
+	h := sha256.Sum256([]byte(workflow))
+	return "bucketsyncd-" + hex.EncodeToString(h[:4]) + "-"
+}
+
+// spoolPath returns the path a transfer's download is staged at in the workflow's spool folder
+func (in Inbound) spoolPath(transferID string) string {
+	return filepath.Join(in.Spool, spoolPrefix(in.Name)+transferID+spoolSuffix)
+}
+
+// prepareSpool creates the workflow's spool folder, removing the partial downloads an earlier
+// run left in it
+func prepareSpool(lf log.Fields, in Inbound) error {
+	const dirPerms = 0700
+	if err := os.MkdirAll(in.Spool, dirPerms); err != nil {
+		return fmt.Errorf("failed to create spool folder: %w", err)
+	}
+	orphans, err := filepath.Glob(filepath.Join(in.Spool, spoolPrefix(in.Name)+"*"+spoolSuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range orphans {
+		if err := os.Remove(name); err != nil {
+			log.WithFields(lf).WithField("name", name).Warn("failed to remove partial download: ", err)
+			continue
+		}
+		log.WithFields(lf).WithField("name", name).Info("removed partial download left by an earlier run")
+	}
+	return nil
+}
+
+// moveIntoPlace moves a complete download from the spool to its destination. Where they are on
+// different file systems, it is copied alongside the destination under a hidden name first, so
+// that the file still appears there complete.
+func moveIntoPlace(spooled, dest string) error {
+	renameErr := os.Rename(spooled, dest)
+	if renameErr == nil {
+		return nil
+	}
+	staged := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+spoolSuffix)
+	if err := copyFile(spooled, staged); err != nil {
+		_ = os.Remove(staged)
+		return fmt.Errorf("failed to move download into place: %w", errors.Join(renameErr, err))
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		_ = os.Remove(staged)
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	return os.Remove(spooled)
+}
+
+// copyFile copies the file at src to dst, syncing it to disk
+func copyFile(src, dst string) error {
+	// #nosec G304 - the path is in the workflow's configured spool folder
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	const filePerms = 0600
+	// #nosec G304 - the path is alongside the file's configured destination
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerms)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,120 @@
+package bucketsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpooledDownload(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	s3, mc := newMemoryS3(t)
+	s3.objects["/bucket/reports/q3.csv"] = []byte("a,b\n1,2\n")
+
+	remote := Remote{Name: "mem", Endpoint: mc.EndpointURL().Host, AccessKey: "key", SecretKey: "secret"}
+	clientKey := remoteClientKey(remote)
+	minioClientsMutex.Lock()
+	minioClients[clientKey] = mc
+	minioClientsMutex.Unlock()
+	defer func() {
+		minioClientsMutex.Lock()
+		delete(minioClients, clientKey)
+		minioClientsMutex.Unlock()
+	}()
+	config = Config{Remotes: []Remote{remote}}
+	config.indexRemotes()
+
+	dir := t.TempDir()
+	in := Inbound{
+		Name:        "spooled",
+		Remote:      "mem",
+		Destination: filepath.Join(dir, "dest"),
+		Spool:       filepath.Join(dir, "spool"),
+	}
+	for _, d := range []string{in.Destination, in.Spool} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := contextWithTransferID(context.Background(), "t1")
+	if err := downloadRecord(ctx, nil, "bucket", "reports/q3.csv", in); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(in.Destination, "q3.csv"))
+	if err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("downloaded %q, %v", data, err)
+	}
+	if entries, _ := os.ReadDir(in.Spool); len(entries) != 0 {
+		t.Errorf("%d files left in the spool", len(entries))
+	}
+
+	// A failed download leaves nothing in the spool, or the destination
+	if err := downloadRecord(ctx, nil, "bucket", "reports/missing.csv", Inbound{
+		Name: in.Name, Remote: in.Remote, Destination: in.Destination, Spool: in.Spool,
+		Retry: Retry{Attempts: 1},
+	}); err == nil {
+		t.Error("missing object downloaded")
+	}
+	if entries, _ := os.ReadDir(in.Spool); len(entries) != 0 {
+		t.Errorf("%d files left in the spool", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(in.Destination, "missing.csv")); !os.IsNotExist(err) {
+		t.Errorf("failed download left a file in the destination: %v", err)
+	}
+}
+
+func TestPrepareSpool(t *testing.T) {
+	spool := filepath.Join(t.TempDir(), "spool")
+	in := Inbound{Name: "reports", Spool: spool}
+	other := Inbound{Name: "invoices", Spool: spool}
+	if err := prepareSpool(nil, in); err != nil {
+		t.Fatal(err)
+	}
+	orphan, theirs := in.spoolPath("abc"), other.spoolPath("def")
+	for _, name := range []string{orphan, theirs} {
+		if err := os.WriteFile(name, []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := prepareSpool(nil, in); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("partial download left by an earlier run not removed")
+	}
+	if _, err := os.Stat(theirs); err != nil {
+		t.Error("another workflow's partial download removed")
+	}
+	if !strings.HasSuffix(orphan, spoolSuffix) || filepath.Dir(orphan) != spool {
+		t.Errorf("spool path %s", orphan)
+	}
+}
+
+func TestMoveIntoPlace(t *testing.T) {
+	dir := t.TempDir()
+	spooled, dest := filepath.Join(dir, "spooled.part"), filepath.Join(dir, "dest", "file.txt")
+	_ = os.WriteFile(spooled, []byte("data"), 0600)
+	_ = os.Mkdir(filepath.Dir(dest), 0700)
+	if err := moveIntoPlace(spooled, dest); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "data" {
+		t.Errorf("moved %q", data)
+	}
+	if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+		t.Error("spooled file left behind")
+	}
+
+	// Neither the rename nor the copy can reach a missing folder, and the download is kept
+	_ = os.WriteFile(spooled, []byte("data"), 0600)
+	if err := moveIntoPlace(spooled, filepath.Join(dir, "missing", "file.txt")); err == nil {
+		t.Error("moved into a missing folder")
+	}
+	if _, err := os.Stat(spooled); err != nil {
+		t.Errorf("spooled file lost: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package bucketsync
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	directionUpload   = "upload"
+	directionDownload = "download"
+	// directionReplicate is a copy between buckets
+	directionReplicate = "replicate"
+
+	transferSuccess = "success"
+	transferFailed  = "failed"
+)
+
+// TransferRecord is a single entry in the transfer state file
+type TransferRecord struct {
+	Time      time.Time `json:"time"`
+	Workflow  string    `json:"workflow"`
+	Direction string    `json:"direction"`
+	Path      string    `json:"path"`
+	Remote    string    `json:"remote"`
+	Bucket    string    `json:"bucket,omitempty"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	ID        string    `json:"transfer_id,omitempty"`
+	// CID is the content identifier of a file added to IPFS
+	CID string `json:"cid,omitempty"`
+
+	// Duration is only used for metrics
+	Duration time.Duration `json:"-"`
+}
+
+var stateMutex sync.Mutex
+
+type transferIDKey struct{}
+
+// newTransferID returns a random identifier which correlates the logs, state record, object metadata
+// and notifications of a single file event or AMQP message
+func newTransferID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func contextWithTransferID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, transferIDKey{}, id)
+}
+
+func transferIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(transferIDKey{}).(string)
+	return id
+}
+
+// withTransferID returns a copy of the log fields which includes the transfer ID
+func withTransferID(lf log.Fields, id string) log.Fields {
+	fields := make(log.Fields, len(lf)+1)
+	maps.Copy(fields, lf)
+	fields["transfer_id"] = id
+	return fields
+}
+
+// recordTransfer updates the transfer metrics and audit trail, and appends the transfer to the state file
+// if one is configured
+func recordTransfer(rec TransferRecord) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	observeTransfer(rec)
+	countCloudWatch(rec)
+	auditTransfer(rec)
+	rememberTransfer(rec)
+	notifyTransfer(rec)
+	pingTransfer(rec)
+
+	configMutex.RLock()
+	stateFile := config.StateFile
+	configMutex.RUnlock()
+	if stateFile == "" {
+		return
+	}
+
+	if err := appendTransferRecord(stateFile, rec); err != nil {
+		log.WithFields(log.Fields{
+			"workflow":   rec.Workflow,
+			"state_file": stateFile,
+		}).Error("failed to record transfer: ", err)
+	}
+}
+
+func appendTransferRecord(path string, rec TransferRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	const filePerms = 0600
+	// #nosec G304 - intentional: path comes from the configuration file
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, filePerms)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readTransferRecords loads every record from a state file
+func readTransferRecords(path string) ([]TransferRecord, error) {
+	// #nosec G304 - intentional: path comes from the configuration file
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var records []TransferRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec TransferRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,91 @@
+package bucketsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRecordTransfer(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+	config = Config{StateFile: stateFile}
+
+	recordTransfer(TransferRecord{Workflow: "out", Direction: directionUpload, Key: "a", Status: transferSuccess})
+	recordTransfer(TransferRecord{Workflow: "in", Direction: directionDownload, Key: "b", Status: transferFailed, Error: "boom"})
+
+	records, err := readTransferRecords(stateFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Workflow != "out" || records[1].Error != "boom" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+	if records[0].Time.IsZero() {
+		t.Error("expected record time to be set")
+	}
+}
+
+func TestRecordTransferDisabled(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+
+	config = Config{}
+	// Should be a no-op without a state file
+	recordTransfer(TransferRecord{Workflow: "out"})
+}
+
+func TestReadTransferRecordsInvalid(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := os.WriteFile(stateFile, []byte("{\"workflow\":\"a\"}\n\nnot json\n"), 0600); err != nil {
+		t.Fatalf("failed to write state file: %v", err)
+	}
+	if _, err := readTransferRecords(stateFile); err == nil {
+		t.Error("expected error for invalid record")
+	}
+}
+
+func TestAppendTransferRecordTime(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+	when := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := appendTransferRecord(stateFile, TransferRecord{Time: when}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records, err := readTransferRecords(stateFile)
+	if err != nil || len(records) != 1 || !records[0].Time.Equal(when) {
+		t.Errorf("unexpected result: %+v, %v", records, err)
+	}
+}
+
+func TestTransferID(t *testing.T) {
+	id := newTransferID()
+	if len(id) != 16 || id == newTransferID() {
+		t.Errorf("expected distinct 16 character IDs, got %q", id)
+	}
+
+	ctx := contextWithTransferID(context.Background(), id)
+	if got := transferIDFromContext(ctx); got != id {
+		t.Errorf("expected %q from context, got %q", id, got)
+	}
+	if got := transferIDFromContext(context.Background()); got != "" {
+		t.Errorf("expected no ID, got %q", got)
+	}
+
+	lf := log.Fields{"workflow": "docs"}
+	fields := withTransferID(lf, id)
+	if fields["transfer_id"] != id || fields["workflow"] != "docs" {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if _, ok := lf["transfer_id"]; ok {
+		t.Error("original fields should not be modified")
+	}
+}
@@ -0,0 +1,113 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	statusPath  = "/status"
+	metricsPath = "/metrics"
+)
+
+var startTime = time.Now()
+
+// statusReport is the response served on the status endpoint
+type statusReport struct {
+	Status   string `json:"status"`
+	Version  string `json:"version"`
+	Uptime   string `json:"uptime"`
+	Outbound int    `json:"outbound"`
+	Inbound  int    `json:"inbound"`
+	// Replicate counts the enabled workflows copying between buckets
+	Replicate int `json:"replicate,omitempty"`
+	// Sync counts the enabled workflows keeping a folder and a bucket in step both ways
+	Sync int `json:"sync,omitempty"`
+	// Alerts lists the workflow thresholds currently crossed and the remotes whose circuits are
+	// open, which make the status degraded
+	Alerts []string `json:"alerts,omitempty"`
+}
+
+// startStatusServer serves the status endpoint and metrics on addr until the returned server is closed
+func startStatusServer(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(statusPath, handleStatus)
+	mux.Handle(metricsPath, promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	const readHeaderTimeout = 5 * time.Second
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("status server failed: ", err)
+		}
+	}()
+	log.Info("serving status on ", ln.Addr())
+	return srv, nil
+}
+
+// statusDegraded is reported while any workflow has crossed one of its thresholds, or any remote's circuit is open
+const statusDegraded = "degraded"
+
+// currentStatus reports the daemon's health along with how many workflows are enabled
+func currentStatus() statusReport {
+	var alerts []string
+	for _, s := range workflowStatuses() {
+		for _, alert := range s.Alerts {
+			alerts = append(alerts, s.Name+": "+alert)
+		}
+	}
+	for _, circuit := range openCircuits() {
+		alerts = append(alerts, "remote "+circuit)
+	}
+
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	report := statusReport{
+		Status:  "ok",
+		Version: version,
+		Uptime:  time.Since(startTime).Round(time.Second).String(),
+	}
+	for _, o := range config.Outbound {
+		if o.IsEnabled() {
+			report.Outbound++
+		}
+	}
+	for _, in := range config.Inbound {
+		if in.IsEnabled() {
+			report.Inbound++
+		}
+	}
+	for _, r := range config.Replicate {
+		if r.IsEnabled() {
+			report.Replicate++
+		}
+	}
+	for _, t := range config.Sync {
+		if t.IsEnabled() {
+			report.Sync++
+		}
+	}
+	if len(alerts) > 0 {
+		report.Status = statusDegraded
+		report.Alerts = alerts
+	}
+	return report
+}
+
+func handleStatus(w http.ResponseWriter, _ *http.Request) {
+	report := currentStatus()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Error("failed to write status response: ", err)
+	}
+}
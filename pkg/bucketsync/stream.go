@@ -0,0 +1,189 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// streamPartSize is the part size of streamed uploads, whose length is unknown until they end.
+// S3 allows 10,000 parts, so a stream may be up to 640GB.
+const streamPartSize = 64 << 20
+
+// defaultStreamKey names a streamed object after its source and when it started
+const defaultStreamKey = `{{.Name}}-{{.Time.Format "20060102-150405"}}`
+
+// streamKeyData is what a key template is rendered with
+type streamKeyData struct {
+	// Name is the stream source's base name without its extension, such as the FIFO's
+	Name     string
+	Workflow string
+	// Time is when the stream started, in UTC
+	Time       time.Time
+	TransferID string
+	Hostname   string
+}
+
+// parseStreamKey parses an object key template, or the default if it is empty
+func parseStreamKey(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultStreamKey
+	}
+	return template.New("key").Option("missingkey=error").Parse(text)
+}
+
+// streamKey renders the key a stream from source is uploaded to
+func streamKey(tmpl *template.Template, o Outbound, source, id string, now time.Time) (string, error) {
+	hostname, _ := os.Hostname()
+	base := filepath.Base(source)
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, streamKeyData{
+		Name:       strings.TrimSuffix(base, filepath.Ext(base)),
+		Workflow:   o.Name,
+		Time:       now.UTC(),
+		TransferID: id,
+		Hostname:   hostname,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render key: %w", err)
+	}
+	key := strings.TrimPrefix(buf.String(), "/")
+	if key == "" {
+		return "", errors.New("key template rendered an empty key")
+	}
+	return key, nil
+}
+
+// uploadStream uploads everything read from r until it ends to an object under the workflow's
+// destination, keyed by tmpl. Since a stream cannot be read again, a failed upload is not retried.
+func uploadStream(lf log.Fields, o Outbound, tmpl *template.Template, source string, r io.Reader) (err error) {
+	finish, ok := startTransfer()
+	if !ok {
+		log.WithFields(lf).WithField("name", source).Warn("shutting down, not uploading stream")
+		return nil
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx, span := startSpan(contextWithTransferID(context.Background(), id), "outbound.stream",
+		attribute.String("workflow", o.Name),
+		attribute.String("file.path", source),
+		attribute.String("transfer.id", id),
+	)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: o.Name, Path: source})
+	done := trackWorkflow(o.Name)
+	defer func() {
+		done(err)
+		if err != nil {
+			emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: o.Name, Path: source, Error: err.Error()})
+			log.WithFields(lf).WithField("name", source).Error("failed to upload stream: ", err)
+		}
+		endSpan(span, err)
+	}()
+
+	u, err := url.Parse(o.Destination)
+	if err != nil {
+		return err
+	}
+	target, err := outboundTarget(o, u)
+	if err != nil {
+		return err
+	}
+	name, err := streamKey(tmpl, o, source, id, time.Now())
+	if err != nil {
+		return err
+	}
+	key := outboundObjectKey(target.prefix, name)
+
+	remote := target.remote
+	breaker := remoteBreaker(remote.Endpoint, remote.CircuitBreaker)
+	if err := breaker.allow(); err != nil {
+		return err
+	}
+	job, err := scheduleTransfer(ctx, o.Name, o.Priority, bandwidthLimit(o.MaxBandwidth), remote, streamPartSize)
+	if err != nil {
+		return err
+	}
+	defer job.release()
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: id,
+		Workflow:   o.Name,
+		Path:       source,
+		Remote:     u.Host,
+		Bucket:     target.bucket,
+		Key:        key,
+	})
+	// The transfer ID is stored as x-amz-meta-transfer-id on S3, linking the object back to the logs
+	metadata := map[string]string{"Transfer-Id": id}
+	progress := startProgress(id, o.Name, directionUpload, source, 0)
+	defer progress.finish()
+	h := sha256.New()
+	start := time.Now()
+	body := &countingReader{r: io.TeeReader(job.throttle(r), io.MultiWriter(h, progress))}
+	if s, ok := target.store.(*minioStore); ok {
+		err = s.putStream(ctx, key, body, streamPartSize, metadata)
+	} else {
+		err = target.store.Put(ctx, key, body, -1, metadata)
+	}
+	breaker.record(err)
+
+	rec := TransferRecord{
+		ID:        id,
+		Workflow:  o.Name,
+		Direction: directionUpload,
+		Path:      source,
+		Remote:    u.Host,
+		Bucket:    target.bucket,
+		Key:       key,
+		Size:      body.n,
+		Duration:  time.Since(start),
+		Status:    transferSuccess,
+	}
+	if err != nil {
+		rec.Status, rec.Error = transferFailed, err.Error()
+		recordTransfer(rec)
+		return err
+	}
+	rec.Checksum = hex.EncodeToString(h.Sum(nil))
+	rec.CID = target.contentID()
+	recordTransfer(rec)
+	emitEvent(transferEvent(eventUploaded, rec))
+	fields := log.Fields{
+		"name":       source,
+		"size":       rec.Size,
+		"throughput": formatRate(transferRate(rec.Size, rec.Duration)),
+	}
+	if rec.CID != "" {
+		fields["cid"] = rec.CID
+	}
+	log.WithFields(lf).WithFields(target.keyFields(key)).WithFields(fields).Info("uploaded stream to " + target.kind)
+
+	SendNotification("bucketsyncd", uploadedMessage(key, o.Destination, rec))
+	return nil
+}
+
+// putStream stores an object of unknown length read from r, uploading it in parts of partSize
+// rather than the parts sized for the largest possible object which minio-go would otherwise buffer
+func (s *minioStore) putStream(ctx context.Context, key string, r io.Reader, partSize uint64, metadata map[string]string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, -1, minio.PutObjectOptions{
+		UserMetadata: metadata,
+		PartSize:     partSize,
+	})
+	return err
+}
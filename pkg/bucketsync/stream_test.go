@@ -0,0 +1,120 @@
+package bucketsync
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordedPut is a body uploaded to a putRecorder, and where it was uploaded to
+type recordedPut struct {
+	path, body string
+}
+
+// newPutRecorder starts a WebDAV stand-in which accepts every request, sending each PUT it
+// receives to the returned channel
+func newPutRecorder(t *testing.T) (*httptest.Server, <-chan recordedPut) {
+	uploads := make(chan recordedPut, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			uploads <- recordedPut{r.URL.Path, string(body)}
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	return server, uploads
+}
+
+func TestStreamKey(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 30, 5, 0, time.FixedZone("ICT", 7*60*60))
+	o := Outbound{Name: "db"}
+	tests := []struct {
+		template, want string
+	}{
+		{"", "backup-20261016-073005"},
+		{`{{.Workflow}}/{{.Time.Format "2006/01/02"}}/{{.Name}}.sql.gz`, "db/2026/10/16/backup.sql.gz"},
+		{"/{{.TransferID}}", "abc123"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseStreamKey(tt.template)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.template, err)
+		}
+		key, err := streamKey(tmpl, o, "/run/bucketsyncd/backup.fifo", "abc123", now)
+		if err != nil {
+			t.Errorf("%q: %v", tt.template, err)
+		} else if key != tt.want {
+			t.Errorf("%q: key = %q, want %q", tt.template, key, tt.want)
+		}
+	}
+
+	tmpl, err := parseStreamKey(`{{.Missing}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := streamKey(tmpl, o, "backup.fifo", "abc123", now); err == nil {
+		t.Error("unknown field rendered")
+	}
+}
+
+func TestFifoValidate(t *testing.T) {
+	cfg := Config{
+		Outbound: []Outbound{{
+			Name:        "dump",
+			Source:      "/tmp/*",
+			Fifo:        "/run/bucketsyncd/dump.fifo",
+			Key:         "{{.Name",
+			Destination: "s3://minio.example.com/backups",
+			Schedule:    "@daily",
+		}},
+	}
+	var msgs []string
+	for _, err := range cfg.Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	joined := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		`outbound "dump": source and fifo cannot both be set`,
+		`outbound "dump": key: `,
+		`outbound "dump": schedule cannot be used with fifo`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing error %q in:\n%s", want, joined)
+		}
+	}
+}
+
+func TestCommandValidate(t *testing.T) {
+	cfg := Config{
+		Outbound: []Outbound{
+			{Name: "unscheduled", Command: []string{"backup.sh"}, Destination: "s3://minio.example.com/backups"},
+			{Name: "both", Command: []string{"backup.sh"}, Fifo: "/run/backup.fifo", Destination: "s3://minio.example.com/backups"},
+			{Name: "nightly", Command: []string{"backup.sh"}, Schedule: "@daily", Destination: "s3://minio.example.com/backups", ProcessWith: "scrub"},
+		},
+	}
+	var msgs []string
+	for _, err := range cfg.Validate() {
+		msgs = append(msgs, err.Error())
+	}
+	joined := strings.Join(msgs, "\n")
+	for _, want := range []string{
+		`outbound "unscheduled": schedule is required with command`,
+		`outbound "both": fifo and command cannot both be set`,
+		`outbound "nightly": process_with cannot be used with command`,
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing error %q in:\n%s", want, joined)
+		}
+	}
+	if len(msgs) != 3 {
+		t.Errorf("Validate returned %d errors, want 3:\n%s", len(msgs), joined)
+	}
+}
@@ -0,0 +1,91 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// TagRule tags the objects uploaded from files whose local path matches Path, a regular
+// expression. Tag keys and values may refer to its captures as {name}, or {1} by number.
+type TagRule struct {
+	Path string            `yaml:"path"`
+	Tags map[string]string `yaml:"tags"`
+}
+
+// tagCapture finds {name} and {1} references to a rule's captures
+var tagCapture = regexp.MustCompile(`\{(\w+)\}`)
+
+func (r TagRule) validate() error {
+	re, err := regexp.Compile(r.Path)
+	if err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+	if len(r.Tags) == 0 {
+		return errors.New("tags: at least one is required")
+	}
+	for k, v := range r.Tags {
+		for _, s := range []string{k, v} {
+			for _, ref := range tagCapture.FindAllStringSubmatch(s, -1) {
+				if captureIndex(re, ref[1]) < 0 {
+					return fmt.Errorf("tag %q: no capture %s in path", k, ref[0])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// captureIndex returns the index of the capture named, or numbered, ref, or -1 if there is none
+func captureIndex(re *regexp.Regexp, ref string) int {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n > re.NumSubexp() {
+			return -1
+		}
+		return n
+	}
+	return re.SubexpIndex(ref)
+}
+
+// objectTags returns the tags the rules give the object uploaded from the file at name. Every
+// rule matching its path applies, later ones overriding earlier ones' tags of the same key. The
+// tags are checked against S3's limits, which the server would otherwise reject them for.
+func objectTags(rules []TagRule, name string) (map[string]string, error) {
+	p := filepath.ToSlash(name)
+	var objTags map[string]string
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Path)
+		if err != nil {
+			return nil, err
+		}
+		m := re.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		expand := func(s string) string {
+			return tagCapture.ReplaceAllStringFunc(s, func(ref string) string {
+				if i := captureIndex(re, ref[1:len(ref)-1]); i >= 0 {
+					return m[i]
+				}
+				return ref
+			})
+		}
+		if objTags == nil {
+			objTags = make(map[string]string, len(r.Tags))
+		}
+		for k, v := range r.Tags {
+			objTags[expand(k)] = expand(v)
+		}
+	}
+	if len(objTags) == 0 {
+		return nil, nil
+	}
+	if _, err := tags.NewTags(objTags, true); err != nil {
+		return nil, fmt.Errorf("invalid object tags: %w", err)
+	}
+	return objTags, nil
+}
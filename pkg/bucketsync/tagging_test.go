@@ -0,0 +1,88 @@
+package bucketsync
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestObjectTags(t *testing.T) {
+	rules := []TagRule{
+		{Path: `^/srv/exports/(?P<dept>[^/]+)/`, Tags: map[string]string{"dept": "{dept}", "source": "exports"}},
+		{Path: `\.(\w+)$`, Tags: map[string]string{"type": "{1}"}},
+		{Path: `/finance/`, Tags: map[string]string{"source": "ledger"}},
+	}
+	tests := []struct {
+		name string
+		want map[string]string
+	}{
+		{"/srv/exports/sales/q3.csv", map[string]string{"dept": "sales", "source": "exports", "type": "csv"}},
+		{"/srv/exports/finance/q3.pdf", map[string]string{"dept": "finance", "source": "ledger", "type": "pdf"}},
+		{"/srv/other/README", nil},
+	}
+	for _, tt := range tests {
+		got, err := objectTags(rules, tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("%s: tags = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Tags S3 would reject fail the upload, rather than being dropped
+	if _, err := objectTags([]TagRule{{Path: `/(.*)$`, Tags: map[string]string{"name": "{1}"}}}, "/"+strings.Repeat("x", 300)); err == nil {
+		t.Error("tag value over 256 characters accepted")
+	}
+}
+
+func TestObjectTagsSent(t *testing.T) {
+	tagging := make(chan string, 1)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			tagging <- r.Header.Get("X-Amz-Tagging")
+		}
+	}))
+	defer srv.Close()
+	mc, err := minio.New(strings.TrimPrefix(srv.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("key", "secret", ""),
+		Secure:    true,
+		Transport: srv.Client().Transport,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMinioStore(mc, "bucket", 0).withTags(map[string]string{"dept": "sales"})
+	if err := store.Put(context.Background(), "q3.csv", strings.NewReader("a,b"), 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := url.ParseQuery(<-tagging); got.Get("dept") != "sales" {
+		t.Errorf("tagging = %v", got)
+	}
+}
+
+func TestTagRuleValidate(t *testing.T) {
+	for _, r := range []TagRule{
+		{Path: `(`, Tags: map[string]string{"a": "b"}},
+		{Path: `/exports/`},
+		{Path: `/exports/(?P<dept>\w+)`, Tags: map[string]string{"team": "{team}"}},
+		{Path: `/exports/(\w+)`, Tags: map[string]string{"{2}": "x"}},
+	} {
+		if err := r.validate(); err == nil {
+			t.Errorf("%+v: validated", r)
+		}
+	}
+	r := TagRule{Path: `/exports/(?P<dept>\w+)/(\w+)`, Tags: map[string]string{"dept": "{dept}", "{2}": "yes"}}
+	if err := r.validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,205 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Tenant is a namespace of remotes and workflows within the configuration, for running one
+// daemon for several customers. Its remotes and workflows are named "tenant/name", its
+// workflows can only use its own remotes, and their local folders must lie within its root.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// Root is the folder the tenant's local folders must lie within; relative paths in its
+	// workflows are taken from it
+	Root      string       `yaml:"root"`
+	Remotes   []Remote     `yaml:"remotes,omitempty"`
+	Outbound  []Outbound   `yaml:"outbound,omitempty"`
+	Inbound   []Inbound    `yaml:"inbound,omitempty"`
+	Replicate []Replicate  `yaml:"replicate,omitempty"`
+	Sync      []TwoWaySync `yaml:"sync,omitempty"`
+}
+
+// tenantName returns the name of a tenant's remote or workflow within the whole configuration
+func tenantName(tenant, name string) string {
+	if tenant == "" || name == "" {
+		return name
+	}
+	return tenant + "/" + name
+}
+
+// tenantPath resolves a tenant's local path, relative ones being taken from its root
+func tenantPath(root, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(root, p)
+}
+
+// tenantLabels adds the tenant's name to a workflow's labels, unless they name one already
+func tenantLabels(tenant string, labels map[string]string) map[string]string {
+	if _, ok := labels["tenant"]; ok {
+		return labels
+	}
+	withTenant := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		withTenant[k] = v
+	}
+	withTenant["tenant"] = tenant
+	return withTenant
+}
+
+// applyTenants adds each tenant's remotes and workflows to the configuration's own, named and
+// resolved within the tenant's namespace, so that the engine runs them like any others. It does
+// so once, however often it is called.
+func (c *Config) applyTenants() {
+	if c.tenantsApplied {
+		return
+	}
+	c.tenantsApplied = true
+	if len(c.Tenants) == 0 {
+		return
+	}
+	// The lists may share their arrays with a configuration the caller still holds
+	c.Remotes, c.Outbound, c.Inbound = slices.Clip(c.Remotes), slices.Clip(c.Outbound), slices.Clip(c.Inbound)
+	c.Replicate, c.Sync = slices.Clip(c.Replicate), slices.Clip(c.Sync)
+	for _, t := range c.Tenants {
+		for _, r := range t.Remotes {
+			r.Name = tenantName(t.Name, r.Name)
+			r.tenant = t.Name
+			c.Remotes = append(c.Remotes, r)
+		}
+		for _, o := range t.Outbound {
+			o.Name = tenantName(t.Name, o.Name)
+			o.Remote = tenantName(t.Name, o.Remote)
+			o.Lock.Remote = tenantName(t.Name, o.Lock.Remote)
+			o.Source = tenantPath(t.Root, o.Source)
+			o.Fifo = tenantPath(t.Root, o.Fifo)
+			o.Quarantine = tenantPath(t.Root, o.Quarantine)
+			o.Lock.Dir = tenantPath(t.Root, o.Lock.Dir)
+			o.ArchiveTo = tenantPath(t.Root, o.ArchiveTo)
+			o.Labels = tenantLabels(t.Name, o.Labels)
+			o.tenant = t.Name
+			c.Outbound = append(c.Outbound, o)
+		}
+		for _, in := range t.Inbound {
+			in.Name = tenantName(t.Name, in.Name)
+			in.Remote = tenantName(t.Name, in.Remote)
+			in.Destination = tenantPath(t.Root, in.Destination)
+			in.Spool = tenantPath(t.Root, in.Spool)
+			in.Labels = tenantLabels(t.Name, in.Labels)
+			in.tenant = t.Name
+			c.Inbound = append(c.Inbound, in)
+		}
+		for _, r := range t.Replicate {
+			r.Name = tenantName(t.Name, r.Name)
+			r.From.Remote = tenantName(t.Name, r.From.Remote)
+			r.To.Remote = tenantName(t.Name, r.To.Remote)
+			r.Labels = tenantLabels(t.Name, r.Labels)
+			r.tenant = t.Name
+			c.Replicate = append(c.Replicate, r)
+		}
+		for _, s := range t.Sync {
+			s.Name = tenantName(t.Name, s.Name)
+			s.Bucket.Remote = tenantName(t.Name, s.Bucket.Remote)
+			s.Local = tenantPath(t.Root, s.Local)
+			s.StateFile = tenantPath(t.Root, s.StateFile)
+			s.Labels = tenantLabels(t.Name, s.Labels)
+			s.tenant = t.Name
+			c.Sync = append(c.Sync, s)
+		}
+	}
+}
+
+// withinRoot reports whether p lies within root
+func withinRoot(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// validateTenants checks the tenants, and that their workflows keep to their own remotes and
+// folders. The workflows are otherwise validated with the rest, once applyTenants has added them.
+func (c *Config) validateTenants() []error {
+	var errs []error
+	roots := make(map[string]string, len(c.Tenants))
+	seen := make(map[string]bool, len(c.Tenants))
+	for i, t := range c.Tenants {
+		name := t.Name
+		switch {
+		case name == "":
+			name = fmt.Sprintf("tenants[%d]", i)
+			errs = append(errs, fmt.Errorf("%s: name is required", name))
+		case strings.Contains(name, "/"):
+			errs = append(errs, fmt.Errorf("tenant %q: name cannot contain /", name))
+		case seen[name]:
+			errs = append(errs, fmt.Errorf("tenant %q: duplicate name", name))
+		}
+		seen[name] = true
+		if !filepath.IsAbs(t.Root) {
+			errs = append(errs, fmt.Errorf("tenant %q: root must be an absolute path", name))
+		}
+		roots[name] = filepath.Clean(t.Root)
+		for _, names := range [][]string{
+			mapNames(t.Remotes, func(r Remote) string { return r.Name }),
+			mapNames(t.Outbound, func(o Outbound) string { return o.Name }),
+			mapNames(t.Inbound, func(in Inbound) string { return in.Name }),
+			mapNames(t.Replicate, func(r Replicate) string { return r.Name }),
+			mapNames(t.Sync, func(s TwoWaySync) string { return s.Name }),
+		} {
+			for _, n := range names {
+				if strings.Contains(n, "/") {
+					errs = append(errs, fmt.Errorf("tenant %q: %q: names cannot contain /", name, n))
+				}
+			}
+		}
+	}
+
+	// Local folders must lie within the tenant's root
+	outside := func(kind, name, tenant, field, p string) {
+		if tenant != "" && p != "" && !withinRoot(roots[tenant], p) {
+			errs = append(errs, fmt.Errorf("%s %q: %s: %s is outside tenant %q's root", kind, name, field, p, tenant))
+		}
+	}
+	for _, o := range c.Outbound {
+		if o.Source != "" {
+			outside("outbound", o.Name, o.tenant, "source", filepath.Dir(o.Source))
+		}
+		outside("outbound", o.Name, o.tenant, "fifo", o.Fifo)
+		outside("outbound", o.Name, o.tenant, "quarantine", o.Quarantine)
+		outside("outbound", o.Name, o.tenant, "lock.dir", o.Lock.Dir)
+		outside("outbound", o.Name, o.tenant, "archive_to", o.ArchiveTo)
+	}
+	for _, in := range c.Inbound {
+		outside("inbound", in.Name, in.tenant, "destination", in.Destination)
+		outside("inbound", in.Name, in.tenant, "spool", in.Spool)
+	}
+	for _, s := range c.Sync {
+		outside("sync", s.Name, s.tenant, "local", s.Local)
+		outside("sync", s.Name, s.tenant, "state_file", s.StateFile)
+	}
+	return errs
+}
+
+// mapNames lists the names of a tenant's remotes or workflows
+func mapNames[T any](items []T, name func(T) string) []string {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = name(item)
+	}
+	return names
+}
+
+// errOtherTenantRemote reports a workflow naming a remote outside its tenant
+var errOtherTenantRemote = errors.New("belongs to another tenant")
+
+// checkRemoteTenant returns an error unless the remote named, if it exists, belongs to the
+// workflow's tenant, or both to none
+func checkRemoteTenant(remoteTenants map[string]string, tenant, remote string) error {
+	if t, ok := remoteTenants[remote]; ok && t != tenant {
+		return fmt.Errorf("remote %q %w", remote, errOtherTenantRemote)
+	}
+	return nil
+}
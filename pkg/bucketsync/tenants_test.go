@@ -0,0 +1,186 @@
+package bucketsync
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const tenantsTestConfig = `
+remotes:
+  - name: own
+    endpoint: minio.example.com
+outbound:
+  - name: backups
+    source: /srv/backups/*
+    destination: s3://shared.example.com/backups
+tenants:
+  - name: acme
+    root: /srv/tenants/acme
+    remotes:
+      - name: minio
+        endpoint: shared.example.com
+        accessKey: acme-key
+        secretKey: acme-secret
+    outbound:
+      - name: invoices
+        source: invoices/*.pdf
+        destination: s3://shared.example.com/acme-invoices
+        archive_to: done
+        settle: 2s
+        lock:
+          dir: locks
+    inbound:
+      - name: scans
+        source: amqp://localhost/
+        queue: acme-scans
+        remote: minio
+        destination: scans
+        labels:
+          team: finance
+  - name: globex
+    root: /srv/tenants/globex
+    remotes:
+      - name: minio
+        endpoint: shared.example.com
+        accessKey: globex-key
+        secretKey: globex-secret
+    sync:
+      - name: docs
+        local: docs
+        bucket:
+          remote: minio
+          bucket: globex-docs
+`
+
+func TestTenants(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte(tenantsTestConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config = Config{}
+	if err := readConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Fatalf("Validate() = %v", errs)
+	}
+
+	var names []string
+	for _, r := range config.Remotes {
+		names = append(names, r.Name)
+	}
+	if got := strings.Join(names, " "); got != "own acme/minio globex/minio" {
+		t.Errorf("remotes = %s", got)
+	}
+	in := config.Inbound[0]
+	if in.Name != "acme/scans" || in.Remote != "acme/minio" || in.Destination != "/srv/tenants/acme/scans" {
+		t.Errorf("inbound resolved to %s with remote %s into %s", in.Name, in.Remote, in.Destination)
+	}
+	if in.Labels["tenant"] != "acme" || in.Labels["team"] != "finance" {
+		t.Errorf("inbound labels = %v", in.Labels)
+	}
+	if s := config.Sync[0]; s.Name != "globex/docs" || s.Bucket.Remote != "globex/minio" || s.Local != "/srv/tenants/globex/docs" {
+		t.Errorf("sync resolved to %s with remote %s in %s", s.Name, s.Bucket.Remote, s.Local)
+	}
+
+	// A destination's endpoint finds the remote of the workflow's own tenant, and never a
+	// tenant's remote for a workflow outside it
+	acme := config.Outbound[1]
+	if acme.Name != "acme/invoices" || acme.Source != "/srv/tenants/acme/invoices/*.pdf" || acme.Lock.Dir != "/srv/tenants/acme/locks" {
+		t.Errorf("outbound resolved to %s watching %s with locks in %s", acme.Name, acme.Source, acme.Lock.Dir)
+	}
+	if acme.ArchiveTo != "/srv/tenants/acme/done" {
+		t.Errorf("outbound archives to %s", acme.ArchiveTo)
+	}
+	if r, ok := outboundRemote(acme, "shared.example.com"); !ok || r.AccessKey != "acme-key" {
+		t.Errorf("acme's outbound found remote %q", r.Name)
+	}
+	if r, ok := outboundRemote(config.Outbound[0], "shared.example.com"); ok {
+		t.Errorf("outbound outside the tenants found remote %q", r.Name)
+	}
+
+	// Applying the tenants again adds nothing
+	config.applyTenants()
+	if len(config.Remotes) != 3 {
+		t.Errorf("%d remotes after applying tenants twice", len(config.Remotes))
+	}
+}
+
+func TestTenantsConfinedAtStartup(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = Config{}
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	escape := strings.Replace(tenantsTestConfig, "source: invoices/*.pdf", "source: /etc/*", 1)
+	if err := os.WriteFile(configFile, []byte(escape), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := readConfig(configFile)
+	if err == nil || !strings.Contains(err.Error(), `source: /etc is outside tenant "acme"'s root`) {
+		t.Errorf("readConfig() = %v, want the tenant's source refused", err)
+	}
+	if len(config.Outbound) != 0 {
+		t.Error("refused configuration was loaded")
+	}
+}
+
+func TestTenantsValidate(t *testing.T) {
+	cfg := Config{
+		Remotes: []Remote{{Name: "own", Endpoint: "minio.example.com"}},
+		Inbound: []Inbound{{
+			Name: "poach", Source: "amqp://localhost/", Queue: "q", Remote: "acme/minio", Destination: "/tmp",
+		}},
+		Tenants: []Tenant{
+			{
+				Name:    "acme",
+				Root:    "/srv/tenants/acme",
+				Remotes: []Remote{{Name: "minio", Endpoint: "shared.example.com"}},
+				Inbound: []Inbound{
+					{Name: "other", Source: "amqp://localhost/", Queue: "q", Remote: "globex/minio", Destination: "in"},
+					{Name: "escape", Source: "amqp://localhost/", Queue: "q", Remote: "minio", Destination: "../globex/in"},
+				},
+				Outbound: []Outbound{
+					{Name: "abs", Source: "/etc/*", Destination: "s3://shared.example.com/b"},
+					{Name: "locks", Source: "in/*", Destination: "s3://shared.example.com/b", Lock: FileLock{Dir: "/var/lib/locks"}},
+					{Name: "archive", Source: "in/*", Destination: "s3://shared.example.com/b", ArchiveTo: "../archive"},
+				},
+			},
+			{Name: "globex", Root: "/srv/tenants/globex", Remotes: []Remote{{Name: "minio", Endpoint: "shared.example.com"}}},
+			{Name: "globex", Root: "relative"},
+			{Name: "a/b", Root: "/srv/tenants/ab", Sync: []TwoWaySync{{Name: "x/y"}}},
+		},
+	}
+	cfg.applyTenants()
+	want := []string{
+		`inbound "poach": remote "acme/minio" belongs to another tenant`,
+		`inbound "acme/other": unknown remote "acme/globex/minio"`,
+		`inbound "acme/escape": destination: /srv/tenants/globex/in is outside tenant "acme"'s root`,
+		`outbound "acme/abs": source: /etc is outside tenant "acme"'s root`,
+		`outbound "acme/locks": lock.dir: /var/lib/locks is outside tenant "acme"'s root`,
+		`outbound "acme/archive": archive_to: /srv/tenants/archive is outside tenant "acme"'s root`,
+		`tenant "globex": duplicate name`,
+		`tenant "globex": root must be an absolute path`,
+		`tenant "a/b": name cannot contain /`,
+		`tenant "a/b": "x/y": names cannot contain /`,
+	}
+	errs := cfg.Validate()
+	for _, w := range want {
+		found := false
+		for _, err := range errs {
+			found = found || err.Error() == w
+		}
+		if !found {
+			t.Errorf("no error %q in %v", w, errors.Join(errs...))
+		}
+	}
+	for _, err := range errs {
+		if errors.Is(err, errOtherTenantRemote) && !strings.Contains(err.Error(), "poach") {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+}
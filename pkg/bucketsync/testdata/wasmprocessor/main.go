@@ -0,0 +1,36 @@
+// Command wasmprocessor is a WebAssembly processor for the tests, deciding on each file by its
+// content: "skip", "reject", "upper" to replace it with its upper-cased content, "hang" to run
+// until stopped, or anything else to accept it
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	if os.Getenv("BUCKETSYNCD_DIRECTION") == "" {
+		fmt.Fprintln(os.Stderr, "no direction given")
+		os.Exit(1)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		os.Exit(1)
+	}
+	switch content := string(bytes.TrimSpace(data)); content {
+	case "skip":
+		fmt.Fprint(os.Stderr, "test skip")
+		os.Exit(11)
+	case "reject":
+		fmt.Fprint(os.Stderr, "test reject")
+		os.Exit(12)
+	case "upper":
+		_, _ = os.Stdout.Write(bytes.ToUpper(data))
+		os.Exit(10)
+	case "hang":
+		for {
+		}
+	}
+}
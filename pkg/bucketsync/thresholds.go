@@ -0,0 +1,183 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Lifecycle events sent when a workflow crosses one of its thresholds, and when it recovers
+const (
+	eventAlert     = "alert"
+	eventRecovered = "recovered"
+)
+
+const (
+	defaultThresholdWindow       = 10 * time.Minute
+	defaultThresholdMinTransfers = 5
+
+	// rateBuckets is how many intervals the error rate window is divided into
+	rateBuckets = 60
+)
+
+// thresholdCheckInterval is how often workflows are checked against their thresholds
+var thresholdCheckInterval = 30 * time.Second
+
+// Thresholds flag a workflow as degraded when too many of its transfers fail, or when it has
+// gone too long without a successful transfer
+type Thresholds struct {
+	// MaxErrorRate is the fraction of transfers, between 0 and 1, which may fail within the window
+	MaxErrorRate float64       `yaml:"max_error_rate"`
+	Window       time.Duration `yaml:"window"`
+	// MinTransfers is how many transfers the window must hold before the error rate is judged
+	MinTransfers int `yaml:"min_transfers"`
+	// StaleAfter is how long a workflow may go without a successful transfer
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// rateBucket counts the transfers which completed during one part of the error rate window
+type rateBucket struct {
+	start  time.Time
+	ok     int
+	failed int
+}
+
+func (t Thresholds) validate() error {
+	if t.MaxErrorRate < 0 || t.MaxErrorRate > 1 {
+		return errors.New("max_error_rate must be between 0 and 1")
+	}
+	if t.Window < 0 || t.MinTransfers < 0 || t.StaleAfter < 0 {
+		return errors.New("window, min_transfers and stale_after must not be negative")
+	}
+	return nil
+}
+
+func (t Thresholds) enabled() bool {
+	return t.MaxErrorRate > 0 || t.StaleAfter > 0
+}
+
+// setThresholds applies a workflow's configured thresholds, filling in the defaults
+func (w *workflowState) setThresholds(t Thresholds) {
+	if t.Window == 0 {
+		t.Window = defaultThresholdWindow
+	}
+	if t.MinTransfers == 0 {
+		t.MinTransfers = defaultThresholdMinTransfers
+	}
+	w.mu.Lock()
+	w.thresholds = t
+	w.mu.Unlock()
+}
+
+// recordOutcome counts a completed transfer towards the error rate. The caller holds w.mu.
+func (w *workflowState) recordOutcome(now time.Time, failed bool) {
+	if w.thresholds.MaxErrorRate == 0 {
+		return
+	}
+	width := w.thresholds.Window / rateBuckets
+	if n := len(w.buckets); n == 0 || now.Sub(w.buckets[n-1].start) >= width {
+		w.buckets = append(w.buckets, rateBucket{start: now})
+	}
+	b := &w.buckets[len(w.buckets)-1]
+	if failed {
+		b.failed++
+	} else {
+		b.ok++
+	}
+	w.pruneBuckets(now)
+}
+
+// pruneBuckets drops the buckets which have left the window. The caller holds w.mu.
+func (w *workflowState) pruneBuckets(now time.Time) {
+	cutoff := now.Add(-w.thresholds.Window)
+	i := 0
+	for i < len(w.buckets) && w.buckets[i].start.Before(cutoff) {
+		i++
+	}
+	w.buckets = w.buckets[i:]
+}
+
+// checkThresholds records and returns the thresholds the workflow is currently breaching
+func (w *workflowState) checkThresholds(now time.Time) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.thresholds
+	var alerts []string
+	if t.MaxErrorRate > 0 {
+		w.pruneBuckets(now)
+		var ok, failed int
+		for _, b := range w.buckets {
+			ok += b.ok
+			failed += b.failed
+		}
+		if total := ok + failed; total >= t.MinTransfers && float64(failed)/float64(total) > t.MaxErrorRate {
+			alerts = append(alerts, fmt.Sprintf("error rate above %g%% over %s", t.MaxErrorRate*100, t.Window))
+		}
+	}
+	if t.StaleAfter > 0 && !w.paused {
+		since := w.started
+		if w.lastTransfer.After(since) {
+			since = w.lastTransfer
+		}
+		if now.Sub(since) > t.StaleAfter {
+			alerts = append(alerts, fmt.Sprintf("no successful transfer in %s", t.StaleAfter))
+		}
+	}
+	w.alerts = alerts
+	return alerts
+}
+
+// watchThresholds checks every workflow against its thresholds until stop is closed, sending
+// an alert event when one is crossed and a recovered event when it clears
+func watchThresholds(stop <-chan struct{}) {
+	active := make(map[string][]string)
+	ticker := time.NewTicker(thresholdCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			workflowsMutex.RLock()
+			states := make([]*workflowState, 0, len(workflows))
+			for _, w := range workflows {
+				states = append(states, w)
+			}
+			workflowsMutex.RUnlock()
+			for _, w := range states {
+				alerts := w.checkThresholds(now)
+				notifyThresholds(w.name, active[w.name], alerts)
+				active[w.name] = alerts
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// notifyThresholds logs and sends the thresholds a workflow has newly crossed or recovered from
+func notifyThresholds(workflow string, before, after []string) {
+	lf := log.Fields{"workflow": workflow}
+	for _, alert := range after {
+		if !slices.Contains(before, alert) {
+			log.WithFields(lf).Warn("workflow threshold crossed: ", alert)
+			notifyAlert(LifecycleEvent{Event: eventAlert, Workflow: workflow, Error: alert})
+		}
+	}
+	for _, alert := range before {
+		if !slices.Contains(after, alert) {
+			log.WithFields(lf).Info("workflow recovered: ", alert)
+			notifyAlert(LifecycleEvent{Event: eventRecovered, Workflow: workflow, Error: alert})
+		}
+	}
+}
+
+// notifyAlert sends a threshold event to the event stream and every notifier interested in it
+func notifyAlert(ev LifecycleEvent) {
+	ev.Time = time.Now().UTC()
+	emitEvent(ev)
+	notifyWebhooks(ev)
+	notifyChat(ev)
+	notifyAMQP(ev)
+}
@@ -0,0 +1,125 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThresholdsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		t       Thresholds
+		wantErr bool
+	}{
+		{"empty", Thresholds{}, false},
+		{"valid", Thresholds{MaxErrorRate: 0.2, Window: 10 * time.Minute, StaleAfter: 6 * time.Hour}, false},
+		{"rate above one", Thresholds{MaxErrorRate: 20}, true},
+		{"negative window", Thresholds{MaxErrorRate: 0.2, Window: -time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.t.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErrorRateThreshold(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	w.setThresholds(Thresholds{MaxErrorRate: 0.2, MinTransfers: 5})
+	w.setHealth(healthWatching)
+	outcome := func(err error) {
+		w.begin()
+		w.end(err)
+	}
+
+	// Too few transfers to judge, however many fail
+	for range 4 {
+		outcome(errors.New("denied"))
+	}
+	if alerts := w.checkThresholds(time.Now()); len(alerts) != 0 {
+		t.Fatalf("alerted on %d transfers: %v", 4, alerts)
+	}
+
+	for range 6 {
+		outcome(nil)
+	}
+	alerts := w.checkThresholds(time.Now())
+	if len(alerts) != 1 || alerts[0] != "error rate above 20% over 10m0s" {
+		t.Fatalf("alerts = %v, want the error rate alert", alerts)
+	}
+	if st := w.status(); st.Health != healthDegraded || len(st.Alerts) != 1 {
+		t.Errorf("status = %+v, want degraded with the alert", st)
+	}
+
+	// Once the failures leave the window the alert clears
+	if alerts := w.checkThresholds(time.Now().Add(11 * time.Minute)); len(alerts) != 0 {
+		t.Errorf("alerts after the window = %v, want none", alerts)
+	}
+}
+
+func TestStaleThreshold(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	w.setThresholds(Thresholds{StaleAfter: time.Hour})
+
+	if alerts := w.checkThresholds(time.Now()); len(alerts) != 0 {
+		t.Fatalf("alerted on a new workflow: %v", alerts)
+	}
+	later := time.Now().Add(2 * time.Hour)
+	if alerts := w.checkThresholds(later); len(alerts) != 1 || alerts[0] != "no successful transfer in 1h0m0s" {
+		t.Fatalf("alerts = %v, want the staleness alert", alerts)
+	}
+
+	// Paused workflows are not expected to transfer anything
+	w.pause()
+	if alerts := w.checkThresholds(later); len(alerts) != 0 {
+		t.Errorf("alerted on a paused workflow: %v", alerts)
+	}
+}
+
+func TestNotifyThresholds(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	closeWebhooks, err := openWebhooks([]Webhook{{URL: srv.URL, EventFilter: EventFilter{Events: []string{eventAlert, eventRecovered}}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stale := "no successful transfer in 1h0m0s"
+	notifyThresholds("photos", nil, []string{stale})
+	notifyThresholds("photos", []string{stale}, []string{stale})
+	notifyThresholds("photos", []string{stale}, nil)
+	closeWebhooks()
+
+	if rec.count() != 2 {
+		t.Fatalf("got %d notifications, want 2", rec.count())
+	}
+	for i, want := range []string{eventAlert, eventRecovered} {
+		var ev LifecycleEvent
+		if err := json.Unmarshal(rec.bodies[i], &ev); err != nil {
+			t.Fatal(err)
+		}
+		if ev.Event != want || ev.Workflow != "photos" || ev.Error != stale {
+			t.Errorf("notification %d = %+v, want %s", i, ev, want)
+		}
+	}
+}
+
+func TestStatusDegradedByThresholds(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("photos", workflowOutbound)
+	w.setThresholds(Thresholds{StaleAfter: time.Hour})
+	w.checkThresholds(time.Now().Add(2 * time.Hour))
+
+	report := currentStatus()
+	if report.Status != statusDegraded || len(report.Alerts) != 1 || report.Alerts[0] != "photos: no successful transfer in 1h0m0s" {
+		t.Errorf("unexpected status report: %+v", report)
+	}
+}
@@ -0,0 +1,91 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Timeouts bounds each stage of talking to a remote, so that a stalled connection fails and is
+// retried rather than hanging its workflow. It can be set globally and per remote, with unset
+// fields taken from the global setting, and then the defaults.
+type Timeouts struct {
+	// Connect bounds establishing a connection, including the TLS handshake
+	Connect time.Duration `yaml:"connect"`
+	// Upload and Download bound each attempt at transferring an object
+	Upload   time.Duration `yaml:"upload"`
+	Download time.Duration `yaml:"download"`
+	// Stat bounds fetching an object's metadata before it is downloaded
+	Stat time.Duration `yaml:"stat"`
+}
+
+var defaultTimeouts = Timeouts{
+	Connect:  10 * time.Second,
+	Upload:   30 * time.Second,
+	Download: 30 * time.Second,
+	Stat:     10 * time.Second,
+}
+
+// errStatTimeout is returned when an object's metadata is not fetched within the stat timeout
+var errStatTimeout = errors.New("timed out fetching object metadata")
+
+func (t Timeouts) validate() error {
+	if t.Connect < 0 || t.Upload < 0 || t.Download < 0 || t.Stat < 0 {
+		return errors.New("connect, upload, download and stat must not be negative")
+	}
+	return nil
+}
+
+// merge returns t with the fields set in override replacing its own
+func (t Timeouts) merge(override Timeouts) Timeouts {
+	if override.Connect > 0 {
+		t.Connect = override.Connect
+	}
+	if override.Upload > 0 {
+		t.Upload = override.Upload
+	}
+	if override.Download > 0 {
+		t.Download = override.Download
+	}
+	if override.Stat > 0 {
+		t.Stat = override.Stat
+	}
+	return t
+}
+
+// remoteTimeouts resolves the timeouts for a remote from its own, global and default settings
+func remoteTimeouts(r Remote) Timeouts {
+	configMutex.RLock()
+	global := config.Timeouts
+	configMutex.RUnlock()
+	return defaultTimeouts.merge(global).merge(r.Timeouts)
+}
+
+// remoteTransport is minio-go's default transport with the connect timeout applied to dialling
+// and the TLS handshake, and at most maxConns connections open, unless it is zero
+func remoteTransport(connect time.Duration, maxConns int) (http.RoundTripper, error) {
+	tr, err := minio.DefaultTransport(true)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}
+	tr.DialContext = dialer.DialContext
+	tr.TLSHandshakeTimeout = connect
+	tr.MaxConnsPerHost = maxConns
+	return tr, nil
+}
+
+// statWithin fetches an object's metadata, cancelling the download with cancel if that takes
+// longer than timeout
+func statWithin(obj *minio.Object, timeout time.Duration, cancel context.CancelFunc) (minio.ObjectInfo, error) {
+	timer := time.AfterFunc(timeout, cancel)
+	info, err := obj.Stat()
+	if !timer.Stop() {
+		return info, errStatTimeout
+	}
+	return info, err
+}
@@ -0,0 +1,81 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestRemoteTimeouts(t *testing.T) {
+	originalConfig := config
+	defer func() {
+		config = originalConfig
+	}()
+	config = Config{Timeouts: Timeouts{Upload: 5 * time.Minute, Stat: 20 * time.Second}}
+
+	got := remoteTimeouts(Remote{Timeouts: Timeouts{Upload: time.Hour}})
+	want := Timeouts{Connect: defaultTimeouts.Connect, Upload: time.Hour, Download: defaultTimeouts.Download, Stat: 20 * time.Second}
+	if got != want {
+		t.Errorf("remoteTimeouts() = %+v, want %+v", got, want)
+	}
+
+	if err := (Timeouts{Download: -time.Second}).validate(); err == nil {
+		t.Error("expected error for a negative timeout")
+	}
+}
+
+func TestRemoteTransport(t *testing.T) {
+	rt, err := remoteTransport(3*time.Second, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := rt.(*http.Transport); tr.TLSHandshakeTimeout != 3*time.Second || tr.DialContext == nil {
+		t.Errorf("connect timeout not applied: handshake %v", tr.TLSHandshakeTimeout)
+	}
+	if tr := rt.(*http.Transport); tr.MaxConnsPerHost != 4 {
+		t.Errorf("connection limit not applied: %d", tr.MaxConnsPerHost)
+	}
+}
+
+func TestStatWithin(t *testing.T) {
+	// A remote which accepts the request but never answers
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	mc, err := minio.New(strings.TrimPrefix(srv.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("key", "secret", ""),
+		Secure:    true,
+		Transport: srv.Client().Transport,
+		Region:    "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	obj, err := mc.GetObject(ctx, "bucket", "key", minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+
+	start := time.Now()
+	_, err = statWithin(obj, 50*time.Millisecond, cancel)
+	if !errors.Is(err, errStatTimeout) {
+		t.Errorf("statWithin() = %v, want errStatTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("statWithin() took %v", elapsed)
+	}
+}
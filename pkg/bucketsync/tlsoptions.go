@@ -0,0 +1,61 @@
+package bucketsync
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions configures TLS connections to a server
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the certificate authorities trusted to sign the server's
+	// certificate, in place of the system's, for servers with private certificates
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertFile and KeyFile are a PEM client certificate and its key, for servers which
+	// authenticate clients by certificate
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// InsecureSkipVerify accepts whatever certificate the server presents, so that anyone able to
+	// intercept the connection can read and change it. It is meant for testing only.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty"`
+}
+
+func (o TLSOptions) validate() error {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	if o.InsecureSkipVerify && o.CAFile != "" {
+		return errors.New("ca_file and insecure_skip_verify cannot both be set")
+	}
+	return nil
+}
+
+// configured reports whether anything differs from the default TLS configuration
+func (o TLSOptions) configured() bool {
+	return o != TLSOptions{}
+}
+
+// config loads the certificates and returns the TLS configuration for connections
+func (o TLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: o.InsecureSkipVerify} //nolint:gosec // Explicitly configured
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA bundle %s", o.CAFile)
+		}
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,127 @@
+package bucketsync
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key to dir
+func writeClientCertificate(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "bucketsyncd"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestWebDAVTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCertificate(t, dir)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		tls  TLSOptions
+		ok   bool
+	}{
+		{"system roots", TLSOptions{}, false},
+		{"ca bundle", TLSOptions{CAFile: caFile}, true},
+		{"client certificate", TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{"insecure", TLSOptions{InsecureSkipVerify: true}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewWebDAVClientWithOptions(server.URL, WebDAVOptions{TLS: tt.tls})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = client.Upload(strings.NewReader("data"), "/file.txt")
+			if tt.ok && err != nil {
+				t.Error(err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("upload succeeded without trusting the server's certificate")
+			}
+		})
+	}
+
+	// The server requiring a certificate only accepts the one configured
+	server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	// Connections kept from before were made without needing one
+	server.CloseClientConnections()
+	for _, opts := range []TLSOptions{{CAFile: caFile}, {CAFile: caFile, CertFile: certFile, KeyFile: keyFile}} {
+		client, err := NewWebDAVClientWithOptions(server.URL, WebDAVOptions{TLS: opts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.Upload(strings.NewReader("data"), "/file.txt")
+		if withCert := opts.CertFile != ""; withCert != (err == nil) {
+			t.Errorf("with client certificate %v: upload error %v", withCert, err)
+		}
+	}
+
+	if _, err := NewWebDAVClientWithOptions(server.URL, WebDAVOptions{TLS: TLSOptions{CAFile: keyFile}}); err == nil {
+		t.Error("client created with a CA bundle holding no certificates")
+	}
+}
+
+func TestTLSOptionsValidate(t *testing.T) {
+	for _, opts := range []TLSOptions{
+		{CertFile: "client.crt"},
+		{KeyFile: "client.key"},
+		{CAFile: "ca.pem", InsecureSkipVerify: true},
+	} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("%+v: validated", opts)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package bucketsync
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider which records ended spans for the duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	sr := tracetest.NewSpanRecorder()
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+	return sr
+}
+
+func TestUploadEventSpan(t *testing.T) {
+	sr := recordSpans(t)
+
+	o := Outbound{Name: "docs", Destination: "s3://minio.example.com/bucket/path"}
+	if err := uploadEvent(log.Fields{}, o, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name() != "outbound.file" || spans[0].Status().Code != codes.Error {
+		t.Errorf("unexpected span %q with status %v", spans[0].Name(), spans[0].Status())
+	}
+}
+
+func TestHandleMessageInvalidPayload(t *testing.T) {
+	sr := recordSpans(t)
+
+	handleMessage(context.Background(), log.Fields{}, Inbound{Name: "in", Queue: "queue"}, newFakeSource(), Message{Body: []byte("not json")})
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected parse and message spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "parse" || spans[1].Name() != "inbound.message" {
+		t.Errorf("unexpected spans %q, %q", spans[0].Name(), spans[1].Name())
+	}
+	if spans[1].Status().Code != codes.Error {
+		t.Errorf("expected message span to record the failure, got %v", spans[1].Status())
+	}
+}
+
+func TestAMQPHeaderCarrier(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	parent := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	headers := amqp.Table{}
+	propagation.TraceContext{}.Inject(parent, amqpHeaderCarrier(headers))
+	if _, ok := headers["traceparent"]; !ok {
+		t.Fatalf("expected traceparent header, got %v", headers)
+	}
+
+	ctx := propagation.TraceContext{}.Extract(context.Background(), amqpHeaderCarrier(headers))
+	if got := trace.SpanContextFromContext(ctx).TraceID(); got != traceID {
+		t.Errorf("expected trace ID %s, got %s", traceID, got)
+	}
+}
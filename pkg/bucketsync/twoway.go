@@ -0,0 +1,698 @@
+package bucketsync
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 - MD5 is only used to compare against S3 ETags
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// conflictNewest resolves a file changed on both sides by keeping whichever was written last
+	conflictNewest = "newest"
+	// conflictRename resolves a file changed on both sides by keeping both
+	conflictRename = "rename"
+
+	// defaultSyncPollInterval is how often a sync workflow compares its folder and bucket
+	defaultSyncPollInterval = time.Minute
+	// defaultSyncStateFile is where a sync workflow keeps its state, within its folder
+	defaultSyncStateFile = ".bucketsyncd-sync.json"
+	// syncPartSuffix marks a download in progress, which is renamed once complete
+	syncPartSuffix = ".bucketsyncd-part"
+)
+
+// What a sync pass does with each file
+const (
+	syncNone         = ""
+	syncUpload       = "upload"
+	syncDownload     = "download"
+	syncRemoveLocal  = "remove local"
+	syncRemoveRemote = "remove remote"
+	syncConflict     = "conflict"
+	// syncForget drops a file removed from both sides from the state
+	syncForget = "forget"
+)
+
+// errSyncInProgress is returned when a scan is asked for while a pass is running
+var errSyncInProgress = errors.New("a sync pass is already in progress")
+
+// syncEntry is a file as it was when both sides were last in step
+type syncEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag"`
+}
+
+// syncState is what a sync workflow keeps in its state file, by the file's slash-separated path
+// within the folder
+type syncState struct {
+	Files map[string]syncEntry `json:"files"`
+}
+
+// twoWayWorkflow keeps a sync workflow's folder and bucket prefix in step
+type twoWayWorkflow struct {
+	t         TwoWaySync
+	store     *minioStore
+	remote    Remote
+	stateFile string
+
+	// mu is held by a pass, so that the admin API's scan cannot run one alongside
+	mu    sync.Mutex
+	state syncState
+}
+
+// newTwoWayWorkflow resolves a sync workflow's remote and loads its state
+func newTwoWayWorkflow(t TwoWaySync) (*twoWayWorkflow, error) {
+	remote, ok := findRemote(t.Bucket.Remote)
+	if !ok {
+		return nil, fmt.Errorf("no credentials found for remote %q", t.Bucket.Remote)
+	}
+	mc, err := remoteClient(remote)
+	if err != nil {
+		return nil, err
+	}
+	w := &twoWayWorkflow{
+		t:         t,
+		store:     newMinioStore(mc, t.Bucket.Bucket, remoteTimeouts(remote).Stat),
+		remote:    remote,
+		stateFile: t.StateFile,
+	}
+	if w.stateFile == "" {
+		w.stateFile = filepath.Join(t.Local, defaultSyncStateFile)
+	}
+	if w.state, err = loadSyncState(w.stateFile); err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+	return w, nil
+}
+
+// loadSyncState reads a sync workflow's state, which is empty until the first pass
+func loadSyncState(path string) (syncState, error) {
+	state := syncState{Files: make(map[string]syncEntry)}
+	// #nosec G304 - intentional: path comes from the configuration file
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]syncEntry)
+	}
+	return state, nil
+}
+
+// saveState writes the state beside its file and renames it into place, so that it is never
+// left half written
+func (w *twoWayWorkflow) saveState() error {
+	b, err := json.Marshal(w.state)
+	if err != nil {
+		return err
+	}
+	tmp := w.stateFile + ".tmp"
+	const filePerms = 0600
+	if err := os.WriteFile(tmp, b, filePerms); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.stateFile)
+}
+
+// key returns the object key a file in the folder is kept as
+func (w *twoWayWorkflow) key(rel string) string {
+	return w.t.Bucket.Prefix + rel
+}
+
+// localPath returns where a file in the folder is kept on disk
+func (w *twoWayWorkflow) localPath(rel string) string {
+	return filepath.Join(w.t.Local, filepath.FromSlash(rel))
+}
+
+// ignored reports whether a file is left out of the sync, as the workflow's own state and
+// partial downloads always are
+func (w *twoWayWorkflow) ignored(rel string) bool {
+	name := path.Base(rel)
+	return strings.HasSuffix(name, syncPartSuffix) || isIgnored(w.t.IgnorePatterns, name)
+}
+
+// listLocal returns the files in the folder and its subfolders, by their slash-separated paths
+func (w *twoWayWorkflow) listLocal() (map[string]os.FileInfo, error) {
+	stateFile, _ := filepath.Abs(w.stateFile)
+	files := make(map[string]os.FileInfo)
+	err := filepath.WalkDir(w.t.Local, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if abs, _ := filepath.Abs(p); abs == stateFile || abs == stateFile+".tmp" {
+			return nil
+		}
+		rel, err := filepath.Rel(w.t.Local, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if w.ignored(rel) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = fi
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local folder: %w", err)
+	}
+	return files, nil
+}
+
+// listRemote returns the objects under the prefix, by their paths within it
+func (w *twoWayWorkflow) listRemote(ctx context.Context) (map[string]StoredObject, error) {
+	objects, err := w.store.List(ctx, w.t.Bucket.Prefix, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bucket: %w", err)
+	}
+	remote := make(map[string]StoredObject, len(objects))
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, w.t.Bucket.Prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") || w.ignored(rel) {
+			continue
+		}
+		remote[rel] = obj
+	}
+	return remote, nil
+}
+
+// planSyncFile decides what to do with a file from how each side differs from when they were
+// last in step. local and remote are nil where the file is missing, and base is nil where it has
+// not been in step before.
+func planSyncFile(local os.FileInfo, remote *StoredObject, base *syncEntry) string {
+	localChanged := local != nil && (base == nil || local.Size() != base.Size || !local.ModTime().Equal(base.ModTime))
+	remoteChanged := remote != nil && (base == nil || remote.ETag != base.ETag)
+	switch {
+	case local == nil && remote == nil:
+		return syncForget
+	case local != nil && remote != nil:
+		switch {
+		case localChanged && remoteChanged:
+			return syncConflict
+		case localChanged:
+			return syncUpload
+		case remoteChanged:
+			return syncDownload
+		}
+		return syncNone
+	case local != nil:
+		// A file changed since the object was removed is kept, and uploaded again
+		if localChanged {
+			return syncUpload
+		}
+		return syncRemoveLocal
+	default:
+		if remoteChanged {
+			return syncDownload
+		}
+		return syncRemoveRemote
+	}
+}
+
+// plan lists what a pass would do with each file which needs something done
+func (w *twoWayWorkflow) plan(ctx context.Context) (map[string]string, map[string]os.FileInfo, map[string]StoredObject, error) {
+	local, err := w.listLocal()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	remote, err := w.listRemote(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	names := make(map[string]bool, len(local)+len(remote))
+	for rel := range local {
+		names[rel] = true
+	}
+	for rel := range remote {
+		names[rel] = true
+	}
+	for rel := range w.state.Files {
+		names[rel] = true
+	}
+	actions := make(map[string]string)
+	for rel := range names {
+		var obj *StoredObject
+		if o, ok := remote[rel]; ok {
+			obj = &o
+		}
+		var base *syncEntry
+		if e, ok := w.state.Files[rel]; ok {
+			base = &e
+		}
+		if action := planSyncFile(local[rel], obj, base); action != syncNone {
+			actions[rel] = action
+		}
+	}
+	return actions, local, remote, nil
+}
+
+// pass compares the folder with the bucket and carries out what that calls for, file by file,
+// saving the state once done. It returns how many files needed something done.
+func (w *twoWayWorkflow) pass(ctx context.Context, lf log.Fields) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	actions, local, remote, err := w.plan(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for rel, action := range actions {
+		if ctx.Err() != nil {
+			break
+		}
+		var obj *StoredObject
+		if o, ok := remote[rel]; ok {
+			obj = &o
+		}
+		// Failures are logged by syncFile as they occur, and the file is tried again next pass
+		_ = w.syncFile(lf, rel, action, local[rel], obj)
+	}
+	if err := w.saveState(); err != nil {
+		return len(actions), fmt.Errorf("failed to save sync state: %w", err)
+	}
+	return len(actions), nil
+}
+
+// syncFile carries out a single file's action, tracing any transfer as a transfer of its own
+func (w *twoWayWorkflow) syncFile(lf log.Fields, rel, action string, local os.FileInfo, remote *StoredObject) (err error) {
+	lf = maps.Clone(lf)
+	lf["file"] = rel
+	switch action {
+	case syncForget:
+		delete(w.state.Files, rel)
+		return nil
+	case syncRemoveLocal:
+		if err := os.Remove(w.localPath(rel)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.WithFields(lf).Error("failed to remove local file: ", err)
+			return err
+		}
+		delete(w.state.Files, rel)
+		log.WithFields(lf).Info("removed local file, as it was removed from the bucket")
+		return nil
+	case syncRemoveRemote:
+		ctx, cancel := context.WithTimeout(context.Background(), remoteTimeouts(w.remote).Upload)
+		defer cancel()
+		if err := w.store.Remove(ctx, w.key(rel)); err != nil {
+			log.WithFields(lf).Error("failed to remove object: ", err)
+			return err
+		}
+		delete(w.state.Files, rel)
+		log.WithFields(lf).Info("removed object, as it was removed from the local folder")
+		return nil
+	}
+
+	finish, ok := startTransfer()
+	if !ok {
+		log.WithFields(lf).Warn("shutting down, not synchronising file")
+		return nil
+	}
+	defer finish()
+	id := newTransferID()
+	lf = withTransferID(lf, id)
+	defer reportPanic(lf)
+	ctx, span := startSpan(contextWithTransferID(context.Background(), id), "sync.file",
+		attribute.String("workflow", w.t.Name),
+		attribute.String("file", rel),
+		attribute.String("transfer.id", id),
+	)
+	name := w.localPath(rel)
+	emitEvent(LifecycleEvent{Event: eventDetected, TransferID: id, Workflow: w.t.Name, Path: name})
+	done := trackWorkflow(w.t.Name)
+	defer func() {
+		done(err)
+		if err != nil {
+			emitEvent(LifecycleEvent{Event: eventFailed, TransferID: id, Workflow: w.t.Name, Path: name, Error: err.Error()})
+		}
+		endSpan(span, err)
+	}()
+
+	if action == syncConflict {
+		if action, err = w.resolveConflict(lf, rel, local, remote); err != nil || action == syncNone {
+			return err
+		}
+	}
+	if action == syncUpload {
+		return w.upload(ctx, lf, rel)
+	}
+	return w.download(ctx, lf, rel)
+}
+
+// resolveConflict decides a file changed on both sides, returning the transfer which settles it.
+// Files whose contents are the same after all need no transfer, and with conflict: rename the
+// local copy is moved aside, to be uploaded as a new file next pass, and the object downloaded.
+func (w *twoWayWorkflow) resolveConflict(lf log.Fields, rel string, local os.FileInfo, remote *StoredObject) (string, error) {
+	name := w.localPath(rel)
+	if local.Size() == remote.Size && len(remote.ETag) == md5.Size*2 {
+		sum, err := fileMD5(name)
+		if err != nil {
+			return syncNone, err
+		}
+		if sum == remote.ETag {
+			w.state.Files[rel] = syncEntry{Size: local.Size(), ModTime: local.ModTime(), ETag: remote.ETag}
+			return syncNone, nil
+		}
+	}
+	if w.t.Conflict == conflictRename {
+		renamed := conflictName(name, time.Now())
+		if err := os.Rename(name, renamed); err != nil {
+			return syncNone, fmt.Errorf("failed to rename conflicting file: %w", err)
+		}
+		log.WithFields(lf).WithField("renamed", renamed).Warn("file changed locally and in the bucket, keeping both")
+		return syncDownload, nil
+	}
+	if local.ModTime().After(remote.LastModified) {
+		log.WithFields(lf).Warn("file changed locally and in the bucket, keeping the newer local file")
+		return syncUpload, nil
+	}
+	log.WithFields(lf).Warn("file changed locally and in the bucket, keeping the newer object")
+	return syncDownload, nil
+}
+
+// conflictName returns the name a conflicting local file is renamed to, keeping its extension
+func conflictName(name string, now time.Time) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + " (conflict " + now.Format("2006-01-02 150405") + ")" + ext
+}
+
+// upload sends a local file to the bucket and records both sides as in step
+func (w *twoWayWorkflow) upload(ctx context.Context, lf log.Fields, rel string) error {
+	name, key := w.localPath(rel), w.key(rel)
+	// #nosec G304 - intentional: path is within the configured folder
+	f, err := os.Open(name)
+	if err != nil {
+		log.WithFields(lf).Error("failed to open file: ", err)
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil {
+			log.WithFields(lf).Error("failed to close file: ", closeErr)
+		}
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		log.WithFields(lf).Error("unable to query file: ", err)
+		return err
+	}
+
+	transferID := transferIDFromContext(ctx)
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: transferID,
+		Workflow:   w.t.Name,
+		Path:       name,
+		Remote:     w.remote.Endpoint,
+		Bucket:     w.t.Bucket.Bucket,
+		Key:        key,
+		Size:       fi.Size(),
+	})
+	timeouts := remoteTimeouts(w.remote)
+	breaker := remoteBreaker(w.remote.Endpoint, w.remote.CircuitBreaker)
+	var checksum string
+	progress := startProgress(transferID, w.t.Name, directionUpload, name, fi.Size())
+	defer progress.finish()
+	start := time.Now()
+	err = retryWithBackoff(ctx, transferRetry(w.t.Retry, w.remote.Retry), func() error {
+		job, err := scheduleTransfer(ctx, w.t.Name, w.t.Priority, bandwidthLimit(w.t.MaxBandwidth), w.remote, uploadMemory(fi.Size()))
+		if err != nil {
+			return err
+		}
+		defer job.release()
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := breaker.allow(); err != nil {
+			return err
+		}
+		h := sha256.New()
+		progress.restart()
+		r := io.TeeReader(job.throttle(f), io.MultiWriter(h, progress))
+		putCtx, cancel := context.WithTimeout(ctx, timeouts.Upload)
+		defer cancel()
+		err = w.store.Put(putCtx, key, r, fi.Size(), map[string]string{"Transfer-Id": transferID})
+		breaker.record(err)
+		if err == nil {
+			checksum = hex.EncodeToString(h.Sum(nil))
+		}
+		return err
+	})
+	var obj StoredObject
+	if err == nil {
+		// The object's ETag tells whether it changes in the bucket from now on
+		statCtx, cancel := context.WithTimeout(ctx, timeouts.Stat)
+		obj, err = w.store.Stat(statCtx, key)
+		cancel()
+	}
+	rec := w.record(directionUpload, transferID, name, key, fi.Size(), checksum, time.Since(start), err)
+	if err != nil {
+		log.WithFields(lf).Error("failed to upload file after retries: ", err)
+		return err
+	}
+	w.state.Files[rel] = syncEntry{Size: fi.Size(), ModTime: fi.ModTime(), ETag: obj.ETag}
+	emitEvent(transferEvent(eventUploaded, rec))
+	log.WithFields(lf).WithFields(log.Fields{
+		"key":        key,
+		"size":       fi.Size(),
+		"throughput": formatRate(transferRate(rec.Size, rec.Duration)),
+	}).Info("uploaded file to bucket")
+	return nil
+}
+
+// download fetches an object into the folder and records both sides as in step. It is written
+// beside its final name and renamed once complete, so a failure leaves the local file alone.
+func (w *twoWayWorkflow) download(ctx context.Context, lf log.Fields, rel string) error {
+	name, key := w.localPath(rel), w.key(rel)
+	transferID := transferIDFromContext(ctx)
+	emitEvent(LifecycleEvent{
+		Event:      eventStarted,
+		TransferID: transferID,
+		Workflow:   w.t.Name,
+		Path:       name,
+		Remote:     w.remote.Endpoint,
+		Bucket:     w.t.Bucket.Bucket,
+		Key:        key,
+	})
+	const dirPerms = 0750
+	if err := os.MkdirAll(filepath.Dir(name), dirPerms); err != nil {
+		log.WithFields(lf).Error("failed to create local folder: ", err)
+		return err
+	}
+	timeouts := remoteTimeouts(w.remote)
+	breaker := remoteBreaker(w.remote.Endpoint, w.remote.CircuitBreaker)
+	partPath := name + syncPartSuffix
+	var obj StoredObject
+	var checksum string
+	progress := startProgress(transferID, w.t.Name, directionDownload, name, 0)
+	defer progress.finish()
+	start := time.Now()
+	err := retryWithBackoff(ctx, transferRetry(w.t.Retry, w.remote.Retry), func() (err error) {
+		job, err := scheduleTransfer(ctx, w.t.Name, w.t.Priority, bandwidthLimit(w.t.MaxBandwidth), w.remote, transferBufferSize)
+		if err != nil {
+			return err
+		}
+		defer job.release()
+		if err := breaker.allow(); err != nil {
+			return err
+		}
+		defer func() {
+			breaker.record(err)
+		}()
+		getCtx, cancel := context.WithTimeout(ctx, timeouts.Download)
+		defer cancel()
+		r, info, err := w.store.Get(getCtx, key)
+		if err != nil {
+			return fmt.Errorf("failed to fetch object: %w", err)
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		obj = info
+		progress.setSize(info.Size)
+		progress.restart()
+		const filePerms = 0600
+		// #nosec G304 - intentional: path is within the configured folder
+		f, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, filePerms)
+		if err != nil {
+			return fmt.Errorf("failed to create local file: %w", err)
+		}
+		h := sha256.New()
+		n, err := copyBuffered(io.MultiWriter(f, h, progress), io.LimitReader(job.throttle(r), info.Size))
+		if err == nil && n < info.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy file from reader: %w", err)
+		}
+		checksum = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	var fi os.FileInfo
+	if err == nil {
+		err = os.Rename(partPath, name)
+	}
+	if err == nil {
+		fi, err = os.Stat(name)
+	}
+	if err != nil {
+		_ = os.Remove(partPath)
+	}
+	rec := w.record(directionDownload, transferID, name, key, obj.Size, checksum, time.Since(start), err)
+	if err != nil {
+		log.WithFields(lf).Error("failed to download object after retries: ", err)
+		return err
+	}
+	w.state.Files[rel] = syncEntry{Size: fi.Size(), ModTime: fi.ModTime(), ETag: obj.ETag}
+	emitEvent(transferEvent(eventDownloaded, rec))
+	log.WithFields(lf).WithFields(log.Fields{
+		"key":        key,
+		"size":       obj.Size,
+		"throughput": formatRate(transferRate(rec.Size, rec.Duration)),
+	}).Info("retrieved remote object to local file")
+	return nil
+}
+
+// record records a transfer's outcome, returning the record
+func (w *twoWayWorkflow) record(direction, id, name, key string, size int64, checksum string, d time.Duration, err error) TransferRecord {
+	rec := TransferRecord{
+		ID:        id,
+		Workflow:  w.t.Name,
+		Direction: direction,
+		Path:      name,
+		Remote:    w.remote.Endpoint,
+		Bucket:    w.t.Bucket.Bucket,
+		Key:       key,
+		Size:      size,
+		Checksum:  checksum,
+		Duration:  d,
+		Status:    transferSuccess,
+	}
+	if err != nil {
+		rec.Status, rec.Error, rec.Checksum = transferFailed, err.Error(), ""
+	}
+	recordTransfer(rec)
+	return rec
+}
+
+// twoWay keeps a sync workflow's folder and bucket in step in the background until shutdown
+func twoWay(t TwoWaySync) {
+	go twoWayWithContext(serviceCtx, t)
+}
+
+func twoWayWithContext(ctx context.Context, t TwoWaySync) {
+	state := registerWorkflow(t.Name, workflowSync)
+	state.setThresholds(t.Thresholds)
+
+	setWorkflowLogLevel(t.Name, t.LogLevel)
+	setWorkflowSampling(t.Name, t.Sampling)
+	lf := workflowLogFields(t.Name, t.Labels, log.Fields{
+		"folder": t.Local,
+		"bucket": t.Bucket.Bucket,
+	})
+	w, err := newTwoWayWorkflow(t)
+	if err != nil {
+		log.WithFields(lf).Error(err)
+		state.setHealth(healthDegraded)
+		return
+	}
+	state.setLocation(t.Local + " with " + w.remote.Endpoint + "/" + t.Bucket.Bucket + "/" + t.Bucket.Prefix)
+	log.WithFields(lf).Info("configuring two-way sync for '", t.Description, "'")
+
+	// Passes are run one at a time, whether polled or asked for through the admin API
+	passes := make(chan struct{}, 1)
+	state.scan = func() (int, error) {
+		if !w.mu.TryLock() {
+			return 0, errSyncInProgress
+		}
+		actions, _, _, err := w.plan(ctx)
+		w.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case passes <- struct{}{}:
+		default:
+		}
+		return len(actions), nil
+	}
+
+	// Both sides are compared every poll interval from the start, or each time the schedule
+	// comes round
+	var schedule *cronSchedule
+	if t.Schedule != "" {
+		if schedule, err = parseSchedule(t.Schedule); err != nil {
+			log.WithFields(lf).Error(err)
+			state.setHealth(healthDegraded)
+			return
+		}
+	}
+	interval := t.PollInterval
+	if interval == 0 {
+		interval = defaultSyncPollInterval
+	}
+	wait := func() time.Duration {
+		if schedule != nil {
+			return time.Until(schedule.next(time.Now()))
+		}
+		return interval
+	}
+	state.setHealth(healthWatching)
+	var first time.Duration
+	if schedule != nil {
+		first = wait()
+	}
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(wait())
+		case <-passes:
+		case <-ctx.Done():
+			log.WithFields(lf).Info("sync stopped, no longer comparing folder and bucket")
+			return
+		}
+		if err := state.waitWhilePaused(ctx); err != nil {
+			continue
+		}
+		// Only the leader syncs, so that replicas don't transfer the same files
+		if !isLeader() {
+			continue
+		}
+		if _, err := w.pass(ctx, lf); err != nil {
+			log.WithFields(lf).Error(err)
+			state.setHealth(healthDegraded)
+			continue
+		}
+		state.setHealth(healthWatching)
+	}
+}
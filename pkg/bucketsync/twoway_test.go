@@ -0,0 +1,121 @@
+package bucketsync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanSyncFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(name, []byte("report"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	local, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inStep := &syncEntry{Size: local.Size(), ModTime: local.ModTime(), ETag: "etag1"}
+	edited := &syncEntry{Size: local.Size() + 1, ModTime: local.ModTime(), ETag: "etag1"}
+	same := &StoredObject{Key: "report.txt", ETag: "etag1"}
+	changed := &StoredObject{Key: "report.txt", ETag: "etag2"}
+
+	tests := []struct {
+		name   string
+		local  os.FileInfo
+		remote *StoredObject
+		base   *syncEntry
+		want   string
+	}{
+		{"in step", local, same, inStep, syncNone},
+		{"new locally", local, nil, nil, syncUpload},
+		{"new in bucket", nil, same, nil, syncDownload},
+		{"new on both sides", local, same, nil, syncConflict},
+		{"changed locally", local, same, edited, syncUpload},
+		{"changed in bucket", local, changed, inStep, syncDownload},
+		{"changed on both sides", local, changed, edited, syncConflict},
+		{"removed locally", nil, same, inStep, syncRemoveRemote},
+		{"removed from bucket", local, nil, inStep, syncRemoveLocal},
+		{"removed locally, changed in bucket", nil, changed, inStep, syncDownload},
+		{"removed from bucket, changed locally", local, nil, edited, syncUpload},
+		{"removed from both", nil, nil, inStep, syncForget},
+	}
+	for _, tt := range tests {
+		if got := planSyncFile(tt.local, tt.remote, tt.base); got != tt.want {
+			t.Errorf("%s: planSyncFile = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConflictName(t *testing.T) {
+	now := time.Date(2026, 10, 16, 14, 30, 5, 0, time.UTC)
+	if got := conflictName("/data/report.txt", now); got != "/data/report (conflict 2026-10-16 143005).txt" {
+		t.Errorf("conflictName = %q", got)
+	}
+	if got := conflictName("/data/README", now); got != "/data/README (conflict 2026-10-16 143005)" {
+		t.Errorf("conflictName = %q", got)
+	}
+}
+
+func TestSyncStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w := &twoWayWorkflow{stateFile: filepath.Join(dir, defaultSyncStateFile)}
+	var err error
+	if w.state, err = loadSyncState(w.stateFile); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	w.state.Files["a/b.txt"] = syncEntry{Size: 3, ModTime: modTime, ETag: "etag"}
+	if err := w.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadSyncState(w.stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := state.Files["a/b.txt"]; e.Size != 3 || !e.ModTime.Equal(modTime) || e.ETag != "etag" {
+		t.Errorf("loaded entry %+v", e)
+	}
+}
+
+func TestSyncListLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"top.txt", "sub/nested.txt", "sub/partial.txt" + syncPartSuffix, "skip.tmp", defaultSyncStateFile} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := &twoWayWorkflow{
+		t:         TwoWaySync{Local: dir, IgnorePatterns: []string{"*.tmp"}},
+		stateFile: filepath.Join(dir, defaultSyncStateFile),
+	}
+	files, err := w.listLocal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files["top.txt"] == nil || files["sub/nested.txt"] == nil {
+		t.Errorf("listLocal = %v, want top.txt and sub/nested.txt", files)
+	}
+}
+
+func TestSyncValidate(t *testing.T) {
+	cfg := Config{
+		Remotes: []Remote{{Name: "minio", Endpoint: "minio.example.com"}},
+		Sync: []TwoWaySync{{
+			Name:     "documents",
+			Bucket:   BucketLocation{Remote: "minio", Bucket: "documents"},
+			Conflict: "oldest",
+		}},
+	}
+	errs := cfg.Validate()
+	if len(errs) != 2 {
+		t.Fatalf("Validate returned %v, want errors for local and conflict", errs)
+	}
+}
@@ -0,0 +1,160 @@
+package bucketsync
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// uploadPool uploads the files a watcher reports through a fixed number of workers, so that the
+// watcher goes on handling events while files upload, and a burst of files uploads in parallel.
+// A file reported again while queued is queued once, and one reported while uploading is
+// uploaded again once that upload is done, never by two workers at once.
+type uploadPool struct {
+	workers int
+	upload  func(name string)
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	queue  []queuedUpload
+	active map[string]bool
+}
+
+// queuedUpload is a file waiting for a worker, and how to upload it if not as the pool does
+type queuedUpload struct {
+	name   string
+	upload func()
+}
+
+func newUploadPool(workers int, upload func(name string)) *uploadPool {
+	p := &uploadPool{workers: max(workers, 1), upload: upload, active: make(map[string]bool)}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+// add queues a file for upload
+func (p *uploadPool) add(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// A file queued to be uploaded some other way is still uploaded as usual
+	if !slices.ContainsFunc(p.queue, func(u queuedUpload) bool { return u.name == name && u.upload == nil }) {
+		p.queue = append(p.queue, queuedUpload{name: name})
+		p.cond.Signal()
+	}
+}
+
+// addFunc queues a file to be uploaded by upload rather than as the pool uploads files, such as
+// to one destination of several held while its remote was down
+func (p *uploadPool) addFunc(name string, upload func()) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.queue = append(p.queue, queuedUpload{name: name, upload: upload})
+	p.cond.Signal()
+}
+
+// run starts the pool's workers, which upload queued files until ctx is done, leaving those
+// still queued. The workers are added to wg, so that it waits for the uploads in progress.
+func (p *uploadPool) run(ctx context.Context, wg *sync.WaitGroup) {
+	stop := context.AfterFunc(ctx, func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		p.cond.Broadcast()
+	})
+	var workers sync.WaitGroup
+	for range p.workers {
+		workers.Go(func() {
+			p.work(ctx)
+		})
+	}
+	wg.Go(func() {
+		workers.Wait()
+		stop()
+	})
+}
+
+func (p *uploadPool) work(ctx context.Context) {
+	for {
+		u, ok := p.take(ctx)
+		if !ok {
+			return
+		}
+		if uploadSlots.acquire(ctx) {
+			if u.upload != nil {
+				u.upload()
+			} else {
+				p.upload(u.name)
+			}
+			uploadSlots.release()
+		}
+		p.mutex.Lock()
+		delete(p.active, u.name)
+		// The file may have been queued again, and left for this upload to finish
+		p.cond.Broadcast()
+		p.mutex.Unlock()
+	}
+}
+
+// take waits for a queued file which is not already being uploaded, and marks it as being
+// uploaded, reporting false once ctx is done
+func (p *uploadPool) take(ctx context.Context) (queuedUpload, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for ctx.Err() == nil {
+		if i := slices.IndexFunc(p.queue, func(u queuedUpload) bool { return !p.active[u.name] }); i >= 0 {
+			u := p.queue[i]
+			p.queue = slices.Delete(p.queue, i, i+1)
+			p.active[u.name] = true
+			return u, true
+		}
+		p.cond.Wait()
+	}
+	return queuedUpload{}, false
+}
+
+// uploadLimit caps the uploads of watched files in progress across all workflows at
+// max_concurrent_uploads, if set
+type uploadLimit struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	active int
+}
+
+var uploadSlots = newUploadLimit()
+
+func newUploadLimit() *uploadLimit {
+	l := &uploadLimit{}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+// acquire waits for a free slot, reporting false if ctx is done first
+func (l *uploadLimit) acquire(ctx context.Context) bool {
+	stop := context.AfterFunc(ctx, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.cond.Broadcast()
+	})
+	defer stop()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for {
+		if ctx.Err() != nil {
+			return false
+		}
+		configMutex.RLock()
+		limit := config.MaxConcurrentUploads
+		configMutex.RUnlock()
+		if limit <= 0 || l.active < limit {
+			l.active++
+			return true
+		}
+		l.cond.Wait()
+	}
+}
+
+func (l *uploadLimit) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.active--
+	l.cond.Broadcast()
+}
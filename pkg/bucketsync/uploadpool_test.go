@@ -0,0 +1,104 @@
+package bucketsync
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyRecorder records the most uploads in progress at once, overall and of each file
+type concurrencyRecorder struct {
+	mutex    sync.Mutex
+	active   int
+	peak     int
+	files    map[string]int
+	overlaps int
+	uploaded atomic.Int32
+}
+
+func (c *concurrencyRecorder) upload(name string) {
+	c.mutex.Lock()
+	c.active++
+	c.peak = max(c.peak, c.active)
+	c.files[name]++
+	if c.files[name] > 1 {
+		c.overlaps++
+	}
+	c.mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	c.mutex.Lock()
+	c.active--
+	c.files[name]--
+	c.mutex.Unlock()
+	c.uploaded.Add(1)
+}
+
+func runUploadPool(t *testing.T, workers int, names ...string) *concurrencyRecorder {
+	t.Helper()
+	rec := &concurrencyRecorder{files: make(map[string]int)}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	p := newUploadPool(workers, rec.upload)
+	p.run(ctx, &wg)
+	for _, name := range names {
+		p.add(name)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for int(rec.uploaded.Load()) < len(names) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+	return rec
+}
+
+func TestUploadPool(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = Config{}
+
+	rec := runUploadPool(t, 3, "a", "b", "c", "d", "e", "f")
+	if rec.uploaded.Load() != 6 || rec.peak != 3 {
+		t.Errorf("uploaded %d files, %d at once, want 6, 3 at once", rec.uploaded.Load(), rec.peak)
+	}
+
+	// The global cap holds every workflow's workers to it
+	config.MaxConcurrentUploads = 1
+	if rec := runUploadPool(t, 3, "a", "b", "c"); rec.peak != 1 {
+		t.Errorf("uploaded %d at once, want 1", rec.peak)
+	}
+}
+
+func TestUploadPoolRequeue(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	config = Config{}
+
+	rec := &concurrencyRecorder{files: make(map[string]int)}
+	started := make(chan struct{}, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	p := newUploadPool(4, func(name string) {
+		started <- struct{}{}
+		rec.upload(name)
+	})
+	p.run(ctx, &wg)
+	p.add("report.csv")
+	<-started
+	// Reported again while uploading, the file is uploaded once more afterwards, and reported
+	// twice while queued, only once
+	p.add("report.csv")
+	p.add("report.csv")
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.uploaded.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+	if rec.uploaded.Load() != 2 || rec.overlaps != 0 {
+		t.Errorf("uploaded %d times with %d overlapping, want twice, one after the other", rec.uploaded.Load(), rec.overlaps)
+	}
+}
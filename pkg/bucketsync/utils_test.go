@@ -0,0 +1,50 @@
+package bucketsync
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"512B", 512, false},
+		{"10KB", 10000, false},
+		{"10MB", 10000000, false},
+		{"1GB", 1000000000, false},
+		{"1.5 MB", 1500000, false},
+		{"1MiB", 1 << 20, false},
+		{"2gib", 2 << 30, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"10XB", 0, true},
+		{"-1MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := map[int64]string{
+		0:          "0 B",
+		999:        "999 B",
+		1500:       "1.5 kB",
+		2000000:    "2.0 MB",
+		1000000000: "1.0 GB",
+	}
+	for in, want := range tests {
+		if got := formatByteSize(in); got != want {
+			t.Errorf("formatByteSize(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	vaultAuthToken      = "token"
+	vaultAuthAppRole    = "approle"
+	vaultAuthKubernetes = "kubernetes"
+
+	// defaultVaultRefresh is how often secrets without a lease, such as those of the KV engine,
+	// are read again
+	defaultVaultRefresh = 5 * time.Minute
+	// vaultRequestTimeout bounds each request to the server
+	vaultRequestTimeout = 30 * time.Second
+)
+
+// Vault is the HashiCorp Vault server which remotes with a vaultPath read their keys from
+type Vault struct {
+	// Address is the server's URL, e.g. https://vault.example.com:8200, by default $VAULT_ADDR
+	Address string `yaml:"address,omitempty"`
+	// Namespace is the Vault Enterprise namespace the paths are in, if any
+	Namespace string `yaml:"namespace,omitempty"`
+	// Auth is how the daemon logs in: "token", the default, "approle" or "kubernetes"
+	Auth string `yaml:"auth,omitempty"`
+	// Mount is the path the approle or kubernetes auth method is enabled at, by default its name
+	Mount string `yaml:"mount,omitempty"`
+	// Token is the token for auth: token, or TokenFile holds it, such as the sink of a Vault
+	// agent, read again whenever the token is refused. By default it is $VAULT_TOKEN.
+	Token     string `yaml:"token,omitempty"`
+	TokenFile string `yaml:"token_file,omitempty"`
+	// RoleID and SecretIDFile, which holds the secret ID, log in with auth: approle
+	RoleID       string `yaml:"role_id,omitempty"`
+	SecretIDFile string `yaml:"secret_id_file,omitempty"`
+	// Role is the role logged in as with auth: kubernetes, using the service account token in
+	// JWTFile, by default the pod's own
+	Role    string `yaml:"role,omitempty"`
+	JWTFile string `yaml:"jwt_file,omitempty"`
+	// Refresh is how often secrets without a lease are read again, by default 5 minutes
+	Refresh time.Duration `yaml:"refresh,omitempty"`
+	// TLS configures the certificates trusted and presented for https:// addresses
+	TLS TLSOptions `yaml:"tls,omitempty"`
+}
+
+func (v Vault) validate() error {
+	switch v.Auth {
+	case "", vaultAuthToken:
+		if v.Token != "" && v.TokenFile != "" {
+			return errors.New("token and token_file cannot both be set")
+		}
+	case vaultAuthAppRole:
+		if v.RoleID == "" || v.SecretIDFile == "" {
+			return errors.New("role_id and secret_id_file are required with auth: approle")
+		}
+	case vaultAuthKubernetes:
+		if v.Role == "" {
+			return errors.New("role is required with auth: kubernetes")
+		}
+	default:
+		return errors.New("auth: must be token, approle or kubernetes")
+	}
+	if v.Refresh < 0 {
+		return errors.New("refresh: must not be negative")
+	}
+	if err := v.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	return nil
+}
+
+// address returns the server's URL, as configured or from the environment
+func (v Vault) address() string {
+	if v.Address != "" {
+		return strings.TrimRight(v.Address, "/")
+	}
+	return strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+}
+
+// vaultResponse is the part of the server's answers which is used
+type vaultResponse struct {
+	LeaseID       string         `json:"lease_id"`
+	LeaseDuration int            `json:"lease_duration"`
+	Renewable     bool           `json:"renewable"`
+	Data          map[string]any `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// errVaultForbidden is returned when the server refuses the token, which is then replaced
+var errVaultForbidden = errors.New("permission denied")
+
+// vaultClient reads secrets from the server with a token it renews, and logs in for again once
+// the token can no longer be renewed or is refused
+type vaultClient struct {
+	cfg    Vault
+	client *http.Client
+
+	mutex sync.Mutex
+	token string
+	// expires is when the token must be renewed by, zero if it is not known to expire
+	expires   time.Time
+	renewable bool
+}
+
+var (
+	vaultClientsMutex sync.Mutex
+	vaultClients      = make(map[Vault]*vaultClient)
+)
+
+// sharedVaultClient returns the client for the server, shared by every remote reading from it so
+// that they share its token
+func sharedVaultClient(cfg Vault) (*vaultClient, error) {
+	vaultClientsMutex.Lock()
+	defer vaultClientsMutex.Unlock()
+	if c, ok := vaultClients[cfg]; ok {
+		return c, nil
+	}
+	tlsConfig, err := cfg.TLS.config()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault client: %w", err)
+	}
+	c := &vaultClient{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   vaultRequestTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}
+	vaultClients[cfg] = c
+	return c, nil
+}
+
+// read reads the secret at path, logging in again once if the token is refused
+func (c *vaultClient) read(ctx context.Context, p string) (*vaultResponse, error) {
+	resp, err := c.authenticated(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from Vault: %w", p, err)
+	}
+	return resp, nil
+}
+
+// renewLease extends the lease of a secret read earlier
+func (c *vaultClient) renewLease(ctx context.Context, leaseID string) (*vaultResponse, error) {
+	resp, err := c.authenticated(ctx, http.MethodPut, "sys/leases/renew", map[string]any{"lease_id": leaseID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew Vault lease: %w", err)
+	}
+	return resp, nil
+}
+
+// authenticated sends a request with the client's token, replacing the token and trying again
+// once if it is refused
+func (c *vaultClient) authenticated(ctx context.Context, method, p string, body any) (*vaultResponse, error) {
+	token, err := c.currentToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, method, p, token, body)
+	if errors.Is(err, errVaultForbidden) {
+		c.forgetToken(token)
+		if token, err = c.currentToken(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = c.do(ctx, method, p, token, body)
+	}
+	return resp, err
+}
+
+// currentToken returns a token which has not expired, renewing it or logging in as necessary
+func (c *vaultClient) currentToken(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	if c.token != "" && (c.expires.IsZero() || now.Before(c.expires)) {
+		return c.token, nil
+	}
+	if c.token != "" && c.renewable {
+		resp, err := c.do(ctx, http.MethodPost, "auth/token/renew-self", c.token, nil)
+		if err == nil && resp.Auth != nil && resp.Auth.LeaseDuration > 0 {
+			c.setToken(c.token, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+			return c.token, nil
+		}
+		log.WithError(err).Debug("Failed to renew Vault token, logging in again")
+	}
+	if err := c.login(ctx); err != nil {
+		c.token = ""
+		return "", err
+	}
+	return c.token, nil
+}
+
+// forgetToken discards a token the server refused, unless it has been replaced already
+func (c *vaultClient) forgetToken(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// setToken records a token, to be renewed once two thirds of its lease have passed
+func (c *vaultClient) setToken(token string, leaseSeconds int, renewable bool) {
+	c.token, c.renewable, c.expires = token, renewable, time.Time{}
+	if leaseSeconds > 0 {
+		c.expires = time.Now().Add(time.Duration(leaseSeconds) * time.Second * 2 / 3)
+	}
+}
+
+// login obtains a token with the configured auth method
+func (c *vaultClient) login(ctx context.Context) error {
+	var body map[string]any
+	switch c.cfg.Auth {
+	case vaultAuthAppRole:
+		secretID, err := readCredentialFile(c.cfg.SecretIDFile, "")
+		if err != nil {
+			return fmt.Errorf("failed to read Vault secret ID: %w", err)
+		}
+		body = map[string]any{"role_id": c.cfg.RoleID, "secret_id": secretID}
+	case vaultAuthKubernetes:
+		jwtFile := c.cfg.JWTFile
+		if jwtFile == "" {
+			jwtFile = path.Join(serviceAccountDir, "token")
+		}
+		jwt, err := readCredentialFile(jwtFile, "")
+		if err != nil {
+			return fmt.Errorf("failed to read service account token: %w", err)
+		}
+		body = map[string]any{"role": c.cfg.Role, "jwt": jwt}
+	default:
+		token, err := readCredentialFile(c.cfg.TokenFile, c.cfg.Token)
+		if err != nil {
+			return fmt.Errorf("failed to read Vault token: %w", err)
+		}
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		if token == "" {
+			return errors.New("no Vault token configured")
+		}
+		c.setToken(token, 0, false)
+		return nil
+	}
+	mount := c.cfg.Mount
+	if mount == "" {
+		mount = c.cfg.Auth
+	}
+	resp, err := c.do(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", "", body)
+	if err != nil {
+		return fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("failed to log in to Vault: no token returned")
+	}
+	c.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	log.WithField("auth", c.cfg.Auth).Debug("Logged in to Vault")
+	return nil
+}
+
+// do sends a request to the API, returning its answer or the server's errors
+func (c *vaultClient) do(ctx context.Context, method, p, token string, body any) (*vaultResponse, error) {
+	address := c.cfg.address()
+	if address == "" {
+		return nil, errors.New("no Vault address configured")
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	ctx, cancel := context.WithTimeout(ctx, vaultRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, address+"/v1/"+strings.TrimLeft(p, "/"), reader)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	var answer vaultResponse
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&answer)
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, errVaultForbidden
+	case resp.StatusCode >= 300 && len(answer.Errors) > 0:
+		return nil, fmt.Errorf("Vault returned %s: %s", resp.Status, strings.Join(answer.Errors, "; "))
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("Vault returned %s", resp.Status)
+	case decodeErr != nil && !errors.Is(decodeErr, io.EOF):
+		return nil, fmt.Errorf("failed to read Vault response: %w", decodeErr)
+	}
+	return &answer, nil
+}
+
+// vaultCredentials provides keys read from a secret in Vault: a KV secret with access_key and
+// secret_key, or credentials issued by the AWS secrets engine. A secret with a lease is renewed
+// while the lease can be extended, and read again once it cannot. One without is read again
+// every refresh.
+type vaultCredentials struct {
+	credentials.Expiry
+	vault Vault
+	path  string
+
+	value     credentials.Value
+	leaseID   string
+	renewable bool
+}
+
+func (p *vaultCredentials) RetrieveWithCredContext(_ *credentials.CredContext) (credentials.Value, error) {
+	ctx := context.Background()
+	client, err := sharedVaultClient(p.vault)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if p.leaseID != "" && p.renewable {
+		resp, err := client.renewLease(ctx, p.leaseID)
+		if err == nil && resp.LeaseDuration > 0 {
+			p.renewable = resp.Renewable
+			p.expireAfter(resp.LeaseDuration)
+			return p.value, nil
+		}
+		log.WithError(err).WithField("path", p.path).Debug("Failed to renew Vault lease, reading the secret again")
+	}
+	resp, err := client.read(ctx, p.path)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	value, err := vaultSecretKeys(resp.Data)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Vault secret %s: %w", p.path, err)
+	}
+	p.value, p.leaseID, p.renewable = value, resp.LeaseID, resp.Renewable
+	p.expireAfter(resp.LeaseDuration)
+	// Keys issued by Vault change with each lease, and are redacted from the log as they arrive
+	addLogSecrets(value.AccessKeyID, value.SecretAccessKey, value.SessionToken)
+	return p.value, nil
+}
+
+func (p *vaultCredentials) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithCredContext(nil)
+}
+
+// expireAfter sets the keys to be renewed or read again once two thirds of their lease have
+// passed, or after refresh if they have none
+func (p *vaultCredentials) expireAfter(leaseSeconds int) {
+	if leaseSeconds <= 0 {
+		refresh := p.vault.Refresh
+		if refresh == 0 {
+			refresh = defaultVaultRefresh
+		}
+		p.SetExpiration(time.Now().Add(refresh), 0)
+		return
+	}
+	lease := time.Duration(leaseSeconds) * time.Second
+	p.SetExpiration(time.Now().Add(lease), lease/3)
+}
+
+// vaultSecretKeys finds the keys in a secret's data, unwrapping that of version 2 of the KV engine
+func vaultSecretKeys(data map[string]any) (credentials.Value, error) {
+	if inner, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = inner
+	}
+	field := func(names ...string) string {
+		for _, name := range names {
+			if s, ok := data[name].(string); ok && s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	value := credentials.Value{
+		AccessKeyID:     field("access_key", "accessKey"),
+		SecretAccessKey: field("secret_key", "secretKey"),
+		SessionToken:    field("security_token", "session_token"),
+		SignerType:      credentials.SignatureV4,
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return credentials.Value{}, errors.New("access_key and secret_key not found")
+	}
+	return value, nil
+}
+
+// currentVault returns the configured Vault server
+func currentVault() Vault {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return config.Vault
+}
@@ -0,0 +1,170 @@
+package bucketsync
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeVault serves AppRole logins, a KV version 2 secret and AWS secrets engine credentials,
+// counting the calls made to each
+type fakeVault struct {
+	mutex  sync.Mutex
+	token  string
+	calls  map[string]int
+	issued int
+}
+
+func newFakeVault(t *testing.T) (*httptest.Server, *fakeVault) {
+	t.Helper()
+	v := &fakeVault{calls: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+		v.calls[r.URL.Path]++
+		if r.URL.Path == "/v1/auth/approle/login" {
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["role_id"] != "uploader" || body["secret_id"] != "s3cret" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = io.WriteString(w, `{"errors":["invalid role or secret ID"]}`)
+				return
+			}
+			v.issued++
+			v.token = "hvs.token" + strings.Repeat("x", v.issued)
+			_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": v.token, "lease_duration": 3600, "renewable": true}})
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != v.token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/minio":
+			_, _ = io.WriteString(w, `{"data":{"data":{"access_key":"AKIAKV","secret_key":"kvsecret"},"metadata":{"version":3}}}`)
+		case "/v1/aws/creds/uploader":
+			_, _ = io.WriteString(w, `{"lease_id":"aws/creds/uploader/abc","lease_duration":900,"renewable":true,"data":{"access_key":"ASIAAWS","secret_key":"awssecret","security_token":"session"}}`)
+		case "/v1/sys/leases/renew":
+			_, _ = io.WriteString(w, `{"lease_id":"aws/creds/uploader/abc","lease_duration":900,"renewable":true}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"errors":[]}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, v
+}
+
+func (v *fakeVault) count(path string) int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.calls[path]
+}
+
+// revoke invalidates the token issued, as if it had been revoked or expired early
+func (v *fakeVault) revoke() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.token = "revoked"
+}
+
+func testVaultConfig(t *testing.T, server *httptest.Server) Vault {
+	t.Helper()
+	secretIDFile := filepath.Join(t.TempDir(), "secret-id")
+	_ = os.WriteFile(secretIDFile, []byte("s3cret\n"), 0600)
+	return Vault{Address: server.URL, Auth: vaultAuthAppRole, RoleID: "uploader", SecretIDFile: secretIDFile}
+}
+
+func TestVaultCredentialsKV(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	server, fake := newFakeVault(t)
+	config = Config{Vault: testVaultConfig(t, server)}
+	t.Cleanup(resetLoadedLogSecrets)
+
+	creds := remoteCredentials(Remote{VaultPath: "secret/data/minio"})
+	v, err := creds.GetWithContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "AKIAKV" || v.SecretAccessKey != "kvsecret" || v.SessionToken != "" {
+		t.Errorf("unexpected keys %+v", v)
+	}
+	logRedactor.mu.RLock()
+	if !logRedactor.loaded["AKIAKV"] || !logRedactor.loaded["kvsecret"] {
+		t.Error("keys read from Vault not redacted from the log")
+	}
+	logRedactor.mu.RUnlock()
+
+	// A refused token is replaced by logging in again
+	fake.revoke()
+	creds.Expire()
+	if _, err := creds.GetWithContext(nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.count("/v1/auth/approle/login"); n != 2 {
+		t.Errorf("logged in %d times, want 2", n)
+	}
+}
+
+func TestVaultCredentialsLeaseRenewal(t *testing.T) {
+	originalConfig := config
+	defer func() { config = originalConfig }()
+	server, fake := newFakeVault(t)
+	config = Config{Vault: testVaultConfig(t, server)}
+
+	creds := remoteCredentials(Remote{VaultPath: "aws/creds/uploader"})
+	v, err := creds.GetWithContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "ASIAAWS" || v.SessionToken != "session" {
+		t.Errorf("unexpected keys %+v", v)
+	}
+	if creds.IsExpired() {
+		t.Error("keys expired as soon as read")
+	}
+
+	creds.Expire()
+	if v, err := creds.GetWithContext(nil); err != nil || v.AccessKeyID != "ASIAAWS" {
+		t.Fatalf("after renewal: %+v, %v", v, err)
+	}
+	if fake.count("/v1/sys/leases/renew") != 1 || fake.count("/v1/aws/creds/uploader") != 1 {
+		t.Errorf("expected the lease renewed rather than new keys issued, got %v", fake.calls)
+	}
+}
+
+func TestVaultSecretKeys(t *testing.T) {
+	v, err := vaultSecretKeys(map[string]any{"accessKey": "a", "secretKey": "s"})
+	if err != nil || v.AccessKeyID != "a" || v.SecretAccessKey != "s" {
+		t.Errorf("vaultSecretKeys() = %+v, %v", v, err)
+	}
+	if _, err := vaultSecretKeys(map[string]any{"username": "a"}); err == nil {
+		t.Error("expected an error for a secret without keys")
+	}
+}
+
+func TestVaultValidate(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Vault: Vault{Auth: "ldap"}}, "auth: must be"},
+		{Config{Vault: Vault{Auth: vaultAuthAppRole, RoleID: "r"}}, "secret_id_file are required"},
+		{Config{Vault: Vault{Auth: vaultAuthKubernetes}}, "role is required"},
+		{Config{Vault: Vault{Address: "https://vault:8200"}, Remotes: []Remote{{Name: "m", Endpoint: "minio:9000", VaultPath: "secret/data/m", AccessKey: "k"}}}, "vaultPath cannot be used"},
+	}
+	for _, tt := range tests {
+		errs := tt.cfg.Validate()
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.want) {
+			t.Errorf("expected an error containing %q, got %v", tt.want, errs)
+		}
+	}
+}
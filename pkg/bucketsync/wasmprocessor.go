@@ -0,0 +1,190 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// A process_with ending in .wasm is a WebAssembly processor, run in-process and sandboxed with no
+// access to the filesystem or network. It is a WASI command, instantiated afresh for each file,
+// which reads the file on standard input and exits with its decision:
+//
+//	0   accept the file
+//	10  replace it with what was written to standard output
+//	11  skip it, giving the reason on standard error
+//	12  reject it, giving the reason on standard error
+//
+// Any other exit code, a trap or running past process_timeout fails the file. The workflow,
+// direction, transfer ID, file path and object key are passed in BUCKETSYNCD_* variables.
+const (
+	wasmExitReplace = 10
+	wasmExitSkip    = 11
+	wasmExitReject  = 12
+
+	// wasmMemoryLimitPages caps each instance's memory at 1GiB
+	wasmMemoryLimitPages = 16384
+	// wasmStderrLimit is how much of an instance's standard error is kept for its reason or logs
+	wasmStderrLimit = 4096
+)
+
+// isWasmProcessor reports whether process_with names a WebAssembly module
+func isWasmProcessor(command string) bool {
+	return strings.HasSuffix(strings.ToLower(command), ".wasm")
+}
+
+// wasmProcessor is a WebAssembly module, compiled when first used
+type wasmProcessor struct {
+	path string
+
+	mu      sync.Mutex
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+}
+
+var (
+	wasmProcessorsMutex sync.Mutex
+	wasmProcessors      = make(map[string]*wasmProcessor)
+)
+
+// wasmProcessorFor returns the processor for the module at path, which workflows naming it share
+func wasmProcessorFor(path string) *wasmProcessor {
+	wasmProcessorsMutex.Lock()
+	defer wasmProcessorsMutex.Unlock()
+	p, ok := wasmProcessors[path]
+	if !ok {
+		p = &wasmProcessor{path: path}
+		wasmProcessors[path] = p
+	}
+	return p
+}
+
+// closeWasmProcessors releases every compiled module
+func closeWasmProcessors() {
+	wasmProcessorsMutex.Lock()
+	defer wasmProcessorsMutex.Unlock()
+	for path, p := range wasmProcessors {
+		p.mu.Lock()
+		if p.runtime != nil {
+			_ = p.runtime.Close(context.Background())
+			p.runtime, p.module = nil, nil
+		}
+		p.mu.Unlock()
+		delete(wasmProcessors, path)
+	}
+}
+
+// load compiles the module unless it already has been
+func (p *wasmProcessor) load(ctx context.Context) (wazero.Runtime, wazero.CompiledModule, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.module != nil {
+		return p.runtime, p.module, nil
+	}
+	// #nosec G304 - intentional: the module is named in the configuration file
+	code, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(wasmMemoryLimitPages))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, nil, err
+	}
+	module, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, nil, fmt.Errorf("failed to compile %s: %w", p.path, err)
+	}
+	p.runtime, p.module = runtime, module
+	return runtime, module, nil
+}
+
+// process runs the module over the file at req.Path, writing any replacement to req.Output
+func (p *wasmProcessor) process(req processRequest, timeout time.Duration) (processResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	runtime, module, err := p.load(ctx)
+	if err != nil {
+		return processResponse{}, fmt.Errorf("processor %s: %w", p.path, err)
+	}
+
+	// #nosec G304 - the file being transferred
+	in, err := os.Open(req.Path)
+	if err != nil {
+		return processResponse{}, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(req.Output, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return processResponse{}, err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	stderr := &limitedBuffer{limit: wasmStderrLimit}
+
+	config := wazero.NewModuleConfig().
+		// Unnamed, so that files may be processed concurrently
+		WithName("").
+		WithArgs(filepath.Base(p.path)).
+		WithStdin(in).
+		WithStdout(out).
+		WithStderr(stderr).
+		WithEnv("BUCKETSYNCD_WORKFLOW", req.Workflow).
+		WithEnv("BUCKETSYNCD_DIRECTION", req.Direction).
+		WithEnv("BUCKETSYNCD_TRANSFER_ID", req.TransferID).
+		WithEnv("BUCKETSYNCD_PATH", req.Path).
+		WithEnv("BUCKETSYNCD_KEY", req.Key)
+	mod, err := runtime.InstantiateModule(ctx, module, config)
+	if mod != nil {
+		_ = mod.Close(ctx)
+	}
+
+	var code uint32
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		return processResponse{}, fmt.Errorf("processor %s: %w", p.path, err)
+	}
+	reason := strings.TrimSpace(stderr.String())
+	switch code {
+	case 0:
+		return processResponse{Action: processAccept}, nil
+	case wasmExitReplace:
+		return processResponse{Action: processReplace}, nil
+	case wasmExitSkip:
+		return processResponse{Action: processSkip, Reason: reason}, nil
+	case wasmExitReject:
+		return processResponse{Action: processReject, Reason: reason}, nil
+	}
+	return processResponse{}, fmt.Errorf("processor %s: %w: %s", p.path, exitErr, reason)
+}
+
+// limitedBuffer keeps the first limit bytes written to it, discarding the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,80 @@
+package bucketsync
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// buildWasmProcessor compiles testdata/wasmprocessor to a WASI module
+func buildWasmProcessor(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("building a WebAssembly module is slow")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	module := filepath.Join(t.TempDir(), "processor.wasm")
+	cmd := exec.Command(goTool, "build", "-o", module, "./testdata/wasmprocessor")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build processor: %v\n%s", err, out)
+	}
+	t.Cleanup(closeWasmProcessors)
+	return module
+}
+
+func TestWasmProcessor(t *testing.T) {
+	module := buildWasmProcessor(t)
+	dir := t.TempDir()
+
+	for _, tt := range []struct {
+		content string
+		action  string
+		want    string
+	}{
+		{"plain", processAccept, "plain"},
+		{"upper", processReplace, "UPPER"},
+		{"skip", processSkip, ""},
+		{"reject", processReject, ""},
+	} {
+		t.Run(tt.content, func(t *testing.T) {
+			path := filepath.Join(dir, tt.content+".txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			result, err := processFile(module, 30*time.Second, "", processRequest{Workflow: "docs", Direction: directionUpload, Path: path})
+			if err != nil {
+				t.Fatalf("processFile() = %v", err)
+			}
+			defer result.close()
+			if result.Action != tt.action {
+				t.Errorf("action = %q, want %q", result.Action, tt.action)
+			}
+			if tt.want != "" {
+				data, _ := os.ReadFile(result.Path)
+				if string(data) != tt.want {
+					t.Errorf("file to transfer holds %q, want %q", data, tt.want)
+				}
+			}
+			if (tt.action == processSkip || tt.action == processReject) && result.Reason != "test "+tt.content {
+				t.Errorf("reason = %q", result.Reason)
+			}
+		})
+	}
+	t.Run("timeout", func(t *testing.T) {
+		path := filepath.Join(dir, "hang.txt")
+		_ = os.WriteFile(path, []byte("hang"), 0600)
+		start := time.Now()
+		if _, err := processFile(module, 500*time.Millisecond, "", processRequest{Direction: directionUpload, Path: path}); err == nil {
+			t.Fatal("expected an error from a module which runs past its timeout")
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("processing took %v, want about the timeout", elapsed)
+		}
+	})
+}
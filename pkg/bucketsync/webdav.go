@@ -0,0 +1,368 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/studio-b12/gowebdav"
+)
+
+// WebDAVClient wraps the gowebdav client with additional functionality
+type WebDAVClient struct {
+	client  *gowebdav.Client
+	baseURL *url.URL
+	// server is rooted at the server rather than baseURL, for chunked uploads, whose chunks are
+	// uploaded outside the destination's folder
+	server *gowebdav.Client
+	// chunkSize is the size of chunks uploaded with the chunking protocol, or zero not to use it
+	chunkSize     int64
+	chunkAttempts int
+	// uploads holds the chunked uploads in progress by their directory, whose requests need
+	// headers naming the destination
+	uploads sync.Map
+}
+
+// WebDAVOptions configures the client for a WebDAV server reached through a remote
+type WebDAVOptions struct {
+	// ChunkSize, e.g. "10MiB", uploads files larger than it, and streams of unknown length, in
+	// chunks of this size using the chunking protocol of Nextcloud and ownCloud, so that no
+	// request exceeds the server's limit and a failed chunk is retried alone
+	ChunkSize string `yaml:"chunk_size,omitempty"`
+	// ChunkAttempts is how many times each chunk is tried, by default 3
+	ChunkAttempts int `yaml:"chunk_attempts,omitempty"`
+	// Auth is how the URL's username and password are sent: "basic" with every request,
+	// "digest" only in answer to a digest challenge, or by default as the server asks. "bearer"
+	// sends Token instead.
+	Auth string `yaml:"auth,omitempty"`
+	// Token is a bearer token, such as an OAuth access token, sent with every request
+	Token string `yaml:"token,omitempty"`
+	// TokenFile holds the bearer token, and is read for each request so that a token refreshed
+	// by another program is picked up
+	TokenFile string `yaml:"token_file,omitempty"`
+	// Headers are added to every request
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// TLS configures the certificates trusted and presented for webdavs:// URLs
+	TLS TLSOptions `yaml:"tls,omitempty"`
+	// MaxIdleConns is how many idle connections to each server are kept for reuse, by default 16
+	MaxIdleConns int `yaml:"max_idle_conns,omitempty"`
+	// IdleTimeout closes connections left idle this long, by default 90s
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+	// KeepAlive is the interval of TCP keep-alive probes, which find a server gone away while a
+	// request waits on it, by default 15s; negative turns them off
+	KeepAlive time.Duration `yaml:"keep_alive,omitempty"`
+	// ResponseTimeout bounds waiting for the server to respond once a request is sent, by
+	// default 2 minutes
+	ResponseTimeout time.Duration `yaml:"response_timeout,omitempty"`
+	// connectTimeout bounds connecting, including the TLS handshake, and is the remote's
+	// timeouts.connect, or by default the global one
+	connectTimeout time.Duration
+	// maxConnections is the remote's max_connections
+	maxConnections int
+}
+
+// NewWebDAVClient creates a new WebDAV client from a URL
+func NewWebDAVClient(urlStr string) (*WebDAVClient, error) {
+	return NewWebDAVClientWithOptions(urlStr, WebDAVOptions{})
+}
+
+// NewWebDAVClientWithOptions creates a new WebDAV client from a URL, configured by opts
+func NewWebDAVClientWithOptions(urlStr string, opts WebDAVOptions) (*WebDAVClient, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse WebDAV URL: %w", err)
+	}
+
+	// Extract credentials from URL if present
+	username := ""
+	password := ""
+	if u.User != nil {
+		username = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+
+	// Create base URL without credentials for client
+	baseURL := &url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   u.Path,
+	}
+
+	transport, err := webdavTransport(u.Host, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WebDAVClient{
+		client:  newWebDAVServerClient(baseURL.String(), username, password, opts, transport),
+		baseURL: baseURL,
+		server:  newWebDAVServerClient((&url.URL{Scheme: u.Scheme, Host: u.Host}).String(), username, password, opts, transport),
+	}
+	w.chunkSize, w.chunkAttempts = opts.chunking()
+	w.server.SetInterceptor(w.interceptChunked)
+	return w, nil
+}
+
+// Upload uploads a file to the WebDAV server. With chunking configured, a file larger than a
+// chunk, or a reader of unknown length, is uploaded in chunks; an upload of a file which failed
+// part way resumes from the chunks already uploaded.
+func (w *WebDAVClient) Upload(localReader io.Reader, remotePath string) error {
+	size := int64(-1)
+	var uploadID string
+	if f, ok := localReader.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			// The same file uploaded to the same place again picks up where it left off
+			size = fi.Size()
+			uploadID = webdavUploadID(remotePath, strconv.FormatInt(size, 10), fi.ModTime().String())
+		}
+	}
+	if w.chunked(size) {
+		if uploadID == "" {
+			uploadID = webdavUploadID(remotePath, newTransferID())
+		}
+		fullPath := path.Join("/", w.baseURL.Path, remotePath)
+		if err := w.uploadChunked(context.Background(), localReader, size, fullPath, uploadID); err != nil {
+			return fmt.Errorf("failed to upload file to WebDAV: %w", err)
+		}
+		return nil
+	}
+
+	// Ensure the directory exists
+	remoteDir := path.Dir(remotePath)
+	if remoteDir != "/" && remoteDir != "." {
+		if err := w.client.MkdirAll(remoteDir, 0755); err != nil {
+			log.WithFields(log.Fields{
+				"remote_dir": remoteDir,
+			}).Warn("failed to create remote directory, continuing anyway: ", err)
+		}
+	}
+
+	// Upload the file
+	err := w.client.WriteStream(remotePath, localReader, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to upload file to WebDAV: %w", err)
+	}
+
+	return nil
+}
+
+// Download downloads a file from the WebDAV server
+func (w *WebDAVClient) Download(remotePath string) (io.ReadCloser, error) {
+	reader, err := w.client.ReadStream(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file from WebDAV: %w", err)
+	}
+
+	return reader, nil
+}
+
+// Exists checks if a file exists on the WebDAV server
+func (w *WebDAVClient) Exists(remotePath string) bool {
+	_, err := w.client.Stat(remotePath)
+	return err == nil
+}
+
+// Delete deletes a file from the WebDAV server
+func (w *WebDAVClient) Delete(remotePath string) error {
+	err := w.client.Remove(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to delete file from WebDAV: %w", err)
+	}
+	return nil
+}
+
+// List lists files in a directory on the WebDAV server
+func (w *WebDAVClient) List(remotePath string) ([]string, error) {
+	infos, err := w.client.ReadDir(remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WebDAV directory: %w", err)
+	}
+
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			files = append(files, info.Name())
+		}
+	}
+
+	return files, nil
+}
+
+// parseWebDAVURL parses a WebDAV URL and extracts components
+func parseWebDAVURL(urlStr string) (endpoint, remotePath string, err error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse WebDAV URL: %w", err)
+	}
+
+	// Validate scheme
+	if !isWebDAVScheme(u.Scheme) {
+		return "", "", fmt.Errorf("unsupported scheme: %s (expected webdav or webdavs)", u.Scheme)
+	}
+
+	// Convert webdav/webdavs to http/https for the client
+	scheme := "http"
+	if u.Scheme == "webdavs" {
+		scheme = "https"
+	}
+
+	endpoint = fmt.Sprintf("%s://%s", scheme, u.Host)
+	if u.User != nil {
+		endpoint = fmt.Sprintf("%s://%s@%s", scheme, u.User.String(), u.Host)
+	}
+
+	remotePath = u.Path
+	if remotePath == "" {
+		remotePath = "/"
+	}
+
+	return endpoint, remotePath, nil
+}
+
+// isWebDAVScheme checks if the scheme is a WebDAV scheme
+func isWebDAVScheme(scheme string) bool {
+	scheme = strings.ToLower(scheme)
+	return scheme == "webdav" || scheme == "webdavs"
+}
+
+// webdavStore is a WebDAV server, keyed by path from its root
+type webdavStore struct {
+	client *gowebdav.Client
+	dav    *WebDAVClient
+}
+
+// newWebDAVStore connects to the server of a webdav:// or webdavs:// URL, over HTTP or HTTPS,
+// with the remote's WebDAV options and connect timeout
+func newWebDAVStore(u *url.URL, remote Remote) (*webdavStore, error) {
+	endpoint, _, err := parseWebDAVURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	opts := remote.WebDAV
+	opts.connectTimeout = remoteTimeouts(remote).Connect
+	opts.maxConnections = remote.MaxConnections
+	w, err := NewWebDAVClientWithOptions(endpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavStore{client: w.client, dav: w}, nil
+}
+
+// webdavError translates a missing path to errObjectNotFound
+func webdavError(err error) error {
+	if gowebdav.IsErrNotFound(err) {
+		return fmt.Errorf("%w: %w", errObjectNotFound, err)
+	}
+	return err
+}
+
+// Put uploads to key, creating its directory if need be, in chunks if the client is configured
+// to. WebDAV has no object metadata, so metadata is ignored but for the transfer ID, with which a
+// retried chunked upload resumes from the chunks already uploaded.
+func (s *webdavStore) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.dav.chunked(size) {
+		id := metadata["Transfer-Id"]
+		if id == "" {
+			id = newTransferID()
+		}
+		return s.dav.uploadChunked(ctx, r, size, key, webdavUploadID(key, strconv.FormatInt(size, 10), id))
+	}
+	if dir := path.Dir(key); dir != "/" && dir != "." {
+		if err := s.client.MkdirAll(dir, 0755); err != nil {
+			log.WithFields(log.Fields{
+				"remote_dir": dir,
+			}).Warn("failed to create remote directory, continuing anyway: ", err)
+		}
+	}
+	if size < 0 {
+		return s.client.WriteStream(key, r, 0644)
+	}
+	return s.client.WriteStreamWithLength(key, r, size, 0644)
+}
+
+func (s *webdavStore) Get(ctx context.Context, key string) (io.ReadCloser, StoredObject, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, StoredObject{}, err
+	}
+	rc, err := s.client.ReadStream(key)
+	if err != nil {
+		return nil, StoredObject{}, webdavError(err)
+	}
+	return rc, info, nil
+}
+
+func (s *webdavStore) Stat(ctx context.Context, key string) (StoredObject, error) {
+	if err := ctx.Err(); err != nil {
+		return StoredObject{}, err
+	}
+	fi, err := s.client.Stat(key)
+	if err != nil {
+		return StoredObject{}, webdavError(err)
+	}
+	return webdavObjectInfo(key, fi), nil
+}
+
+func (s *webdavStore) Remove(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return webdavError(s.client.Remove(key))
+}
+
+// List lists the directory prefix, descending into its subdirectories if recursive
+func (s *webdavStore) List(ctx context.Context, prefix string, recursive bool) ([]StoredObject, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fis, err := s.client.ReadDir(prefix)
+	if err != nil {
+		return nil, webdavError(err)
+	}
+	infos := []StoredObject{}
+	for _, fi := range fis {
+		key := path.Join(prefix, fi.Name())
+		if !fi.IsDir() {
+			infos = append(infos, webdavObjectInfo(key, fi))
+			continue
+		}
+		if !recursive {
+			infos = append(infos, StoredObject{Key: key + "/", IsPrefix: true})
+			continue
+		}
+		sub, err := s.List(ctx, key, true)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, sub...)
+	}
+	return infos, nil
+}
+
+func (s *webdavStore) Presign(context.Context, string, time.Duration) (*url.URL, error) {
+	return nil, errPresignUnsupported
+}
+
+func webdavObjectInfo(key string, fi os.FileInfo) StoredObject {
+	info := StoredObject{Key: key, Size: fi.Size(), LastModified: fi.ModTime()}
+	if f, ok := fi.(gowebdav.File); ok {
+		info.ETag = strings.Trim(f.ETag(), `"`)
+	}
+	return info
+}
@@ -0,0 +1,146 @@
+package bucketsync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/studio-b12/gowebdav"
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	webdavAuthBasic  = "basic"
+	webdavAuthDigest = "digest"
+	webdavAuthBearer = "bearer"
+)
+
+func (o WebDAVOptions) validateAuth() error {
+	switch o.Auth {
+	case "", webdavAuthBasic, webdavAuthDigest:
+		// A token alone implies bearer
+		if o.Auth != "" && (o.Token != "" || o.TokenFile != "") {
+			return fmt.Errorf("token and token_file cannot be used with auth: %s", o.Auth)
+		}
+	case webdavAuthBearer:
+		if o.Token == "" && o.TokenFile == "" {
+			return errors.New("token or token_file is required with auth: bearer")
+		}
+	default:
+		return errors.New("auth: must be basic, digest or bearer")
+	}
+	if o.Token != "" && o.TokenFile != "" {
+		return errors.New("token and token_file cannot both be set")
+	}
+	for name, value := range o.Headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("headers: invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("headers: invalid value for %s", name)
+		}
+	}
+	return nil
+}
+
+// authorizer returns how requests to the server authenticate, with the username and password
+// from the URL unless a token is configured
+func (o WebDAVOptions) authorizer(username, password string) gowebdav.Authorizer {
+	switch {
+	case o.Token != "" || o.TokenFile != "":
+		return gowebdav.NewPreemptiveAuth(&webdavBearerAuth{token: o.Token, tokenFile: o.TokenFile})
+	case o.Auth == webdavAuthBasic:
+		// Sent with every request, so uploads need not be buffered to be sent again after a challenge
+		return gowebdav.NewPreemptiveAuth(&webdavBasicAuth{username: username, password: password})
+	case o.Auth == webdavAuthDigest:
+		auth := gowebdav.NewEmptyAuth()
+		auth.AddAuthenticator(webdavAuthDigest, func(c *http.Client, rs *http.Response, path string) (gowebdav.Authenticator, error) {
+			return gowebdav.NewDigestAuth(username, password, rs)
+		})
+		return auth
+	default:
+		// Whichever of basic, digest and passport the server asks for
+		return gowebdav.NewAutoAuth(username, password)
+	}
+}
+
+// newWebDAVServerClient creates a client for the WebDAV server at root, authenticating and
+// adding headers as configured, and connecting through transport
+func newWebDAVServerClient(root, username, password string, opts WebDAVOptions, transport http.RoundTripper) *gowebdav.Client {
+	client := gowebdav.NewAuthClient(root, opts.authorizer(username, password))
+	client.SetTransport(transport)
+	for name, value := range opts.Headers {
+		client.SetHeader(name, value)
+	}
+	return client
+}
+
+// webdavBasicAuth sends a username and password with every request, without waiting to be
+// challenged for them
+type webdavBasicAuth struct {
+	username, password string
+}
+
+func (a *webdavBasicAuth) Authorize(c *http.Client, rq *http.Request, path string) error {
+	rq.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *webdavBasicAuth) Verify(c *http.Client, rs *http.Response, path string) (bool, error) {
+	if rs.StatusCode == http.StatusUnauthorized {
+		return false, gowebdav.NewPathError("Authorize", path, rs.StatusCode)
+	}
+	return false, nil
+}
+
+func (a *webdavBasicAuth) Clone() gowebdav.Authenticator {
+	return a
+}
+
+func (a *webdavBasicAuth) Close() error {
+	return nil
+}
+
+func (a *webdavBasicAuth) String() string {
+	return "basic auth for " + a.username
+}
+
+// webdavBearerAuth sends a bearer token with every request. A token read from a file is read
+// again for each request, so that one refreshed by another program is picked up.
+type webdavBearerAuth struct {
+	token, tokenFile string
+}
+
+func (a *webdavBearerAuth) Authorize(c *http.Client, rq *http.Request, path string) error {
+	token := a.token
+	if a.tokenFile != "" {
+		b, err := os.ReadFile(a.tokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read WebDAV token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	rq.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *webdavBearerAuth) Verify(c *http.Client, rs *http.Response, path string) (bool, error) {
+	if rs.StatusCode == http.StatusUnauthorized {
+		return false, gowebdav.NewPathError("Authorize", path, rs.StatusCode)
+	}
+	return false, nil
+}
+
+func (a *webdavBearerAuth) Clone() gowebdav.Authenticator {
+	return a
+}
+
+func (a *webdavBearerAuth) Close() error {
+	return nil
+}
+
+func (a *webdavBearerAuth) String() string {
+	return "bearer token auth"
+}
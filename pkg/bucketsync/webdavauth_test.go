@@ -0,0 +1,166 @@
+package bucketsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// authRecordingServer accepts uploads, challenging requests without credentials with challenge,
+// and records the Authorization and X-Tenant headers of each
+type authRecordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newAuthRecordingServer(t *testing.T, challenge string) *authRecordingServer {
+	s := &authRecordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.Header.Get("Authorization")+" tenant="+r.Header.Get("X-Tenant"))
+		s.mu.Unlock()
+		if r.Header.Get("Authorization") == "" && challenge != "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *authRecordingServer) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return ""
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func TestWebDAVAuth(t *testing.T) {
+	t.Run("bearer token and headers", func(t *testing.T) {
+		server := newAuthRecordingServer(t, `Bearer realm="files"`)
+		client, err := NewWebDAVClientWithOptions(server.URL, WebDAVOptions{
+			Token:   "app-token",
+			Headers: map[string]string{"X-Tenant": "acme"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Upload(strings.NewReader("data"), "/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := server.last(), "PUT Bearer app-token tenant=acme"; got != want {
+			t.Errorf("request = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("token file read for each request", func(t *testing.T) {
+		server := newAuthRecordingServer(t, `Bearer realm="files"`)
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		if err := os.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		client, err := NewWebDAVClientWithOptions(server.URL, WebDAVOptions{Auth: "bearer", TokenFile: tokenFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, token := range []string{"first", "second"} {
+			if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Upload(strings.NewReader("data"), "/file.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := server.last(), "PUT Bearer "+token+" tenant="; got != want {
+				t.Errorf("request = %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("preemptive basic", func(t *testing.T) {
+		server := newAuthRecordingServer(t, `Basic realm="files"`)
+		u := strings.Replace(server.URL, "http://", "http://alice:secret@", 1)
+		client, err := NewWebDAVClientWithOptions(u, WebDAVOptions{Auth: "basic"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Upload(strings.NewReader("data"), "/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		// The credentials go with the first request, which is not challenged
+		for _, r := range server.requests {
+			if !strings.HasPrefix(r, "PUT Basic ") && !strings.HasPrefix(r, "MKCOL Basic ") {
+				t.Errorf("request without basic credentials: %q", r)
+			}
+		}
+	})
+
+	t.Run("digest", func(t *testing.T) {
+		server := newAuthRecordingServer(t, `Digest realm="files", nonce="abc123", qop="auth", algorithm=MD5`)
+		u := strings.Replace(server.URL, "http://", "http://alice:secret@", 1)
+		client, err := NewWebDAVClientWithOptions(u, WebDAVOptions{Auth: "digest"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Upload(strings.NewReader("data"), "/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := server.last(); !strings.HasPrefix(got, `PUT Digest username="alice"`) {
+			t.Errorf("request = %q, want digest credentials", got)
+		}
+	})
+
+	t.Run("digest refuses basic challenge", func(t *testing.T) {
+		server := newAuthRecordingServer(t, `Basic realm="files"`)
+		u := strings.Replace(server.URL, "http://", "http://alice:secret@", 1)
+		client, err := NewWebDAVClientWithOptions(u, WebDAVOptions{Auth: "digest"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Upload(strings.NewReader("data"), "/file.txt"); err == nil {
+			t.Error("upload succeeded, sending the password in the clear")
+		}
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		for _, r := range server.requests {
+			if strings.Contains(r, "Basic") {
+				t.Errorf("basic credentials sent: %q", r)
+			}
+		}
+	})
+}
+
+func TestWebDAVAuthValidate(t *testing.T) {
+	for _, opts := range []WebDAVOptions{
+		{Auth: "ntlm"},
+		{Auth: "bearer"},
+		{Auth: "digest", Token: "abcd"},
+		{Token: "abcd", TokenFile: "/run/secrets/token"},
+		{Headers: map[string]string{"Bad Header": "x"}},
+		{Headers: map[string]string{"X-Tenant": "a\r\nb"}},
+	} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("%+v: validated", opts)
+		}
+	}
+	for _, opts := range []WebDAVOptions{
+		{Auth: "digest"},
+		{Token: "abcd"},
+		{Auth: "bearer", TokenFile: "/run/secrets/token"},
+		{Headers: map[string]string{"X-Tenant": "acme"}},
+	} {
+		if err := opts.validate(); err != nil {
+			t.Errorf("%+v: %v", opts, err)
+		}
+	}
+}
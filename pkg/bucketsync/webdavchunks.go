@@ -0,0 +1,190 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/studio-b12/gowebdav"
+)
+
+const (
+	// The chunking protocol allows chunks of 5MiB to 5GiB, other than the last, and 10,000 of them
+	minWebDAVChunkSize = 5 << 20
+	maxWebDAVChunkSize = 5 << 30
+	maxWebDAVChunks    = 10000
+	// defaultWebDAVChunkAttempts is how many times each chunk is tried by default
+	defaultWebDAVChunkAttempts = 3
+)
+
+// webdavChunkRetryDelay is the wait before retrying a chunk, doubling for each retry
+var webdavChunkRetryDelay = time.Second
+
+// errWebDAVChunkingPath reports a destination outside the folders the chunking protocol serves
+var errWebDAVChunkingPath = errors.New("chunked uploads need a Nextcloud or ownCloud URL such as .../remote.php/dav/files/USER/...")
+
+func (o WebDAVOptions) validate() error {
+	if o.ChunkSize != "" {
+		size, err := parseByteSize(o.ChunkSize)
+		if err != nil {
+			return fmt.Errorf("chunk_size: %w", err)
+		}
+		if size < minWebDAVChunkSize || size > maxWebDAVChunkSize {
+			return errors.New("chunk_size: must be between 5MiB and 5GiB")
+		}
+	}
+	if o.ChunkAttempts < 0 {
+		return errors.New("chunk_attempts: must not be negative")
+	}
+	if err := o.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if err := o.validateTransport(); err != nil {
+		return err
+	}
+	return o.validateAuth()
+}
+
+// chunking resolves the chunk size, zero if chunked uploads are off, and the attempts per chunk
+func (o WebDAVOptions) chunking() (size int64, attempts int) {
+	if o.ChunkSize != "" {
+		// Validated already
+		size, _ = parseByteSize(o.ChunkSize)
+	}
+	attempts = o.ChunkAttempts
+	if attempts == 0 {
+		attempts = defaultWebDAVChunkAttempts
+	}
+	return size, attempts
+}
+
+// chunked reports whether an upload of size bytes, or -1 if unknown, is made in chunks
+func (w *WebDAVClient) chunked(size int64) bool {
+	return w.chunkSize > 0 && (size < 0 || size > w.chunkSize)
+}
+
+// webdavUploadID names the directory a chunked upload is assembled in, from what identifies it,
+// so that uploading the same thing again finds the chunks already uploaded
+func webdavUploadID(parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return "bucketsyncd-" + hex.EncodeToString(h[:16])
+}
+
+// webdavUploadDir returns the directory in which chunks for fullPath, a path from the server's
+// root under .../files/USER/, are uploaded: the matching .../uploads/USER/id
+func webdavUploadDir(fullPath, id string) (string, error) {
+	i := strings.Index(fullPath, "/files/")
+	if i < 0 {
+		return "", errWebDAVChunkingPath
+	}
+	user, _, ok := strings.Cut(fullPath[i+len("/files/"):], "/")
+	if !ok || user == "" {
+		return "", errWebDAVChunkingPath
+	}
+	return fullPath[:i] + "/uploads/" + user + "/" + id, nil
+}
+
+// webdavUpload is a chunked upload in progress
+type webdavUpload struct {
+	// destination is the URL of the file being uploaded
+	destination string
+	// size is the file's length, or -1 if unknown
+	size int64
+}
+
+// interceptChunked adds the headers naming a chunked upload's destination and length to the
+// requests for it, which servers storing files in S3 need in order to upload the chunks there
+func (w *WebDAVClient) interceptChunked(method string, rq *http.Request) {
+	p := strings.TrimSuffix(rq.URL.Path, "/")
+	v, ok := w.uploads.Load(p)
+	if !ok {
+		v, ok = w.uploads.Load(path.Dir(p))
+	}
+	if !ok {
+		return
+	}
+	upload := v.(*webdavUpload)
+	if method == http.MethodPut || method == "MKCOL" {
+		// A MOVE names its own destination
+		rq.Header.Set("Destination", upload.destination)
+	}
+	if upload.size >= 0 {
+		rq.Header.Set("OC-Total-Length", strconv.FormatInt(upload.size, 10))
+	}
+}
+
+// uploadChunked uploads everything read from r to fullPath, a path from the server's root, using
+// the chunking protocol of Nextcloud and ownCloud: the chunks are uploaded into a directory of
+// their own, each retried on failure, and then assembled by moving the directory's .file onto the
+// destination. Chunks left in the directory by an earlier attempt with the same id are not
+// uploaded again. size is the length of r, or -1 if unknown.
+func (w *WebDAVClient) uploadChunked(ctx context.Context, r io.Reader, size int64, fullPath, id string) error {
+	dir, err := webdavUploadDir(fullPath, id)
+	if err != nil {
+		return err
+	}
+	destination := *w.baseURL
+	destination.Path = fullPath
+	w.uploads.Store(dir, &webdavUpload{destination: destination.String(), size: size})
+	defer w.uploads.Delete(dir)
+
+	uploaded := make(map[string]int64)
+	fis, err := w.server.ReadDir(dir)
+	switch {
+	case err == nil:
+		for _, fi := range fis {
+			uploaded[fi.Name()] = fi.Size()
+		}
+	case gowebdav.IsErrNotFound(err):
+		if err := w.server.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("failed to start chunked upload: %w", err)
+		}
+	default:
+		return fmt.Errorf("failed to list chunked upload: %w", err)
+	}
+
+	retry := Retry{Attempts: w.chunkAttempts, InitialDelay: webdavChunkRetryDelay, MaxDelay: 30 * webdavChunkRetryDelay}
+	buf := make([]byte, w.chunkSize)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k, err := io.ReadFull(r, buf)
+		if err == io.EOF && n > 1 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n > maxWebDAVChunks {
+			return fmt.Errorf("more than %d chunks needed; increase chunk_size", maxWebDAVChunks)
+		}
+		// Chunks are numbered from 1, and assembled in order of their names
+		name := fmt.Sprintf("%05d", n)
+		if got, ok := uploaded[name]; !ok || got != int64(k) {
+			chunk := buf[:k]
+			err = retryWithBackoff(ctx, retry, func() error {
+				return w.server.WriteStreamWithLength(path.Join(dir, name), bytes.NewReader(chunk), int64(len(chunk)), 0644)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to upload chunk %d: %w", n, err)
+			}
+		}
+		if k < len(buf) {
+			break
+		}
+	}
+	if err := w.server.Rename(path.Join(dir, ".file"), fullPath, true); err != nil {
+		return fmt.Errorf("failed to assemble chunked upload: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package bucketsync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockChunkingServer is a WebDAV server speaking the Nextcloud chunking protocol
+type mockChunkingServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+	// puts counts the PUTs of each path, and failures fails the next PUTs of a path
+	puts     map[string]int
+	failures map[string]int
+	// headers records the Destination and OC-Total-Length headers of each request
+	headers []string
+}
+
+func newMockChunkingServer(t *testing.T) *mockChunkingServer {
+	s := &mockChunkingServer{
+		files:    map[string][]byte{},
+		dirs:     map[string]bool{},
+		puts:     map[string]int{},
+		failures: map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *mockChunkingServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	if strings.Contains(p, "/uploads/") {
+		s.headers = append(s.headers, fmt.Sprintf("%s %s destination=%s length=%s",
+			r.Method, path.Base(p), r.Header.Get("Destination"), r.Header.Get("OC-Total-Length")))
+	}
+	switch r.Method {
+	case "MKCOL":
+		if s.dirs[p] {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.dirs[p] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodPut:
+		s.puts[p]++
+		if s.failures[p] > 0 {
+			s.failures[p]--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.files[p] = body
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		if !s.dirs[p] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(207)
+		_, _ = fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`+
+			`<d:response><d:href>%s/</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop>`+
+			`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, p)
+		for name, data := range s.files {
+			if path.Dir(name) == p {
+				_, _ = fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype/>`+
+					`<d:getcontentlength>%d</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`,
+					name, len(data))
+			}
+		}
+		_, _ = fmt.Fprint(w, `</d:multistatus>`)
+	case "MOVE":
+		dir := path.Dir(p)
+		if path.Base(p) != ".file" || !s.dirs[dir] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var names []string
+		for name := range s.files {
+			if path.Dir(name) == dir {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var assembled []byte
+		for _, name := range names {
+			assembled = append(assembled, s.files[name]...)
+			delete(s.files, name)
+		}
+		delete(s.dirs, dir)
+		if total := r.Header.Get("OC-Total-Length"); total != "" && total != strconv.Itoa(len(assembled)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		dest, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.files[dest.Path] = assembled
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWebDAVChunkedUpload(t *testing.T) {
+	defer func(d time.Duration) {
+		webdavChunkRetryDelay = d
+	}(webdavChunkRetryDelay)
+	webdavChunkRetryDelay = time.Millisecond
+	server := newMockChunkingServer(t)
+	const chunkSize = minWebDAVChunkSize
+	content := make([]byte, 2*chunkSize+1234)
+	rand.New(rand.NewSource(1)).Read(content)
+	name := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(name, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewWebDAVClientWithOptions(server.URL+"/remote.php/dav/files/alice", WebDAVOptions{ChunkSize: "5MiB", ChunkAttempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// The second chunk fails more often than it is retried, failing the upload
+	fi, _ := f.Stat()
+	dir, _ := webdavUploadDir("/remote.php/dav/files/alice/videos/video.mp4",
+		webdavUploadID("/videos/video.mp4", strconv.FormatInt(fi.Size(), 10), fi.ModTime().String()))
+	server.failures[dir+"/00002"] = 2
+	if err := client.Upload(f, "/videos/video.mp4"); err == nil {
+		t.Fatal("upload succeeded despite a chunk failing on every attempt")
+	}
+
+	// Uploading again resumes with the second chunk
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload(f, "/videos/video.mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.files["/remote.php/dav/files/alice/videos/video.mp4"]; !bytes.Equal(got, content) {
+		t.Fatalf("assembled %d bytes, want %d matching", len(got), len(content))
+	}
+	if n := server.puts[dir+"/00001"]; n != 1 {
+		t.Errorf("first chunk uploaded %d times, want once", n)
+	}
+	if n := server.puts[dir+"/00002"]; n != 3 {
+		t.Errorf("second chunk uploaded %d times, want 3", n)
+	}
+	if n := server.puts[dir+"/00003"]; n != 1 {
+		t.Errorf("last chunk uploaded %d times, want once", n)
+	}
+	want := fmt.Sprintf("PUT 00003 destination=%s/remote.php/dav/files/alice/videos/video.mp4 length=%d", server.URL, len(content))
+	found := false
+	for _, h := range server.headers {
+		found = found || h == want
+	}
+	if !found {
+		t.Errorf("no request %q in:\n%s", want, strings.Join(server.headers, "\n"))
+	}
+
+	// Streams of unknown length are chunked too
+	if err := client.Upload(bytes.NewReader(content[:chunkSize]), "/videos/stream.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.files["/remote.php/dav/files/alice/videos/stream.bin"]; !bytes.Equal(got, content[:chunkSize]) {
+		t.Errorf("assembled %d bytes of stream, want %d matching", len(got), chunkSize)
+	}
+}
+
+func TestWebDAVChunkedUploadPath(t *testing.T) {
+	server := newMockChunkingServer(t)
+	client, err := NewWebDAVClientWithOptions(server.URL+"/dav", WebDAVOptions{ChunkSize: "5MiB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload(strings.NewReader("stream"), "/file.txt"); !errors.Is(err, errWebDAVChunkingPath) {
+		t.Errorf("Upload outside a files folder = %v", err)
+	}
+}
+
+func TestWebDAVOptionsValidate(t *testing.T) {
+	for _, opts := range []WebDAVOptions{
+		{ChunkSize: "1MiB"},
+		{ChunkSize: "10GiB"},
+		{ChunkSize: "lots"},
+		{ChunkAttempts: -1},
+	} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("%+v: validated", opts)
+		}
+	}
+	if err := (WebDAVOptions{ChunkSize: "10MiB", ChunkAttempts: 5}).validate(); err != nil {
+		t.Error(err)
+	}
+}
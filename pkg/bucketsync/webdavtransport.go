@@ -0,0 +1,102 @@
+package bucketsync
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebDAVMaxIdleConns    = 16
+	defaultWebDAVIdleTimeout     = 90 * time.Second
+	defaultWebDAVKeepAlive       = 15 * time.Second
+	defaultWebDAVResponseTimeout = 2 * time.Minute
+)
+
+func (o WebDAVOptions) validateTransport() error {
+	if o.MaxIdleConns < 0 {
+		return errors.New("max_idle_conns: must not be negative")
+	}
+	if o.IdleTimeout < 0 || o.ResponseTimeout < 0 {
+		return errors.New("idle_timeout and response_timeout must not be negative")
+	}
+	return nil
+}
+
+// webdavTransportKey identifies a transport by everything configuring it, so that clients for
+// the same server share one, and with it their idle connections
+type webdavTransportKey struct {
+	tls                                TLSOptions
+	connect, keepAlive, idle, response time.Duration
+	maxIdleConns, maxConns             int
+}
+
+var (
+	webdavTransportsMutex sync.Mutex
+	webdavTransports      = make(map[webdavTransportKey]*http.Transport)
+)
+
+// transportKey resolves the transport settings, defaulting those which are unset
+func (o WebDAVOptions) transportKey() webdavTransportKey {
+	key := webdavTransportKey{
+		tls:          o.TLS,
+		connect:      o.connectTimeout,
+		keepAlive:    o.KeepAlive,
+		idle:         o.IdleTimeout,
+		response:     o.ResponseTimeout,
+		maxIdleConns: o.MaxIdleConns,
+		maxConns:     o.maxConnections,
+	}
+	if key.connect == 0 {
+		key.connect = remoteTimeouts(Remote{}).Connect
+	}
+	if key.keepAlive == 0 {
+		key.keepAlive = defaultWebDAVKeepAlive
+	}
+	if key.idle == 0 {
+		key.idle = defaultWebDAVIdleTimeout
+	}
+	if key.response == 0 {
+		key.response = defaultWebDAVResponseTimeout
+	}
+	if key.maxIdleConns == 0 {
+		key.maxIdleConns = defaultWebDAVMaxIdleConns
+	}
+	return key
+}
+
+// webdavTransport returns the transport for clients configured by opts to server, shared by
+// every client with the same settings. Clients are created for each upload, and one transport
+// each would leave their connections idle until they timed out rather than reuse them.
+func webdavTransport(server string, opts WebDAVOptions) (*http.Transport, error) {
+	key := opts.transportKey()
+	webdavTransportsMutex.Lock()
+	defer webdavTransportsMutex.Unlock()
+	if tr, ok := webdavTransports[key]; ok {
+		return tr, nil
+	}
+	tlsConfig, err := key.tls.config()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.InsecureSkipVerify {
+		log.WithField("server", server).Warn("TLS certificate verification is disabled for this WebDAV server; connections to it can be intercepted")
+	}
+	// A negative keep-alive turns probes off, which is how the dialer takes it too
+	dialer := &net.Dialer{Timeout: key.connect, KeepAlive: key.keepAlive}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialer.DialContext
+	tr.TLSClientConfig = tlsConfig
+	tr.TLSHandshakeTimeout = key.connect
+	tr.ResponseHeaderTimeout = key.response
+	tr.IdleConnTimeout = key.idle
+	tr.MaxIdleConns = max(tr.MaxIdleConns, key.maxIdleConns)
+	tr.MaxIdleConnsPerHost = key.maxIdleConns
+	tr.MaxConnsPerHost = key.maxConns
+	webdavTransports[key] = tr
+	return tr, nil
+}
@@ -0,0 +1,128 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebDAVTransportReuse(t *testing.T) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// A client is created for each upload, as the outbound workflows do
+	opts := WebDAVOptions{MaxIdleConns: 4, IdleTimeout: time.Minute}
+	for i := 0; i < 10; i++ {
+		client, err := NewWebDAVClientWithOptions(server.URL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Upload(strings.NewReader("data"), "/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("%d connections made for 10 uploads, want 1", n)
+	}
+
+	a, _ := webdavTransport("", opts)
+	b, _ := webdavTransport("", WebDAVOptions{MaxIdleConns: 4, IdleTimeout: time.Minute, Token: "abcd"})
+	c, _ := webdavTransport("", WebDAVOptions{MaxIdleConns: 8})
+	if a != b || a == c {
+		t.Error("transports are not shared by exactly the clients with the same settings")
+	}
+	if a.MaxIdleConnsPerHost != 4 || a.IdleConnTimeout != time.Minute || a.ResponseHeaderTimeout != defaultWebDAVResponseTimeout {
+		t.Errorf("transport not configured: %d idle conns, %v idle timeout, %v response timeout",
+			a.MaxIdleConnsPerHost, a.IdleConnTimeout, a.ResponseHeaderTimeout)
+	}
+}
+
+func TestWebDAVMaxConnections(t *testing.T) {
+	var active, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	// Each upload has a store of its own, as the outbound workflows do, all through one remote
+	u, _ := url.Parse(strings.Replace(server.URL, "http://", "webdav://", 1))
+	remote := Remote{Endpoint: u.Host, MaxConnections: 2}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store, err := newWebDAVStore(u, remote)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := store.Put(context.Background(), fmt.Sprintf("/file%d.txt", i), strings.NewReader("data"), 4, nil); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if p := peak.Load(); p > 2 {
+		t.Errorf("%d requests in progress at once, want at most 2", p)
+	}
+}
+
+func TestWebDAVResponseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewWebDAVClientWithOptions(server.URL, WebDAVOptions{ResponseTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := client.Upload(strings.NewReader("data"), "/file.txt"); err == nil {
+		t.Error("upload to a server which never responds succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("upload took %v to give up", elapsed)
+	}
+}
+
+func TestWebDAVTransportValidate(t *testing.T) {
+	for _, opts := range []WebDAVOptions{
+		{MaxIdleConns: -1},
+		{IdleTimeout: -time.Second},
+		{ResponseTimeout: -time.Second},
+	} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("%+v: validated", opts)
+		}
+	}
+	if err := (WebDAVOptions{KeepAlive: -1}).validate(); err != nil {
+		t.Errorf("negative keep_alive, turning probes off: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookTimeout = 10 * time.Second
+
+	// webhookQueueSize is how many notifications may wait for each webhook before new ones are dropped
+	webhookQueueSize = 256
+
+	// webhookSignatureHeader carries the HMAC-SHA256 of the request body when a secret is configured
+	webhookSignatureHeader = "X-Bucketsyncd-Signature"
+	webhookEventHeader     = "X-Bucketsyncd-Event"
+)
+
+// webhookBackoff is the delay before the first retry of a failed delivery, doubling for each retry
+var webhookBackoff = time.Second
+
+// Notifications configures where transfer outcomes are sent, besides desktop notifications
+type Notifications struct {
+	Webhooks []Webhook      `yaml:"webhooks"`
+	Chat     []ChatNotifier `yaml:"chat"`
+	Email    []EmailAlert   `yaml:"email"`
+	// AMQP publishes CloudEvents to exchanges
+	AMQP []AMQPPublisher `yaml:"amqp"`
+}
+
+// configured reports whether any notifications are set up
+func (n Notifications) configured() bool {
+	return len(n.Webhooks) > 0 || len(n.Chat) > 0 || len(n.Email) > 0 || len(n.AMQP) > 0
+}
+
+// EventFilter selects which transfer outcomes a notifier is sent
+type EventFilter struct {
+	// Events to send: uploaded, downloaded, failed, alert and recovered. All are sent when empty.
+	Events []string `yaml:"events"`
+	// Workflows whose transfers are sent. All are sent when empty.
+	Workflows []string `yaml:"workflows"`
+}
+
+// Webhook is an HTTP endpoint which receives a JSON POST when a transfer completes or fails
+type Webhook struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Secret, when set, signs each body with HMAC-SHA256
+	Secret      string `yaml:"secret"`
+	EventFilter `yaml:",inline"`
+	// Template renders the body from the lifecycle event; the event itself is sent as JSON when empty
+	Template string            `yaml:"template"`
+	Headers  map[string]string `yaml:"headers"`
+	Retries  int               `yaml:"retries"`
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+// webhookTemplateFuncs are available to webhook templates; json quotes a value for embedding in JSON
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (h Webhook) validate() error {
+	if err := validateHTTPURL(h.URL); err != nil {
+		return err
+	}
+	if err := h.EventFilter.validate(); err != nil {
+		return err
+	}
+	if h.Template != "" {
+		if _, err := template.New(h.Name).Funcs(webhookTemplateFuncs).Parse(h.Template); err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+	if h.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+	return nil
+}
+
+func validateHTTPURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http or https URL")
+	}
+	return nil
+}
+
+func (f EventFilter) validate() error {
+	for _, event := range f.Events {
+		if !slices.Contains([]string{eventUploaded, eventDownloaded, eventReplicated, eventFailed, eventAlert, eventRecovered}, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// wants reports whether the notifier is interested in an event
+func (f EventFilter) wants(ev LifecycleEvent) bool {
+	return (len(f.Events) == 0 || slices.Contains(f.Events, ev.Event)) &&
+		(len(f.Workflows) == 0 || slices.Contains(f.Workflows, ev.Workflow))
+}
+
+// webhookSender delivers notifications to a single webhook from its own queue, so a slow
+// endpoint cannot delay transfers or other webhooks
+type webhookSender struct {
+	hook     Webhook
+	template *template.Template
+	client   *http.Client
+	queue    chan LifecycleEvent
+}
+
+var (
+	webhooksMutex sync.RWMutex
+	webhooks      []*webhookSender
+)
+
+// openWebhooks starts delivering transfer notifications to the configured webhooks.
+// The returned function stops accepting notifications and waits for those queued to be delivered.
+func openWebhooks(hooks []Webhook) (func(), error) {
+	senders := make([]*webhookSender, 0, len(hooks))
+	for _, h := range hooks {
+		if err := h.validate(); err != nil {
+			return nil, fmt.Errorf("webhook %q: %w", h.Name, err)
+		}
+		s := newWebhookSender(h)
+		if h.Template != "" {
+			s.template = template.Must(template.New(h.Name).Funcs(webhookTemplateFuncs).Parse(h.Template))
+		}
+		senders = append(senders, s)
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range s.queue {
+				if err := s.deliver(context.Background(), ev); err != nil {
+					log.WithFields(log.Fields{
+						"webhook":     s.hook.Name,
+						"event":       ev.Event,
+						"transfer_id": ev.TransferID,
+					}).Error("failed to deliver webhook notification: ", err)
+				}
+			}
+		}()
+	}
+
+	webhooksMutex.Lock()
+	webhooks = senders
+	webhooksMutex.Unlock()
+
+	return func() {
+		webhooksMutex.Lock()
+		webhooks = nil
+		webhooksMutex.Unlock()
+		for _, s := range senders {
+			close(s.queue)
+		}
+		wg.Wait()
+	}, nil
+}
+
+// newWebhookSender applies the defaults to a webhook and prepares to deliver to it
+func newWebhookSender(h Webhook) *webhookSender {
+	if h.Name == "" {
+		h.Name = h.URL
+	}
+	if h.Retries == 0 {
+		h.Retries = defaultWebhookRetries
+	}
+	if h.Timeout == 0 {
+		h.Timeout = defaultWebhookTimeout
+	}
+	return &webhookSender{
+		hook:   h,
+		client: &http.Client{Timeout: h.Timeout},
+		queue:  make(chan LifecycleEvent, webhookQueueSize),
+	}
+}
+
+// openNotifications starts every configured webhook, chat notifier, email alert and AMQP publisher,
+// returning a function which flushes and stops them all
+func openNotifications(cfg Notifications) (func(), error) {
+	closeWebhooks, err := openWebhooks(cfg.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	closeChat, err := openChatNotifiers(cfg.Chat)
+	if err != nil {
+		closeWebhooks()
+		return nil, err
+	}
+	closeEmail, err := openEmailAlerts(cfg.Email)
+	if err != nil {
+		closeChat()
+		closeWebhooks()
+		return nil, err
+	}
+	closeAMQP, err := openAMQPPublishers(cfg.AMQP)
+	if err != nil {
+		closeEmail()
+		closeChat()
+		closeWebhooks()
+		return nil, err
+	}
+	return func() {
+		closeAMQP()
+		closeEmail()
+		closeChat()
+		closeWebhooks()
+	}, nil
+}
+
+// notifyTransfer sends a completed or failed transfer to the webhooks, chat notifiers, email alerts
+// and AMQP publishers interested in it
+func notifyTransfer(rec TransferRecord) {
+	event := eventUploaded
+	switch {
+	case rec.Status == transferFailed:
+		event = eventFailed
+	case rec.Direction == directionDownload:
+		event = eventDownloaded
+	case rec.Direction == directionReplicate:
+		event = eventReplicated
+	}
+	ev := transferEvent(event, rec)
+	ev.Time = rec.Time
+
+	notifyWebhooks(ev)
+	notifyChat(ev)
+	notifyEmail(ev)
+	notifyAMQP(ev)
+}
+
+// notifyWebhooks queues an event for every webhook interested in it
+func notifyWebhooks(ev LifecycleEvent) {
+	webhooksMutex.RLock()
+	defer webhooksMutex.RUnlock()
+	for _, s := range webhooks {
+		if !s.hook.wants(ev) {
+			continue
+		}
+		select {
+		case s.queue <- ev:
+		default:
+			log.WithFields(log.Fields{
+				"webhook":     s.hook.Name,
+				"transfer_id": ev.TransferID,
+			}).Warn("webhook queue is full, dropping notification")
+		}
+	}
+}
+
+// deliver renders an event and sends it to the webhook
+func (s *webhookSender) deliver(ctx context.Context, ev LifecycleEvent) error {
+	body, err := s.render(ev)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, ev.Event, body)
+}
+
+// send posts a body to the webhook, retrying with exponential backoff on network errors,
+// rate limiting and server errors
+func (s *webhookSender) send(ctx context.Context, event string, body []byte) error {
+	delay := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.hook.Retries {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"webhook": s.hook.Name,
+			"attempt": attempt + 1,
+			"backoff": delay,
+		}).Warn("webhook delivery failed, retrying: ", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// render produces the request body, from the template if one is configured
+func (s *webhookSender) render(ev LifecycleEvent) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(ev)
+	}
+	var buf bytes.Buffer
+	if err := s.template.Execute(&buf, ev); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends one delivery attempt, reporting whether a failure is worth retrying
+func (s *webhookSender) post(ctx context.Context, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bucketsyncd/"+version)
+	if event != "" {
+		req.Header.Set(webhookEventHeader, event)
+	}
+	for k, v := range s.hook.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.hook.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(s.hook.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Webhook URLs often embed credentials, so leave them out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// webhookSignature is the hex HMAC-SHA256 of body, keyed with the webhook's secret
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,215 @@
+package bucketsync
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a test endpoint which records each request and replies with queued statuses
+type webhookRecorder struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *webhookRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func fastWebhookBackoff(t *testing.T) {
+	original := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = original })
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Webhook
+		wantErr bool
+	}{
+		{"valid", Webhook{URL: "https://hooks.example.com/x", EventFilter: EventFilter{Events: []string{eventFailed}}}, false},
+		{"missing url", Webhook{}, true},
+		{"bad scheme", Webhook{URL: "ftp://example.com/"}, true},
+		{"unknown event", Webhook{URL: "https://example.com/", EventFilter: EventFilter{Events: []string{"acked"}}}, true},
+		{"bad template", Webhook{URL: "https://example.com/", Template: "{{.Key"}, true},
+		{"negative retries", Webhook{URL: "https://example.com/", Retries: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hook.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventFilterWants(t *testing.T) {
+	hook := EventFilter{Events: []string{eventFailed}, Workflows: []string{"photos"}}
+	tests := []struct {
+		ev   LifecycleEvent
+		want bool
+	}{
+		{LifecycleEvent{Event: eventFailed, Workflow: "photos"}, true},
+		{LifecycleEvent{Event: eventUploaded, Workflow: "photos"}, false},
+		{LifecycleEvent{Event: eventFailed, Workflow: "backups"}, false},
+	}
+	for _, tt := range tests {
+		if got := hook.wants(tt.ev); got != tt.want {
+			t.Errorf("wants(%s, %s) = %v, want %v", tt.ev.Event, tt.ev.Workflow, got, tt.want)
+		}
+	}
+	if !(EventFilter{}).wants(LifecycleEvent{Event: eventDownloaded, Workflow: "any"}) {
+		t.Error("webhook without filters should want every event")
+	}
+}
+
+func TestWebhookDeliverSigned(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	s := &webhookSender{
+		hook:   Webhook{Name: "ops", URL: srv.URL, Secret: "s3cret", Headers: map[string]string{"X-Team": "storage"}},
+		client: srv.Client(),
+	}
+	ev := LifecycleEvent{Event: eventUploaded, Workflow: "photos", Key: "a.jpg", TransferID: "abc"}
+	if err := s.deliver(context.Background(), ev); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req, body := rec.requests[0], rec.bodies[0]
+	if got, want := req.Header.Get(webhookSignatureHeader), "sha256="+webhookSignature("s3cret", body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if req.Header.Get(webhookEventHeader) != eventUploaded || req.Header.Get("X-Team") != "storage" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+	var got LifecycleEvent
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if got.Key != "a.jpg" || got.TransferID != "abc" {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	closeWebhooks, err := openWebhooks([]Webhook{{
+		URL:      srv.URL,
+		Template: `{"text": {{json (printf "%s %s: %s" .Workflow .Event .Key)}}}`,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	notifyTransfer(TransferRecord{Workflow: "photos", Direction: directionUpload, Status: transferSuccess, Key: `say "hi".txt`})
+	closeWebhooks()
+
+	if rec.count() != 1 {
+		t.Fatalf("got %d requests, want 1", rec.count())
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.bodies[0], &body); err != nil {
+		t.Fatalf("body is not JSON: %v: %s", err, rec.bodies[0])
+	}
+	if want := `photos uploaded: say "hi".txt`; body["text"] != want {
+		t.Errorf("text = %q, want %q", body["text"], want)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	fastWebhookBackoff(t)
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"retried until success", []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}, false, 3},
+		{"gives up after retries", []int{502, 502, 502}, true, 3},
+		{"client error not retried", []int{http.StatusBadRequest}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &webhookRecorder{statuses: tt.statuses}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			s := &webhookSender{hook: Webhook{URL: srv.URL, Retries: 2}, client: srv.Client()}
+			err := s.deliver(context.Background(), LifecycleEvent{Event: eventFailed})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if rec.count() != tt.wantCalls {
+				t.Errorf("got %d requests, want %d", rec.count(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNotifyWebhooksFilters(t *testing.T) {
+	failures := &webhookRecorder{}
+	failureSrv := httptest.NewServer(failures)
+	defer failureSrv.Close()
+	all := &webhookRecorder{}
+	allSrv := httptest.NewServer(all)
+	defer allSrv.Close()
+
+	closeWebhooks, err := openWebhooks([]Webhook{
+		{Name: "failures", URL: failureSrv.URL, EventFilter: EventFilter{Events: []string{eventFailed}}},
+		{Name: "all", URL: allSrv.URL},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	notifyTransfer(TransferRecord{Workflow: "photos", Direction: directionUpload, Status: transferSuccess})
+	notifyTransfer(TransferRecord{Workflow: "backups", Direction: directionDownload, Status: transferSuccess})
+	notifyTransfer(TransferRecord{Workflow: "photos", Direction: directionUpload, Status: transferFailed, Error: "boom"})
+	closeWebhooks()
+
+	if failures.count() != 1 || failures.requests[0].Header.Get(webhookEventHeader) != eventFailed {
+		t.Errorf("failures webhook got %d requests", failures.count())
+	}
+	if all.count() != 3 {
+		t.Fatalf("all webhook got %d requests, want 3", all.count())
+	}
+	var got []string
+	for _, req := range all.requests {
+		got = append(got, req.Header.Get(webhookEventHeader))
+	}
+	if got[0] != eventUploaded || got[1] != eventDownloaded || got[2] != eventFailed {
+		t.Errorf("events = %v", got)
+	}
+
+	// Nothing is sent once the webhooks are closed
+	notifyTransfer(TransferRecord{Workflow: "photos", Status: transferFailed})
+	if all.count() != 3 {
+		t.Error("notification sent after close")
+	}
+}
@@ -0,0 +1,131 @@
+package bucketsync
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	logLevelsMutex sync.RWMutex
+	// baseLogLevel applies to entries which don't belong to a workflow with a log_level of its own
+	baseLogLevel      = log.InfoLevel
+	workflowLogLevels = make(map[string]log.Level)
+)
+
+// parseLogLevel converts a configured log level, which validLogLevel has accepted, defaulting to info
+func parseLogLevel(level string) log.Level {
+	switch level {
+	case debugLevel:
+		return log.DebugLevel
+	case warnLevel:
+		return log.WarnLevel
+	case errorLevel:
+		return log.ErrorLevel
+	default:
+		return log.InfoLevel
+	}
+}
+
+// setLogLevel sets the level for entries outside workflows with their own log_level
+func setLogLevel(level log.Level) {
+	logLevelsMutex.Lock()
+	defer logLevelsMutex.Unlock()
+	baseLogLevel = level
+	applyLogLevel()
+}
+
+func getLogLevel() log.Level {
+	logLevelsMutex.RLock()
+	defer logLevelsMutex.RUnlock()
+	return baseLogLevel
+}
+
+// setWorkflowLogLevel gives a workflow's entries their own level, or the base level again if level is empty
+func setWorkflowLogLevel(name, level string) {
+	logLevelsMutex.Lock()
+	defer logLevelsMutex.Unlock()
+	if level == "" {
+		delete(workflowLogLevels, name)
+	} else {
+		workflowLogLevels[name] = parseLogLevel(level)
+	}
+	applyLogLevel()
+}
+
+// applyLogLevel lets the most verbose level needed through the logger, leaving logEntryEnabled to
+// drop what each workflow doesn't want. logLevelsMutex must be held.
+func applyLogLevel() {
+	level := baseLogLevel
+	for _, l := range workflowLogLevels {
+		level = max(level, l)
+	}
+	log.SetLevel(level)
+}
+
+// logEntryEnabled reports whether an entry is at or above the level for its workflow
+func logEntryEnabled(entry *log.Entry) bool {
+	logLevelsMutex.RLock()
+	defer logLevelsMutex.RUnlock()
+	level := baseLogLevel
+	if name, ok := entry.Data["workflow"].(string); ok {
+		if l, ok := workflowLogLevels[name]; ok {
+			level = l
+		}
+	}
+	return entry.Level <= level
+}
+
+// levelFilterFormatter writes nothing for entries below their workflow's level
+type levelFilterFormatter struct {
+	log.Formatter
+}
+
+func (f levelFilterFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if !logEntryEnabled(entry) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
+
+// levelFilterHook passes a hook only the entries at or above their workflow's level
+type levelFilterHook struct {
+	log.Hook
+}
+
+func (h levelFilterHook) Fire(entry *log.Entry) error {
+	if !logEntryEnabled(entry) {
+		return nil
+	}
+	return h.Hook.Fire(entry)
+}
+
+// addLogHook adds a hook for log outputs, which respects each workflow's log level
+func addLogHook(hook log.Hook) {
+	log.AddHook(levelFilterHook{hook})
+}
+
+func validateWorkflowLogging(level string, labels map[string]string) error {
+	if !validLogLevel(level) {
+		return fmt.Errorf("invalid log_level %q (expected debug, info, warn or error)", level)
+	}
+	for k := range labels {
+		if k == "" || k == "workflow" || k == log.ErrorKey {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+	}
+	return nil
+}
+
+// workflowLogFields are the fields logged with all of a workflow's entries: its labels, then its name
+func workflowLogFields(name string, labels map[string]string, fields log.Fields) log.Fields {
+	lf := make(log.Fields, len(labels)+len(fields)+1)
+	for k, v := range labels {
+		lf[k] = v
+	}
+	maps.Copy(lf, fields)
+	lf["workflow"] = name
+	return lf
+}
@@ -0,0 +1,88 @@
+package bucketsync
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestValidateWorkflowLogging(t *testing.T) {
+	if err := validateWorkflowLogging("error", map[string]string{"site": "lon1"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateWorkflowLogging("verbose", nil); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if err := validateWorkflowLogging("", map[string]string{"workflow": "other"}); err == nil {
+		t.Error("expected error for a label named workflow")
+	}
+}
+
+func TestWorkflowLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	originalOut, originalFormatter, originalLevel := log.StandardLogger().Out, log.StandardLogger().Formatter, getLogLevel()
+	log.SetOutput(&buf)
+	log.SetFormatter(levelFilterFormatter{&log.TextFormatter{DisableColors: true}})
+	defer func() {
+		setWorkflowLogLevel("noisy", "")
+		setWorkflowLogLevel("verbose", "")
+		setLogLevel(originalLevel)
+		log.SetOutput(originalOut)
+		log.SetFormatter(originalFormatter)
+	}()
+	setLogLevel(log.InfoLevel)
+	setWorkflowLogLevel("noisy", errorLevel)
+	setWorkflowLogLevel("verbose", debugLevel)
+
+	log.WithFields(workflowLogFields("noisy", nil, nil)).Info("noisy info")
+	log.WithFields(workflowLogFields("noisy", nil, nil)).Error("noisy error")
+	log.WithFields(workflowLogFields("verbose", nil, nil)).Debug("verbose debug")
+	log.WithFields(workflowLogFields("other", nil, nil)).Debug("other debug")
+	log.Info("service info")
+
+	out := buf.String()
+	for _, want := range []string{"noisy error", "verbose debug", "service info"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output lacks %q: %s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"noisy info", "other debug"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("log output contains %q: %s", unwanted, out)
+		}
+	}
+}
+
+func TestLevelFilterHook(t *testing.T) {
+	defer setWorkflowLogLevel("noisy", "")
+	setWorkflowLogLevel("noisy", warnLevel)
+	rec := &levelRecorder{}
+	hook := levelFilterHook{rec}
+	_ = hook.Fire(&log.Entry{Level: log.InfoLevel, Data: workflowLogFields("noisy", nil, nil)})
+	_ = hook.Fire(&log.Entry{Level: log.WarnLevel, Data: workflowLogFields("noisy", nil, nil)})
+	if rec.fired != 1 {
+		t.Errorf("hook fired %d times, want 1", rec.fired)
+	}
+}
+
+type levelRecorder struct {
+	fired int
+}
+
+func (r *levelRecorder) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (r *levelRecorder) Fire(*log.Entry) error {
+	r.fired++
+	return nil
+}
+
+func TestWorkflowLogFields(t *testing.T) {
+	lf := workflowLogFields("photos", map[string]string{"tenant": "acme", "queue": "label"}, log.Fields{"queue": "scans"})
+	if lf["workflow"] != "photos" || lf["tenant"] != "acme" || lf["queue"] != "scans" {
+		t.Errorf("workflowLogFields() = %v", lf)
+	}
+}
@@ -0,0 +1,296 @@
+package bucketsync
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	workflowOutbound = "outbound"
+	workflowInbound  = "inbound"
+	// workflowReplicate copies objects between buckets
+	workflowReplicate = "replicate"
+	// workflowSync keeps a folder and a bucket in step both ways
+	workflowSync = "sync"
+
+	// recentTransferLimit is how many transfers are kept in memory for the admin API
+	recentTransferLimit = 100
+)
+
+// Workflow health, as reported by the status command and admin API
+const (
+	healthStarting   = "starting"
+	healthWatching   = "watching"
+	healthConnecting = "connecting"
+	healthConnected  = "connected"
+	healthDegraded   = "degraded"
+)
+
+// workflowState is the runtime state of a running workflow, shared with the admin API
+type workflowState struct {
+	mu            sync.Mutex
+	name          string
+	kind          string
+	location      string
+	health        string
+	paused        bool
+	resumed       chan struct{}
+	pending       []string
+	inFlight      int
+	transfers     int64
+	errors        int64
+	bytes         int64
+	lastTransfer  time.Time
+	lastError     string
+	lastErrorTime time.Time
+	started       time.Time
+
+	// thresholds are checked against the recent outcomes in buckets, and alerts holds those crossed
+	thresholds Thresholds
+	buckets    []rateBucket
+	alerts     []string
+
+	// scan transfers the workflow's existing files or objects in the background, returning how
+	// many there are, and upload uploads a single file held while the workflow was paused.
+	// Inbound workflows without a schedule and outbound workflows reading a FIFO set neither, and
+	// only outbound workflows watching a folder set upload.
+	scan   func() (int, error)
+	upload func(name string)
+}
+
+// workflowStatus is a snapshot of a workflow's state
+type workflowStatus struct {
+	Name          string     `json:"name"`
+	Kind          string     `json:"kind"`
+	Location      string     `json:"location,omitempty"`
+	Health        string     `json:"health"`
+	Paused        bool       `json:"paused"`
+	QueueDepth    int        `json:"queue_depth"`
+	Transfers     int64      `json:"transfers"`
+	Errors        int64      `json:"errors"`
+	Bytes         int64      `json:"bytes"`
+	LastTransfer  *time.Time `json:"last_transfer,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Alerts        []string   `json:"alerts,omitempty"`
+	// Active lists the transfers in progress, with their rates and estimated time remaining
+	Active []transferProgressStatus `json:"active,omitempty"`
+}
+
+var (
+	workflowsMutex sync.RWMutex
+	workflows      = make(map[string]*workflowState)
+
+	recentMutex     sync.Mutex
+	recentTransfers []TransferRecord
+)
+
+// registerWorkflow records a workflow as running, replacing any previous state for it
+func registerWorkflow(name, kind string) *workflowState {
+	w := &workflowState{name: name, kind: kind, health: healthStarting, started: time.Now().UTC()}
+	workflowsMutex.Lock()
+	workflows[name] = w
+	workflowsMutex.Unlock()
+	return w
+}
+
+func findWorkflow(name string) (*workflowState, bool) {
+	workflowsMutex.RLock()
+	defer workflowsMutex.RUnlock()
+	w, ok := workflows[name]
+	return w, ok
+}
+
+// workflowStatuses returns a snapshot of every running workflow, sorted by name
+func workflowStatuses() []workflowStatus {
+	workflowsMutex.RLock()
+	statuses := make([]workflowStatus, 0, len(workflows))
+	for _, w := range workflows {
+		statuses = append(statuses, w.status())
+	}
+	workflowsMutex.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+func (w *workflowState) status() workflowStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := workflowStatus{
+		Name:       w.name,
+		Kind:       w.kind,
+		Location:   w.location,
+		Health:     w.health,
+		Paused:     w.paused,
+		QueueDepth: w.inFlight + len(w.pending),
+		Transfers:  w.transfers,
+		Errors:     w.errors,
+		Bytes:      w.bytes,
+		LastError:  w.lastError,
+		Alerts:     slices.Clone(w.alerts),
+		Active:     workflowProgress(w.name),
+	}
+	// A workflow whose most recent transfer failed, or which has crossed a threshold,
+	// is degraded even while connected
+	if (w.lastErrorTime.After(w.lastTransfer) || len(w.alerts) > 0) && s.Health != healthStarting {
+		s.Health = healthDegraded
+	}
+	if !w.lastTransfer.IsZero() {
+		t := w.lastTransfer
+		s.LastTransfer = &t
+	}
+	if !w.lastErrorTime.IsZero() {
+		t := w.lastErrorTime
+		s.LastErrorTime = &t
+	}
+	return s
+}
+
+// trackWorkflow counts a file or message against a workflow's statistics, returning the
+// function which records its outcome
+func trackWorkflow(name string) func(error) {
+	w, ok := findWorkflow(name)
+	if !ok {
+		return func(error) {}
+	}
+	w.begin()
+	return w.end
+}
+
+// setLocation records the folder an outbound workflow watches, or the queue an inbound workflow consumes
+func (w *workflowState) setLocation(location string) {
+	w.mu.Lock()
+	w.location = location
+	w.mu.Unlock()
+}
+
+// setHealth records whether the workflow is watching, connected or degraded
+func (w *workflowState) setHealth(health string) {
+	w.mu.Lock()
+	w.health = health
+	w.mu.Unlock()
+}
+
+// begin counts a detected file or message as queued until end is called
+func (w *workflowState) begin() {
+	w.mu.Lock()
+	w.inFlight++
+	w.mu.Unlock()
+}
+
+// end records the outcome of a file or message passed to begin
+func (w *workflowState) end(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inFlight--
+	now := time.Now().UTC()
+	w.recordOutcome(now, err != nil)
+	if err != nil {
+		w.errors++
+		w.lastError, w.lastErrorTime = err.Error(), now
+		return
+	}
+	w.transfers++
+	w.lastTransfer = now
+}
+
+func (w *workflowState) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.paused {
+		w.paused = true
+		w.resumed = make(chan struct{})
+	}
+}
+
+// resume restarts a paused workflow, uploading any files held while it was paused
+func (w *workflowState) resume() {
+	w.mu.Lock()
+	if !w.paused {
+		w.mu.Unlock()
+		return
+	}
+	w.paused = false
+	close(w.resumed)
+	pending := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	if len(pending) > 0 && w.upload != nil {
+		go func() {
+			for _, name := range pending {
+				w.upload(name)
+			}
+		}()
+	}
+}
+
+// hold queues a file for upload on resume if the workflow is paused, reporting whether it did
+func (w *workflowState) hold(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.paused {
+		return false
+	}
+	if !slices.Contains(w.pending, name) {
+		w.pending = append(w.pending, name)
+	}
+	return true
+}
+
+func (w *workflowState) isPaused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused
+}
+
+// waitWhilePaused blocks until the workflow is resumed or the context is cancelled
+func (w *workflowState) waitWhilePaused(ctx context.Context) error {
+	w.mu.Lock()
+	paused, resumed := w.paused, w.resumed
+	w.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rememberTransfer keeps the most recent transfers for the admin API
+func rememberTransfer(rec TransferRecord) {
+	if w, ok := findWorkflow(rec.Workflow); ok && rec.Status == transferSuccess {
+		w.mu.Lock()
+		w.bytes += rec.Size
+		w.mu.Unlock()
+	}
+
+	recentMutex.Lock()
+	defer recentMutex.Unlock()
+	recentTransfers = append(recentTransfers, rec)
+	if len(recentTransfers) > recentTransferLimit {
+		recentTransfers = recentTransfers[len(recentTransfers)-recentTransferLimit:]
+	}
+}
+
+// recentTransferRecords returns up to limit of the most recent transfers, newest first
+func recentTransferRecords(limit int) []TransferRecord {
+	recentMutex.Lock()
+	defer recentMutex.Unlock()
+	if limit <= 0 || limit > len(recentTransfers) {
+		limit = len(recentTransfers)
+	}
+	records := make([]TransferRecord, 0, limit)
+	for i := len(recentTransfers) - 1; i >= len(recentTransfers)-limit; i-- {
+		records = append(records, recentTransfers[i])
+	}
+	return records
+}
@@ -0,0 +1,191 @@
+package bucketsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// resetWorkflows clears the workflow registry and recent transfers for the duration of a test
+func resetWorkflows(t *testing.T) {
+	t.Helper()
+	workflowsMutex.Lock()
+	originalWorkflows := workflows
+	workflows = make(map[string]*workflowState)
+	workflowsMutex.Unlock()
+	recentMutex.Lock()
+	originalRecent := recentTransfers
+	recentTransfers = nil
+	recentMutex.Unlock()
+	t.Cleanup(func() {
+		workflowsMutex.Lock()
+		workflows = originalWorkflows
+		workflowsMutex.Unlock()
+		recentMutex.Lock()
+		recentTransfers = originalRecent
+		recentMutex.Unlock()
+	})
+}
+
+func TestWorkflowStatistics(t *testing.T) {
+	resetWorkflows(t)
+	registerWorkflow("photos", workflowOutbound)
+
+	done := trackWorkflow("photos")
+	if got := workflowStatuses()[0].QueueDepth; got != 1 {
+		t.Errorf("queue depth = %d, want 1", got)
+	}
+	done(nil)
+	trackWorkflow("photos")(errors.New("upload failed"))
+
+	// Untracked workflows are ignored
+	trackWorkflow("missing")(nil)
+
+	statuses := workflowStatuses()
+	if len(statuses) != 1 {
+		t.Fatalf("got %d workflows, want 1", len(statuses))
+	}
+	s := statuses[0]
+	if s.QueueDepth != 0 || s.Transfers != 1 || s.Errors != 1 {
+		t.Errorf("unexpected counts: %+v", s)
+	}
+	if s.LastTransfer == nil || s.LastErrorTime == nil || s.LastError != "upload failed" {
+		t.Errorf("unexpected last transfer/error: %+v", s)
+	}
+}
+
+func TestWorkflowHealth(t *testing.T) {
+	resetWorkflows(t)
+	w := registerWorkflow("backups", workflowInbound)
+	if got := w.status().Health; got != healthStarting {
+		t.Errorf("initial health = %q, want %q", got, healthStarting)
+	}
+
+	w.setHealth(healthConnected)
+	trackWorkflow("backups")(errors.New("download failed"))
+	if got := w.status().Health; got != healthDegraded {
+		t.Errorf("health after failure = %q, want %q", got, healthDegraded)
+	}
+
+	// The next successful transfer clears the degraded state
+	time.Sleep(time.Millisecond)
+	trackWorkflow("backups")(nil)
+	if got := w.status().Health; got != healthConnected {
+		t.Errorf("health after success = %q, want %q", got, healthConnected)
+	}
+
+	rememberTransfer(TransferRecord{Workflow: "backups", Status: transferSuccess, Size: 100})
+	rememberTransfer(TransferRecord{Workflow: "backups", Status: transferFailed, Size: 50})
+	if got := w.status().Bytes; got != 100 {
+		t.Errorf("bytes = %d, want 100", got)
+	}
+}
+
+func TestWorkflowStatusesSorted(t *testing.T) {
+	resetWorkflows(t)
+	registerWorkflow("zeta", workflowInbound)
+	registerWorkflow("alpha", workflowOutbound)
+
+	statuses := workflowStatuses()
+	if len(statuses) != 2 || statuses[0].Name != "alpha" || statuses[1].Name != "zeta" {
+		t.Errorf("unexpected order: %+v", statuses)
+	}
+}
+
+func TestWorkflowPauseResume(t *testing.T) {
+	w := &workflowState{name: "photos"}
+	if err := w.waitWhilePaused(context.Background()); err != nil {
+		t.Fatalf("unexpected error while running: %v", err)
+	}
+
+	w.pause()
+	w.pause()
+	if !w.isPaused() {
+		t.Fatal("expected workflow to be paused")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.waitWhilePaused(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded while paused, got %v", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- w.waitWhilePaused(context.Background())
+	}()
+	w.resume()
+	w.resume()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("unexpected error after resume: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waitWhilePaused did not return after resume")
+	}
+	if w.isPaused() {
+		t.Error("expected workflow to be running")
+	}
+}
+
+func TestWorkflowHoldWhilePaused(t *testing.T) {
+	w := &workflowState{name: "photos"}
+	uploaded := make(chan string, 3)
+	w.upload = func(name string) {
+		uploaded <- name
+	}
+
+	if w.hold("a.jpg") {
+		t.Error("running workflow held a file")
+	}
+
+	w.pause()
+	for _, name := range []string{"a.jpg", "b.jpg", "a.jpg"} {
+		if !w.hold(name) {
+			t.Errorf("paused workflow did not hold %s", name)
+		}
+	}
+	if got := w.status().QueueDepth; got != 2 {
+		t.Errorf("queue depth while paused = %d, want 2", got)
+	}
+
+	w.resume()
+	var got []string
+	for range 2 {
+		select {
+		case name := <-uploaded:
+			got = append(got, name)
+		case <-time.After(time.Second):
+			t.Fatalf("held files not uploaded on resume, got %v", got)
+		}
+	}
+	if got[0] != "a.jpg" || got[1] != "b.jpg" {
+		t.Errorf("uploaded %v, want [a.jpg b.jpg]", got)
+	}
+	if depth := w.status().QueueDepth; depth != 0 {
+		t.Errorf("queue depth after resume = %d, want 0", depth)
+	}
+}
+
+func TestRecentTransferRecords(t *testing.T) {
+	resetWorkflows(t)
+	for i := range recentTransferLimit + 5 {
+		rememberTransfer(TransferRecord{Key: fmt.Sprintf("key-%d", i)})
+	}
+
+	all := recentTransferRecords(0)
+	if len(all) != recentTransferLimit {
+		t.Fatalf("got %d records, want %d", len(all), recentTransferLimit)
+	}
+	if want := fmt.Sprintf("key-%d", recentTransferLimit+4); all[0].Key != want {
+		t.Errorf("newest record = %q, want %q", all[0].Key, want)
+	}
+
+	latest := recentTransferRecords(2)
+	if len(latest) != 2 || latest[1].Key != fmt.Sprintf("key-%d", recentTransferLimit+3) {
+		t.Errorf("unexpected latest records: %+v", latest)
+	}
+}
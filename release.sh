@@ -12,8 +12,8 @@ VERSION=$1
 
 echo "Updating version to $VERSION..."
 
-# Update main.go
-sed -i "s/version = \".*\"/version = \"$VERSION\"/" main.go
+# Update pkg/bucketsync/main.go
+sed -i "s/version = \".*\"/version = \"$VERSION\"/" pkg/bucketsync/main.go
 
 # Update Makefile
 sed -i "s/VERSION := .*/VERSION := $VERSION/" Makefile